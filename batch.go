@@ -1,79 +1,400 @@
 package gokalman
 
-import "github.com/gonum/matrix/mat64"
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
 
 type measurementInfo struct {
 	RealObs        *mat64.Vector
 	ComputedObs    *mat64.Vector
 	ObservationDev *mat64.Vector
 	Φ, H           *mat64.Dense
+	Φ0             *mat64.Dense    // Φ(t_i, t_0), used to map this measurement back to the epoch.
+	R              mat64.Symmetric // Measurement noise used to weight this measurement.
 }
 
-// BatchKF defines a vanilla kalman filter. Use NewVanilla to initialize.
+// BatchKF defines a batch least squares filter. Use NewBatchKF to initialize.
 type BatchKF struct {
 	Λ            *mat64.Dense
 	N            *mat64.Vector
 	Measurements []measurementInfo
 	noise        Noise
-	locked       bool // Locks the KF to prevent adding more measurements when iterating
+	invR         *mat64.Dense    // Inverse of the measurement noise of the Noise, computed on first use.
+	x0Bar        *mat64.Vector   // A priori state deviation at epoch.
+	P0Bar        mat64.Symmetric // A priori covariance at epoch.
+	Φ0           *mat64.Dense    // Φ(t_k, t_0) of the latest measurement.
+	locked       bool            // Locks the KF to prevent adding more measurements when iterating
 	step         int
+	pcc          mat64.Symmetric // Covariance of the consider parameters, nil if the consider analysis is disabled.
+	Mxc          *mat64.Dense    // Accumulation of the consider partials, i.e. Σ (H_i Φ(t_i, t_0))ᵀ R_i⁻¹ Hc_i.
+	Θ0           *mat64.Dense    // Θ(t_k, t_0) of the latest measurement, i.e. the sensitivity of the state to the consider parameters.
+	Θ, Hc        *mat64.Dense    // Consider sensitivity and measurement partials of the next measurement, if set with PrepareConsider.
 }
 
-// NewBatchKF returns a new hybrid Kalman Filter which can be used both as a CKF and EKF.
-// Warning: there is a failsafe preventing any update prior to updating the matrices.
+// NewBatchKF returns a new batch least squares filter, which estimates the state deviation at the epoch t_0.
 // Usage:
 // ```
-/// kf.Prepare(Φ, Htilde)
-//  estimate, err := kf.Update(realObs, computedObs)
-/// ```
+// kf.SetNextMeasurement(realObs, computedObs, Φ, Htilde) // For each measurement
+// xHat0, P0, err := kf.Solve()
+// ```
+// Use Iterate to iterate the batch on a non-linear problem.
 // Parameters:
-// - x0: initial state estimate
-// - P0: initial covariance symmetric matrix
-// - noise: Noise
-// - measSize: number of rows of the measurement vector (not actually important)
+// - numMeasurements: expected number of measurements (more may be added)
+// - noise: Noise, whose measurement matrix weights the measurements unless overwritten (cf. SetNextMeasurementWithNoise)
 func NewBatchKF(numMeasurements int, noise Noise) *BatchKF {
-	meas := make([]measurementInfo, numMeasurements)
+	meas := make([]measurementInfo, 0, numMeasurements)
 	// Note that we will create the Λ matrix and N vector on the first call to SetNextMeasurement.
-	return &BatchKF{nil, nil, meas, noise, false, 0}
+	return &BatchKF{Measurements: meas, noise: noise}
+}
+
+// SetAPriori sets the a priori state deviation and covariance at the epoch. If not set, the batch only uses the measurements.
+func (kf *BatchKF) SetAPriori(x0Bar *mat64.Vector, P0Bar mat64.Symmetric) error {
+	if err := checkMatDims(x0Bar, P0Bar, "x0Bar", "P0Bar", rows2cols); err != nil {
+		return err
+	}
+	var x0 mat64.Vector
+	x0.CloneVec(x0Bar)
+	kf.x0Bar = &x0
+	kf.P0Bar = P0Bar
+	return nil
+}
+
+// EnableConsider enables the consider covariance analysis, where Pcc is the covariance of the unestimated consider
+// parameters, whose a priori deviation is zero. Their effect on each measurement is set with PrepareConsider.
+// Will return an error if measurements were already set, since their consider partials are unknown.
+func (kf *BatchKF) EnableConsider(Pcc mat64.Symmetric) error {
+	if Pcc == nil {
+		return errors.New("consider covariance Pcc must be provided")
+	}
+	if kf.step > 0 {
+		return errors.New("consider analysis must be enabled prior to the first measurement")
+	}
+	kf.pcc = Pcc
+	kf.Mxc = nil
+	kf.Θ0 = nil
+	kf.Θ, kf.Hc = nil, nil
+	return nil
+}
+
+// ConsiderEnabled returns whether the consider covariance analysis is enabled.
+func (kf *BatchKF) ConsiderEnabled() bool {
+	return kf.pcc != nil
+}
+
+// PrepareConsider sets the sensitivity Θ of the state to the consider parameters from the previous measurement (or
+// from the epoch for the first one), i.e. x_i = Φ*x_{i-1} + Θ*c, and the partials Hc of the next measurement with
+// respect to them, i.e. y = H̃*x + Hc*c. Either may be nil if the consider parameters do not affect the dynamics or
+// the measurement. It only applies to the next measurement, and must be called prior to SetNextMeasurement.
+func (kf *BatchKF) PrepareConsider(Θ, Hc *mat64.Dense) error {
+	if err := checkConsider(kf.pcc, Θ, Hc); err != nil {
+		return err
+	}
+	kf.Θ = Θ
+	kf.Hc = Hc
+	return nil
 }
 
 // SetNextMeasurement sets the next sequential measurement to the list of measurements to be taken into account for the filter.
-func (kf *BatchKF) SetNextMeasurement(realObs, computedObs *mat64.Vector, Φ, H *mat64.Dense) {
+// Φ is the STM from the previous measurement (or from the epoch for the first one), and H is Htilde at the time of the measurement:
+// the measurement is mapped back to the epoch with Φ(t_i, t_0).
+// The measurement is weighted by the inverse of the measurement noise of the Noise.
+// Will return an error if the KF is locked (e.g. when called outside of the observation function during Iterate).
+func (kf *BatchKF) SetNextMeasurement(realObs, computedObs *mat64.Vector, Φ, H *mat64.Dense) error {
+	if kf.noise == nil {
+		return errors.New("no Noise provided: use SetNextMeasurementWithNoise")
+	}
+	if kf.invR == nil {
+		var invR mat64.Dense
+		if err := invR.Inverse(kf.noise.MeasurementMatrix()); err != nil {
+			return fmt.Errorf("measurement noise is not invertible: %s", err)
+		}
+		kf.invR = &invR
+	}
+	return kf.setNextMeasurement(realObs, computedObs, Φ, H, kf.noise.MeasurementMatrix(), kf.invR)
+}
+
+// SetNextMeasurementWithNoise is like SetNextMeasurement but weights this measurement with the provided measurement noise R
+// instead of the one from the Noise.
+func (kf *BatchKF) SetNextMeasurementWithNoise(realObs, computedObs *mat64.Vector, Φ, H *mat64.Dense, R mat64.Symmetric) error {
+	var invR mat64.Dense
+	if err := invR.Inverse(R); err != nil {
+		return fmt.Errorf("measurement noise is not invertible: %s", err)
+	}
+	return kf.setNextMeasurement(realObs, computedObs, Φ, H, R, &invR)
+}
+
+func (kf *BatchKF) setNextMeasurement(realObs, computedObs *mat64.Vector, Φ, H *mat64.Dense, R mat64.Symmetric, invR *mat64.Dense) error {
+	if kf.locked {
+		return errors.New("batch is locked")
+	}
+	// Check the dimensions of each matrix to avoid errors.
+	if err := checkMatDims(realObs, computedObs, "real observation", "computed observation", rowsAndcols); err != nil {
+		return err
+	}
+	if err := checkMatDims(H, realObs, "H", "real observation", rows2rows); err != nil {
+		return err
+	}
+	if err := checkMatDims(H, Φ, "H", "Φ", cols2rows); err != nil {
+		return err
+	}
+	if err := checkMatDims(Φ, Φ, "Φ", "Φ", rows2cols); err != nil {
+		return err
+	}
+	if err := checkMatDims(R, realObs, "R", "real observation", rows2rows); err != nil {
+		return err
+	}
+	if kf.Λ != nil {
+		if err := checkMatDims(Φ, kf.Λ, "Φ", "Λ", rowsAndcols); err != nil {
+			return err
+		}
+	}
+	if kf.pcc != nil {
+		if kf.Θ != nil {
+			if err := checkMatDims(kf.Θ, Φ, "Θ", "Φ", rows2rows); err != nil {
+				return err
+			}
+		}
+		if kf.Hc != nil {
+			if err := checkMatDims(kf.Hc, realObs, "Hc", "real observation", rows2rows); err != nil {
+				return err
+			}
+		}
+	}
 	if kf.N == nil || kf.Λ == nil {
 		// There is no reason for both to *not* happen at the same time, but whatevs
 		_, cH := H.Dims()
 		kf.N = mat64.NewVector(cH, nil)
 		kf.Λ = mat64.NewDense(cH, cH, nil)
 	}
+	// Map the measurement back to the epoch.
+	var Φ0 mat64.Dense
+	if kf.Φ0 == nil {
+		Φ0.Clone(Φ)
+	} else {
+		Φ0.Mul(Φ, kf.Φ0)
+	}
+	kf.Φ0 = &Φ0
+	var HΦ0 mat64.Dense
+	HΦ0.Mul(H, &Φ0)
 	// And compute the current Λ and N.
-	var HtR, HtRH mat64.Dense
-	HtR.Mul(H.T(), kf.noise.MeasurementMatrix())
-	HtRH.Mul(&HtR, H)
-	kf.Λ.Add(kf.Λ, &HtRH)
+	var HtRinv, HtRinvH mat64.Dense
+	HtRinv.Mul(HΦ0.T(), invR)
+	HtRinvH.Mul(&HtRinv, &HΦ0)
+	kf.Λ.Add(kf.Λ, &HtRinvH)
 	// Compute observation deviation y
-	var y, HtRY mat64.Vector
+	var y, HtRinvY mat64.Vector
 	y.SubVec(realObs, computedObs)
 	// Store the measurement
-	kf.Measurements[kf.step] = measurementInfo{realObs, computedObs, &y, Φ, H}
-	HtRY.MulVec(&HtR, &y)
-	kf.N.AddVec(kf.N, &HtRY)
+	kf.Measurements = append(kf.Measurements, measurementInfo{realObs, computedObs, &y, Φ, H, &Φ0, R})
+	HtRinvY.MulVec(&HtRinv, &y)
+	kf.N.AddVec(kf.N, &HtRinvY)
+	if kf.pcc != nil {
+		kf.accumulateConsider(Φ, H, &HtRinv)
+	}
 	kf.step++
+	return nil
+}
+
+// accumulateConsider maps the consider partials of the next measurement back to the epoch and accumulates them:
+// Θ(t_i, t_0) = Φ*Θ(t_{i-1}, t_0) + Θ, and Mxc += (H*Φ(t_i, t_0))ᵀ R⁻¹ (H*Θ(t_i, t_0) + Hc).
+func (kf *BatchKF) accumulateConsider(Φ, H, HtRinv *mat64.Dense) {
+	n, _ := Φ.Dims()
+	q, _ := kf.pcc.Dims()
+	if kf.Θ0 == nil {
+		kf.Θ0 = mat64.NewDense(n, q, nil)
+		kf.Mxc = mat64.NewDense(n, q, nil)
+	}
+	var Θ0 mat64.Dense
+	Θ0.Mul(Φ, kf.Θ0)
+	if kf.Θ != nil {
+		Θ0.Add(&Θ0, kf.Θ)
+	}
+	kf.Θ0 = &Θ0
+	var Hc, HtRinvHc mat64.Dense
+	Hc.Mul(H, &Θ0)
+	if kf.Hc != nil {
+		Hc.Add(&Hc, kf.Hc)
+	}
+	HtRinvHc.Mul(HtRinv, &Hc)
+	kf.Mxc.Add(kf.Mxc, &HtRinvHc)
+	kf.Θ, kf.Hc = nil, nil
+}
+
+// ConsiderCovariance returns the consider covariance at the epoch given the covariance P0 returned by Solve, and the
+// cross covariance of the state and the consider parameters: with Sxc = -P0*Mxc, Pc = P0 + Sxc*Pcc*Sxcᵀ and
+// Pxc = Sxc*Pcc.
+func (kf *BatchKF) ConsiderCovariance(P0 mat64.Symmetric) (Pc *mat64.SymDense, Pxc *mat64.Dense, err error) {
+	if kf.pcc == nil {
+		return nil, nil, errors.New("consider analysis is not enabled")
+	}
+	if kf.Mxc == nil {
+		return nil, nil, errors.New("no measurements to compute the consider covariance")
+	}
+	if err = checkMatDims(P0, kf.Mxc, "P0", "Mxc", cols2rows); err != nil {
+		return nil, nil, err
+	}
+	var Sxc, SxcPccSxct mat64.Dense
+	Sxc.Mul(P0, kf.Mxc)
+	Sxc.Scale(-1, &Sxc)
+	Pxc = new(mat64.Dense)
+	Pxc.Mul(&Sxc, kf.pcc)
+	SxcPccSxct.Mul(Pxc, Sxc.T())
+	SxcPccSxct.Add(&SxcPccSxct, P0)
+	return symmetrize(&SxcPccSxct), Pxc, nil
 }
 
 // Solve will solve the Batch Kalman filter once and return xHat0 and P0, or an error
 func (kf *BatchKF) Solve() (xHat0 *mat64.Vector, P0 *mat64.SymDense, err error) {
-	var Λinv mat64.Dense
-	if err = Λinv.Inverse(kf.Λ); err != nil {
-		return nil, nil, err
+	if kf.Λ == nil {
+		return nil, nil, errors.New("no measurements to solve for")
 	}
-	// Make Λinv symmetric and store in P0
-	P0, err = AsSymDense(&Λinv)
+	// Add the a priori information.
+	Λ := mat64.DenseCopyOf(kf.Λ)
+	N := mat64.NewVector(kf.N.Len(), nil)
+	N.CloneVec(kf.N)
+	if kf.P0Bar != nil {
+		if err = checkMatDims(kf.Λ, kf.P0Bar, "Λ", "P0Bar", rowsAndcols); err != nil {
+			return nil, nil, err
+		}
+		var P0BarInv mat64.Dense
+		if err = P0BarInv.Inverse(kf.P0Bar); err != nil {
+			return nil, nil, fmt.Errorf("a priori covariance is not invertible: %s", err)
+		}
+		Λ.Add(Λ, &P0BarInv)
+		var Λx0Bar mat64.Vector
+		Λx0Bar.MulVec(&P0BarInv, kf.x0Bar)
+		N.AddVec(N, &Λx0Bar)
+	}
+	ΛSym, err := AsSymDense(Λ)
 	if err != nil {
 		return nil, nil, err
 	}
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(ΛSym); !ok {
+		return nil, nil, errors.New("information matrix is not positive definite: the state is not observable")
+	}
+	P0 = mat64.NewSymDense(ΛSym.Symmetric(), nil)
+	if err = P0.InverseCholesky(&chol); err != nil {
+		return nil, nil, err
+	}
 	// Compute xHat0
-	rΛ, _ := kf.Λ.Dims()
-	xHat0 = mat64.NewVector(rΛ, nil)
-	xHat0.MulVec(P0, kf.N)
+	xHat0 = mat64.NewVector(N.Len(), nil)
+	if err = xHat0.SolveCholeskyVec(&chol, N); err != nil {
+		return nil, nil, err
+	}
 	return xHat0, P0, nil
 }
+
+// Residuals returns the post-fit residuals y_i - H_i Φ(t_i, t_0) xHat0 of each measurement.
+func (kf *BatchKF) Residuals(xHat0 *mat64.Vector) []*mat64.Vector {
+	residuals := make([]*mat64.Vector, kf.step)
+	for i := 0; i < kf.step; i++ {
+		m := kf.Measurements[i]
+		var x, Hx, ε mat64.Vector
+		x.MulVec(m.Φ0, xHat0)
+		Hx.MulVec(m.H, &x)
+		ε.SubVec(m.ObservationDev, &Hx)
+		residuals[i] = &ε
+	}
+	return residuals
+}
+
+// BatchObservations computes the observations along the reference trajectory starting at X0, and sets each of them
+// with kf.SetNextMeasurement.
+type BatchObservations func(X0 *mat64.Vector, kf *BatchKF) error
+
+// Iterate solves the batch for the reference initial state X0 and corrects the reference, until the post-fit RMS of the
+// residuals is below rmsThreshold or its relative change is below rmsTolerance, or until maxIterations are reached. The a priori state deviation is
+// updated accordingly after each iteration. The measurements are recomputed on each iteration by calling observe.
+func (kf *BatchKF) Iterate(X0 *mat64.Vector, observe BatchObservations, maxIterations int, rmsTolerance, rmsThreshold float64) (*BatchResult, error) {
+	if maxIterations < 1 {
+		return nil, errors.New("at least one iteration is required")
+	}
+	X := mat64.NewVector(X0.Len(), nil)
+	X.CloneVec(X0)
+	result := &BatchResult{}
+	prevRMS := 0.0
+	for iter := 1; iter <= maxIterations; iter++ {
+		// Reset the accumulation and recompute the measurements along the new reference.
+		kf.Λ = nil
+		kf.N = nil
+		kf.Φ0 = nil
+		kf.Θ0 = nil
+		kf.Mxc = nil
+		kf.Measurements = kf.Measurements[:0]
+		kf.step = 0
+		kf.locked = false
+		if err := observe(X, kf); err != nil {
+			return nil, fmt.Errorf("iteration %d: %s", iter, err)
+		}
+		kf.locked = true
+		xHat0, P0, err := kf.Solve()
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %s", iter, err)
+		}
+		prefit := make([]*mat64.Vector, kf.step)
+		for i := 0; i < kf.step; i++ {
+			prefit[i] = kf.Measurements[i].ObservationDev
+		}
+		result.Residuals = kf.Residuals(xHat0)
+		result.PrefitRMS = rms(prefit)
+		result.PostfitRMS = rms(result.Residuals)
+		result.Deviation = xHat0
+		result.Covariance = P0
+		if kf.pcc != nil {
+			if result.ConsiderCovariance, result.ConsiderCrossCovariance, err = kf.ConsiderCovariance(P0); err != nil {
+				return nil, fmt.Errorf("iteration %d: %s", iter, err)
+			}
+		}
+		result.Iterations = iter
+		// Correct the reference and the a priori deviation.
+		X.AddVec(X, xHat0)
+		if kf.x0Bar != nil {
+			kf.x0Bar.SubVec(kf.x0Bar, xHat0)
+		}
+		if result.PostfitRMS <= rmsThreshold || (iter > 1 && math.Abs(prevRMS-result.PostfitRMS) <= rmsTolerance*prevRMS) {
+			result.Converged = true
+			break
+		}
+		prevRMS = result.PostfitRMS
+	}
+	result.State = X
+	return result, nil
+}
+
+// BatchResult is the output of an iterated batch.
+type BatchResult struct {
+	State                   *mat64.Vector   // Corrected reference state at the epoch.
+	Deviation               *mat64.Vector   // Deviation estimated on the last iteration.
+	Covariance              *mat64.SymDense // Covariance at the epoch.
+	ConsiderCovariance      *mat64.SymDense // Consider covariance at the epoch, nil if the consider analysis is disabled.
+	ConsiderCrossCovariance *mat64.Dense    // Cross covariance of the state and the consider parameters at the epoch.
+	Residuals               []*mat64.Vector // Post-fit residuals of each measurement on the last iteration.
+	PrefitRMS, PostfitRMS   float64         // RMS of the residuals on the last iteration.
+	Iterations              int
+	Converged               bool
+}
+
+func (r BatchResult) String() string {
+	return fmt.Sprintf("BatchResult [%d iterations, converged=%v, RMS pre=%e post=%e]\nx=%v\nP=%v\n", r.Iterations, r.Converged, r.PrefitRMS, r.PostfitRMS, mat64.Formatted(r.State.T()), mat64.Formatted(r.Covariance))
+}
+
+// rms returns the root mean square of all the components of the provided vectors.
+func rms(vectors []*mat64.Vector) float64 {
+	sum := 0.0
+	count := 0
+	for _, v := range vectors {
+		for i := 0; i < v.Len(); i++ {
+			sum += v.At(i, 0) * v.At(i, 0)
+		}
+		count += v.Len()
+	}
+	if count == 0 {
+		return 0
+	}
+	return math.Sqrt(sum / float64(count))
+}
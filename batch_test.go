@@ -0,0 +1,191 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestBatchMatchesCKF(t *testing.T) {
+	// Linear problem: the batch with a priori information must match the CKF mapped back to the epoch.
+	Φ := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	noise := NewNoiseless(ScaledIdentity(2, 0), ScaledIdentity(1, 0.25))
+	x0Bar := mat64.NewVector(2, []float64{0.1, -0.2})
+	P0Bar := ScaledIdentity(2, 10)
+	ys := []float64{0.8, 1.6, 2.1, 2.9, 3.7, 4.2, 5.1}
+
+	ckf, _, err := NewHybridKF(x0Bar, P0Bar, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := NewBatchKF(0, noise) // More measurements than expected must be supported.
+	if err := batch.SetAPriori(x0Bar, P0Bar); err != nil {
+		t.Fatal(err)
+	}
+	var est Estimate
+	for _, y := range ys {
+		ckf.Prepare(Φ, H)
+		est, err = ckf.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.SetNextMeasurement(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil), Φ, H); err != nil {
+			t.Fatal(err)
+		}
+	}
+	xHat0, P0, err := batch.Solve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Map the batch estimate to the last measurement.
+	var xHatk mat64.Vector
+	xHatk.MulVec(batch.Φ0, xHat0)
+	var Pk, Ptmp mat64.Dense
+	Ptmp.Mul(batch.Φ0, P0)
+	Pk.Mul(&Ptmp, batch.Φ0.T())
+	if !mat64.EqualApprox(&xHatk, est.State(), 1e-10) {
+		t.Fatalf("batch state differs from CKF\n%v\n%v", mat64.Formatted(xHatk.T()), mat64.Formatted(est.State().T()))
+	}
+	if !mat64.EqualApprox(&Pk, est.Covariance(), 1e-10) {
+		t.Fatalf("batch covariance differs from CKF\n%v\n%v", mat64.Formatted(&Pk), mat64.Formatted(est.Covariance()))
+	}
+	residuals := batch.Residuals(xHat0)
+	if len(residuals) != len(ys) {
+		t.Fatalf("expected %d residuals, got %d", len(ys), len(residuals))
+	}
+	for i, ε := range residuals {
+		var x mat64.Vector
+		x.MulVec(batch.Measurements[i].Φ0, xHat0)
+		if exp := ys[i] - x.At(0, 0); math.Abs(ε.At(0, 0)-exp) > 1e-12 {
+			t.Fatalf("residual #%d is %f instead of %f", i, ε.At(0, 0), exp)
+		}
+	}
+}
+
+func TestBatchMeasurementNoise(t *testing.T) {
+	// Each measurement has its own noise: the batch must match the CKF whose noise is updated before each measurement.
+	Φ := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	noise := NewNoiseless(ScaledIdentity(2, 0), ScaledIdentity(1, 1))
+	x0Bar := mat64.NewVector(2, nil)
+	P0Bar := ScaledIdentity(2, 100)
+	ys := []float64{0.8, 1.6, 2.1, 2.9, 3.7, 4.2, 5.1}
+	σs := []float64{0.1, 1, 0.5, 2, 0.1, 0.3, 1}
+
+	ckf, _, err := NewHybridKF(x0Bar, P0Bar, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := NewBatchKF(len(ys), noise)
+	if err := batch.SetAPriori(x0Bar, P0Bar); err != nil {
+		t.Fatal(err)
+	}
+	var est Estimate
+	for i, y := range ys {
+		R := ScaledIdentity(1, σs[i]*σs[i])
+		realObs := mat64.NewVector(1, []float64{y})
+		ckf.SetNoise(NewNoiseless(ScaledIdentity(2, 0), R))
+		ckf.Prepare(Φ, H)
+		est, err = ckf.Update(realObs, mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.SetNextMeasurementWithNoise(realObs, mat64.NewVector(1, nil), Φ, H, R); err != nil {
+			t.Fatal(err)
+		}
+	}
+	xHat0, _, err := batch.Solve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var xHatk mat64.Vector
+	xHatk.MulVec(batch.Φ0, xHat0)
+	if !mat64.EqualApprox(&xHatk, est.State(), 1e-10) {
+		t.Fatalf("batch state differs from CKF\n%v\n%v", mat64.Formatted(xHatk.T()), mat64.Formatted(est.State().T()))
+	}
+	if len(batch.Measurements) != len(ys) {
+		t.Fatalf("expected %d measurements, got %d", len(ys), len(batch.Measurements))
+	}
+}
+
+func TestBatchDimensions(t *testing.T) {
+	noise := NewNoiseless(ScaledIdentity(2, 0), ScaledIdentity(1, 1))
+	batch := NewBatchKF(1, noise)
+	Φ := DenseIdentity(2)
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	obs := mat64.NewVector(1, nil)
+	if err := batch.SetNextMeasurement(obs, mat64.NewVector(2, nil), Φ, H); err == nil {
+		t.Fatal("observations of different sizes should fail")
+	}
+	if err := batch.SetNextMeasurement(mat64.NewVector(2, nil), mat64.NewVector(2, nil), Φ, H); err == nil {
+		t.Fatal("H and observations of different sizes should fail")
+	}
+	if err := batch.SetNextMeasurement(obs, obs, DenseIdentity(3), H); err == nil {
+		t.Fatal("H and Φ of different sizes should fail")
+	}
+	if err := batch.SetNextMeasurementWithNoise(obs, obs, Φ, H, ScaledIdentity(2, 1)); err == nil {
+		t.Fatal("R and observations of different sizes should fail")
+	}
+	if err := batch.SetNextMeasurementWithNoise(obs, obs, Φ, H, ScaledIdentity(1, 0)); err == nil {
+		t.Fatal("singular R should fail")
+	}
+	if err := batch.SetNextMeasurement(obs, obs, Φ, H); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.SetNextMeasurement(obs, obs, DenseIdentity(3), mat64.NewDense(1, 3, nil)); err == nil {
+		t.Fatal("changing the state size should fail")
+	}
+}
+
+func TestBatchIterate(t *testing.T) {
+	// Constant velocity object observed in range from a station offset by d.
+	d := 5.0
+	truth := mat64.NewVector(2, []float64{3, 0.7})
+	Φ := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	noise := NewNoiseless(ScaledIdentity(2, 0), ScaledIdentity(1, 1))
+	numMeas := 20
+	ρ := func(X *mat64.Vector) float64 {
+		return math.Sqrt(X.At(0, 0)*X.At(0, 0) + d*d)
+	}
+	observe := func(X0 *mat64.Vector, kf *BatchKF) error {
+		var X, Xtruth mat64.Vector
+		X.CloneVec(X0)
+		Xtruth.CloneVec(truth)
+		for k := 0; k < numMeas; k++ {
+			X.MulVec(Φ, &X)
+			Xtruth.MulVec(Φ, &Xtruth)
+			H := mat64.NewDense(1, 2, []float64{X.At(0, 0) / ρ(&X), 0})
+			if err := kf.SetNextMeasurement(mat64.NewVector(1, []float64{ρ(&Xtruth)}), mat64.NewVector(1, []float64{ρ(&X)}), Φ, H); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	batch := NewBatchKF(numMeas, noise)
+	res, err := batch.Iterate(mat64.NewVector(2, []float64{2, 0.5}), observe, 10, 1e-6, 1e-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Converged || res.Iterations < 2 {
+		t.Fatalf("batch did not converge properly:\n%s", res)
+	}
+	if !mat64.EqualApprox(res.State, truth, 1e-8) {
+		t.Fatalf("batch did not converge to the truth:\n%s", res)
+	}
+	if res.PostfitRMS > res.PrefitRMS || res.PostfitRMS > 1e-10 {
+		t.Fatalf("invalid RMS:\n%s", res)
+	}
+	// The batch must be locked after iterating.
+	if err := batch.SetNextMeasurement(mat64.NewVector(1, nil), mat64.NewVector(1, nil), Φ, mat64.NewDense(1, 2, nil)); err == nil {
+		t.Fatal("batch should be locked")
+	}
+	// Not enough iterations.
+	if res, err = NewBatchKF(numMeas, noise).Iterate(mat64.NewVector(2, []float64{2, 0.5}), observe, 1, 1e-6, 1e-10); err != nil {
+		t.Fatal(err)
+	}
+	if res.Converged {
+		t.Fatal("batch should not have converged in one iteration")
+	}
+}
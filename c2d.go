@@ -8,6 +8,13 @@ import (
 	"github.com/gonum/matrix/mat64"
 )
 
+// VanLoanModel returns the TimeStepModel of the provided CT system A, Γ, W, i.e. VanLoan over each time step.
+func VanLoanModel(A, Γ, W *mat64.Dense) TimeStepModel {
+	return func(Δt float64) (mat64.Matrix, mat64.Symmetric, error) {
+		return VanLoan(A, Γ, W, Δt)
+	}
+}
+
 // VanLoan computes the F and Q matrices from the provided CT system A, Γ, W and
 // the sampling rate Δt.
 func VanLoan(A, Γ, W *mat64.Dense, Δt float64) (*mat64.Dense, *mat64.SymDense, error) {
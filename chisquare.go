@@ -93,3 +93,35 @@ func NewChiSquare(kf LDKF, runs MonteCarloRuns, controls []*mat64.Vector, withNE
 
 	return NISmeans, NEESmeans, nil
 }
+
+// NEES returns the normalized estimation error squared of the estimate with respect to the true state, which can be
+// used to check the consistency of any filter (e.g. the UKF or EnKF) outside of NewChiSquare.
+func NEES(est Estimate, truth *mat64.Vector) (float64, error) {
+	if err := checkMatDims(est.State(), truth, "estimate", "truth", rows2rows); err != nil {
+		return 0, err
+	}
+	var e mat64.Vector
+	e.SubVec(truth, est.State())
+	return normalizedSquare(&e, est.Covariance())
+}
+
+// NIS returns the normalized innovation squared given the innovation and its covariance S.
+func NIS(innov *mat64.Vector, S mat64.Symmetric) (float64, error) {
+	if err := checkMatDims(innov, S, "innovation", "S", rows2rows); err != nil {
+		return 0, err
+	}
+	return normalizedSquare(innov, S)
+}
+
+// normalizedSquare returns vᵀ*P⁻¹*v.
+func normalizedSquare(v *mat64.Vector, P mat64.Symmetric) (float64, error) {
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(P); !ok {
+		return 0, errors.New("covariance is not positive definite")
+	}
+	var PInvV mat64.Vector
+	if err := PInvV.SolveCholeskyVec(&chol, v); err != nil {
+		return 0, err
+	}
+	return mat64.Dot(v, &PInvV), nil
+}
@@ -0,0 +1,89 @@
+package gokalman
+
+import (
+	"errors"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// Consider parameters are unestimated parameters (e.g. a station location or a gravity parameter error) whose
+// uncertainty Pcc is accounted for in the consider covariance, without adding them to the solve-for state.
+// The estimates are those of the filter which ignores these parameters (their a priori deviation is zero), but the
+// consider covariance reflects the actual uncertainty of those estimates given Pcc.
+// The sensitivity matrix Θ maps the consider parameters into the state over a step, i.e. x_{k+1} = Φ*x_k + Θ*c,
+// and Hc is the partial of the measurement with respect to the consider parameters, i.e. y = H̃*x + Hc*c.
+
+// checkConsider checks the dimensions of the sensitivity and consider partials of the next update, if provided,
+// given the consider covariance Pcc.
+func checkConsider(Pcc mat64.Symmetric, Θ, Hc *mat64.Dense) error {
+	if Pcc == nil {
+		return errors.New("consider analysis is not enabled")
+	}
+	if Θ != nil {
+		if err := checkMatDims(Θ, Pcc, "Θ", "Pcc", cols2cols); err != nil {
+			return err
+		}
+	}
+	if Hc != nil {
+		if err := checkMatDims(Hc, Pcc, "Hc", "Pcc", cols2cols); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// considerTimeUpdate returns the predicted consider covariance and the predicted cross covariance of the state and
+// the consider parameters:
+// P̄c = Φ*Pc*Φᵀ + Φ*Pxc*Θᵀ + Θ*Pxcᵀ*Φᵀ + Θ*Pcc*Θᵀ + ΓQΓᵀ and P̄xc = Φ*Pxc + Θ*Pcc.
+// Θ and ΓQΓᵀ may be nil.
+func considerTimeUpdate(Φ *mat64.Dense, Pc mat64.Symmetric, Pxc *mat64.Dense, Θ *mat64.Dense, Pcc mat64.Symmetric, ΓQΓt *mat64.Dense) (*mat64.Dense, *mat64.Dense) {
+	var ΦPc, PcBar, PxcBar mat64.Dense
+	ΦPc.Mul(Φ, Pc)
+	PcBar.Mul(&ΦPc, Φ.T())
+	PxcBar.Mul(Φ, Pxc)
+	if Θ != nil {
+		var ΘPcc, ΘPccΘt, ΦPxcΘt mat64.Dense
+		ΘPcc.Mul(Θ, Pcc)
+		ΘPccΘt.Mul(&ΘPcc, Θ.T())
+		ΦPxcΘt.Mul(&PxcBar, Θ.T())
+		PcBar.Add(&PcBar, &ΘPccΘt)
+		PcBar.Add(&PcBar, &ΦPxcΘt)
+		PcBar.Add(&PcBar, ΦPxcΘt.T())
+		PxcBar.Add(&PxcBar, &ΘPcc)
+	}
+	if ΓQΓt != nil {
+		PcBar.Add(&PcBar, ΓQΓt)
+	}
+	return &PcBar, &PxcBar
+}
+
+// considerMeasurementUpdate returns the consider covariance and the cross covariance of the state and the consider
+// parameters after a measurement update with the gain K, which ignores the consider parameters. With A = I - K*H̃:
+// Pc = A*P̄c*Aᵀ - A*P̄xc*Hcᵀ*Kᵀ - K*Hc*P̄xcᵀ*Aᵀ + K*(Hc*Pcc*Hcᵀ + R)*Kᵀ and Pxc = A*P̄xc - K*Hc*Pcc.
+// Hc may be nil if the measurement does not depend on the consider parameters.
+func considerMeasurementUpdate(K, H *mat64.Dense, R mat64.Symmetric, Hc *mat64.Dense, PcBar mat64.Matrix, PxcBar *mat64.Dense, Pcc mat64.Symmetric) (*mat64.Dense, *mat64.Dense) {
+	var A, APcBar, Pc, KR, KRKt, Pxc mat64.Dense
+	A.Mul(K, H)
+	n, _ := A.Dims()
+	A.Sub(DenseIdentity(n), &A)
+	APcBar.Mul(&A, PcBar)
+	Pc.Mul(&APcBar, A.T())
+	KR.Mul(K, R)
+	KRKt.Mul(&KR, K.T())
+	Pc.Add(&Pc, &KRKt)
+	Pxc.Mul(&A, PxcBar)
+	if Hc != nil {
+		var HcPcc, KHcPcc, KHcPccHct, KHcPccHctKt, APxcHct, APxcHctKt mat64.Dense
+		HcPcc.Mul(Hc, Pcc)
+		KHcPcc.Mul(K, &HcPcc)
+		KHcPccHct.Mul(&KHcPcc, Hc.T())
+		KHcPccHctKt.Mul(&KHcPccHct, K.T())
+		Pc.Add(&Pc, &KHcPccHctKt)
+		APxcHct.Mul(&Pxc, Hc.T()) // Pxc is A*P̄xc at this point.
+		APxcHctKt.Mul(&APxcHct, K.T())
+		Pc.Sub(&Pc, &APxcHctKt)
+		Pc.Sub(&Pc, APxcHctKt.T())
+		Pxc.Sub(&Pxc, &KHcPcc)
+	}
+	return &Pc, &Pxc
+}
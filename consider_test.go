@@ -0,0 +1,267 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestHybridConsider(t *testing.T) {
+	// The robot's position is observed with an unestimated bias c, which also slightly drives its velocity.
+	// The consider covariance must match the actual error covariance of the filter, computed by propagating the
+	// covariance of the augmented error [x - x̂; c] with the filter's gain.
+	Φ := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Γ := mat64.NewDense(2, 1, []float64{0.5, 1})
+	Θ := mat64.NewDense(2, 1, []float64{0, 0.1})
+	Hc := mat64.NewDense(1, 1, []float64{1})
+	Pcc := mat64.NewSymDense(1, []float64{0.04})
+	noise := NewNoiseless(mat64.NewSymDense(1, []float64{1e-3}), mat64.NewSymDense(1, []float64{0.25}))
+	x0 := mat64.NewVector(2, nil)
+	P0 := ScaledIdentity(2, 10)
+	kf, est0, err := NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est0.ConsiderCovariance() != nil {
+		t.Fatal("consider covariance must be nil when disabled")
+	}
+	if err = kf.EnableConsider(Pcc); err != nil {
+		t.Fatal(err)
+	}
+	if !kf.ConsiderEnabled() {
+		t.Fatal("consider analysis not enabled")
+	}
+	// Augmented error covariance.
+	PAug := mat64.NewDense(3, 3, nil)
+	PAug.Copy(P0)
+	PAug.Set(2, 2, Pcc.At(0, 0))
+	FAug := mat64.NewDense(3, 3, []float64{1, 1, 0, 0, 1, 0.1, 0, 0, 1})
+	ΓAug := mat64.NewDense(3, 1, []float64{0.5, 1, 0})
+	for k := 0; k < 30; k++ {
+		kf.Prepare(Φ, H)
+		kf.PreparePNT(Γ)
+		if err = kf.PrepareConsider(Θ, Hc); err != nil {
+			t.Fatal(err)
+		}
+		var est Estimate
+		if k%10 == 9 {
+			est, err = kf.Predict()
+		} else {
+			est, err = kf.Update(mat64.NewVector(1, []float64{math.Sin(float64(k))}), mat64.NewVector(1, nil))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var FP, ΓQ mat64.Dense
+		FP.Mul(FAug, PAug)
+		PAug.Mul(&FP, FAug.T())
+		ΓQ.Mul(ΓAug, noise.ProcessMatrix())
+		FP.Mul(&ΓQ, ΓAug.T())
+		PAug.Add(PAug, &FP)
+		if k%10 != 9 {
+			// [x - x̂; c] = [A, -K*Hc; 0, I]*[x - x̄; c] - [K; 0]*v
+			K := est.(*HybridKFEstimate).Gain()
+			A := mat64.NewDense(3, 3, nil)
+			A.Set(2, 2, 1)
+			for i := 0; i < 2; i++ {
+				A.Set(i, i, 1)
+				A.Set(i, 0, A.At(i, 0)-K.At(i, 0))
+				A.Set(i, 2, -K.At(i, 0))
+			}
+			var AP, KRKt mat64.Dense
+			AP.Mul(A, PAug)
+			PAug.Mul(&AP, A.T())
+			KAug := mat64.NewDense(3, 1, []float64{K.At(0, 0), K.At(1, 0), 0})
+			KRKt.Mul(KAug, noise.MeasurementMatrix())
+			AP.Mul(&KRKt, KAug.T())
+			PAug.Add(PAug, &AP)
+		}
+		hEst := est.(*HybridKFEstimate)
+		Pc := hEst.ConsiderCovariance()
+		Pxc := hEst.ConsiderCrossCovariance()
+		if !mat64.EqualApprox(Pc, PAug.View(0, 0, 2, 2), 1e-9) {
+			t.Fatalf("k=%d: consider covariance differs\n%v\n%v", k, mat64.Formatted(Pc), mat64.Formatted(PAug.View(0, 0, 2, 2)))
+		}
+		if !mat64.EqualApprox(Pxc, PAug.View(0, 2, 2, 1), 1e-9) {
+			t.Fatalf("k=%d: consider cross covariance differs\n%v\n%v", k, mat64.Formatted(Pxc), mat64.Formatted(PAug.View(0, 2, 2, 1)))
+		}
+		for i := 0; i < 2; i++ {
+			if Pc.At(i, i) < est.Covariance().At(i, i) {
+				t.Fatalf("k=%d: consider variance #%d is smaller than the computed one", k, i)
+			}
+		}
+	}
+
+	// Without any consider parameter effect, the consider covariance is the computed one.
+	kf, _, err = NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kf.EnableConsider(Pcc); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 5; k++ {
+		kf.Prepare(Φ, H)
+		est, err := kf.Update(mat64.NewVector(1, []float64{float64(k)}), mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mat64.EqualApprox(est.(*HybridKFEstimate).ConsiderCovariance(), est.Covariance(), 1e-12) {
+			t.Fatalf("k=%d: consider covariance differs without consider partials", k)
+		}
+	}
+}
+
+func TestBatchConsiderMatchesHybrid(t *testing.T) {
+	// Linear problem: the consider covariance of the batch mapped to the last measurement must match the CKF's.
+	Φ := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Θ := mat64.NewDense(2, 2, []float64{0.5, 0, 1, 0})
+	Hc := mat64.NewDense(1, 2, []float64{0, 1})
+	Pcc := mat64.NewSymDense(2, []float64{0.01, 0, 0, 0.09})
+	noise := NewNoiseless(ScaledIdentity(2, 0), ScaledIdentity(1, 0.25))
+	x0Bar := mat64.NewVector(2, nil)
+	P0Bar := ScaledIdentity(2, 10)
+	ys := []float64{0.8, 1.6, 2.1, 2.9, 3.7, 4.2, 5.1}
+
+	ckf, _, err := NewHybridKF(x0Bar, P0Bar, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ckf.EnableConsider(Pcc); err != nil {
+		t.Fatal(err)
+	}
+	batch := NewBatchKF(len(ys), noise)
+	if err = batch.SetAPriori(x0Bar, P0Bar); err != nil {
+		t.Fatal(err)
+	}
+	if err = batch.EnableConsider(Pcc); err != nil {
+		t.Fatal(err)
+	}
+	var est Estimate
+	for _, y := range ys {
+		ckf.Prepare(Φ, H)
+		if err = ckf.PrepareConsider(Θ, Hc); err != nil {
+			t.Fatal(err)
+		}
+		if est, err = ckf.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if err = batch.PrepareConsider(Θ, Hc); err != nil {
+			t.Fatal(err)
+		}
+		if err = batch.SetNextMeasurement(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil), Φ, H); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, P0, err := batch.Solve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Pc0, Pxc0, err := batch.ConsiderCovariance(P0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if Pc0.At(i, i) <= P0.At(i, i) {
+			t.Fatalf("consider variance #%d is not larger than the computed one", i)
+		}
+	}
+	// Map the consider covariance to the last measurement, accounting for the consider parameters' sensitivity.
+	Pck, Pxck := considerTimeUpdate(batch.Φ0, Pc0, Pxc0, batch.Θ0, Pcc, nil)
+	hEst := est.(*HybridKFEstimate)
+	if !mat64.EqualApprox(Pck, hEst.ConsiderCovariance(), 1e-9) {
+		t.Fatalf("batch consider covariance differs from CKF\n%v\n%v", mat64.Formatted(Pck), mat64.Formatted(hEst.ConsiderCovariance()))
+	}
+	if !mat64.EqualApprox(Pxck, hEst.ConsiderCrossCovariance(), 1e-9) {
+		t.Fatalf("batch consider cross covariance differs from CKF\n%v\n%v", mat64.Formatted(Pxck), mat64.Formatted(hEst.ConsiderCrossCovariance()))
+	}
+
+	// Iterating the batch reports the consider covariance too.
+	observe := func(X0 *mat64.Vector, kf *BatchKF) error {
+		X := mat64.NewVector(2, nil)
+		X.CloneVec(X0)
+		for _, y := range ys {
+			X.MulVec(Φ, X)
+			if err := kf.PrepareConsider(Θ, Hc); err != nil {
+				return err
+			}
+			if err := kf.SetNextMeasurement(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, []float64{X.At(0, 0)}), Φ, H); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	iterated := NewBatchKF(len(ys), noise)
+	if err = iterated.EnableConsider(Pcc); err != nil {
+		t.Fatal(err)
+	}
+	result, err := iterated.Iterate(mat64.NewVector(2, nil), observe, 3, 1e-6, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ConsiderCovariance == nil || result.ConsiderCrossCovariance == nil {
+		t.Fatal("the iterated batch did not report the consider covariance")
+	}
+	if result.ConsiderCovariance.At(1, 1) <= result.Covariance.At(1, 1) {
+		t.Fatal("the iterated consider variance is not larger than the computed one")
+	}
+}
+
+func TestConsiderErrors(t *testing.T) {
+	noise := NewNoiseless(ScaledIdentity(2, 0), ScaledIdentity(1, 0.25))
+	Pcc := mat64.NewSymDense(1, []float64{1})
+	kf, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 1), noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kf.PrepareConsider(nil, nil); err == nil {
+		t.Fatal("preparing the consider partials while disabled did not fail")
+	}
+	if err = kf.EnableConsider(nil); err == nil {
+		t.Fatal("nil consider covariance did not fail")
+	}
+	if err = kf.EnableConsider(Pcc); err != nil {
+		t.Fatal(err)
+	}
+	if err = kf.PrepareConsider(mat64.NewDense(2, 2, nil), nil); err == nil {
+		t.Fatal("invalid Θ columns did not fail")
+	}
+	if err = kf.PrepareConsider(mat64.NewDense(3, 1, nil), nil); err == nil {
+		t.Fatal("invalid Θ rows did not fail")
+	}
+	if err = kf.PrepareConsider(nil, mat64.NewDense(1, 2, nil)); err == nil {
+		t.Fatal("invalid Hc columns did not fail")
+	}
+	kf.Prepare(DenseIdentity(2), mat64.NewDense(1, 2, []float64{1, 0}))
+	if err = kf.PrepareConsider(nil, mat64.NewDense(2, 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kf.Update(mat64.NewVector(1, nil), mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("invalid Hc rows did not fail")
+	}
+	kf.EnableSequential()
+	if err = kf.PrepareConsider(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kf.Update(mat64.NewVector(1, nil), mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("consider analysis with sequential measurements did not fail")
+	}
+	kf.DisableConsider()
+	if kf.ConsiderEnabled() {
+		t.Fatal("consider analysis not disabled")
+	}
+
+	batch := NewBatchKF(1, noise)
+	if _, _, err = batch.ConsiderCovariance(ScaledIdentity(2, 1)); err == nil {
+		t.Fatal("consider covariance while disabled did not fail")
+	}
+	if err = batch.SetNextMeasurement(mat64.NewVector(1, nil), mat64.NewVector(1, nil), DenseIdentity(2), mat64.NewDense(1, 2, []float64{1, 0})); err != nil {
+		t.Fatal(err)
+	}
+	if err = batch.EnableConsider(Pcc); err == nil {
+		t.Fatal("enabling the consider analysis after a measurement did not fail")
+	}
+}
@@ -0,0 +1,298 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// checkCrossCovariance returns an error if the cross covariance S is not of size rows(Q) x rows(R).
+func checkCrossCovariance(Q, R mat64.Symmetric, S mat64.Matrix) error {
+	if S == nil {
+		return errors.New("cross covariance S must be provided")
+	}
+	if err := checkMatDims(S, Q, "S", "Q", rows2rows); err != nil {
+		return err
+	}
+	return checkMatDims(S, R, "S", "R", cols2cols)
+}
+
+// jointCovariance returns the covariance [Q S; Sᵀ R] of the process and measurement noises.
+func jointCovariance(Q, R mat64.Symmetric, S mat64.Matrix) *mat64.SymDense {
+	q, _ := Q.Dims()
+	r, _ := R.Dims()
+	joint := mat64.NewSymDense(q+r, nil)
+	for i := 0; i < q; i++ {
+		for j := i; j < q; j++ {
+			joint.SetSym(i, j, Q.At(i, j))
+		}
+		for j := 0; j < r; j++ {
+			joint.SetSym(i, q+j, S.At(i, j))
+		}
+	}
+	for i := 0; i < r; i++ {
+		for j := i; j < r; j++ {
+			joint.SetSym(q+i, q+j, R.At(i, j))
+		}
+	}
+	return joint
+}
+
+// kalmanGain returns the gain K = (P̄*Hᵀ + M)*(H*P̄*Hᵀ + H*M + Mᵀ*Hᵀ + R)⁻¹ and the innovation covariance, where M is
+// the cross covariance of the prediction error and the measurement noise, or nil if they are independent.
+func kalmanGain(PBar, H mat64.Matrix, R mat64.Symmetric, M mat64.Matrix) (K, S *mat64.Dense, err error) {
+	var PHt, HPHt, SInv mat64.Dense
+	PHt.Mul(PBar, H.T())
+	if M != nil {
+		PHt.Add(&PHt, M)
+	}
+	HPHt.Mul(H, &PHt)
+	if M != nil {
+		var MtHt mat64.Dense
+		MtHt.Mul(M.T(), H.T())
+		HPHt.Add(&HPHt, &MtHt)
+	}
+	HPHt.Add(&HPHt, R)
+	if err = SInv.Inverse(&HPHt); err != nil {
+		return nil, nil, err
+	}
+	K = new(mat64.Dense)
+	K.Mul(&PHt, &SInv)
+	return K, &HPHt, nil
+}
+
+// josephCovariance returns the updated covariance (I - K*H)*P̄*(I - K*H)ᵀ + K*R*Kᵀ, minus (I - K*H)*M*Kᵀ and its
+// transpose if the cross covariance M of the prediction error and the measurement noise is not nil.
+func josephCovariance(K, H, PBar mat64.Matrix, R mat64.Symmetric, M mat64.Matrix) *mat64.Dense {
+	var P, Ptmp, IKH, KR, KRKt mat64.Dense
+	IKH.Mul(K, H)
+	n, _ := IKH.Dims()
+	IKH.Sub(Identity(n), &IKH)
+	Ptmp.Mul(&IKH, PBar)
+	P.Mul(&Ptmp, IKH.T())
+	KR.Mul(K, R)
+	KRKt.Mul(&KR, K.T())
+	P.Add(&P, &KRKt)
+	if M != nil {
+		var IKHM, IKHMKt mat64.Dense
+		IKHM.Mul(&IKH, M)
+		IKHMKt.Mul(&IKHM, K.T())
+		P.Sub(&P, &IKHMKt)
+		P.Sub(&P, IKHMKt.T())
+	}
+	return &P
+}
+
+// correlatedSqrtUpdate returns the lower triangular factors of the innovation covariance Syy*Syyᵀ and of the updated
+// covariance Skp1Plus*Skp1Plusᵀ, and W such that the gain is W*Syy⁻¹, from the factor L of the previous covariance and
+// the factor J = [Jw; Jv] of the joint covariance [Q S; Sᵀ R]. The time and measurement updates are computed at once
+// by a QR decomposition of the array [H*F*L  H*Jw+Jv; F*L  Jw]ᵀ.
+func correlatedSqrtUpdate(F, H, L, J mat64.Matrix) (Syy, W, Skp1Plus *mat64.Dense) {
+	n, _ := L.Dims()
+	p, _ := H.Dims()
+	var FL, HFL, HJw, HJwJv mat64.Dense
+	FL.Mul(F, L)
+	HFL.Mul(H, &FL)
+	Jw := mat64.DenseCopyOf(J).View(0, 0, n, n+p)
+	Jv := mat64.DenseCopyOf(J).View(n, 0, p, n+p)
+	HJw.Mul(H, Jw)
+	HJwJv.Add(&HJw, Jv)
+	// The array is transposed directly, such that its QR decomposition gives the upper triangular factors.
+	At := mat64.NewDense(2*n+p, p+n, nil)
+	for i := 0; i < n; i++ {
+		for j := 0; j < p; j++ {
+			At.Set(i, j, HFL.At(j, i))
+		}
+		for j := 0; j < n; j++ {
+			At.Set(i, p+j, FL.At(j, i))
+		}
+	}
+	for i := 0; i < n+p; i++ {
+		for j := 0; j < p; j++ {
+			At.Set(n+i, j, HJwJv.At(j, i))
+		}
+		for j := 0; j < n; j++ {
+			At.Set(n+i, p+j, Jw.At(j, i))
+		}
+	}
+	var qr mat64.QR
+	qr.Factorize(At)
+	var U mat64.Dense
+	U.RFromQR(&qr)
+	Syy, W, Skp1Plus = new(mat64.Dense), new(mat64.Dense), new(mat64.Dense)
+	Syy.Clone(U.View(0, 0, p, p).T())
+	W.Clone(U.View(0, p, p, n).T())
+	Skp1Plus.Clone(U.View(p, p, n, n).T())
+	return
+}
+
+// AugmentColoredMeasurementNoise returns the matrices of the linear system whose state is augmented with a coloured
+// measurement noise v_{k+1} = Ψ*v_k + ζ_k, where ζ_k ~ N(0, Qζ) (e.g. from MarkovNoise's Transition and Covariance),
+// such that the augmented measurement y = [H I]*[x; v] has no measurement noise. The augmented filter is then
+// initialized with the state [x0; 0] and the block diagonal covariance of P0 and that of v_0.
+// G may be nil if there is no control, in which case Ga is a zero matrix (of a single column), i.e. the augmented
+// filters do not use the control either.
+func AugmentColoredMeasurementNoise(F, G, H mat64.Matrix, Q mat64.Symmetric, Ψ mat64.Matrix, Qζ mat64.Symmetric) (Fa, Ga, Ha *mat64.Dense, Qa *mat64.SymDense, err error) {
+	if err = checkMatDims(F, Q, "F", "Q", rowsAndcols); err != nil {
+		return
+	}
+	if err = checkMatDims(H, F, "H", "F", cols2cols); err != nil {
+		return
+	}
+	if err = checkMatDims(Ψ, Qζ, "Ψ", "Qζ", rowsAndcols); err != nil {
+		return
+	}
+	if err = checkMatDims(H, Ψ, "H", "Ψ", rows2rows); err != nil {
+		return
+	}
+	n, _ := F.Dims()
+	p, _ := H.Dims()
+	Fa = mat64.NewDense(n+p, n+p, nil)
+	Ha = mat64.NewDense(p, n+p, nil)
+	Qa = mat64.NewSymDense(n+p, nil)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			Fa.Set(i, j, F.At(i, j))
+			if j >= i {
+				Qa.SetSym(i, j, Q.At(i, j))
+			}
+		}
+	}
+	for i := 0; i < p; i++ {
+		for j := 0; j < p; j++ {
+			Fa.Set(n+i, n+j, Ψ.At(i, j))
+			if j >= i {
+				Qa.SetSym(n+i, n+j, Qζ.At(i, j))
+			}
+		}
+		for j := 0; j < n; j++ {
+			Ha.Set(i, j, H.At(i, j))
+		}
+		Ha.Set(i, n+i, 1)
+	}
+	if IsNil(G) {
+		Ga = mat64.NewDense(n+p, 1, nil)
+		return
+	}
+	if err = checkMatDims(G, F, "G", "F", rows2rows); err != nil {
+		return
+	}
+	_, m := G.Dims()
+	Ga = mat64.NewDense(n+p, m, nil)
+	Ga.View(0, 0, n, m).(*mat64.Dense).Copy(G)
+	return
+}
+
+// MeasurementDifferencing handles a coloured measurement noise v_k = Ψ*v_{k-1} + ζ_{k-1}, where ζ ~ N(0, Qζ), in a
+// linear filter without augmenting its state, by processing the differenced measurements z_k = y_k - Ψ*y_{k-1}.
+// As x_{k-1} = F⁻¹*(x_k - G*u_{k-1} - w_{k-1}), z_k = H'*x_k + Ψ*H*F⁻¹*G*u_{k-1} + v'_k where H' = H - Ψ*H*F⁻¹.
+// The noise v'_k = Ψ*H*F⁻¹*w_{k-1} + ζ_{k-1} is white, of covariance R' = Ψ*H*F⁻¹*Q*F⁻ᵀ*Hᵀ*Ψᵀ + Qζ, but correlated
+// with the process noise: S' = Q*F⁻ᵀ*Hᵀ*Ψᵀ. The first measurement, y_1 = H*x_1 + Ψ*v_0 + ζ_0, is processed as is.
+// Use NewMeasurementDifferencing to initialize, and Update instead of the filter's Update.
+type MeasurementDifferencing struct {
+	H, Hd      mat64.Matrix  // Measurement matrices of the first and of the differenced measurements
+	Ψ, ΨHFinvG *mat64.Dense  // ΨHFinvG is nil without control
+	noise0     Noise         // Noise of the first measurement
+	noise      Noise         // Noise of the differenced measurements
+	prev       *mat64.Vector // Previous measurement, nil prior to the first one
+}
+
+// NewMeasurementDifferencing returns a new MeasurementDifferencing for the linear system (F, G, H) of process noise Q,
+// where F must be invertible and G may be nil, and the measurement noise v_k = Ψ*v_{k-1} + ζ_{k-1}, where ζ ~ N(0, Qζ)
+// and v_0 ~ N(0, Pv0) (e.g. from MarkovNoise's Transition, Covariance and InitialCovariance).
+func NewMeasurementDifferencing(F, G, H mat64.Matrix, Q mat64.Symmetric, Ψ mat64.Matrix, Qζ, Pv0 mat64.Symmetric) (*MeasurementDifferencing, error) {
+	if err := checkMatDims(F, Q, "F", "Q", rowsAndcols); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(H, F, "H", "F", cols2cols); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(Ψ, Qζ, "Ψ", "Qζ", rowsAndcols); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(Ψ, Pv0, "Ψ", "Pv0", rowsAndcols); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(H, Ψ, "H", "Ψ", rows2rows); err != nil {
+		return nil, err
+	}
+	var Finv mat64.Dense
+	if err := Finv.Inverse(F); err != nil {
+		return nil, fmt.Errorf("F is not invertible: %s", err)
+	}
+	// R_1 = Ψ*Pv0*Ψᵀ + Qζ
+	var ΨPv0, R1 mat64.Dense
+	ΨPv0.Mul(Ψ, Pv0)
+	R1.Mul(&ΨPv0, Ψ.T())
+	R1.Add(&R1, Qζ)
+	// H' = H - Ψ*H*F⁻¹, S' = Q*(Ψ*H*F⁻¹)ᵀ and R' = Ψ*H*F⁻¹*S' + Qζ
+	var ΨH, ΨHFinv, Hd, Sd, Rd mat64.Dense
+	ΨH.Mul(Ψ, H)
+	ΨHFinv.Mul(&ΨH, &Finv)
+	Hd.Sub(H, &ΨHFinv)
+	Sd.Mul(Q, ΨHFinv.T())
+	Rd.Mul(&ΨHFinv, &Sd)
+	Rd.Add(&Rd, Qζ)
+	var ΨHFinvG *mat64.Dense
+	if !IsNil(G) {
+		if err := checkMatDims(G, F, "G", "F", rows2rows); err != nil {
+			return nil, err
+		}
+		ΨHFinvG = new(mat64.Dense)
+		ΨHFinvG.Mul(&ΨHFinv, G)
+	}
+	R1Sym, err := AsSymDense(&R1)
+	if err != nil {
+		return nil, err
+	}
+	RdSym, err := AsSymDense(&Rd)
+	if err != nil {
+		return nil, err
+	}
+	noise, err := NewCorrelatedNoiseless(Q, RdSym, &Sd)
+	if err != nil {
+		return nil, err
+	}
+	return &MeasurementDifferencing{H, &Hd, mat64.DenseCopyOf(Ψ), ΨHFinvG, NewNoiseless(Q, R1Sym), noise, nil}, nil
+}
+
+// Difference returns the measurement to process instead of the provided one, and its measurement matrix and noise.
+// The control is that of the time update to the epoch of the measurement.
+func (d *MeasurementDifferencing) Difference(measurement, control *mat64.Vector) (z *mat64.Vector, H mat64.Matrix, noise Noise, err error) {
+	if err = checkMatDims(measurement, d.Ψ, "measurement (y)", "Ψ", rows2rows); err != nil {
+		return nil, nil, nil, err
+	}
+	prev := d.prev
+	d.prev = measurement
+	if prev == nil {
+		return measurement, d.H, d.noise0, nil
+	}
+	// z_k = y_k - Ψ*y_{k-1} - Ψ*H*F⁻¹*G*u_{k-1}
+	z = mat64.NewVector(measurement.Len(), nil)
+	z.MulVec(d.Ψ, prev)
+	z.SubVec(measurement, z)
+	if d.ΨHFinvG != nil && control != nil {
+		var Du mat64.Vector
+		Du.MulVec(d.ΨHFinvG, control)
+		z.SubVec(z, &Du)
+	}
+	return z, d.Hd, d.noise, nil
+}
+
+// Update sets the measurement matrix and the noise of the filter for the provided measurement, and updates the filter
+// with the differenced measurement. The estimate's measurement and innovation are those of the differenced measurement.
+func (d *MeasurementDifferencing) Update(kf LDKF, measurement, control *mat64.Vector) (Estimate, error) {
+	z, H, noise, err := d.Difference(measurement, control)
+	if err != nil {
+		return nil, err
+	}
+	kf.SetMeasurementMatrix(H)
+	kf.SetNoise(noise)
+	return kf.Update(z, control)
+}
+
+// Reset restarts the differencing, e.g. after resetting the filter.
+func (d *MeasurementDifferencing) Reset() {
+	d.prev = nil
+}
@@ -0,0 +1,398 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestCorrelatedAWGN(t *testing.T) {
+	Q := mat64.NewSymDense(2, []float64{1, 0, 0, 2})
+	R := mat64.NewSymDense(1, []float64{0.5})
+	S := mat64.NewDense(2, 1, []float64{0.4, -0.6})
+	n, err := NewCorrelatedAWGN(Q, R, S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Seed(2017)
+	samples := 50000
+	cross := mat64.NewDense(2, 1, nil)
+	var vv float64
+	for k := 0; k < samples; k++ {
+		w := n.Process(k)
+		v := n.Measurement(k)
+		var wvt mat64.Dense
+		wvt.Mul(w, v.T())
+		wvt.Scale(1/float64(samples), &wvt)
+		cross.Add(cross, &wvt)
+		vv += v.At(0, 0) * v.At(0, 0) / float64(samples)
+	}
+	if !mat64.EqualApprox(cross, S, 0.03) {
+		t.Fatalf("invalid cross covariance\n%v", mat64.Formatted(cross))
+	}
+	if math.Abs(vv-0.5) > 0.03 {
+		t.Fatalf("invalid measurement noise variance %f", vv)
+	}
+	if !mat64.Equal(n.CrossCovariance(), S) || NewAWGN(Q, R).CrossCovariance() != nil || NewNoiseless(Q, R).CrossCovariance() != nil {
+		t.Fatal("invalid cross covariance")
+	}
+	if _, err := NewCorrelatedAWGN(Q, R, mat64.NewDense(1, 1, nil)); err == nil {
+		t.Fatal("cross covariance of an invalid size should fail")
+	}
+	if _, err := NewCorrelatedAWGN(Q, R, mat64.NewDense(2, 1, []float64{2, 0})); err == nil {
+		t.Fatal("cross covariance leading to a joint covariance which is not positive definite should fail")
+	}
+	if _, err := NewCorrelatedNoiseless(Q, R, mat64.NewDense(2, 2, nil)); err == nil {
+		t.Fatal("cross covariance of an invalid size should fail")
+	}
+}
+
+// correlatedRobot returns the linear robot with noises, where the process noise over each time update is correlated
+// with the measurement noise of the next measurement, and noisy measurements of it.
+func correlatedRobot(t *testing.T) (F, G mat64.Matrix, H *mat64.Dense, Q, R *mat64.SymDense, S *mat64.Dense, x0 *mat64.Vector, truth *BatchGroundTruth) {
+	F, G, _ = Robot1DMatrices()
+	H = mat64.NewDense(1, 2, []float64{1, 0})
+	Q = mat64.NewSymDense(2, []float64{1e-3, 0, 0, 2e-3})
+	R = mat64.NewSymDense(1, []float64{1e-2})
+	S = mat64.NewDense(2, 1, []float64{2e-3, 3e-3})
+	x0 = mat64.NewVector(2, []float64{0, 0.35})
+	noise, err := NewCorrelatedAWGN(Q, R, S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noise.Seed(2017)
+	sim, err := NewSimulator(x0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truth, err = sim.Run(30, nil); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestCorrelatedFilters(t *testing.T) {
+	F, G, H, Q, R, S, x0, truth := correlatedRobot(t)
+	P0 := ScaledIdentity(2, 0.5)
+	noise, err := NewCorrelatedNoiseless(Q, R, S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqrt, _, err := NewSquareRoot(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hkf, _, err := NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncorrelated, _, err := NewVanilla(x0, P0, F, G, H, NewNoiseless(Q, R))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mat64.NewVector(1, nil)
+	for k, y := range truth.Measurements() {
+		vEst, err := vanilla.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sEst, err := sqrt.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hkf.Prepare(mat64.DenseCopyOf(F), H)
+		hkf.PreparePNT(DenseIdentity(2))
+		hEst, err := hkf.Update(y, mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		uEst, err := uncorrelated.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, est := range []Estimate{sEst, hEst} {
+			if !mat64.EqualApprox(est.State(), vEst.State(), 1e-10) || !mat64.EqualApprox(est.Covariance(), vEst.Covariance(), 1e-10) {
+				t.Fatalf("k=%d: filter #%d differs from the vanilla KF\n%v\n%v", k, i, mat64.Formatted(est.Covariance()), mat64.Formatted(vEst.Covariance()))
+			}
+		}
+		if k == 0 {
+			// P⁺ = P̄ - K*(H*P̄ + Sᵀ) with K = (P̄*Hᵀ + S)*(H*P̄*Hᵀ + H*S + Sᵀ*Hᵀ + R)⁻¹
+			var PBar, PHt, KHPS mat64.Dense
+			PBar.Mul(F, P0)
+			PBar.Mul(&PBar, F.T())
+			PBar.Add(&PBar, Q)
+			PHt.Mul(&PBar, H.T())
+			PHt.Add(&PHt, S)
+			var HPHt mat64.Dense
+			HPHt.Mul(H, &PHt)
+			s := HPHt.At(0, 0) + mat64.Dot(S.ColView(0), H.RowView(0)) + R.At(0, 0)
+			KHPS.Mul(&PHt, PHt.T())
+			KHPS.Scale(1/s, &KHPS)
+			PBar.Sub(&PBar, &KHPS)
+			if !mat64.EqualApprox(&PBar, vEst.Covariance(), 1e-12) {
+				t.Fatalf("invalid covariance\n%v\n%v", mat64.Formatted(&PBar), mat64.Formatted(vEst.Covariance()))
+			}
+		}
+		if k > 0 && mat64.EqualApprox(uEst.Covariance(), vEst.Covariance(), 1e-12) {
+			t.Fatalf("k=%d: the cross covariance was ignored", k)
+		}
+	}
+}
+
+func TestCorrelatedMonteCarlo(t *testing.T) {
+	// The covariance of the correlated filter must match its actual errors, and be lower than that of a filter which
+	// ignores the correlation.
+	F, G, H, Q, R, S, x0, _ := correlatedRobot(t)
+	P0 := Q
+	noise, err := NewCorrelatedAWGN(Q, R, S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noise.Seed(5044)
+	correlated, err := NewCorrelatedNoiseless(Q, R, S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, steps := 500, 10
+	var mse, mseUncorrelated, trace float64
+	u := mat64.NewVector(1, nil)
+	for r := 0; r < runs; r++ {
+		// The initial state is drawn from the initial estimate.
+		xTrue := mat64.NewVector(2, nil)
+		xTrue.AddVec(x0, noise.Process(0))
+		noise.Measurement(0) // Draw the correlated sample to keep the following samples aligned.
+		sim, err := NewSimulator(xTrue, F, G, H, noise)
+		if err != nil {
+			t.Fatal(err)
+		}
+		truth, err := sim.Run(steps, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kf, _, _ := NewVanilla(x0, P0, F, G, H, correlated)
+		ukf, _, _ := NewVanilla(x0, P0, F, G, H, NewNoiseless(Q, R))
+		var est, uest Estimate
+		for _, y := range truth.Measurements() {
+			est, _ = kf.Update(y, u)
+			uest, _ = ukf.Update(y, u)
+		}
+		var e, ue mat64.Vector
+		e.SubVec(truth.States()[steps-1], est.State())
+		ue.SubVec(truth.States()[steps-1], uest.State())
+		mse += mat64.Dot(&e, &e) / float64(runs)
+		mseUncorrelated += mat64.Dot(&ue, &ue) / float64(runs)
+		trace = est.Covariance().At(0, 0) + est.Covariance().At(1, 1)
+	}
+	if math.Abs(mse-trace)/trace > 0.15 {
+		t.Fatalf("MSE %e does not match the trace of the covariance %e", mse, trace)
+	}
+	if mse >= mseUncorrelated {
+		t.Fatalf("MSE %e is not lower than that of the uncorrelated filter %e", mse, mseUncorrelated)
+	}
+}
+
+func TestColoredMeasurementNoise(t *testing.T) {
+	// Differencing the measurements and augmenting the state are both optimal, so they must match.
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Q := mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3})
+	process, err := NewWhiteNoise(Q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colored, err := NewGaussMarkovNoise([]float64{0.5}, []float64{0.2}, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noise, _ := NewSequenceNoise(process, colored)
+	noise.Seed(2017)
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	sim, err := NewSimulator(x0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mat64.NewVector(1, []float64{0.2})
+	truth, err := sim.Run(40, []*mat64.Vector{u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ψ, Qζ, Pv0 := colored.Transition(), colored.Covariance(), colored.InitialCovariance()
+	P0 := ScaledIdentity(2, 0.1)
+
+	diff, err := NewMeasurementDifferencing(F, G, H, Q, Ψ, Qζ, Pv0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf, _, err := NewVanilla(x0, P0, F, G, H, NewNoiseless(Q, Qζ))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Fa, Ga, Ha, Qa, err := AugmentColoredMeasurementNoise(F, G, H, Q, Ψ, Qζ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xa0 := mat64.NewVector(3, []float64{x0.At(0, 0), x0.At(1, 0), 0})
+	Pa0 := mat64.NewSymDense(3, nil)
+	Pa0.SetSym(0, 0, P0.At(0, 0))
+	Pa0.SetSym(1, 1, P0.At(1, 1))
+	Pa0.SetSym(2, 2, Pv0.At(0, 0))
+	akf, _, err := NewVanilla(xa0, Pa0, Fa, Ga, Ha, NewNoiseless(Qa, mat64.NewSymDense(1, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	white, _, err := NewVanilla(x0, P0, F, G, H, NewNoiseless(Q, Pv0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sse, sseWhite float64
+	for k, y := range truth.Measurements() {
+		est, err := diff.Update(kf, y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aest, err := akf.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		west, err := white.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if math.Abs(est.State().At(i, 0)-aest.State().At(i, 0)) > 1e-8 {
+				t.Fatalf("k=%d: differenced and augmented states differ\n%v\n%v", k, mat64.Formatted(est.State()), mat64.Formatted(aest.State()))
+			}
+			for j := 0; j < 2; j++ {
+				if math.Abs(est.Covariance().At(i, j)-aest.Covariance().At(i, j)) > 1e-10 {
+					t.Fatalf("k=%d: differenced and augmented covariances differ\n%v\n%v", k, mat64.Formatted(est.Covariance()), mat64.Formatted(aest.Covariance()))
+				}
+			}
+		}
+		e := truth.States()[k].At(0, 0) - est.State().At(0, 0)
+		we := truth.States()[k].At(0, 0) - west.State().At(0, 0)
+		sse += e * e
+		sseWhite += we * we
+	}
+	if sse >= sseWhite {
+		t.Fatalf("handling the coloured noise did not improve the estimates: %e >= %e", sse, sseWhite)
+	}
+	// A reset restarts the differencing.
+	diff.Reset()
+	if _, H1, noise1, _ := diff.Difference(truth.Measurements()[0], u); !mat64.Equal(H1, H) || noise1.CrossCovariance() != nil {
+		t.Fatal("the first measurement after a reset must not be differenced")
+	}
+}
+
+func TestColoredMeasurementNoiseNoControl(t *testing.T) {
+	F, _, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Q := mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3})
+	colored, err := NewGaussMarkovNoise([]float64{0.5}, []float64{0.2}, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ψ, Qζ, Pv0 := colored.Transition(), colored.Covariance(), colored.InitialCovariance()
+	Fa, Ga, Ha, Qa, err := AugmentColoredMeasurementNoise(F, nil, H, Q, Ψ, Qζ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := Ga.Dims(); r != 3 || !IsNil(Ga) {
+		t.Fatalf("invalid augmented control matrix without control\n%v", mat64.Formatted(Ga))
+	}
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 0.1)
+	G := mat64.NewDense(2, 1, nil)
+	diff, err := NewMeasurementDifferencing(F, nil, H, Q, Ψ, Qζ, Pv0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf, _, err := NewVanilla(x0, P0, F, G, H, NewNoiseless(Q, Qζ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xa0 := mat64.NewVector(3, []float64{x0.At(0, 0), x0.At(1, 0), 0})
+	Pa0 := mat64.NewSymDense(3, nil)
+	Pa0.SetSym(0, 0, P0.At(0, 0))
+	Pa0.SetSym(1, 1, P0.At(1, 1))
+	Pa0.SetSym(2, 2, Pv0.At(0, 0))
+	akf, _, err := NewVanilla(xa0, Pa0, Fa, Ga, Ha, NewNoiseless(Qa, mat64.NewSymDense(1, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mat64.NewVector(1, nil)
+	for k := 0; k < 20; k++ {
+		y := mat64.NewVector(1, []float64{0.035*float64(k) + 0.1*math.Sin(float64(k))})
+		est, err := diff.Update(kf, y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aest, err := akf.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if math.Abs(est.State().At(i, 0)-aest.State().At(i, 0)) > 1e-8 {
+				t.Fatalf("k=%d: differenced and augmented states differ\n%v\n%v", k, mat64.Formatted(est.State()), mat64.Formatted(aest.State()))
+			}
+		}
+	}
+}
+
+func TestCorrelatedErrors(t *testing.T) {
+	F, G, H, Q, R, S, x0, truth := correlatedRobot(t)
+	P0 := ScaledIdentity(2, 0.5)
+	noise, err := NewCorrelatedNoiseless(Q, R, S)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y := truth.Measurements()[0]
+	u := mat64.NewVector(1, nil)
+	vanilla, _, _ := NewVanilla(x0, P0, F, G, H, noise)
+	vanilla.EnableSequential()
+	if _, err := vanilla.Update(y, u); err == nil {
+		t.Fatal("sequential vanilla KF with correlated noises did not fail")
+	}
+	sqrt, _, _ := NewSquareRoot(x0, P0, F, G, H, noise)
+	sqrt.EnableSequential()
+	if _, err := sqrt.Update(y, u); err == nil {
+		t.Fatal("sequential square root KF with correlated noises did not fail")
+	}
+	hkf, _, _ := NewHybridKF(x0, P0, noise, 1)
+	hkf.EnableSequential()
+	hkf.Prepare(mat64.DenseCopyOf(F), H)
+	hkf.PreparePNT(DenseIdentity(2))
+	if _, err := hkf.Update(y, mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("sequential hybrid KF with correlated noises did not fail")
+	}
+	hkf.DisableSequential()
+	if err := hkf.EnableConsider(ScaledIdentity(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	hkf.Prepare(mat64.DenseCopyOf(F), H)
+	hkf.PreparePNT(DenseIdentity(2))
+	if _, err := hkf.Update(y, mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("consider analysis with correlated noises did not fail")
+	}
+	hkf.DisableConsider()
+	hkf.Prepare(mat64.DenseCopyOf(F), H)
+	hkf.PreparePNT(mat64.NewDense(2, 3, nil))
+	if _, err := hkf.Update(y, mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("invalid Γ did not fail")
+	}
+	Ψ := ScaledIdentity(1, 0.5)
+	if _, err := NewMeasurementDifferencing(mat64.NewDense(2, 2, nil), G, H, Q, Ψ, Ψ, Ψ); err == nil {
+		t.Fatal("singular F did not fail")
+	}
+	if _, err := NewMeasurementDifferencing(F, G, H, Q, ScaledIdentity(2, 0.5), Ψ, Ψ); err == nil {
+		t.Fatal("invalid Ψ did not fail")
+	}
+	if _, _, _, _, err := AugmentColoredMeasurementNoise(F, G, mat64.NewDense(1, 3, nil), Q, Ψ, Ψ); err == nil {
+		t.Fatal("invalid H did not fail")
+	}
+}
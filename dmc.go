@@ -0,0 +1,176 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// DMC defines the dynamic model compensation (cf. "Statistical Orbit determination" by Tapley, Schutz & Born, section 4.9).
+// The state is augmented with one first order Gauss–Markov acceleration per axis, which absorbs the unmodelled accelerations.
+// The base state must be made of the positions followed by the velocities of each axis, e.g. [x y z vx vy vz].
+type DMC struct {
+	τ []float64 // Time constants of each acceleration
+	σ []float64 // Steady state standard deviation of each acceleration
+}
+
+// NewDMC returns a new dynamic model compensation with the provided time constants τ and steady state standard deviations σ
+// of each acceleration. The power spectral density of the driving white noise is 2σ²/τ.
+func NewDMC(τ, σ []float64) (*DMC, error) {
+	if len(τ) == 0 {
+		return nil, errors.New("at least one time constant must be provided")
+	}
+	if len(τ) != len(σ) {
+		return nil, fmt.Errorf("τ and σ must have the same length: %d != %d", len(τ), len(σ))
+	}
+	for i := range τ {
+		if τ[i] <= 0 {
+			return nil, fmt.Errorf("time constant τ[%d] must be strictly positive", i)
+		}
+		if σ[i] < 0 {
+			return nil, fmt.Errorf("standard deviation σ[%d] must be positive", i)
+		}
+	}
+	return &DMC{append([]float64(nil), τ...), append([]float64(nil), σ...)}, nil
+}
+
+// Size returns the number of DMC accelerations added to the state.
+func (d *DMC) Size() int {
+	return len(d.τ)
+}
+
+// AugmentState returns the augmented initial state and covariance, where the accelerations start at zero with their steady state variance.
+func (d *DMC) AugmentState(x0 *mat64.Vector, P0 mat64.Symmetric) (*mat64.Vector, *mat64.SymDense, error) {
+	if err := checkMatDims(x0, P0, "x0", "P0", rows2cols); err != nil {
+		return nil, nil, err
+	}
+	n := x0.Len()
+	m := d.Size()
+	x := mat64.NewVector(n+m, nil)
+	P := mat64.NewSymDense(n+m, nil)
+	for i := 0; i < n; i++ {
+		x.SetVec(i, x0.At(i, 0))
+		for j := i; j < n; j++ {
+			P.SetSym(i, j, P0.At(i, j))
+		}
+	}
+	for i := 0; i < m; i++ {
+		P.SetSym(n+i, n+i, d.σ[i]*d.σ[i])
+	}
+	return x, P, nil
+}
+
+// STM returns the augmented state transition matrix from the STM Φ of the base state over Δt.
+func (d *DMC) STM(Φ mat64.Matrix, Δt float64) (*mat64.Dense, error) {
+	n, c := Φ.Dims()
+	if n != c {
+		return nil, fmt.Errorf("Φ must be square: %d×%d", n, c)
+	}
+	m := d.Size()
+	if n != 2*m {
+		return nil, fmt.Errorf("base state of size %d is not made of the positions and velocities of %d DMC accelerations", n, m)
+	}
+	ΦAug := mat64.NewDense(n+m, n+m, nil)
+	setBlock(ΦAug, 0, 0, Φ)
+	for i := 0; i < m; i++ {
+		β := 1 / d.τ[i]
+		ΦAug.Set(i, n+i, gmImpulse(β, Δt, 2))
+		ΦAug.Set(m+i, n+i, gmImpulse(β, Δt, 1))
+		ΦAug.Set(n+i, n+i, gmImpulse(β, Δt, 0))
+	}
+	return ΦAug, nil
+}
+
+// Htilde returns the augmented measurement sensitivity matrix: the measurements do not depend on the DMC accelerations.
+func (d *DMC) Htilde(H mat64.Matrix) *mat64.Dense {
+	r, c := H.Dims()
+	HAug := mat64.NewDense(r, c+d.Size(), nil)
+	setBlock(HAug, 0, 0, H)
+	return HAug
+}
+
+// ProcessNoise returns the discrete process noise covariance of the augmented state over Δt, where n is the size of the base state.
+// The position, velocity and acceleration contributions of each axis are integrated analytically.
+func (d *DMC) ProcessNoise(n int, Δt float64) *mat64.SymDense {
+	m := d.Size()
+	Q := mat64.NewSymDense(n+m, nil)
+	for i := 0; i < m; i++ {
+		β := 1 / d.τ[i]
+		q := 2 * β * d.σ[i] * d.σ[i]
+		idx := []int{i, m + i, n + i} // Position, velocity and acceleration.
+		if β*Δt < 1 {
+			// The closed form suffers from catastrophic cancellation for short steps.
+			for a := 0; a < 3; a++ {
+				for b := a; b < 3; b++ {
+					Q.SetSym(idx[a], idx[b], q*gmCovarianceSeries(β, Δt, 2-a, 2-b))
+				}
+			}
+			continue
+		}
+		T := Δt
+		E1 := math.Exp(-β * T)
+		I1 := -math.Expm1(-β*T) / β         // ∫ e^{-βt} dt
+		I2 := -math.Expm1(-2*β*T) / (2 * β) // ∫ e^{-2βt} dt
+		J1 := (1 - E1*(1+β*T)) / (β * β)    // ∫ t e^{-βt} dt
+		β2, β3, β4 := β*β, β*β*β, β*β*β*β
+		Q.SetSym(idx[0], idx[0], q*(T*T*T/(3*β2)+T/β4+I2/β4-T*T/β3+2*J1/β3-2*I1/β4))
+		Q.SetSym(idx[0], idx[1], q/β*(T*T/(2*β)-T/β2+2*I1/β2-J1/β-I2/β2))
+		Q.SetSym(idx[0], idx[2], q*(J1/β-I1/β2+I2/β2))
+		Q.SetSym(idx[1], idx[1], q/β2*(T-2*I1+I2))
+		Q.SetSym(idx[1], idx[2], q/β*(I1-I2))
+		Q.SetSym(idx[2], idx[2], q*I2)
+	}
+	return Q
+}
+
+// gmSeriesTerms is the number of terms used in the series expansions of the Gauss–Markov process, which converge for βt < 1.
+const gmSeriesTerms = 25
+
+// gmImpulse returns the response after t of the acceleration (p=0), velocity (p=1) or position (p=2) to a unit acceleration,
+// i.e. the p-th integral of e^{-βt}: Σ_k (-β)^k t^{k+p}/(k+p)!.
+func gmImpulse(β, t float64, p int) float64 {
+	if β*t >= 1 {
+		switch p {
+		case 0:
+			return math.Exp(-β * t)
+		case 1:
+			return -math.Expm1(-β*t) / β
+		default:
+			return (β*t + math.Expm1(-β*t)) / (β * β)
+		}
+	}
+	sum := 0.0
+	term := math.Pow(t, float64(p)) / float64(factorial(p)) // k = 0
+	for k := 0; k < gmSeriesTerms; k++ {
+		sum += term
+		term *= -β * t / float64(k+p+1)
+	}
+	return sum
+}
+
+// gmCovarianceSeries returns ∫_0^T g_a(t) g_b(t) dt, where g_a and g_b are the impulse responses of orders pa and pb.
+func gmCovarianceSeries(β, T float64, pa, pb int) float64 {
+	sum := 0.0
+	ca := 1 / float64(factorial(pa)) // (-β)^k/(k+pa)!
+	for k := 0; k < gmSeriesTerms; k++ {
+		cb := 1 / float64(factorial(pb))
+		for l := 0; l < gmSeriesTerms-k; l++ {
+			deg := k + l + pa + pb + 1
+			sum += ca * cb * math.Pow(T, float64(deg)) / float64(deg)
+			cb *= -β / float64(l+pb+1)
+		}
+		ca *= -β / float64(k+pa+1)
+	}
+	return sum
+}
+
+// factorial returns n!.
+func factorial(n int) int {
+	f := 1
+	for i := 2; i <= n; i++ {
+		f *= i
+	}
+	return f
+}
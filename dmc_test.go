@@ -0,0 +1,241 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestNewDMCErrors(t *testing.T) {
+	if _, err := NewDMC(nil, nil); err == nil {
+		t.Fatal("empty DMC should fail")
+	}
+	if _, err := NewDMC([]float64{1, 2}, []float64{1}); err == nil {
+		t.Fatal("mismatched τ and σ should fail")
+	}
+	if _, err := NewDMC([]float64{0}, []float64{1}); err == nil {
+		t.Fatal("null τ should fail")
+	}
+	if _, err := NewDMC([]float64{1}, []float64{-1}); err == nil {
+		t.Fatal("negative σ should fail")
+	}
+	dmc, err := NewDMC([]float64{10, 20, 30}, []float64{1e-6, 1e-6, 1e-6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dmc.STM(DenseIdentity(4), 1); err == nil {
+		t.Fatal("base state too small should fail")
+	}
+	if _, err := dmc.STM(DenseIdentity(7), 1); err == nil {
+		t.Fatal("base state with other components than the positions and velocities should fail")
+	}
+	if _, err := dmc.STM(mat64.NewDense(6, 5, nil), 1); err == nil {
+		t.Fatal("non square STM should fail")
+	}
+	if _, err := dmc.STM(DenseIdentity(6), 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDMCSTMAndProcessNoise(t *testing.T) {
+	for _, τ := range []float64{0.5, 10, 30, 1e3} {
+		σ := 1e-3
+		Δt := 10.0
+		dmc, err := NewDMC([]float64{τ}, []float64{σ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		β := 1 / τ
+		// Impulse responses of the position, velocity and acceleration to the driving noise, after t.
+		g := func(t float64) [3]float64 {
+			return [3]float64{t/β - (1-math.Exp(-β*t))/(β*β), (1 - math.Exp(-β*t)) / β, math.Exp(-β * t)}
+		}
+		ΦBase := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+		Φ, err := dmc.STM(ΦBase, Δt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gT := g(Δt)
+		for i := 0; i < 3; i++ {
+			if math.Abs(Φ.At(i, 2)-gT[i]) > 1e-9*math.Abs(gT[i]) {
+				t.Fatalf("τ=%f: Φ[%d][2]=%e instead of %e", τ, i, Φ.At(i, 2), gT[i])
+			}
+		}
+		if !mat64.Equal(Φ.Slice(0, 2, 0, 2), ΦBase) {
+			t.Fatal("base STM was modified")
+		}
+		// Compare with Simpson's rule of q ∫ g(t) g(t)ᵀ dt.
+		q := 2 * β * σ * σ
+		Q := dmc.ProcessNoise(2, Δt)
+		steps := 2000
+		h := Δt / float64(steps)
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				sum := 0.0
+				for s := 0; s <= steps; s++ {
+					w := 2.0
+					if s == 0 || s == steps {
+						w = 1
+					} else if s%2 == 1 {
+						w = 4
+					}
+					gs := g(float64(s) * h)
+					sum += w * gs[i] * gs[j]
+				}
+				exp := q * sum * h / 3
+				if math.Abs(Q.At(i, j)-exp) > 1e-6*math.Abs(exp) {
+					t.Fatalf("τ=%f: Q[%d][%d]=%e instead of %e", τ, i, j, Q.At(i, j), exp)
+				}
+			}
+		}
+	}
+}
+
+func TestDMCHybridKF(t *testing.T) {
+	// 1D motion with an unmodelled constant acceleration, observed in position only.
+	a := 1e-3
+	Δt := 10.0
+	dmc, err := NewDMC([]float64{1e4}, []float64{1e-2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x0, P0, err := dmc.AugmentState(mat64.NewVector(2, nil), ScaledIdentity(2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x0.Len() != 3 || P0.At(2, 2) != 1e-4 || P0.At(0, 0) != 1 {
+		t.Fatalf("invalid augmented state\n%v\n%v", mat64.Formatted(x0.T()), mat64.Formatted(P0))
+	}
+	noise := NewNoiseless(ScaledIdentity(3, 0), mat64.NewSymDense(1, []float64{1e-2}))
+	kf, _, err := NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Φ := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	baseKF, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 1), noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := baseKF.PrepareDMC(dmc, Φ, H, Δt); err == nil {
+		t.Fatal("expected an error with a state which is not augmented")
+	}
+	var est Estimate
+	for k := 1; k <= 100; k++ {
+		tk := float64(k) * Δt
+		if err := kf.PrepareDMC(dmc, Φ, H, Δt); err != nil {
+			t.Fatal(err)
+		}
+		est, err = kf.Update(mat64.NewVector(1, []float64{0.5 * a * tk * tk}), mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if aHat := est.State().At(2, 0); math.Abs(aHat-a) > 3*math.Sqrt(est.Covariance().At(2, 2)) || math.Abs(aHat-a) > 1e-4 {
+		t.Fatalf("DMC acceleration %e does not match %e (σ=%e)", aHat, a, math.Sqrt(est.Covariance().At(2, 2)))
+	}
+	// Without calling PrepareDMC again, the KF must be locked.
+	if _, err := kf.Update(mat64.NewVector(1, nil), mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("KF should be locked")
+	}
+	// Prepare must discard the DMC process noise of a previous PrepareDMC call.
+	if err := kf.PrepareDMC(dmc, Φ, H, Δt); err != nil {
+		t.Fatal(err)
+	}
+	ΦAug, err := dmc.STM(Φ, Δt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf.Prepare(ΦAug, dmc.Htilde(H))
+	kf.PreparePNT(DenseIdentity(3))
+	prevP := est.Covariance()
+	est, err = kf.Predict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ΦP, PBar mat64.Dense
+	ΦP.Mul(ΦAug, prevP)
+	PBar.Mul(&ΦP, ΦAug.T())
+	if !mat64.EqualApprox(est.PredCovariance(), &PBar, 1e-12) {
+		t.Fatalf("DMC process noise used after Prepare\n%v\n%v", mat64.Formatted(est.PredCovariance()), mat64.Formatted(&PBar))
+	}
+}
+
+func TestDMCSRIF(t *testing.T) {
+	// The SRIF with DMC must match the HybridKF with the augmented Φ and the DMC process noise, including when smoothed.
+	a := 1e-3
+	Δt := 10.0
+	dmc, err := NewDMC([]float64{1e3}, []float64{1e-2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x0, P0, err := dmc.AugmentState(mat64.NewVector(2, nil), ScaledIdentity(2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noise := NewNoiseless(ScaledIdentity(3, 0), mat64.NewSymDense(1, []float64{1e-2}))
+	hkf, _, err := NewHybridKF(x0, P0, NewNoiseless(dmc.ProcessNoise(2, Δt), mat64.NewSymDense(1, []float64{1e-2})), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srif, _, err := NewSRIF(x0, P0, 1, false, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Φ := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	ΦAug, err := dmc.STM(Φ, Δt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseSRIF, _, err := NewSRIF(mat64.NewVector(2, nil), ScaledIdentity(2, 1), 1, false, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := baseSRIF.PrepareDMC(dmc, Φ, H, Δt); err == nil {
+		t.Fatal("expected an error with a state which is not augmented")
+	}
+	hkfEsts := make([]*HybridKFEstimate, 20)
+	srifEsts := make([]*SRIFEstimate, 20)
+	for k := range srifEsts {
+		tk := float64(k+1) * Δt
+		hkf.Prepare(ΦAug, dmc.Htilde(H))
+		hkf.PreparePNT(DenseIdentity(3))
+		if err := srif.PrepareDMC(dmc, Φ, H, Δt); err != nil {
+			t.Fatal(err)
+		}
+		realObs := mat64.NewVector(1, []float64{0.5 * a * tk * tk})
+		hEst, err := hkf.Update(realObs, mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sEst, err := srif.Update(realObs, mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mat64.EqualApprox(hEst.State(), sEst.State(), 1e-8) {
+			t.Fatalf("k=%d: SRIF state differs from HybridKF\n%v\n%v", k, mat64.Formatted(hEst.State()), mat64.Formatted(sEst.State()))
+		}
+		if !mat64.EqualApprox(hEst.Covariance(), sEst.Covariance(), 1e-8) {
+			t.Fatalf("k=%d: SRIF covariance differs from HybridKF\n%v\n%v", k, mat64.Formatted(hEst.Covariance()), mat64.Formatted(sEst.Covariance()))
+		}
+		hkfEsts[k] = hEst.(*HybridKFEstimate)
+		srifEsts[k] = sEst.(*SRIFEstimate)
+	}
+	if err := hkf.SmoothAll(hkfEsts); err != nil {
+		t.Fatal(err)
+	}
+	if err := srif.SmoothAll(srifEsts); err != nil {
+		t.Fatal(err)
+	}
+	for k := range srifEsts {
+		if !mat64.EqualApprox(hkfEsts[k].State(), srifEsts[k].State(), 1e-8) {
+			t.Fatalf("k=%d: SRIF smoothed state differs from RTS\n%v\n%v", k, mat64.Formatted(hkfEsts[k].State()), mat64.Formatted(srifEsts[k].State()))
+		}
+	}
+	// Without calling PrepareDMC again, the KF must be locked.
+	if _, err := srif.Update(mat64.NewVector(1, nil), mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("KF should be locked")
+	}
+}
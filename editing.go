@@ -0,0 +1,143 @@
+package gokalman
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/gonum/stat/distuv"
+)
+
+// MeasurementEditor defines a measurement editing policy, used to reject outliers prior to the measurement update.
+// When a measurement is rejected, the filter skips the measurement update and the estimate is the prediction.
+type MeasurementEditor interface {
+	// Reject returns whether the measurement must be rejected from its innovation y - H*\bar{x}
+	// and the covariance of that innovation S = H*\bar{P}*H' + R.
+	Reject(innovation *mat64.Vector, S mat64.Symmetric) bool
+	Reset()         // Reinitializes the editor (e.g. the count of consecutive rejections).
+	String() string // Stringer interface implementation
+}
+
+// consecutiveRejections limits the number of consecutive rejections: once reached, the next measurement is accepted.
+// This prevents the filter from rejecting all measurements after a divergence. A max of zero means no limit.
+type consecutiveRejections struct {
+	max, count int
+}
+
+// edit returns whether the measurement is rejected given whether the policy would reject it.
+func (c *consecutiveRejections) edit(reject bool) bool {
+	if !reject || (c.max > 0 && c.count >= c.max) {
+		c.count = 0
+		return false
+	}
+	c.count++
+	return true
+}
+
+// NσEditor rejects measurements whose innovation is outside of the Nσ bounds of the innovation covariance on any component.
+type NσEditor struct {
+	N float64
+	consecutiveRejections
+}
+
+// NewNσEditor returns a new NσEditor. Set maxConsecutive to zero to allow any number of consecutive rejections.
+func NewNσEditor(N float64, maxConsecutive int) *NσEditor {
+	return &NσEditor{N, consecutiveRejections{maxConsecutive, 0}}
+}
+
+// Reject implements the MeasurementEditor interface.
+func (e *NσEditor) Reject(innovation *mat64.Vector, S mat64.Symmetric) bool {
+	reject := false
+	for i := 0; i < innovation.Len(); i++ {
+		if math.Abs(innovation.At(i, 0)) > e.N*math.Sqrt(S.At(i, i)) {
+			reject = true
+			break
+		}
+	}
+	return e.edit(reject)
+}
+
+// Reset implements the MeasurementEditor interface.
+func (e *NσEditor) Reset() {
+	e.count = 0
+}
+
+func (e *NσEditor) String() string {
+	return fmt.Sprintf("NσEditor{N=%f, max consecutive=%d}", e.N, e.max)
+}
+
+// MahalanobisEditor rejects measurements whose squared Mahalanobis distance y'*S^-1*y exceeds a gate.
+// The gate is either fixed, or the χ² quantile of the provided probability for the measurement size.
+type MahalanobisEditor struct {
+	gate        float64 // Fixed gate on the squared distance, used if probability is zero.
+	probability float64
+	consecutiveRejections
+}
+
+// NewMahalanobisEditor returns a new MahalanobisEditor which rejects measurements whose Mahalanobis distance exceeds d.
+// Set maxConsecutive to zero to allow any number of consecutive rejections.
+func NewMahalanobisEditor(d float64, maxConsecutive int) *MahalanobisEditor {
+	return &MahalanobisEditor{d * d, 0, consecutiveRejections{maxConsecutive, 0}}
+}
+
+// NewChiSquareEditor returns a new MahalanobisEditor which rejects measurements whose squared Mahalanobis distance
+// is beyond the χ² quantile of the provided probability (e.g. 0.99), where the degrees of freedom are the measurement size.
+// Set maxConsecutive to zero to allow any number of consecutive rejections.
+func NewChiSquareEditor(probability float64, maxConsecutive int) *MahalanobisEditor {
+	if probability <= 0 || probability >= 1 {
+		panic("probability must be within ]0;1[")
+	}
+	return &MahalanobisEditor{0, probability, consecutiveRejections{maxConsecutive, 0}}
+}
+
+// Reject implements the MeasurementEditor interface.
+func (e *MahalanobisEditor) Reject(innovation *mat64.Vector, S mat64.Symmetric) bool {
+	d2, err := mahalanobis2(innovation, S)
+	if err != nil {
+		// The innovation covariance is singular, so the gate cannot be evaluated.
+		return e.edit(false)
+	}
+	return e.edit(d2 > e.Gate(innovation.Len()))
+}
+
+// Gate returns the gate on the squared Mahalanobis distance for a measurement of the provided size.
+func (e *MahalanobisEditor) Gate(measSize int) float64 {
+	if e.probability == 0 {
+		return e.gate
+	}
+	return distuv.ChiSquared{K: float64(measSize)}.Quantile(e.probability)
+}
+
+// Reset implements the MeasurementEditor interface.
+func (e *MahalanobisEditor) Reset() {
+	e.count = 0
+}
+
+func (e *MahalanobisEditor) String() string {
+	if e.probability == 0 {
+		return fmt.Sprintf("MahalanobisEditor{d²=%f, max consecutive=%d}", e.gate, e.max)
+	}
+	return fmt.Sprintf("MahalanobisEditor{χ² p=%f, max consecutive=%d}", e.probability, e.max)
+}
+
+// mahalanobis2 returns the squared Mahalanobis distance y'*S^-1*y.
+func mahalanobis2(y *mat64.Vector, S mat64.Symmetric) (float64, error) {
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(S); !ok {
+		return 0, fmt.Errorf("innovation covariance is not positive definite")
+	}
+	var Sinvy mat64.Vector
+	if err := Sinvy.SolveCholeskyVec(&chol, y); err != nil {
+		return 0, err
+	}
+	return mat64.Dot(y, &Sinvy), nil
+}
+
+// innovationCovariance returns S = H*\bar{P}*H' + R.
+func innovationCovariance(H, PBar, R mat64.Matrix) (*mat64.SymDense, error) {
+	var PHt, S mat64.Dense
+	PHt.Mul(PBar, H.T())
+	S.Mul(H, &PHt)
+	S.Add(&S, R)
+	return AsSymDense(&S)
+}
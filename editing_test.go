@@ -0,0 +1,172 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestImplementsEditor(t *testing.T) {
+	implements := func(e MeasurementEditor) {}
+	implements(NewNσEditor(3, 0))
+	implements(NewMahalanobisEditor(3, 0))
+	implements(NewChiSquareEditor(0.99, 0))
+}
+
+func TestNσEditor(t *testing.T) {
+	S := mat64.NewSymDense(2, []float64{4, 0, 0, 1})
+	e := NewNσEditor(3, 2)
+	if e.Reject(mat64.NewVector(2, []float64{5.9, -2.9}), S) {
+		t.Fatal("innovation within 3σ should not be rejected")
+	}
+	outlier := mat64.NewVector(2, []float64{0, 3.1})
+	if !e.Reject(outlier, S) || !e.Reject(outlier, S) {
+		t.Fatal("innovation outside of 3σ should be rejected")
+	}
+	if e.Reject(outlier, S) {
+		t.Fatal("third consecutive rejection should be accepted")
+	}
+	if !e.Reject(outlier, S) {
+		t.Fatal("count of consecutive rejections not reset on acceptance")
+	}
+	e.Reset()
+	if !e.Reject(outlier, S) || !e.Reject(outlier, S) || e.Reject(outlier, S) {
+		t.Fatal("count of consecutive rejections not reset")
+	}
+	// Unlimited number of rejections.
+	e = NewNσEditor(3, 0)
+	for i := 0; i < 10; i++ {
+		if !e.Reject(outlier, S) {
+			t.Fatal("innovation outside of 3σ should be rejected")
+		}
+	}
+}
+
+func TestMahalanobisEditor(t *testing.T) {
+	S := mat64.NewSymDense(2, []float64{4, 0, 0, 1})
+	// Squared distance of 1.5²/4 + 2.5² = 6.8125
+	y := mat64.NewVector(2, []float64{1.5, 2.5})
+	if NewMahalanobisEditor(2.7, 0).Reject(y, S) {
+		t.Fatal("distance below 2.7 should not be rejected")
+	}
+	if !NewMahalanobisEditor(2.5, 0).Reject(y, S) {
+		t.Fatal("distance above 2.5 should be rejected")
+	}
+	e := NewChiSquareEditor(0.99, 0)
+	if g := e.Gate(1); math.Abs(g-6.634896601) > 1e-6 {
+		t.Fatalf("invalid χ² gate for one degree of freedom: %f", g)
+	}
+	if g := e.Gate(2); math.Abs(g-9.210340372) > 1e-6 {
+		t.Fatalf("invalid χ² gate for two degrees of freedom: %f", g)
+	}
+	if e.Reject(y, S) {
+		t.Fatal("squared distance of 6.8 should not be rejected with two degrees of freedom at 99%")
+	}
+	if !NewChiSquareEditor(0.95, 0).Reject(y, S) {
+		t.Fatal("squared distance of 6.8 should be rejected with two degrees of freedom at 95%")
+	}
+	assertPanic(t, func() {
+		NewChiSquareEditor(1, 0)
+	})
+}
+
+func TestMeasurementEditing(t *testing.T) {
+	// 1D constant velocity observed in position, with an outlier at k=5.
+	F := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	noise := NewNoiseless(ScaledIdentity(2, 1e-4), ScaledIdentity(1, 0.1))
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 1)
+	ys := []float64{1.1, 1.9, 3.0, 4.1, 5.0, 1e3, 7.1, 7.9, 9.0}
+	outlier := 5
+	updates := map[string]func(y float64) (Estimate, error){}
+
+	vanilla, _, err := NewVanilla(x0, P0, F, mat64.NewDense(2, 1, nil), H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanilla.SetMeasurementEditor(NewChiSquareEditor(0.999, 0))
+	updates["Vanilla"] = func(y float64) (Estimate, error) {
+		return vanilla.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	sqrt, _, err := NewSquareRoot(x0, P0, F, mat64.NewDense(2, 1, nil), H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqrt.SetMeasurementEditor(NewChiSquareEditor(0.999, 0))
+	updates["SquareRoot"] = func(y float64) (Estimate, error) {
+		return sqrt.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	info, _, err := NewInformationFromState(x0, P0, F, mat64.NewDense(2, 1, nil), H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.SetMeasurementEditor(NewChiSquareEditor(0.999, 0))
+	updates["Information"] = func(y float64) (Estimate, error) {
+		return info.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	hkf, _, err := NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hkf.SetMeasurementEditor(NewNσEditor(5, 0))
+	updates["HybridKF"] = func(y float64) (Estimate, error) {
+		hkf.Prepare(F, H)
+		hkf.PreparePNT(DenseIdentity(2))
+		return hkf.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	srif, _, err := NewSRIF(x0, P0, 1, false, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srif.SetMeasurementEditor(NewNσEditor(5, 0))
+	updates["SRIF"] = func(y float64) (Estimate, error) {
+		srif.Prepare(F, H)
+		srif.PreparePNT(DenseIdentity(2))
+		return srif.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	ukf, _, err := NewUKF(x0, P0, func(x *mat64.Vector) *mat64.Vector {
+		var xKp1 mat64.Vector
+		xKp1.MulVec(F, x)
+		return &xKp1
+	}, func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(1, []float64{x.At(0, 0)})
+	}, noise, NewJulierSigmaPoints(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ukf.SetMeasurementEditor(NewMahalanobisEditor(5, 0))
+	updates["UKF"] = func(y float64) (Estimate, error) {
+		return ukf.Update(mat64.NewVector(1, []float64{y}))
+	}
+
+	for name, update := range updates {
+		for k, y := range ys {
+			est, err := update(y)
+			if err != nil {
+				t.Fatalf("%s k=%d: %s", name, k, err)
+			}
+			if k != outlier {
+				if est.Rejected() {
+					t.Fatalf("%s k=%d: measurement should not be rejected\n%s", name, k, est)
+				}
+				continue
+			}
+			if !est.Rejected() {
+				t.Fatalf("%s: outlier was not rejected\n%s", name, est)
+			}
+			if !mat64.EqualApprox(est.Covariance(), est.PredCovariance(), 1e-10) {
+				t.Fatalf("%s: covariance was updated with the outlier\n%s", name, est)
+			}
+			if math.Abs(est.State().At(0, 0)-6) > 0.5 {
+				t.Fatalf("%s: state was updated with the outlier\n%s", name, est)
+			}
+		}
+	}
+}
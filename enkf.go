@@ -0,0 +1,468 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/gonum/stat/distmv"
+)
+
+// EnKFScheme defines how the ensemble members are updated with a measurement.
+type EnKFScheme uint8
+
+const (
+	// StochasticEnKF updates each member with its own perturbed observation (Burgers et al., 1998).
+	StochasticEnKF EnKFScheme = iota + 1
+	// SquareRootEnKF deterministically updates the ensemble mean and anomalies, processing each scalar
+	// measurement serially (Whitaker & Hamill, 2002). It does not require perturbed observations.
+	SquareRootEnKF
+)
+
+func (s EnKFScheme) String() string {
+	switch s {
+	case StochasticEnKF:
+		return "stochastic"
+	case SquareRootEnKF:
+		return "square root"
+	default:
+		panic("unknown EnKF scheme")
+	}
+}
+
+// NewEnKF returns a new Ensemble Kalman Filter, whose initial ensemble of the provided size is drawn from N(x0, P0).
+// Parameters:
+// - x0: initial state estimate
+// - P0: initial covariance symmetric matrix
+// - f: non-linear propagation function
+// - h: non-linear measurement function
+// - noise: Noise (Q must be of the size of the state unless PreparePNT is called)
+// - size: number of ensemble members
+// - scheme: measurement update scheme
+// - rng: source of the ensemble and noise samples (seeded with the time if nil)
+func NewEnKF(x0 *mat64.Vector, P0 mat64.Symmetric, f NLPropagation, h NLMeasurement, noise Noise, size int, scheme EnKFScheme, rng *rand.Rand) (*EnKF, *EnKFEstimate, error) {
+	// Let's check the dimensions of everything here to return an error ASAP.
+	if err := checkMatDims(x0, P0, "x0", "Covar0", rows2cols); err != nil {
+		return nil, nil, err
+	}
+	if f == nil || h == nil {
+		return nil, nil, errors.New("both the propagation and the measurement functions must be provided")
+	}
+	if size < 2 {
+		return nil, nil, errors.New("the ensemble requires at least two members")
+	}
+	if scheme != StochasticEnKF && scheme != SquareRootEnKF {
+		return nil, nil, fmt.Errorf("unknown EnKF scheme %d", scheme)
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	dist, ok := distmv.NewNormal(mat64.Col(nil, 0, x0), P0, rng)
+	if !ok {
+		return nil, nil, errors.New("initial covariance is not positive definite")
+	}
+	ensemble := make([]*mat64.Vector, size)
+	for i := range ensemble {
+		ensemble[i] = mat64.NewVector(x0.Len(), dist.Rand(nil))
+	}
+	measSize, _ := noise.MeasurementMatrix().Dims()
+	x, P := ensembleStatistics(ensemble)
+	est0 := &EnKFEstimate{x, mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), P, P, nil, nil, ensemble, false, time.Time{}}
+	return &EnKF{f, h, nil, noise, scheme, rng, 1, nil, nil, est0, false, 0, nil, timeTagger{}}, est0, nil
+}
+
+// EnKF defines an Ensemble Kalman Filter. Use NewEnKF to initialize.
+// The process and measurement noises are assumed additive.
+type EnKF struct {
+	F          NLPropagation // Non-linear propagation function
+	H          NLMeasurement // Non-linear measurement function
+	Γ          *mat64.Dense
+	Noise      Noise
+	scheme     EnKFScheme
+	rng        *rand.Rand
+	inflation  float64      // Multiplicative inflation of the forecast anomalies.
+	ρxy        *mat64.Dense // Localisation of the state-measurement covariance, if set.
+	ρyy        *mat64.Dense // Localisation of the measurement covariance, if set.
+	prevEst    *EnKFEstimate
+	sncEnabled bool // Stores whether we should enable or disable the state noise compensation.
+	step       int
+	editor     MeasurementEditor
+	timeTagger
+}
+
+func (kf *EnKF) String() string {
+	return fmt.Sprintf("EnKF [k=%d] %s (N=%d, λ=%g)\n%s", kf.step, kf.scheme, len(kf.prevEst.ensemble), kf.inflation, kf.Noise)
+}
+
+// SetNoise updates the Noise.
+func (kf *EnKF) SetNoise(n Noise) {
+	kf.Noise = n
+}
+
+// GetNoise returns the Noise.
+func (kf *EnKF) GetNoise() Noise {
+	return kf.Noise
+}
+
+// SetMeasurementEditor sets the measurement editing policy used to reject outliers (nil to disable).
+// The innovation covariance is that of the ensemble, i.e. Pyy + R.
+func (kf *EnKF) SetMeasurementEditor(e MeasurementEditor) {
+	kf.editor = e
+}
+
+// SetInflation sets the multiplicative inflation λ of the forecast ensemble anomalies, which compensates for the
+// underestimation of the covariance by a small ensemble. A value of 1 disables the inflation.
+func (kf *EnKF) SetInflation(λ float64) error {
+	if λ < 1 {
+		return errors.New("inflation must be greater than or equal to one")
+	}
+	kf.inflation = λ
+	return nil
+}
+
+// SetLocalization sets the localisation (or tapering) of the ensemble covariances, which are multiplied element wise
+// by ρxy (state-measurement, of size n×m) and ρyy (measurement, of size m×m) to remove spurious correlations.
+// With a non diagonal R, the square root scheme applies the localisation to the whitened measurements.
+// Set both to nil to disable the localisation.
+func (kf *EnKF) SetLocalization(ρxy *mat64.Dense, ρyy mat64.Symmetric) error {
+	if ρxy == nil && ρyy == nil {
+		kf.ρxy, kf.ρyy = nil, nil
+		return nil
+	}
+	if ρxy == nil || ρyy == nil {
+		return errors.New("both localisation matrices must be provided")
+	}
+	if err := checkMatDims(ρxy, kf.prevEst.state, "ρxy", "state", rows2rows); err != nil {
+		return err
+	}
+	if err := checkMatDims(ρxy, kf.Noise.MeasurementMatrix(), "ρxy", "R", cols2cols); err != nil {
+		return err
+	}
+	if err := checkMatDims(ρyy, kf.Noise.MeasurementMatrix(), "ρyy", "R", rowsAndcols); err != nil {
+		return err
+	}
+	kf.ρxy = ρxy
+	kf.ρyy = mat64.DenseCopyOf(ρyy)
+	return nil
+}
+
+// PreparePNT prepares the process noise transition matrix and enabled the SNC
+// for the next update. If not called, the process noise samples are added directly to the members.
+func (kf *EnKF) PreparePNT(Γ *mat64.Dense) {
+	kf.Γ = Γ
+	kf.sncEnabled = true
+}
+
+// Predict computes only the time update (or prediction).
+func (kf *EnKF) Predict() (est Estimate, err error) {
+	return kf.fullUpdate(true, nil)
+}
+
+// Update computes a full time and measurement update.
+func (kf *EnKF) Update(realObservation *mat64.Vector) (est Estimate, err error) {
+	return kf.fullUpdate(false, realObservation)
+}
+
+// fullUpdate performs all the steps of an update and allows to stop right after the pure prediction (or time update) step.
+func (kf *EnKF) fullUpdate(purePrediction bool, realObservation *mat64.Vector) (est Estimate, err error) {
+	R := kf.Noise.MeasurementMatrix()
+	if !purePrediction {
+		if err = checkMatDims(realObservation, R, "real observation", "R", rows2rows); err != nil {
+			return nil, err
+		}
+	}
+	n := kf.prevEst.state.Len()
+	N := len(kf.prevEst.ensemble)
+
+	// Time update: propagate each member and add its process noise.
+	ensemble := make([]*mat64.Vector, N)
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		for i, member := range kf.prevEst.ensemble {
+			ensemble[i] = mat64.NewVector(n, nil)
+			ensemble[i].CloneVec(member)
+		}
+	} else {
+		if ensemble, err = propagateSamples(kf.prevEst.ensemble, kf.F, kf.Noise.ProcessMatrix(), kf.Γ, kf.sncEnabled, kf.rng); err != nil {
+			return nil, err
+		}
+		if kf.inflation != 1 {
+			xBar := weightedMean(ensemble, uniformWeights(N, N))
+			for _, member := range ensemble {
+				member.SubVec(member, xBar)
+				member.AddScaledVec(xBar, kf.inflation, member)
+			}
+		}
+	}
+	kf.sncEnabled = false
+	xBar, PBar := ensembleStatistics(ensemble)
+
+	if purePrediction {
+		measSize, _ := R.Dims()
+		est = &EnKFEstimate{xBar, mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), PBar, PBar, nil, nil, ensemble, false, kf.advance()}
+		kf.prevEst = est.(*EnKFEstimate)
+		kf.step++
+		return
+	}
+
+	// Measurement update
+	Y := make([]*mat64.Vector, N)
+	for i, member := range ensemble {
+		Y[i] = kf.H(member)
+	}
+	yHat := weightedMean(Y, uniformWeights(N, N))
+	if err = checkMatDims(realObservation, yHat, "real observation", "computed observation", rows2rows); err != nil {
+		return nil, err
+	}
+	Pxy := weightedCovariance(ensemble, xBar, Y, yHat, uniformWeights(N, N-1))
+	Pyy := weightedCovariance(Y, yHat, Y, yHat, uniformWeights(N, N-1))
+	if kf.ρxy != nil {
+		Pxy.MulElem(Pxy, kf.ρxy)
+		Pyy.MulElem(Pyy, kf.ρyy)
+	}
+	S := mat64.DenseCopyOf(Pyy)
+	S.Add(S, R)
+	var SInv, K mat64.Dense
+	if ierr := SInv.Inverse(S); ierr != nil {
+		return nil, fmt.Errorf("could not invert `Pyy + R` at k=%d: %s", kf.step, ierr)
+	}
+	K.Mul(Pxy, &SInv)
+
+	var innov mat64.Vector
+	innov.SubVec(realObservation, yHat)
+	SSym, err := AsSymDense(S)
+	if err != nil {
+		return nil, err
+	}
+	if kf.editor != nil && kf.editor.Reject(&innov, SSym) {
+		// Skip the measurement update.
+		est = &EnKFEstimate{xBar, yHat, &innov, PBar, PBar, SSym, &K, ensemble, true, kf.advance()}
+		kf.prevEst = est.(*EnKFEstimate)
+		kf.step++
+		return
+	}
+
+	switch kf.scheme {
+	case StochasticEnKF:
+		measurement, ok := distmv.NewNormal(make([]float64, innov.Len()), R, kf.rng)
+		if !ok {
+			return nil, errors.New("measurement noise is not positive definite")
+		}
+		for i, member := range ensemble {
+			// Perturbed observation: y + v_i - h(x_i)
+			var δy, Kδy mat64.Vector
+			δy.SubVec(realObservation, Y[i])
+			δy.AddVec(&δy, mat64.NewVector(innov.Len(), measurement.Rand(nil)))
+			Kδy.MulVec(&K, &δy)
+			member.AddVec(member, &Kδy)
+		}
+	case SquareRootEnKF:
+		if err = kf.serialSquareRootUpdate(ensemble, xBar, Y, yHat, &innov); err != nil {
+			return nil, fmt.Errorf("square root update at k=%d: %s", kf.step, err)
+		}
+	}
+	xHat, P := ensembleStatistics(ensemble)
+	est = &EnKFEstimate{xHat, yHat, &innov, P, PBar, SSym, &K, ensemble, false, kf.advance()}
+	kf.prevEst = est.(*EnKFEstimate)
+	kf.step++
+	return
+}
+
+// serialSquareRootUpdate updates the ensemble in place by processing each scalar measurement serially: the mean is
+// updated with the Kalman gain, and the anomalies with the reduced gain α*K where α = 1/(1 + √(r/(σ²+r))), such that
+// the updated ensemble covariance matches the Kalman update without perturbed observations.
+// The measurements are first whitened if R is not diagonal.
+func (kf *EnKF) serialSquareRootUpdate(ensemble []*mat64.Vector, xBar *mat64.Vector, Y []*mat64.Vector, yHat, innov *mat64.Vector) error {
+	n := xBar.Len()
+	N := len(ensemble)
+	m := innov.Len()
+	// Anomalies of the state and the measurements, stored as columns.
+	X := mat64.NewDense(n, N, nil)
+	Ya := mat64.NewDense(m, N, nil)
+	var δx, δy mat64.Vector
+	for i := 0; i < N; i++ {
+		δx.SubVec(ensemble[i], xBar)
+		X.SetCol(i, mat64.Col(nil, 0, &δx))
+		δy.SubVec(Y[i], yHat)
+		Ya.SetCol(i, mat64.Col(nil, 0, &δy))
+	}
+	Yw, d, r, _, err := whitenMeasurement(Ya, innov, kf.Noise.MeasurementMatrix())
+	if err != nil {
+		return err
+	}
+	d = mat64.NewVector(m, mat64.Col(nil, 0, d))
+	x := mat64.NewVector(n, nil)
+	x.CloneVec(xBar)
+	for j := 0; j < m; j++ {
+		yj := mat64.NewVector(N, mat64.Row(nil, j, Yw))
+		σ2 := mat64.Dot(yj, yj) / float64(N-1)
+		s := σ2 + r[j]
+		if s <= 0 {
+			return fmt.Errorf("variance of measurement #%d is not positive: %f", j, s)
+		}
+		var Kx, Ky mat64.Vector
+		Kx.MulVec(X, yj)
+		Kx.ScaleVec(1/(float64(N-1)*s), &Kx)
+		Ky.MulVec(Yw, yj)
+		Ky.ScaleVec(1/(float64(N-1)*s), &Ky)
+		if kf.ρxy != nil {
+			for i := 0; i < n; i++ {
+				Kx.SetVec(i, Kx.At(i, 0)*kf.ρxy.At(i, j))
+			}
+			for i := 0; i < m; i++ {
+				Ky.SetVec(i, Ky.At(i, 0)*kf.ρyy.At(i, j))
+			}
+		}
+		ν := d.At(j, 0)
+		x.AddScaledVec(x, ν, &Kx)
+		d.AddScaledVec(d, -ν, &Ky)
+		α := 1 / (1 + math.Sqrt(r[j]/s))
+		X.RankOne(X, -α, &Kx, yj)
+		Yw.RankOne(Yw, -α, &Ky, yj)
+	}
+	for i := 0; i < N; i++ {
+		ensemble[i].AddVec(x, X.ColView(i))
+	}
+	return nil
+}
+
+// propagateSamples returns the samples (ensemble members or particles) propagated with f, each with its own process
+// noise drawn from N(0, Q), or from Γ*N(0, Q) if the SNC is enabled. There is no process noise if Q is nil.
+func propagateSamples(samples []*mat64.Vector, f NLPropagation, Q mat64.Symmetric, Γ *mat64.Dense, snc bool, rng *rand.Rand) ([]*mat64.Vector, error) {
+	var process *distmv.Normal
+	if !IsNil(Q) {
+		if !snc && len(samples) > 0 {
+			if err := checkMatDims(Q, samples[0], "Q", "state", rows2rows); err != nil {
+				return nil, err
+			}
+		}
+		var ok bool
+		if process, ok = distmv.NewNormal(make([]float64, Q.Symmetric()), Q, rng); !ok {
+			return nil, errors.New("process noise is not positive definite")
+		}
+	}
+	propagated := make([]*mat64.Vector, len(samples))
+	for i, sample := range samples {
+		propagated[i] = f(sample)
+		if propagated[i].Len() != sample.Len() {
+			return nil, fmt.Errorf("propagation function returned a state of size %d instead of %d", propagated[i].Len(), sample.Len())
+		}
+		if process == nil {
+			continue
+		}
+		w := mat64.NewVector(process.Dim(), process.Rand(nil))
+		if snc {
+			var Γw mat64.Vector
+			Γw.MulVec(Γ, w)
+			w = &Γw
+		}
+		propagated[i].AddVec(propagated[i], w)
+	}
+	return propagated, nil
+}
+
+// ensembleStatistics returns the mean and the sample covariance of the ensemble.
+func ensembleStatistics(ensemble []*mat64.Vector) (*mat64.Vector, *mat64.SymDense) {
+	N := len(ensemble)
+	mean := weightedMean(ensemble, uniformWeights(N, N))
+	// The covariance is symmetric by construction, up to rounding errors.
+	return mean, symmetrize(weightedCovariance(ensemble, mean, ensemble, mean, uniformWeights(N, N-1)))
+}
+
+// uniformWeights returns N weights of 1/d.
+func uniformWeights(N, d int) []float64 {
+	W := make([]float64, N)
+	for i := range W {
+		W[i] = 1 / float64(d)
+	}
+	return W
+}
+
+// EnKFEstimate is the output of each update state of the EnKF.
+// It implements the Estimate interface.
+type EnKFEstimate struct {
+	state, meas, innov           *mat64.Vector
+	covar, predCovar, innovCovar mat64.Symmetric
+	gain                         mat64.Matrix
+	ensemble                     []*mat64.Vector
+	rejected                     bool
+	epoch                        time.Time
+}
+
+// IsWithinNσ returns whether the estimation is within the 2σ bounds.
+func (e EnKFEstimate) IsWithinNσ(N float64) bool {
+	for i := 0; i < e.state.Len(); i++ {
+		nσ := N * math.Sqrt(e.covar.At(i, i))
+		if e.state.At(i, 0) > nσ || e.state.At(i, 0) < -nσ {
+			return false
+		}
+	}
+	return true
+}
+
+// IsWithin2σ returns whether the estimation is within the 2σ bounds.
+func (e EnKFEstimate) IsWithin2σ() bool {
+	return e.IsWithinNσ(2)
+}
+
+// State implements the Estimate interface.
+func (e EnKFEstimate) State() *mat64.Vector {
+	return e.state
+}
+
+// Measurement implements the Estimate interface.
+func (e EnKFEstimate) Measurement() *mat64.Vector {
+	return e.meas
+}
+
+// Innovation implements the Estimate interface.
+func (e EnKFEstimate) Innovation() *mat64.Vector {
+	return e.innov
+}
+
+// InnovationCovariance returns the covariance of the innovation, i.e. Pyy + R (nil for a prediction).
+func (e EnKFEstimate) InnovationCovariance() mat64.Symmetric {
+	return e.innovCovar
+}
+
+// Covariance implements the Estimate interface.
+func (e EnKFEstimate) Covariance() mat64.Symmetric {
+	return e.covar
+}
+
+// PredCovariance implements the Estimate interface.
+func (e EnKFEstimate) PredCovariance() mat64.Symmetric {
+	return e.predCovar
+}
+
+// Rejected implements the Estimate interface.
+func (e EnKFEstimate) Rejected() bool {
+	return e.rejected
+}
+
+// Epoch implements the Estimate interface.
+func (e EnKFEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
+// Gain returns the Kalman gain.
+func (e EnKFEstimate) Gain() mat64.Matrix {
+	return e.gain
+}
+
+// Ensemble returns the ensemble members of this estimate.
+func (e EnKFEstimate) Ensemble() []*mat64.Vector {
+	return e.ensemble
+}
+
+func (e EnKFEstimate) String() string {
+	state := mat64.Formatted(e.State(), mat64.Prefix("  "))
+	meas := mat64.Formatted(e.Measurement(), mat64.Prefix("  "))
+	covar := mat64.Formatted(e.Covariance(), mat64.Prefix("  "))
+	innov := mat64.Formatted(e.Innovation(), mat64.Prefix("  "))
+	predp := mat64.Formatted(e.PredCovariance(), mat64.Prefix("   "))
+	return fmt.Sprintf("{\ns=%v\ny=%v\nP=%v\nP-=%v\ni=%v\n}", state, meas, covar, predp, innov)
+}
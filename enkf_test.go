@@ -0,0 +1,271 @@
+package gokalman
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// linearRobot returns the propagation and measurement functions of the 1D robot, observed by its position.
+func linearRobot() (F mat64.Matrix, H *mat64.Dense, f NLPropagation, h NLMeasurement) {
+	F, _, _ = Robot1DMatrices()
+	H = mat64.NewDense(1, 2, []float64{1, 0})
+	f = func(x *mat64.Vector) *mat64.Vector {
+		var xKp1 mat64.Vector
+		xKp1.MulVec(F, x)
+		return &xKp1
+	}
+	h = func(x *mat64.Vector) *mat64.Vector {
+		var y mat64.Vector
+		y.MulVec(H, x)
+		return &y
+	}
+	return
+}
+
+func TestEnKFLinear(t *testing.T) {
+	// With linear dynamics and measurements, a large ensemble must approximate the vanilla KF.
+	F, H, f, h := linearRobot()
+	_, G, _ := Robot1DMatrices()
+	Q := mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3})
+	R := mat64.NewSymDense(1, []float64{0.1})
+	noise := NewNoiseless(Q, R)
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 10)
+	for _, scheme := range []EnKFScheme{StochasticEnKF, SquareRootEnKF} {
+		vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kf, _, err := NewEnKF(x0, P0, f, h, noise, 5000, scheme, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := 0; k < 50; k++ {
+			y := mat64.NewVector(1, []float64{0.035*float64(k) + 0.1*math.Sin(float64(k))})
+			vEst, err := vanilla.Update(y, mat64.NewVector(1, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			eEst, err := kf.Update(y)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				σ := math.Sqrt(vEst.Covariance().At(i, i))
+				if math.Abs(vEst.State().At(i, 0)-eEst.State().At(i, 0)) > 0.1*σ {
+					t.Fatalf("%s k=%d: state #%d differs\n%v\n%v", scheme, k, i, mat64.Formatted(vEst.State()), mat64.Formatted(eEst.State()))
+				}
+				if ratio := eEst.Covariance().At(i, i) / vEst.Covariance().At(i, i); ratio < 0.9 || ratio > 1.1 {
+					t.Fatalf("%s k=%d: variance #%d differs by a ratio of %f", scheme, k, i, ratio)
+				}
+			}
+		}
+	}
+}
+
+func TestEnKFRange(t *testing.T) {
+	// Constant velocity target in 2D observed by its range and bearing from the origin.
+	Δt := 1.0
+	f := func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(4, []float64{x.At(0, 0) + Δt*x.At(2, 0), x.At(1, 0) + Δt*x.At(3, 0), x.At(2, 0), x.At(3, 0)})
+	}
+	h := func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(2, []float64{math.Hypot(x.At(0, 0), x.At(1, 0)), math.Atan2(x.At(1, 0), x.At(0, 0))})
+	}
+	x0 := mat64.NewVector(4, []float64{110, 40, 0, 0})
+	P0 := mat64.NewSymDense(4, []float64{400, 0, 0, 0, 0, 400, 0, 0, 0, 0, 25, 0, 0, 0, 0, 25})
+	Q := ScaledIdentity(4, 1e-6)
+	R := mat64.NewSymDense(2, []float64{1e-2, 0, 0, 1e-6})
+	for _, scheme := range []EnKFScheme{StochasticEnKF, SquareRootEnKF} {
+		kf, _, err := NewEnKF(x0, P0, f, h, NewNoiseless(Q, R), 200, scheme, rand.New(rand.NewSource(2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = kf.SetInflation(1.01); err != nil {
+			t.Fatal(err)
+		}
+		truth := mat64.NewVector(4, []float64{100, 50, 1, -2})
+		var est Estimate
+		for k := 0; k < 30; k++ {
+			truth = f(truth)
+			if k%5 == 4 {
+				if est, err = kf.Predict(); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if est, err = kf.Update(h(truth)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var Δx mat64.Vector
+		Δx.SubVec(truth, est.State())
+		for i := 0; i < 4; i++ {
+			if math.Abs(Δx.At(i, 0)) > 3*math.Sqrt(est.Covariance().At(i, i))+1e-3 {
+				t.Fatalf("%s: state #%d not within 3σ: error=%f σ=%f", scheme, i, Δx.At(i, 0), math.Sqrt(est.Covariance().At(i, i)))
+			}
+		}
+		if len(est.(*EnKFEstimate).Ensemble()) != 200 {
+			t.Fatalf("%s: invalid ensemble size", scheme)
+		}
+		if _, err = kf.Update(mat64.NewVector(3, nil)); err == nil {
+			t.Fatalf("%s: invalid observation size did not fail", scheme)
+		}
+	}
+}
+
+func TestEnKFConsistency(t *testing.T) {
+	// The average NEES and NIS of a simulated run must be close to the state and measurement sizes.
+	_, _, f, h := linearRobot()
+	Q := mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3})
+	R := mat64.NewSymDense(1, []float64{0.1})
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 1)
+	rng := rand.New(rand.NewSource(3))
+	for _, scheme := range []EnKFScheme{StochasticEnKF, SquareRootEnKF} {
+		kf, _, err := NewEnKF(x0, P0, f, h, NewNoiseless(Q, R), 500, scheme, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		truth := mat64.NewVector(2, []float64{rng.NormFloat64(), 0.35 + rng.NormFloat64()})
+		steps := 400
+		var sumNEES, sumNIS float64
+		for k := 0; k < steps; k++ {
+			truth = f(truth)
+			truth.SetVec(0, truth.At(0, 0)+rng.NormFloat64()*math.Sqrt(Q.At(0, 0)))
+			truth.SetVec(1, truth.At(1, 0)+rng.NormFloat64()*math.Sqrt(Q.At(1, 1)))
+			y := h(truth)
+			y.SetVec(0, y.At(0, 0)+rng.NormFloat64()*math.Sqrt(R.At(0, 0)))
+			est, err := kf.Update(y)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nees, err := NEES(est, truth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nis, err := NIS(est.Innovation(), est.(*EnKFEstimate).InnovationCovariance())
+			if err != nil {
+				t.Fatal(err)
+			}
+			sumNEES += nees
+			sumNIS += nis
+		}
+		if avg := sumNEES / float64(steps); avg < 1.5 || avg > 2.5 {
+			t.Fatalf("%s: average NEES of %f is not consistent", scheme, avg)
+		}
+		if avg := sumNIS / float64(steps); avg < 0.75 || avg > 1.25 {
+			t.Fatalf("%s: average NIS of %f is not consistent", scheme, avg)
+		}
+	}
+}
+
+func TestEnKFInflationLocalization(t *testing.T) {
+	_, _, f, h := linearRobot()
+	noise := NewNoiseless(mat64.NewSymDense(2, nil), mat64.NewSymDense(1, []float64{0.1}))
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 1)
+	y := mat64.NewVector(1, []float64{0.2})
+	// A localisation of ones must not change the square root update, which is deterministic.
+	kf1, _, err := NewEnKF(x0, P0, f, h, noise, 20, SquareRootEnKF, rand.New(rand.NewSource(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf2, _, err := NewEnKF(x0, P0, f, h, noise, 20, SquareRootEnKF, rand.New(rand.NewSource(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kf2.SetLocalization(mat64.NewDense(2, 1, []float64{1, 1}), mat64.NewSymDense(1, []float64{1})); err != nil {
+		t.Fatal(err)
+	}
+	est1, err := kf1.Update(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	est2, err := kf2.Update(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mat64.EqualApprox(est1.State(), est2.State(), 1e-12) || !mat64.EqualApprox(est1.Covariance(), est2.Covariance(), 1e-12) {
+		t.Fatal("localisation of ones changed the estimate")
+	}
+	// Without any correlation between the velocity and the position, the velocity is not updated.
+	if err = kf2.SetLocalization(mat64.NewDense(2, 1, []float64{1, 0}), mat64.NewSymDense(1, []float64{1})); err != nil {
+		t.Fatal(err)
+	}
+	prior, err := kf2.Predict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf2.SetNextEpoch(prior.Epoch()) // Update at the same epoch to compare with the prior.
+	est2, err = kf2.Update(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(est2.State().At(1, 0)-prior.State().At(1, 0)) > 1e-12 {
+		t.Fatalf("velocity was updated despite the localisation: %f != %f", est2.State().At(1, 0), prior.State().At(1, 0))
+	}
+	if math.Abs(est2.State().At(0, 0)-prior.State().At(0, 0)) < 1e-6 {
+		t.Fatal("position was not updated")
+	}
+	// The inflation scales the forecast anomalies.
+	kf1.SetLocalization(nil, nil)
+	if err = kf1.SetInflation(2); err != nil {
+		t.Fatal(err)
+	}
+	pred, err := kf1.Predict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected mat64.SymDense
+	expected.ScaleSym(4, est1.Covariance())
+	var Φ mat64.Dense
+	F, _, _ := Robot1DMatrices()
+	Φ.Mul(F, &expected)
+	expectedP := mat64.NewDense(2, 2, nil)
+	expectedP.Mul(&Φ, F.T())
+	if !mat64.EqualApprox(pred.Covariance(), expectedP, 1e-9) {
+		t.Fatalf("invalid inflated covariance\n%v\n%v", mat64.Formatted(pred.Covariance()), mat64.Formatted(expectedP))
+	}
+}
+
+func TestEnKFErrors(t *testing.T) {
+	_, _, f, h := linearRobot()
+	noise := NewNoiseless(ScaledIdentity(2, 1e-4), mat64.NewSymDense(1, []float64{0.1}))
+	x0 := mat64.NewVector(2, nil)
+	P0 := ScaledIdentity(2, 1)
+	if _, _, err := NewEnKF(x0, P0, nil, h, noise, 10, StochasticEnKF, nil); err == nil {
+		t.Fatal("missing propagation function did not fail")
+	}
+	if _, _, err := NewEnKF(x0, P0, f, h, noise, 1, StochasticEnKF, nil); err == nil {
+		t.Fatal("single member ensemble did not fail")
+	}
+	if _, _, err := NewEnKF(x0, P0, f, h, noise, 10, EnKFScheme(0), nil); err == nil {
+		t.Fatal("unknown scheme did not fail")
+	}
+	if _, _, err := NewEnKF(x0, ScaledIdentity(3, 1), f, h, noise, 10, StochasticEnKF, nil); err == nil {
+		t.Fatal("invalid covariance size did not fail")
+	}
+	if _, _, err := NewEnKF(x0, mat64.NewSymDense(2, []float64{-1, 0, 0, 1}), f, h, noise, 10, StochasticEnKF, nil); err == nil {
+		t.Fatal("non positive definite covariance did not fail")
+	}
+	kf, _, err := NewEnKF(x0, P0, f, h, noise, 10, StochasticEnKF, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kf.SetInflation(0.9); err == nil {
+		t.Fatal("deflation did not fail")
+	}
+	if err = kf.SetLocalization(mat64.NewDense(2, 1, nil), nil); err == nil {
+		t.Fatal("partial localisation did not fail")
+	}
+	if err = kf.SetLocalization(mat64.NewDense(3, 1, nil), mat64.NewSymDense(1, nil)); err == nil {
+		t.Fatal("invalid localisation size did not fail")
+	}
+	if err = kf.SetLocalization(mat64.NewDense(2, 1, nil), mat64.NewSymDense(2, nil)); err == nil {
+		t.Fatal("invalid measurement localisation size did not fail")
+	}
+}
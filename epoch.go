@@ -0,0 +1,104 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// TimeStepModel returns the state transition matrix F and the process noise Q of a time update of Δt seconds, e.g.
+// the discretization of a continuous time system (cf. VanLoanModel).
+type TimeStepModel func(Δt float64) (F mat64.Matrix, Q mat64.Symmetric, err error)
+
+// timeTagger stores the epochs of the estimates of a filter, such that measurements may be time tagged and unevenly
+// spaced instead of being implicitly indexed by the step. Filters embed it.
+// If SetNextEpoch is never called, the epochs remain at their initial value and the filter behaves as before.
+// The Vanilla, SquareRoot and Information filters compute F and Q of each time update from the time step with their
+// TimeStepModel, if set (cf. SetTimeStepModel). The HybridKF and SRIF are prepared with the STM of each update, and
+// the HybridKF's Step computes it from the models over the time step.
+// NOTE: the UKF, EnKF and ParticleFilter propagate with their f and Noise as set, whatever the time step: for unevenly
+// spaced epochs, set them from TimeStep before each update.
+type timeTagger struct {
+	epoch0, epoch, nextEpoch time.Time
+	nextSet                  bool
+}
+
+// Epoch returns the epoch of the latest estimate.
+func (t *timeTagger) Epoch() time.Time {
+	return t.epoch
+}
+
+// SetEpoch sets the epoch of the latest estimate, e.g. the epoch of the initial estimate, which is restored on Reset.
+func (t *timeTagger) SetEpoch(epoch time.Time) {
+	t.epoch0 = epoch
+	t.epoch = epoch
+	t.nextSet = false
+}
+
+// SetNextEpoch sets the epoch of the next estimate (i.e. of the next measurement or prediction).
+// If it is the epoch of the latest estimate (e.g. several measurements at the same epoch), the time update is skipped.
+// Returns an error if it is before the epoch of the latest estimate, in which case the next epoch is unset.
+func (t *timeTagger) SetNextEpoch(epoch time.Time) error {
+	if epoch.Before(t.epoch) {
+		t.nextSet = false
+		return fmt.Errorf("next epoch %s is before the current epoch %s", epoch, t.epoch)
+	}
+	t.nextEpoch = epoch
+	t.nextSet = true
+	return nil
+}
+
+// SetNextTimeStep sets the epoch of the next estimate Δt seconds after the latest estimate (cf. SetNextEpoch).
+func (t *timeTagger) SetNextTimeStep(Δt float64) error {
+	return t.SetNextEpoch(t.epoch.Add(time.Duration(Δt * float64(time.Second))))
+}
+
+// TimeStep returns the time in seconds between the latest estimate and the next one (zero if the next epoch is not set).
+func (t *timeTagger) TimeStep() float64 {
+	if !t.nextSet {
+		return 0
+	}
+	return t.nextEpoch.Sub(t.epoch).Seconds()
+}
+
+// sameEpoch returns whether the next estimate is at the epoch of the latest one, in which case there is no time update.
+func (t *timeTagger) sameEpoch() bool {
+	return t.nextSet && t.nextEpoch.Equal(t.epoch)
+}
+
+// advance moves to the next epoch, if set, and returns the epoch of the new estimate.
+func (t *timeTagger) advance() time.Time {
+	if t.nextSet {
+		t.epoch = t.nextEpoch
+		t.nextSet = false
+	}
+	return t.epoch
+}
+
+// reset returns to the epoch provided to SetEpoch.
+func (t *timeTagger) reset() {
+	t.epoch = t.epoch0
+	t.nextSet = false
+}
+
+// modelTimeUpdate returns the state transition matrix and the process noise of the time update to the next epoch from
+// the model, for a state of size n. Returns an error if the next epoch is not set.
+func (t *timeTagger) modelTimeUpdate(model TimeStepModel, n int) (mat64.Matrix, mat64.Symmetric, error) {
+	if !t.nextSet {
+		return nil, nil, errors.New("the next epoch must be set to compute the time update from the model")
+	}
+	Δt := t.TimeStep()
+	F, Q, err := model(Δt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("time update of %f s: %s", Δt, err)
+	}
+	if r, c := F.Dims(); r != n || c != n {
+		return nil, nil, fmt.Errorf("F of the time update is %d×%d instead of %d×%d", r, c, n, n)
+	}
+	if r := Q.Symmetric(); r != n {
+		return nil, nil, fmt.Errorf("Q of the time update is %d×%d instead of %d×%d", r, r, n, n)
+	}
+	return F, Q, nil
+}
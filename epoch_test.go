@@ -0,0 +1,274 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestTimeTagger(t *testing.T) {
+	var tt timeTagger
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	tt.SetEpoch(t0)
+	if tt.TimeStep() != 0 || tt.sameEpoch() {
+		t.Fatal("next epoch should not be set")
+	}
+	if err := tt.SetNextEpoch(t0.Add(-time.Second)); err == nil {
+		t.Fatal("next epoch before the current one should fail")
+	}
+	if err := tt.SetNextEpoch(t0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.SetNextEpoch(t0.Add(-time.Second)); err == nil || tt.sameEpoch() {
+		t.Fatal("next epoch should be unset after an invalid one")
+	}
+	if err := tt.SetNextTimeStep(2.5); err != nil {
+		t.Fatal(err)
+	}
+	if tt.TimeStep() != 2.5 {
+		t.Fatalf("invalid time step %f", tt.TimeStep())
+	}
+	if e := tt.advance(); !e.Equal(t0.Add(2500 * time.Millisecond)) {
+		t.Fatalf("invalid epoch %s", e)
+	}
+	// Without a next epoch, the epoch does not change.
+	if e := tt.advance(); !e.Equal(t0.Add(2500 * time.Millisecond)) {
+		t.Fatalf("invalid epoch %s", e)
+	}
+	tt.reset()
+	if !tt.Epoch().Equal(t0) {
+		t.Fatalf("epoch not reset: %s", tt.Epoch())
+	}
+}
+
+func TestTimeTaggedMeasurements(t *testing.T) {
+	// 1D constant velocity observed in position, with two measurements at the third epoch.
+	F := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	G := mat64.NewDense(2, 1, nil)
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 1)
+	noise := NewNoiseless(ScaledIdentity(2, 1e-2), ScaledIdentity(1, 0.1))
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	Δts := []float64{1, 1, 0, 1}
+	ys := []float64{1.1, 1.9, 2.05, 3.0}
+
+	type filter interface {
+		Epoch() time.Time
+		SetEpoch(time.Time)
+		SetNextTimeStep(float64) error
+	}
+	updates := map[string]func(y float64) (Estimate, error){}
+	filters := map[string]filter{}
+
+	vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters["Vanilla"] = vanilla
+	updates["Vanilla"] = func(y float64) (Estimate, error) {
+		return vanilla.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	sqrt, _, err := NewSquareRoot(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters["SquareRoot"] = sqrt
+	updates["SquareRoot"] = func(y float64) (Estimate, error) {
+		return sqrt.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	info, _, err := NewInformationFromState(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters["Information"] = info
+	updates["Information"] = func(y float64) (Estimate, error) {
+		return info.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	hkf, _, err := NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters["HybridKF"] = hkf
+	updates["HybridKF"] = func(y float64) (Estimate, error) {
+		hkf.Prepare(F, H)
+		hkf.PreparePNT(DenseIdentity(2))
+		return hkf.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	srif, _, err := NewSRIF(x0, P0, 1, false, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters["SRIF"] = srif
+	updates["SRIF"] = func(y float64) (Estimate, error) {
+		srif.Prepare(F, H)
+		srif.PreparePNT(DenseIdentity(2))
+		return srif.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+	}
+
+	ukf, _, err := NewUKF(x0, P0, func(x *mat64.Vector) *mat64.Vector {
+		var xKp1 mat64.Vector
+		xKp1.MulVec(F, x)
+		return &xKp1
+	}, func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(1, []float64{x.At(0, 0)})
+	}, noise, NewJulierSigmaPoints(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters["UKF"] = ukf
+	updates["UKF"] = func(y float64) (Estimate, error) {
+		return ukf.Update(mat64.NewVector(1, []float64{y}))
+	}
+
+	for name, update := range updates {
+		kf := filters[name]
+		kf.SetEpoch(t0)
+		var prevEst Estimate
+		for k, y := range ys {
+			if err := kf.SetNextTimeStep(Δts[k]); err != nil {
+				t.Fatal(err)
+			}
+			est, err := update(y)
+			if err != nil {
+				t.Fatalf("%s k=%d: %s", name, k, err)
+			}
+			expEpoch := t0.Add(time.Duration(k+1) * time.Second)
+			if k >= 2 {
+				expEpoch = expEpoch.Add(-time.Second)
+			}
+			if !est.Epoch().Equal(expEpoch) || !kf.Epoch().Equal(expEpoch) {
+				t.Fatalf("%s k=%d: epoch is %s instead of %s", name, k, est.Epoch(), expEpoch)
+			}
+			if Δts[k] == 0 {
+				// No time update at the same epoch.
+				if !mat64.EqualApprox(est.PredCovariance(), prevEst.Covariance(), 1e-10) {
+					t.Fatalf("%s: time update performed at the same epoch\n%v\n%v", name, mat64.Formatted(est.PredCovariance()), mat64.Formatted(prevEst.Covariance()))
+				}
+				if math.Abs(est.State().At(1, 0)-prevEst.State().At(1, 0)) > 0.5 || est.Covariance().At(0, 0) >= prevEst.Covariance().At(0, 0) {
+					t.Fatalf("%s: invalid update at the same epoch\n%s", name, est)
+				}
+			} else if prevEst != nil && mat64.EqualApprox(est.PredCovariance(), prevEst.Covariance(), 1e-6) {
+				t.Fatalf("%s k=%d: no time update performed", name, k)
+			}
+			prevEst = est
+		}
+		if err := kf.SetNextTimeStep(-1); err == nil {
+			t.Fatalf("%s: time step backwards should fail", name)
+		}
+	}
+}
+
+func TestTimeStepModel(t *testing.T) {
+	// 1D constant velocity driven by a white noise acceleration, observed in position at uneven epochs.
+	A := mat64.NewDense(2, 2, []float64{0, 1, 0, 0})
+	Γ := mat64.NewDense(2, 1, []float64{0, 1})
+	W := mat64.NewDense(1, 1, []float64{0.1})
+	model := VanLoanModel(A, Γ, W)
+	// F and Q given to the filters are those of a unit time step, and must not be used.
+	F1, Q1, err := VanLoan(A, Γ, W, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	G := mat64.NewDense(2, 1, nil)
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	R := ScaledIdentity(1, 0.2)
+	noise := NewNoiseless(Q1, R)
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 1)
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	Δts := []float64{1, 2.5, 0, 0.5, 4}
+	ys := []float64{1.1, 3.4, 3.6, 4.1, 8.3}
+
+	// Hand propagated reference.
+	refX := make([]*mat64.Vector, len(ys))
+	refP := make([]*mat64.Dense, len(ys))
+	x := mat64.NewVector(2, nil)
+	x.CloneVec(x0)
+	P := mat64.DenseCopyOf(P0)
+	for k, y := range ys {
+		if Δts[k] > 0 {
+			F, Q, err := VanLoan(A, Γ, W, Δts[k])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var Fx mat64.Vector
+			Fx.MulVec(F, x)
+			x.CloneVec(&Fx)
+			var FP, FPFt mat64.Dense
+			FP.Mul(F, P)
+			FPFt.Mul(&FP, F.T())
+			P.Add(&FPFt, Q)
+		}
+		// Scalar measurement update: K = P Hᵀ / (H P Hᵀ + R).
+		s := P.At(0, 0) + R.At(0, 0)
+		K := mat64.NewVector(2, []float64{P.At(0, 0) / s, P.At(1, 0) / s})
+		var Kν mat64.Vector
+		Kν.ScaleVec(y-x.At(0, 0), K)
+		x.AddVec(x, &Kν)
+		var KHP mat64.Dense
+		KHP.Mul(K, P.RowView(0).T())
+		P.Sub(P, &KHP)
+		refX[k] = mat64.NewVector(2, nil)
+		refX[k].CloneVec(x)
+		refP[k] = mat64.DenseCopyOf(P)
+	}
+
+	type filter interface {
+		SetEpoch(time.Time)
+		SetNextTimeStep(float64) error
+		SetTimeStepModel(TimeStepModel)
+		Update(measurement, control *mat64.Vector) (Estimate, error)
+	}
+	vanilla, _, err := NewVanilla(x0, P0, F1, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqrt, _, err := NewSquareRoot(x0, P0, F1, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := NewInformationFromState(x0, P0, F1, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, kf := range map[string]filter{"Vanilla": vanilla, "SquareRoot": sqrt, "Information": info} {
+		kf.SetTimeStepModel(model)
+		kf.SetEpoch(t0)
+		// The time step must be known to compute the time update.
+		if _, err := kf.Update(mat64.NewVector(1, []float64{ys[0]}), mat64.NewVector(1, nil)); err == nil {
+			t.Fatalf("%s: update without a next epoch should fail", name)
+		}
+		for k, y := range ys {
+			if err := kf.SetNextTimeStep(Δts[k]); err != nil {
+				t.Fatal(err)
+			}
+			est, err := kf.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+			if err != nil {
+				t.Fatalf("%s k=%d: %s", name, k, err)
+			}
+			if !mat64.EqualApprox(est.State(), refX[k], 1e-8) {
+				t.Fatalf("%s k=%d: invalid state\n%v\n%v", name, k, mat64.Formatted(est.State()), mat64.Formatted(refX[k]))
+			}
+			if !mat64.EqualApprox(est.Covariance(), refP[k], 1e-8) {
+				t.Fatalf("%s k=%d: invalid covariance\n%v\n%v", name, k, mat64.Formatted(est.Covariance()), mat64.Formatted(refP[k]))
+			}
+		}
+		// A model of the wrong size is rejected.
+		kf.SetTimeStepModel(func(Δt float64) (mat64.Matrix, mat64.Symmetric, error) {
+			return DenseIdentity(3), ScaledIdentity(3, 1), nil
+		})
+		if err := kf.SetNextTimeStep(1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kf.Update(mat64.NewVector(1, []float64{ys[0]}), mat64.NewVector(1, nil)); err == nil {
+			t.Fatalf("%s: model of invalid dimensions should fail", name)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ChristopherRabotin/gokalman"
+	"github.com/ChristopherRabotin/gokalman/od"
+	"github.com/gonum/matrix/mat64"
+)
+
+// Orbit determination of a LEO spacecraft with two-body and J2 dynamics, tracked by three ground stations.
+// Everything is computed by the od package, so no ephemeris is needed.
+func main() {
+	startDT := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	endDT := startDT.Add(24 * time.Hour)
+	timeStep := 10 * time.Second
+	rng := rand.New(rand.NewSource(2017))
+
+	σρ := math.Pow(1e-3, 2)    // m , but all measurements in km.
+	σρDot := math.Pow(1e-6, 2) // mm/s , but all measurements in km/s.
+	stations := []od.Station{
+		od.NewStation("st1", 0, 10, -35.398333, 148.981944, σρ, σρDot),
+		od.NewStation("st2", 0, 10, 40.427222, 355.749444, σρ, σρDot),
+		od.NewStation("st3", 0, 10, 35.247164, 243.205, σρ, σρDot),
+	}
+	for i := range stations {
+		stations[i].Epoch = startDT
+	}
+
+	gravity := od.NewTwoBodyJ2()
+	truthDynamics, err := gokalman.NewRK45(gravity.Derivatives, nil, 1e-10)
+	if err != nil {
+		panic(err)
+	}
+	dynamics, err := gokalman.NewRK45(gravity.Derivatives, gravity.Jacobian, 1e-10)
+	if err != nil {
+		panic(err)
+	}
+	dynamics.Epoch = startDT
+
+	// Propagate the truth until the first station sees the spacecraft, where the estimation starts.
+	Xtrue := od.StateFromOE(7000, 0.001, 30, 80, 40, 0, od.EarthMu)
+	firstDT := startDT
+	for ; !visible(stations, Xtrue, firstDT); firstDT = firstDT.Add(timeStep) {
+		if Xtrue, _, err = truthDynamics.Integrate(Xtrue, 0, timeStep.Seconds()); err != nil {
+			panic(err)
+		}
+	}
+
+	// Initial estimate, off by about a kilometer and a meter per second.
+	Xref := mat64.NewVector(6, []float64{1, -1, 0.5, 1e-3, -1e-3, 5e-4})
+	Xref.AddVec(Xref, Xtrue)
+	P0 := mat64.NewSymDense(6, nil)
+	for i := 0; i < 3; i++ {
+		P0.SetSym(i, i, 10)
+		P0.SetSym(i+3, i+3, 1e-4)
+	}
+	noiseKF := gokalman.NewNoiseless(gokalman.ScaledIdentity(3, 1e-12), mat64.NewSymDense(2, []float64{σρ, 0, 0, σρDot}))
+	kf, _, err := gokalman.NewHybridKF(mat64.NewVector(6, nil), P0, noiseKF, 2)
+	if err != nil {
+		panic(err)
+	}
+	kf.SetEpoch(firstDT)
+	station := &od.Station{} // Measurement model of the station tracking the spacecraft.
+	if err = kf.SetModels(dynamics, station, Xref); err != nil {
+		panic(err)
+	}
+
+	stateExport, err := gokalman.NewCSVExporter([]string{"x", "y", "z", "xDot", "yDot", "zDot"}, ".", "orbitOD.csv")
+	if err != nil {
+		panic(err)
+	}
+
+	measNo := 0
+	for dt := firstDT; !dt.After(endDT); dt = dt.Add(timeStep) {
+		if dt.After(firstDT) {
+			if Xtrue, _, err = truthDynamics.Integrate(Xtrue, 0, timeStep.Seconds()); err != nil {
+				panic(err)
+			}
+		}
+		measured := false
+		for _, st := range stations {
+			if !st.Visible(Xtrue, dt) {
+				continue
+			}
+			measured = true
+			y, merr := st.Measure(Xtrue, dt, rng)
+			if merr != nil {
+				panic(merr)
+			}
+			if measNo == 20 {
+				// The reference is now close enough to the truth to switch to the EKF.
+				kf.EnableEKF()
+			}
+			*station = st
+			est, serr := kf.Step(dt, y)
+			if serr != nil {
+				panic(fmt.Errorf("#%d: %s", measNo, serr))
+			}
+			writeError(stateExport, kf, est, Xtrue)
+			measNo++
+		}
+		if !measured {
+			est, serr := kf.Step(dt, nil)
+			if serr != nil {
+				panic(serr)
+			}
+			writeError(stateExport, kf, est, Xtrue)
+		}
+	}
+	stateExport.Close()
+	fmt.Printf("processed %d measurements\n", measNo)
+}
+
+// writeError writes the error of the full state estimate with respect to the truth.
+func writeError(e *gokalman.CSVExporter, kf *gokalman.HybridKF, est gokalman.Estimate, Xtrue *mat64.Vector) {
+	// The full state estimate is the reference in EKF mode, and the reference plus the deviation otherwise.
+	Xest := mat64.NewVector(6, nil)
+	Xest.CloneVec(kf.Reference())
+	if !kf.EKFEnabled() {
+		Xest.AddVec(Xest, est.State())
+	}
+	truth := gokalman.NewBatchGroundTruth([]*mat64.Vector{Xtrue}, []*mat64.Vector{est.Measurement()})
+	e.Write(truth.ErrorWithOffset(0, est, diff(Xest, est.State())))
+}
+
+// visible returns whether any station sees the spacecraft at X.
+func visible(stations []od.Station, X *mat64.Vector, dt time.Time) bool {
+	for _, st := range stations {
+		if st.Visible(X, dt) {
+			return true
+		}
+	}
+	return false
+}
+
+// diff returns a - b.
+func diff(a, b *mat64.Vector) *mat64.Vector {
+	d := mat64.NewVector(a.Len(), nil)
+	d.SubVec(a, b)
+	return d
+}
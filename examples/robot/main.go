@@ -5,7 +5,6 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"time"
 
 	"github.com/ChristopherRabotin/gokalman"
 	"github.com/gonum/matrix/mat64"
@@ -21,11 +20,12 @@ func main() {
 	//Q := mat64.NewSymDense(2, []float64{0.0003, 0.005, 0.005, 0.1}) // Q true
 	Q := mat64.NewSymDense(2, []float64{5e-2, 5e-4, 5e-4, 1e-3}) // Q small
 	//Q := mat64.NewSymDense(2, []float64{0.5, 0, 0, 1}) // Q big
-	noise := gokalman.NewAWGN(Q, R)
+	seed := int64(2017) // Change the seed for other (reproducible) runs.
+	noise := gokalman.NewSeededAWGN(Q, R, seed)
 	x0 := mat64.NewVector(2, []float64{0, 0})
 	P0 := gokalman.ScaledIdentity(2, 2)
 	// Find a random initial state.
-	x0Noise, _ := distmv.NewNormal(make([]float64, 2), P0, rand.New(rand.NewSource(time.Now().UnixNano())))
+	x0Noise, _ := distmv.NewNormal(make([]float64, 2), P0, rand.New(rand.NewSource(seed)))
 	x0v := x0Noise.Rand(nil)
 	mcX0 := mat64.NewVector(len(x0v), x0v)
 
@@ -38,7 +38,7 @@ func main() {
 		controls[k] = mat64.NewVector(1, []float64{math.Cos(0.75 * float64(k+1) * 0.1)})
 	}
 
-	runs := gokalman.NewMonteCarloRuns(sims, steps, 1, controls, mcKF)
+	runs := gokalman.NewSeededMonteCarloRuns(seed, sims, steps, 1, controls, mcKF)
 	headers := []string{"xi", "xi_dot"}
 	for fNo, contents := range runs.AsCSV(headers) {
 		f, _ := os.Create(fmt.Sprintf("./montecarlo-%s.csv", headers[fNo]))
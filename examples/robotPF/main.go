@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/ChristopherRabotin/gokalman"
+	"github.com/gonum/matrix/mat64"
+)
+
+// A robot moves along a line and only measures its distance to a beacon. Its initial position is either left or
+// right of the beacon at the same distance, which is a multimodal uncertainty: a bootstrap particle filter keeps
+// both hypotheses until the motion of the robot removes the ambiguity.
+func main() {
+	Δt := 0.1
+	beacon := 1.0
+	F := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	f := func(x *mat64.Vector) *mat64.Vector {
+		var xKp1 mat64.Vector
+		xKp1.MulVec(F, x)
+		return &xKp1
+	}
+	h := func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(1, []float64{math.Abs(x.At(0, 0) - beacon)})
+	}
+	Q := mat64.NewSymDense(2, []float64{1e-5, 0, 0, 1e-4})
+	R := mat64.NewSymDense(1, []float64{0.01})
+	rng := rand.New(rand.NewSource(2017))
+
+	// Half of the particles are on each side of the beacon, and all move right.
+	particles := make([]*mat64.Vector, 2000)
+	for i := range particles {
+		x0 := -2.0
+		if i%2 == 0 {
+			x0 = 4
+		}
+		particles[i] = mat64.NewVector(2, []float64{x0 + 0.2*rng.NormFloat64(), 0.35 + 0.05*rng.NormFloat64()})
+	}
+	pf, _, err := gokalman.NewParticleFilter(particles, f, h, nil, gokalman.NewNoiseless(Q, R), gokalman.SystematicResampling{}, rng)
+	if err != nil {
+		panic(err)
+	}
+
+	stateExport, err := gokalman.NewCSVExporter([]string{"x", "xDot"}, ".", "robotPF.csv")
+	if err != nil {
+		panic(err)
+	}
+	truth := mat64.NewVector(2, []float64{-2, 0.35})
+	resamplings := 0
+	for k := 0; k < 120; k++ {
+		truth = f(truth)
+		truth.SetVec(1, truth.At(1, 0)+rng.NormFloat64()*math.Sqrt(Q.At(1, 1)))
+		y := h(truth)
+		y.SetVec(0, y.At(0, 0)+rng.NormFloat64()*math.Sqrt(R.At(0, 0)))
+		est, err := pf.Update(y)
+		if err != nil {
+			panic(err)
+		}
+		if est.(*gokalman.ParticleEstimate).Resampled() {
+			resamplings++
+		}
+		stateExport.Write(gokalman.NewBatchGroundTruth([]*mat64.Vector{truth}, []*mat64.Vector{y}).Error(0, est))
+	}
+	stateExport.Close()
+	fmt.Printf("%d resamplings\n%s\n", resamplings, pf)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/ChristopherRabotin/gokalman"
@@ -12,7 +13,6 @@ import (
 func main() {
 	// Prepare the estimate channels.
 	var wg sync.WaitGroup
-	truthEstChan := make(chan (gokalman.Estimate), 1)
 	vanillaEstChan := make(chan (gokalman.Estimate), 1)
 	informationEstChan := make(chan (gokalman.Estimate), 1)
 	sqrtEstChan := make(chan (gokalman.Estimate), 1)
@@ -57,23 +57,19 @@ func main() {
 	P0 := mat64.NewSymDense(4, []float64{5, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0.01, 0, 0, 0, 0, 0.00001})
 	//P0.ScaleSym(1e10, P0)
 
-	// Truth generation, via a vanilla KF with AWGN.
-	truthNoise := gokalman.NewAWGN(Q, R)
-	truthKF, est0, err := gokalman.NewPurePredictorVanilla(x0, P0, &Fcl, Gcl, H, truthNoise)
+	// Truth generation, via a simulator with AWGN.
+	seed := int64(5044) // Change the seed for other (reproducible) runs.
+	sim, err := gokalman.NewSimulator(x0, &Fcl, Gcl, H, gokalman.NewSeededAWGN(Q, R, seed))
 	if err != nil {
 		panic(err)
 	}
-	go processEst("truth", truthEstChan)
-	truthEstChan <- est0
 
 	scPeriod := 5.431e3                  // Spacecraft period.
 	samples := int((scPeriod / 50) / Δt) // Propagation time in samples.
-	stateTruth := make([]*mat64.Vector, samples)
-	measurements := make([]*mat64.Vector, samples)
 
 	vanillaMCKF, _, _ := gokalman.NewPurePredictorVanilla(x0, P0, F, G, H, gokalman.NewAWGN(Q, R))
 	numMC := 15
-	runs := gokalman.NewMonteCarloRuns(numMC, samples, 2, []*mat64.Vector{mat64.NewVector(2, nil)}, vanillaMCKF)
+	runs := gokalman.NewSeededMonteCarloRuns(seed, numMC, samples, 2, []*mat64.Vector{mat64.NewVector(2, nil)}, vanillaMCKF)
 	// Write the information in N files.
 	headers := []string{"dr", "dr_dot", "dtheta", "dtheta_dot"}
 	for fNo, contents := range runs.AsCSV(headers) {
@@ -84,33 +80,31 @@ func main() {
 
 	// With control via Fcl/Gcl
 	vanillaMCKF, _, _ = gokalman.NewPurePredictorVanilla(x0, P0, &Fcl, Gcl, H, gokalman.NewAWGN(Q, R))
-	runs = gokalman.NewMonteCarloRuns(numMC, samples, 2, []*mat64.Vector{mat64.NewVector(2, nil)}, vanillaMCKF)
+	runs = gokalman.NewSeededMonteCarloRuns(seed, numMC, samples, 2, []*mat64.Vector{mat64.NewVector(2, nil)}, vanillaMCKF)
 	for fNo, contents := range runs.AsCSV(headers) {
 		f, _ := os.Create(fmt.Sprintf("./mc-ctrl-%s.csv", headers[fNo]))
 		f.WriteString(contents)
 		f.Close()
 	}
 
-	for k := 0; k < samples; k++ {
-		est, kferr := truthKF.Update(mat64.NewVector(2, nil), mat64.NewVector(4, nil))
-		if kferr != nil {
-			panic(fmt.Errorf("k=%d %s", k, kferr))
-		}
-		truthEstChan <- est
-		stateTruth[k] = est.State()
-		measurements[k] = est.Measurement()
+	truth, err := sim.Run(samples, nil)
+	if err != nil {
+		panic(err)
 	}
+	measurements := truth.Measurements()
+	// Output the true states to a CSV file.
+	tf, _ := os.Create("./truth.csv")
+	tf.WriteString(strings.Join(headers, ",") + "\n")
+	for _, state := range truth.States() {
+		tf.WriteString(fmt.Sprintf("%f,%f,%f,%f\n", state.At(0, 0), state.At(1, 0), state.At(2, 0), state.At(3, 0)))
+	}
+	tf.Close()
 
 	// KF part
-	close(truthEstChan)
-	wg.Wait()
-
 	go processEst("vanilla", vanillaEstChan)
 	go processEst("information", informationEstChan)
 	go processEst("sqrt", sqrtEstChan)
 
-	truth := gokalman.NewBatchGroundTruth(stateTruth, measurements)
-
 	// Vanilla KF
 	noiseKF := gokalman.NewNoiseless(Q, R)
 	vanillaKF, vest0, err := gokalman.NewVanilla(x0, P0, &Fcl, Gcl, H, noiseKF)
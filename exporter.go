@@ -6,6 +6,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/gonum/matrix/mat64"
 )
 
 // Exporter defines an export interface.
@@ -94,3 +96,75 @@ func NewCustomCSVExporter(headers []string, filepath, filename string, covarBoun
 func NewCSVExporter(headers []string, filepath, filename string) (e *CSVExporter, err error) {
 	return NewCustomCSVExporter(headers, filepath, filename, 2)
 }
+
+// MeasurementCSVExporter exports the measurement and observation deviation of each estimate, whose size may vary from
+// one estimate to the next (cf. MeasurementDescriptor). Each label has its own columns, left empty if not measured.
+type MeasurementCSVExporter struct {
+	labels    []string
+	delimiter string
+	hdlr      *os.File
+}
+
+// Close closes the file.
+func (e MeasurementCSVExporter) Close() (err error) {
+	if _, err = e.hdlr.WriteString(fmt.Sprintf("# Closing date (UTC): %s\n", time.Now().UTC())); err != nil {
+		return
+	}
+	return e.hdlr.Close()
+}
+
+// Write writes the measurement of the estimate to the CSV file.
+// The measurement components are matched to the columns with their labels if the estimate is a LabeledEstimate
+// with labels, and in order otherwise (in which case the measurement must be of the size of the exporter's labels).
+func (e MeasurementCSVExporter) Write(est Estimate) error {
+	meas := est.Measurement()
+	labels := e.labels
+	if lest, ok := est.(LabeledEstimate); ok && lest.MeasurementLabels() != nil {
+		labels = lest.MeasurementLabels()
+	}
+	if labels == nil || meas == nil || meas.Len() != len(labels) {
+		return fmt.Errorf("cannot match the measurement to the labels %v", labels)
+	}
+	var Δobs *mat64.Vector
+	if dest, ok := est.(interface {
+		ObservationDev() *mat64.Vector
+	}); ok {
+		Δobs = dest.ObservationDev()
+	}
+	vals := make([]string, len(e.labels)*2)
+	for i, label := range labels {
+		col := -1
+		for j, eLabel := range e.labels {
+			if eLabel == label {
+				col = 2 * j
+				break
+			}
+		}
+		if col < 0 {
+			return fmt.Errorf("unknown measurement label `%s`", label)
+		}
+		vals[col] = fmt.Sprintf("%f", meas.At(i, 0))
+		if Δobs != nil && Δobs.Len() == meas.Len() {
+			vals[col+1] = fmt.Sprintf("%f", Δobs.At(i, 0))
+		}
+	}
+	_, err := e.hdlr.WriteString(strings.Join(vals, e.delimiter) + "\n")
+	return err
+}
+
+// NewMeasurementCSVExporter initializes a new CSV export of the measurements with the provided labels.
+func NewMeasurementCSVExporter(labels []string, filepath, filename string) (e *MeasurementCSVExporter, err error) {
+	f, err := os.Create(fmt.Sprintf("%s/%s", filepath, filename))
+	if err != nil {
+		return
+	}
+	delimiter := ","
+	hdr := make([]string, len(labels)*2)
+	for i, label := range labels {
+		hdr[2*i] = label
+		hdr[2*i+1] = label + "-Δobs"
+	}
+	f.WriteString(fmt.Sprintf("# Creation date (UTC): %s\n%s\n", time.Now(), strings.Join(hdr, delimiter)))
+	e = &MeasurementCSVExporter{labels, delimiter, f}
+	return
+}
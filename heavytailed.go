@@ -0,0 +1,213 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/gonum/stat/distuv"
+)
+
+// IIDNoise is a white noise sequence whose independent samples follow a possibly non-Gaussian distribution of
+// covariance Σ. Use NewStudentTNoise, NewMixtureNoise or NewUniformNoise to initialize, and NewSequenceNoise to use
+// it as the process or measurement noise. The noise is seeded from the time unless Seed is called.
+type IIDNoise struct {
+	name     string
+	Σ        mat64.Symmetric
+	L        *mat64.Dense                        // Factor of Σ
+	standard func(r *rand.Rand, z *mat64.Vector) // Draws z such that E[z] = 0 and E[z*zᵀ] = I
+	rand     *rand.Rand
+	randSource
+}
+
+func newIIDNoise(name string, Σ mat64.Symmetric, standard func(r *rand.Rand, z *mat64.Vector)) (*IIDNoise, error) {
+	if Σ == nil {
+		return nil, errors.New("covariance must be provided")
+	}
+	L, err := noiseFactor(Σ)
+	if err != nil {
+		return nil, fmt.Errorf("%s covariance: %s", name, err)
+	}
+	n := &IIDNoise{name, Σ, L, standard, nil, randSource{}}
+	n.Reset()
+	return n, nil
+}
+
+// NewStudentTNoise returns a new multivariate Student-t noise of covariance Σ with ν > 2 degrees of freedom. The
+// lower ν, the heavier the tails; it tends to a Gaussian noise as ν grows.
+func NewStudentTNoise(Σ mat64.Symmetric, ν float64) (*IIDNoise, error) {
+	if ν <= 2 {
+		return nil, errors.New("ν must be greater than 2 for the covariance to be defined")
+	}
+	return newIIDNoise("StudentT", Σ, func(r *rand.Rand, z *mat64.Vector) {
+		// A Student-t is a Gaussian scaled by sqrt(ν/χ²_ν), whose variance is ν/(ν-2).
+		scale := math.Sqrt((ν - 2) / distuv.ChiSquared{K: ν, Src: r}.Rand())
+		for i := 0; i < z.Len(); i++ {
+			z.SetVec(i, scale*r.NormFloat64())
+		}
+	})
+}
+
+// NewMixtureNoise returns a new contaminated Gaussian noise of covariance Σ: each sample is drawn from the nominal
+// Gaussian with probability 1-ε, and from a Gaussian whose covariance is κ times the nominal one with probability ε.
+// The nominal covariance is Σ/(1-ε+ε*κ), such that the mixture covariance is Σ.
+func NewMixtureNoise(Σ mat64.Symmetric, ε, κ float64) (*IIDNoise, error) {
+	if ε < 0 || ε > 1 {
+		return nil, errors.New("ε must be within [0;1]")
+	}
+	if κ <= 0 {
+		return nil, errors.New("κ must be strictly positive")
+	}
+	nominal := 1 / math.Sqrt(1-ε+ε*κ)
+	return newIIDNoise("Mixture", Σ, func(r *rand.Rand, z *mat64.Vector) {
+		scale := nominal
+		if r.Float64() < ε {
+			scale *= math.Sqrt(κ)
+		}
+		for i := 0; i < z.Len(); i++ {
+			z.SetVec(i, scale*r.NormFloat64())
+		}
+	})
+}
+
+// NewUniformNoise returns a new noise of covariance Σ whose components, prior to correlation by Σ, are uniformly
+// distributed within ±sqrt(3) standard deviations.
+func NewUniformNoise(Σ mat64.Symmetric) (*IIDNoise, error) {
+	return newIIDNoise("Uniform", Σ, func(r *rand.Rand, z *mat64.Vector) {
+		for i := 0; i < z.Len(); i++ {
+			z.SetVec(i, math.Sqrt(3)*(2*r.Float64()-1))
+		}
+	})
+}
+
+// Next implements the NoiseSequence interface.
+func (n *IIDNoise) Next() *mat64.Vector {
+	z := mat64.NewVector(n.Size(), nil)
+	n.standard(n.rand, z)
+	var Lz mat64.Vector
+	Lz.MulVec(n.L, z)
+	return &Lz
+}
+
+// Covariance implements the NoiseSequence interface.
+func (n *IIDNoise) Covariance() mat64.Symmetric {
+	return n.Σ
+}
+
+// MarginalCovariance implements the NoiseSequence interface: the samples are independent, so it is Σ.
+func (n *IIDNoise) MarginalCovariance() mat64.Symmetric {
+	return n.Σ
+}
+
+// Size implements the NoiseSequence interface.
+func (n *IIDNoise) Size() int {
+	return n.Σ.Symmetric()
+}
+
+// Reset implements the NoiseSequence interface.
+func (n *IIDNoise) Reset() {
+	n.rand = n.rng()
+}
+
+// Seed implements the Seeder interface.
+func (n *IIDNoise) Seed(seed int64) {
+	n.setSeed(seed)
+	n.Reset()
+}
+
+// String implements the Stringer interface.
+func (n *IIDNoise) String() string {
+	return fmt.Sprintf("%s{Σ=%v}", n.name, mat64.Formatted(n.Σ, mat64.Prefix("   ")))
+}
+
+// OutlierNoise injects gross outliers in a noise sequence: at each sample, with the provided rate, an outlier of
+// ±N standard deviations (per the sequence marginal covariance) is added on a random component. Its covariances are
+// those of the sequence, i.e. the outliers are not accounted for, as they are meant to be rejected by a
+// MeasurementEditor.
+// The outliers are drawn from the time unless Seed is called.
+type OutlierNoise struct {
+	sequence NoiseSequence
+	rate, N  float64
+	k        int   // Number of samples since the last reset
+	outliers []int // Indexes of the samples with an outlier since the last reset
+	rand     *rand.Rand
+	randSource
+}
+
+// NewOutlierNoise returns a new OutlierNoise which adds ±N standard deviations outliers to the sequence at the
+// provided rate within [0;1].
+func NewOutlierNoise(sequence NoiseSequence, rate, N float64) (*OutlierNoise, error) {
+	if sequence == nil {
+		return nil, errors.New("noise sequence must be provided")
+	}
+	if rate < 0 || rate > 1 {
+		return nil, errors.New("rate must be within [0;1]")
+	}
+	if N <= 0 {
+		return nil, errors.New("N must be strictly positive")
+	}
+	n := &OutlierNoise{sequence, rate, N, 0, nil, nil, randSource{}}
+	n.rand = n.rng()
+	return n, nil
+}
+
+// Next implements the NoiseSequence interface.
+func (n *OutlierNoise) Next() *mat64.Vector {
+	Σ := n.sequence.MarginalCovariance()
+	sample := n.sequence.Next()
+	if n.rand.Float64() < n.rate {
+		i := n.rand.Intn(sample.Len())
+		outlier := n.N * math.Sqrt(Σ.At(i, i))
+		if n.rand.Intn(2) == 0 {
+			outlier = -outlier
+		}
+		sample.SetVec(i, sample.At(i, 0)+outlier)
+		n.outliers = append(n.outliers, n.k)
+	}
+	n.k++
+	return sample
+}
+
+// Outliers returns the indexes of the samples which include an outlier since the last reset, e.g. to evaluate the
+// measurement editing.
+func (n *OutlierNoise) Outliers() []int {
+	return n.outliers
+}
+
+// Covariance implements the NoiseSequence interface.
+func (n *OutlierNoise) Covariance() mat64.Symmetric {
+	return n.sequence.Covariance()
+}
+
+// MarginalCovariance implements the NoiseSequence interface: that of the sequence, without the outliers.
+func (n *OutlierNoise) MarginalCovariance() mat64.Symmetric {
+	return n.sequence.MarginalCovariance()
+}
+
+// Size implements the NoiseSequence interface.
+func (n *OutlierNoise) Size() int {
+	return n.sequence.Size()
+}
+
+// Reset implements the NoiseSequence interface.
+func (n *OutlierNoise) Reset() {
+	n.sequence.Reset()
+	n.rand = n.rng()
+	n.k = 0
+	n.outliers = nil
+}
+
+// Seed implements the Seeder interface: the sequence and the outliers are seeded with different seeds derived from
+// the provided one.
+func (n *OutlierNoise) Seed(seed int64) {
+	n.sequence.Seed(MonteCarloSeed(seed, 0))
+	n.setSeed(MonteCarloSeed(seed, 1))
+	n.Reset()
+}
+
+// String implements the Stringer interface.
+func (n *OutlierNoise) String() string {
+	return fmt.Sprintf("Outlier{rate=%f, N=%f\n%s}", n.rate, n.N, n.sequence)
+}
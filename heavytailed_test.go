@@ -0,0 +1,139 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestIIDNoise(t *testing.T) {
+	Σ := mat64.NewSymDense(2, []float64{4, 1, 1, 2})
+	tNoise, err := NewStudentTNoise(Σ, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixture, err := NewMixtureNoise(Σ, 0.1, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uniform, err := NewUniformNoise(Σ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range []struct {
+		noise    *IIDNoise
+		kurtosis func(float64) bool // Checks the excess kurtosis of the first component
+	}{
+		{tNoise, func(k float64) bool { return k > 1 }},
+		{mixture, func(k float64) bool { return k > 1 }},
+		{uniform, func(k float64) bool { return k < -0.5 }},
+	} {
+		test.noise.Seed(2017)
+		samples := 100000
+		cov := mat64.NewSymDense(2, nil)
+		var m4 float64
+		for s := 0; s < samples; s++ {
+			w := test.noise.Next()
+			cov.SymRankOne(cov, 1/float64(samples), w)
+			m4 += math.Pow(w.At(0, 0), 4) / float64(samples)
+		}
+		if !mat64.EqualApprox(cov, Σ, 0.15) {
+			t.Fatalf("noise #%d: invalid covariance\n%v", i, mat64.Formatted(cov))
+		}
+		if k := m4/(cov.At(0, 0)*cov.At(0, 0)) - 3; !test.kurtosis(k) {
+			t.Fatalf("noise #%d: unexpected excess kurtosis %f", i, k)
+		}
+		// Seeded samples are reproduced after a reset.
+		test.noise.Reset()
+		w0 := test.noise.Next()
+		test.noise.Reset()
+		if !mat64.Equal(w0, test.noise.Next()) {
+			t.Fatalf("noise #%d: seeded samples differ after reset", i)
+		}
+	}
+}
+
+func TestOutlierNoise(t *testing.T) {
+	R := mat64.NewSymDense(1, []float64{0.01})
+	white, _ := NewWhiteNoise(R)
+	outliers, err := NewOutlierNoise(white, 0.05, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outliers.Covariance() != mat64.Symmetric(R) {
+		t.Fatal("the outliers must not change the covariance")
+	}
+	process, _ := NewWhiteNoise(mat64.NewSymDense(2, []float64{1e-6, 0, 0, 1e-5}))
+	noise, _ := NewSequenceNoise(process, outliers)
+	noise.Seed(2017)
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	x0 := mat64.NewVector(2, []float64{0, 0.1})
+	sim, err := NewSimulator(x0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := 400
+	truth, err := sim.Run(steps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	injected := append([]int(nil), outliers.Outliers()...)
+	if rate := float64(len(injected)) / float64(steps); rate < 0.02 || rate > 0.1 {
+		t.Fatalf("invalid outlier rate %f", rate)
+	}
+	// The measurement editing must reject the outliers, and only those once converged.
+	kf, _, err := NewVanilla(x0, ScaledIdentity(2, 1e-2), F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf.SetMeasurementEditor(NewNσEditor(5, 0))
+	isOutlier := make(map[int]bool)
+	for _, k := range injected {
+		isOutlier[k] = true
+	}
+	for k, y := range truth.Measurements() {
+		est, err := kf.Update(y, mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k > 20 && est.Rejected() != isOutlier[k] {
+			t.Fatalf("k=%d: rejected=%v but outlier=%v", k, est.Rejected(), isOutlier[k])
+		}
+	}
+	// Seeded outliers are reproduced after a reset.
+	sim.Reset()
+	if _, err = sim.Run(steps, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(outliers.Outliers()) != len(injected) || outliers.Outliers()[0] != injected[0] {
+		t.Fatal("seeded outliers differ after reset")
+	}
+}
+
+func TestHeavyTailedErrors(t *testing.T) {
+	Σ := ScaledIdentity(2, 1)
+	if _, err := NewStudentTNoise(Σ, 2); err == nil {
+		t.Fatal("ν=2 did not fail")
+	}
+	if _, err := NewMixtureNoise(Σ, 1.5, 10); err == nil {
+		t.Fatal("invalid ε did not fail")
+	}
+	if _, err := NewMixtureNoise(Σ, 0.1, 0); err == nil {
+		t.Fatal("invalid κ did not fail")
+	}
+	if _, err := NewUniformNoise(nil); err == nil {
+		t.Fatal("missing covariance did not fail")
+	}
+	white, _ := NewWhiteNoise(Σ)
+	if _, err := NewOutlierNoise(nil, 0.1, 10); err == nil {
+		t.Fatal("missing sequence did not fail")
+	}
+	if _, err := NewOutlierNoise(white, -0.1, 10); err == nil {
+		t.Fatal("invalid rate did not fail")
+	}
+	if _, err := NewOutlierNoise(white, 0.1, 0); err == nil {
+		t.Fatal("invalid N did not fail")
+	}
+}
@@ -83,6 +83,18 @@ func AsSymDense(m *mat64.Dense) (*mat64.SymDense, error) {
 	return mat64.NewSymDense(r, vals), nil
 }
 
+// symmetrize returns the symmetric part (m + m')/2 of the square matrix m, e.g. to remove rounding errors.
+func symmetrize(m mat64.Matrix) *mat64.SymDense {
+	n, _ := m.Dims()
+	S := mat64.NewSymDense(n, nil)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			S.SetSym(i, j, (m.At(i, j)+m.At(j, i))/2)
+		}
+	}
+	return S
+}
+
 // DimensionAgreement defines how two matrices' dimensions should agree.
 type DimensionAgreement uint8
 
@@ -145,6 +157,10 @@ func HouseholderTransf(A *mat64.Dense, n, m int) {
 		for i := k; i < m+n; i++ {
 			sigma += math.Pow(A.At(i, k), 2)
 		}
+		if sigma == 0 {
+			// This column is already zero below the diagonal.
+			continue
+		}
 		sigma = math.Sqrt(sigma) * Sign(A.At(k, k))
 		u := make([]float64, m+n)
 		u[k] = A.At(k, k) + sigma
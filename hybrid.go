@@ -4,11 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/gonum/matrix/mat64"
 )
 
-// NewHybridKF returns a new hybrid Kalman Filter which can be used both as a CKF and EKF.
+// NewHybridKF returns a new hybrid Kalman Filter which can be used both as a CKF and EKF, optionally iterated (IEKF).
 // Warning: there is a failsafe preventing any update prior to updating the matrices.
 // Usage:
 // ```
@@ -29,20 +30,43 @@ func NewHybridKF(x0 *mat64.Vector, P0 mat64.Symmetric, noise Noise, measSize int
 	// Populate with the initial values.
 	cr, _ := P0.Dims()
 	predCovar := mat64.NewSymDense(cr, nil)
-	est0 := &HybridKFEstimate{nil, nil, x0, mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), P0, predCovar, nil}
-	return &HybridKF{nil, nil, nil, noise, est0, false, true, false, measSize, 0}, est0, nil
+	est0 := &HybridKFEstimate{
+		state:     x0,
+		meas:      mat64.NewVector(measSize, nil),
+		innov:     mat64.NewVector(measSize, nil),
+		Δobs:      mat64.NewVector(measSize, nil),
+		covar:     P0,
+		predCovar: predCovar,
+	}
+	return &HybridKF{Noise: noise, prevEst: est0, locked: true, measSize: measSize}, est0, nil
 }
 
 // HybridKF defines a hybrid kalman filter for non-linear dynamical systems. Use NewHybridKF to initialize.
 type HybridKF struct {
 	Φ, Htilde, Γ *mat64.Dense
 	Noise        Noise
+	dmcQ         mat64.Symmetric        // Process noise of the DMC accelerations, used instead of the Noise's for the next update.
+	measDesc     *MeasurementDescriptor // Descriptor of the next measurement, if set with PrepareMeasurement.
 	prevEst      *HybridKFEstimate
 	ekfMode      bool // Allows switching between CKF and EKF.
 	locked       bool // Locks the KF to ensure Prepare is called.
 	sncEnabled   bool // Stores whether we should enable or disable the state noise compensation.
 	measSize     int  // Stores the measurement vector size, needed only for Predict()
 	step         int
+	editor       MeasurementEditor
+	sequential   bool // Process each scalar measurement one at a time.
+	dynamics     DynamicsModel
+	measModel    MeasurementModel
+	xRef         *mat64.Vector // Reference state at the epoch of the latest estimate, used with the models.
+	refUpdated   bool          // Whether the reference already includes the latest estimated deviation (EKF).
+	iekfTol      float64       // Convergence threshold on the norm of the change of the IEKF state deviation.
+	iekfMaxIter  int           // Maximum number of IEKF iterations, zero if the IEKF is disabled.
+	iekfModel    MeasurementModel
+	iekfRef      *mat64.Vector // Reference state of the next update, around which the IEKF relinearises.
+	iekfEpoch    time.Time
+	pcc          mat64.Symmetric // Covariance of the consider parameters, nil if the consider analysis is disabled.
+	Θ, Hc        *mat64.Dense    // Consider sensitivity and measurement partials of the next update, if set with PrepareConsider.
+	timeTagger
 }
 
 // EKFEnabled returns whether the KF is in EKF mode.
@@ -56,8 +80,16 @@ func (kf *HybridKF) EnableEKF() {
 }
 
 // DisableEKF switches this back to a CKF mode.
+// If Step already updated the reference with the latest estimate, the CKF starts from a zero deviation.
 func (kf *HybridKF) DisableEKF() {
 	kf.ekfMode = false
+	if kf.refUpdated {
+		// Copy the latest estimate such that the one returned by Step is not modified.
+		prevEst := *kf.prevEst
+		prevEst.state = mat64.NewVector(prevEst.state.Len(), nil)
+		kf.prevEst = &prevEst
+		kf.refUpdated = false
+	}
 }
 
 func (kf *HybridKF) String() string {
@@ -74,13 +106,173 @@ func (kf *HybridKF) GetNoise() Noise {
 	return kf.Noise
 }
 
+// IEKFEnabled returns whether the measurement updates are iterated (IEKF).
+func (kf *HybridKF) IEKFEnabled() bool {
+	return kf.iekfMaxIter > 0
+}
+
+// EnableIEKF switches to the iterated EKF measurement update, which relinearises the measurement model around the
+// updated state until the norm of the change of the state deviation is below tolerance, or after maxIterations.
+// The measurement model and the reference state are provided with PrepareIterated, which Step calls with the models.
+// The IEKF is not available when processing the measurements sequentially.
+func (kf *HybridKF) EnableIEKF(tolerance float64, maxIterations int) error {
+	if tolerance <= 0 {
+		return errors.New("IEKF tolerance must be strictly positive")
+	}
+	if maxIterations < 1 {
+		return errors.New("IEKF requires at least one iteration")
+	}
+	kf.iekfTol = tolerance
+	kf.iekfMaxIter = maxIterations
+	return nil
+}
+
+// DisableIEKF switches back to a single measurement update.
+func (kf *HybridKF) DisableIEKF() {
+	kf.iekfMaxIter = 0
+}
+
+// SequentialEnabled returns whether the measurements are processed one scalar at a time.
+func (kf *HybridKF) SequentialEnabled() bool {
+	return kf.sequential
+}
+
+// EnableSequential switches to processing each scalar measurement one at a time, which avoids the inversion of H*P*H' + R.
+// If R is not diagonal, the measurements are first whitened with its Cholesky factor.
+// Measurement editing is then applied to each (whitened) scalar measurement.
+func (kf *HybridKF) EnableSequential() {
+	kf.sequential = true
+}
+
+// DisableSequential switches back to processing the whole measurement vector at once.
+func (kf *HybridKF) DisableSequential() {
+	kf.sequential = false
+}
+
+// SetMeasurementEditor sets the measurement editing policy used to reject outliers (nil to disable).
+func (kf *HybridKF) SetMeasurementEditor(e MeasurementEditor) {
+	kf.editor = e
+}
+
+// ConsiderEnabled returns whether the consider covariance analysis is enabled.
+func (kf *HybridKF) ConsiderEnabled() bool {
+	return kf.pcc != nil
+}
+
+// EnableConsider enables the consider covariance analysis, where Pcc is the covariance of the unestimated consider
+// parameters. Their effect on the next update is set with PrepareConsider. The estimates are unchanged, but also
+// report the consider covariance, which is initialized to the covariance of the latest estimate.
+// The consider analysis is not available when processing the measurements sequentially.
+func (kf *HybridKF) EnableConsider(Pcc mat64.Symmetric) error {
+	if Pcc == nil {
+		return errors.New("consider covariance Pcc must be provided")
+	}
+	q, _ := Pcc.Dims()
+	kf.pcc = Pcc
+	kf.Θ, kf.Hc = nil, nil
+	kf.prevEst.considerCovar = kf.prevEst.Covariance()
+	kf.prevEst.considerCross = mat64.NewDense(kf.prevEst.State().Len(), q, nil)
+	return nil
+}
+
+// DisableConsider disables the consider covariance analysis.
+func (kf *HybridKF) DisableConsider() {
+	kf.pcc = nil
+	kf.Θ, kf.Hc = nil, nil
+}
+
+// PrepareConsider sets the sensitivity Θ of the state to the consider parameters over the next time update, i.e.
+// x_{k+1} = Φ*x_k + Θ*c, and the partials Hc of the next measurement with respect to them, i.e. y = H̃*x + Hc*c.
+// Either may be nil if the consider parameters do not affect the dynamics or the measurement. Like PreparePNT,
+// it only applies to the next update and may be called prior to Step.
+func (kf *HybridKF) PrepareConsider(Θ, Hc *mat64.Dense) error {
+	if err := checkConsider(kf.pcc, Θ, Hc); err != nil {
+		return err
+	}
+	if Θ != nil {
+		if err := checkMatDims(Θ, kf.prevEst.State(), "Θ", "state", rows2rows); err != nil {
+			return err
+		}
+	}
+	kf.Θ = Θ
+	kf.Hc = Hc
+	return nil
+}
+
 // Prepare unlocks the KF ready for the next Update call.
 func (kf *HybridKF) Prepare(Φ, Htilde *mat64.Dense) {
 	kf.Φ = Φ
 	kf.Htilde = Htilde
+	kf.measDesc = nil
+	kf.iekfRef = nil
+	kf.dmcQ = nil
 	kf.locked = false
 }
 
+// PrepareIterated sets the measurement model and the reference state at the epoch of the next update, around which
+// the IEKF relinearises. It must be called after Prepare (or PrepareMeasurement) if the IEKF is enabled.
+func (kf *HybridKF) PrepareIterated(model MeasurementModel, Xref *mat64.Vector, epoch time.Time) error {
+	if model == nil {
+		return errors.New("IEKF requires a measurement model")
+	}
+	if err := checkMatDims(Xref, kf.prevEst.State(), "reference state", "state", rows2rows); err != nil {
+		return err
+	}
+	kf.iekfModel = model
+	kf.iekfRef = Xref
+	kf.iekfEpoch = epoch
+	return nil
+}
+
+// PrepareMeasurement unlocks the KF ready for the next Update call with a measurement whose size and noise
+// may differ from the previous ones. The descriptor's R is used instead of the Noise's for the next update only.
+func (kf *HybridKF) PrepareMeasurement(Φ *mat64.Dense, m *MeasurementDescriptor) error {
+	if err := checkMatDims(Φ, m.Htilde, "Φ", "H", cols2cols); err != nil {
+		return err
+	}
+	kf.Prepare(Φ, m.Htilde)
+	kf.measDesc = m
+	return nil
+}
+
+// measurementNoise returns the measurement noise of the next update.
+func (kf *HybridKF) measurementNoise() mat64.Symmetric {
+	if kf.measDesc != nil {
+		return kf.measDesc.R
+	}
+	return kf.Noise.MeasurementMatrix()
+}
+
+// measurementLabels returns the labels of the next measurement, if any.
+func (kf *HybridKF) measurementLabels() []string {
+	if kf.measDesc != nil {
+		return kf.measDesc.Labels
+	}
+	return nil
+}
+
+// crossCovariance returns the cross covariance Γ*S of the process noise over the next time update and the measurement
+// noise, where S is the Noise's, or nil if they are independent. It only applies with the SNC (cf. PreparePNT) and
+// the Noise's Q and R, i.e. neither with the DMC nor with a MeasurementDescriptor.
+func (kf *HybridKF) crossCovariance() (mat64.Matrix, error) {
+	S := kf.Noise.CrossCovariance()
+	if S == nil || !kf.sncEnabled || kf.dmcQ != nil || kf.measDesc != nil {
+		return nil, nil
+	}
+	if err := checkCrossCovariance(kf.Noise.ProcessMatrix(), kf.Noise.MeasurementMatrix(), S); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(kf.Γ, S, "Γ", "S", cols2rows); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(S, kf.Htilde, "S", "H", cols2rows); err != nil {
+		return nil, err
+	}
+	var ΓS mat64.Dense
+	ΓS.Mul(kf.Γ, S)
+	return &ΓS, nil
+}
+
 // PreparePNT prepares the process noise transition matrix and enabled the SNC
 // for the next update. WARNING: If not called, the SNC *will not* be included.
 func (kf *HybridKF) PreparePNT(Γ *mat64.Dense) {
@@ -88,6 +280,105 @@ func (kf *HybridKF) PreparePNT(Γ *mat64.Dense) {
 	kf.sncEnabled = true
 }
 
+// PrepareDMC unlocks the KF ready for the next Update call with dynamic model compensation.
+// Φ and Htilde are those of the base state; they are augmented with the DMC accelerations, and the process noise
+// of the augmented state over Δt replaces the Noise's process matrix for the next update only.
+// NOTE: the KF must have been initialized with the augmented state (cf. DMC.AugmentState).
+func (kf *HybridKF) PrepareDMC(dmc *DMC, Φ, Htilde *mat64.Dense, Δt float64) error {
+	ΦAug, err := dmc.STM(Φ, Δt)
+	if err != nil {
+		return err
+	}
+	n, _ := Φ.Dims()
+	if size := kf.prevEst.State().Len(); size != n+dmc.Size() {
+		return fmt.Errorf("state of size %d is not augmented with the %d DMC accelerations of a base state of size %d", size, dmc.Size(), n)
+	}
+	kf.Prepare(ΦAug, dmc.Htilde(Htilde))
+	kf.PreparePNT(DenseIdentity(n + dmc.Size()))
+	kf.dmcQ = dmc.ProcessNoise(n, Δt)
+	return nil
+}
+
+// SetModels sets the dynamics and measurement models, and the reference state at the epoch of the latest estimate
+// (cf. SetEpoch), such that Step computes Φ, H̃ and the computed observations instead of the caller.
+func (kf *HybridKF) SetModels(dynamics DynamicsModel, measurement MeasurementModel, Xref *mat64.Vector) error {
+	if dynamics == nil || measurement == nil {
+		return errors.New("both the dynamics and the measurement models must be provided")
+	}
+	if err := checkMatDims(Xref, kf.prevEst.State(), "reference state", "state", rows2rows); err != nil {
+		return err
+	}
+	kf.dynamics = dynamics
+	kf.measModel = measurement
+	kf.xRef = Xref
+	kf.refUpdated = false
+	return nil
+}
+
+// Reference returns the reference state at the epoch of the latest estimate. In CKF mode, the full state estimate
+// is the reference plus the estimated deviation. In EKF mode, the reference is updated with each estimate.
+func (kf *HybridKF) Reference() *mat64.Vector {
+	return kf.xRef
+}
+
+// Step propagates the reference state with the dynamics model to the provided epoch and performs the time update,
+// and the measurement update with the measurement model if an observation is provided (nil for a prediction only).
+// To enable the SNC, call PreparePNT prior to Step. Requires SetModels to be called first.
+// If Step fails, the next epoch is unset and the epoch of the latest estimate is unchanged.
+func (kf *HybridKF) Step(epoch time.Time, realObservation *mat64.Vector) (est Estimate, err error) {
+	if kf.dynamics == nil {
+		return nil, errors.New("models not set (call SetModels() first)")
+	}
+	if err = kf.SetNextEpoch(epoch); err != nil {
+		return nil, err
+	}
+	Xref := kf.xRef
+	if kf.ekfMode && !kf.refUpdated {
+		// The deviation estimated prior to switching to EKF mode must first be applied to the reference.
+		Xref = mat64.NewVector(kf.xRef.Len(), nil)
+		Xref.AddVec(kf.xRef, kf.prevEst.State())
+	}
+	X, Φ, err := kf.dynamics.Propagate(Xref, kf.Epoch(), epoch)
+	if err != nil {
+		// The epoch is not reached, so the next update must not use it.
+		kf.nextSet = false
+		return nil, fmt.Errorf("could not propagate to %s: %s", epoch, err)
+	}
+	if realObservation == nil {
+		kf.Prepare(Φ, kf.Htilde)
+		if est, err = kf.Predict(); err != nil {
+			kf.nextSet = false
+			return nil, err
+		}
+		kf.xRef = X
+		kf.refUpdated = kf.ekfMode
+		return
+	}
+	computedObservation, Htilde, err := kf.measModel.Observe(X, epoch)
+	if err != nil {
+		kf.nextSet = false
+		return nil, fmt.Errorf("could not compute observation at %s: %s", epoch, err)
+	}
+	kf.Prepare(Φ, Htilde)
+	if kf.IEKFEnabled() {
+		if err = kf.PrepareIterated(kf.measModel, X, epoch); err != nil {
+			kf.nextSet = false
+			return nil, err
+		}
+	}
+	if est, err = kf.Update(realObservation, computedObservation); err != nil {
+		kf.nextSet = false
+		return nil, err
+	}
+	if kf.ekfMode {
+		// Update the reference trajectory with the estimated deviation.
+		X.AddVec(X, est.State())
+	}
+	kf.xRef = X
+	kf.refUpdated = kf.ekfMode
+	return
+}
+
 // Update computes a full time and measurement update.
 // Will return an error if the KF is locked (call Prepare to unlock).
 func (kf *HybridKF) Update(realObservation, computedObservation *mat64.Vector) (est Estimate, err error) {
@@ -109,23 +400,78 @@ func (kf *HybridKF) fullUpdate(purePrediction bool, realObservation, computedObs
 		if err = checkMatDims(realObservation, computedObservation, "real observation", "computed observation", rowsAndcols); err != nil {
 			return nil, err
 		}
+		if err = checkMatDims(realObservation, kf.Htilde, "observation", "H", rows2rows); err != nil {
+			return nil, err
+		}
+		if kf.IEKFEnabled() {
+			if kf.sequential {
+				return nil, errors.New("IEKF is not available with sequential measurements")
+			}
+			if kf.iekfRef == nil {
+				return nil, errors.New("IEKF reference not set (call PrepareIterated() first)")
+			}
+		}
+		if kf.pcc != nil {
+			if kf.sequential {
+				return nil, errors.New("consider analysis is not available with sequential measurements")
+			}
+			if kf.Hc != nil {
+				if err = checkMatDims(kf.Hc, kf.Htilde, "Hc", "H", rows2rows); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		n, _ := kf.Φ.Dims()
+		kf.Φ = DenseIdentity(n)
+		kf.sncEnabled = false
+		kf.dmcQ = nil
+		kf.Θ = nil
+	}
+	var M mat64.Matrix // Cross covariance of the process noise over the time update and the measurement noise.
+	if !purePrediction {
+		if M, err = kf.crossCovariance(); err != nil {
+			return nil, err
+		}
+		if M != nil && kf.sequential {
+			return nil, errors.New("correlated process and measurement noises are not available with sequential measurements")
+		}
+		if M != nil && kf.pcc != nil {
+			return nil, errors.New("correlated process and measurement noises are not available with the consider analysis")
+		}
 	}
 	// PBar
 	var PBar, ΦP mat64.Dense
+	var ΓQΓt *mat64.Dense
 	ΦP.Mul(kf.Φ, kf.prevEst.Covariance())
 	PBar.Mul(&ΦP, kf.Φ.T())
 	if kf.sncEnabled {
 		// Add the process noise
-		var ΓQΓt, ΓQ mat64.Dense
-		ΓQ.Mul(kf.Γ, kf.Noise.ProcessMatrix())
+		Q := kf.Noise.ProcessMatrix()
+		if kf.dmcQ != nil {
+			Q = kf.dmcQ
+		}
+		var ΓQ mat64.Dense
+		ΓQ.Mul(kf.Γ, Q)
+		ΓQΓt = new(mat64.Dense)
 		ΓQΓt.Mul(&ΓQ, kf.Γ.T())
-		PBar.Add(&PBar, &ΓQΓt)
+		PBar.Add(&PBar, ΓQΓt)
+	}
+	// Consider covariance time update, which remains nil if the consider analysis is disabled.
+	var PcBar mat64.Symmetric
+	var PxcBar *mat64.Dense
+	if kf.pcc != nil {
+		var PcBarDense *mat64.Dense
+		PcBarDense, PxcBar = considerTimeUpdate(kf.Φ, kf.prevEst.considerCovar, kf.prevEst.considerCross, kf.Θ, kf.pcc, ΓQΓt)
+		PcBar = symmetrize(PcBarDense)
 	}
 
 	if purePrediction {
 		var xBar mat64.Vector
 		if kf.ekfMode {
-			xBar = *mat64.NewVector(6, nil)
+			xBar = *mat64.NewVector(kf.prevEst.State().Len(), nil)
 		} else {
 			xBar.MulVec(kf.Φ, kf.prevEst.State())
 		}
@@ -134,77 +480,228 @@ func (kf *HybridKF) fullUpdate(purePrediction bool, realObservation, computedObs
 		if symerr != nil {
 			return nil, symerr
 		}
-		est = &HybridKFEstimate{kf.Φ, kf.Γ, &xBar, mat64.NewVector(kf.measSize, nil), mat64.NewVector(kf.measSize, nil), mat64.NewVector(kf.measSize, nil), PBarSym, PBarSym, mat64.NewDense(1, 1, nil)}
+		var Γ *mat64.Dense
+		if kf.sncEnabled {
+			Γ = mat64.DenseCopyOf(kf.Γ)
+		}
+		est = &HybridKFEstimate{
+			Φ:             mat64.DenseCopyOf(kf.Φ),
+			Γ:             Γ,
+			state:         &xBar,
+			meas:          mat64.NewVector(kf.measSize, nil),
+			innov:         mat64.NewVector(kf.measSize, nil),
+			Δobs:          mat64.NewVector(kf.measSize, nil),
+			covar:         PBarSym,
+			predCovar:     PBarSym,
+			gain:          mat64.NewDense(1, 1, nil),
+			considerCovar: PcBar,
+			considerCross: PxcBar,
+			epoch:         kf.advance(),
+		}
 		kf.prevEst = est.(*HybridKFEstimate)
 		kf.step++
 		kf.sncEnabled = false
+		kf.dmcQ = nil
+		kf.Θ, kf.Hc = nil, nil
 		kf.locked = true
 		return
 	}
 
-	// Kalman gain
-	var PHt, HPHt, K mat64.Dense
-	PHt.Mul(&PBar, kf.Htilde.T())
-	HPHt.Mul(kf.Htilde, &PHt)
-	HPHt.Add(&HPHt, kf.Noise.MeasurementMatrix())
-	if ierr := HPHt.Inverse(&HPHt); ierr != nil {
-		return nil, fmt.Errorf("could not invert `H*P_kp1_minus*H' + R` at k=%d: %s", kf.step, ierr)
-	}
-	K.Mul(&PHt, &HPHt)
-
 	// Compute observation deviation y
 	var y mat64.Vector
 	y.SubVec(realObservation, computedObservation)
 
 	var innov, xHat mat64.Vector
+	var xBar *mat64.Vector
 	if kf.ekfMode {
-		xHat.MulVec(&K, &y)
+		// The reference trajectory was updated with the previous estimate, so the deviation is zero.
+		xBar = mat64.NewVector(kf.prevEst.State().Len(), nil)
+		innov.CloneVec(&y)
 	} else {
 		// Prediction step.
-		var xBar mat64.Vector
+		xBar = mat64.NewVector(kf.prevEst.State().Len(), nil)
 		xBar.MulVec(kf.Φ, kf.prevEst.State())
-		// Measurement update
 		var Hx mat64.Vector
-		Hx.MulVec(kf.Htilde, &xBar) // Predicted measurement
-		innov.SubVec(&y, &Hx)       // Innovation vector
-		// XXX: Does not support scalar measurements.
-		xHat.MulVec(&K, &innov)
-		xHat.AddVec(&xBar, &xHat)
-	}
-	var P, Ptmp1, IKH, KR, KRKt mat64.Dense
-	IKH.Mul(&K, kf.Htilde)
-	n, _ := IKH.Dims()
-	IKH.Sub(Identity(n), &IKH)
-	Ptmp1.Mul(&IKH, &PBar)
-	P.Mul(&Ptmp1, IKH.T())
-	KR.Mul(&K, kf.Noise.MeasurementMatrix())
-	KRKt.Mul(&KR, K.T())
-	P.Add(&P, &KRKt)
-
+		Hx.MulVec(kf.Htilde, xBar) // Predicted measurement
+		innov.SubVec(&y, &Hx)      // Innovation vector
+	}
+	Φ := *mat64.DenseCopyOf(kf.Φ)
+	var Γ *mat64.Dense
+	if kf.sncEnabled {
+		Γ = mat64.DenseCopyOf(kf.Γ)
+	}
 	PBarSym, err := AsSymDense(&PBar)
 	if err != nil {
 		return nil, err
 	}
 
-	PSym, err := AsSymDense(&P)
+	if kf.sequential {
+		xHat, PSym, K, rejected, serr := sequentialUpdate(xBar, &PBar, kf.Htilde, &innov, kf.measurementNoise(), kf.editor)
+		if serr != nil {
+			return nil, fmt.Errorf("sequential update at k=%d: %s", kf.step, serr)
+		}
+		est = &HybridKFEstimate{
+			Φ:         &Φ,
+			Γ:         Γ,
+			state:     xHat,
+			meas:      realObservation,
+			innov:     &innov,
+			Δobs:      &y,
+			covar:     PSym,
+			predCovar: PBarSym,
+			gain:      K,
+			rejected:  rejected,
+			labels:    kf.measurementLabels(),
+			epoch:     kf.advance(),
+		}
+		kf.prevEst = est.(*HybridKFEstimate)
+		kf.step++
+		kf.sncEnabled = false
+		kf.dmcQ = nil
+		kf.Θ, kf.Hc = nil, nil
+		kf.locked = true
+		return
+	}
+
+	// Kalman gain, and innovation covariance S used for measurement editing.
+	K, S, ierr := kalmanGain(&PBar, kf.Htilde, kf.measurementNoise(), M)
+	if ierr != nil {
+		return nil, fmt.Errorf("could not invert `H*P_kp1_minus*H' + R` at k=%d: %s", kf.step, ierr)
+	}
+
+	if kf.editor != nil {
+		SSym, serr := AsSymDense(S)
+		if serr != nil {
+			return nil, serr
+		}
+		if kf.editor.Reject(&innov, SSym) {
+			// Skip the measurement update.
+			est = &HybridKFEstimate{
+				Φ:             &Φ,
+				Γ:             Γ,
+				state:         xBar,
+				meas:          realObservation,
+				innov:         &innov,
+				Δobs:          &y,
+				covar:         PBarSym,
+				predCovar:     PBarSym,
+				gain:          K,
+				rejected:      true,
+				labels:        kf.measurementLabels(),
+				considerCovar: PcBar,
+				considerCross: PxcBar,
+				epoch:         kf.advance(),
+			}
+			kf.prevEst = est.(*HybridKFEstimate)
+			kf.step++
+			kf.sncEnabled = false
+			kf.dmcQ = nil
+			kf.Θ, kf.Hc = nil, nil
+			kf.locked = true
+			return
+		}
+	}
+	// Measurement update
+	Htilde := kf.Htilde
+	iterations := 0
+	if kf.iekfRef == nil {
+		xHat.MulVec(K, &innov)
+		xHat.AddVec(xBar, &xHat)
+	} else {
+		xIter, KIter, HIter, iter, ierr := kf.iteratedUpdate(xBar, &PBar, realObservation, computedObservation, M)
+		if ierr != nil {
+			return nil, ierr
+		}
+		xHat.CloneVec(xIter)
+		K = KIter
+		Htilde = HIter
+		iterations = iter
+	}
+	P := josephCovariance(K, Htilde, &PBar, kf.measurementNoise(), M)
+	PSym, err := AsSymDense(P)
 	if err != nil {
 		return nil, err
 	}
-	Φ := *mat64.DenseCopyOf(kf.Φ)
-	var Γ *mat64.Dense
-	if kf.Γ != nil {
-		Γ = mat64.DenseCopyOf(kf.Γ)
+	var Pc mat64.Symmetric
+	var Pxc *mat64.Dense
+	if kf.pcc != nil {
+		var PcDense *mat64.Dense
+		PcDense, Pxc = considerMeasurementUpdate(K, Htilde, kf.measurementNoise(), kf.Hc, PcBar, PxcBar, kf.pcc)
+		Pc = symmetrize(PcDense)
+	}
+	est = &HybridKFEstimate{
+		Φ:             &Φ,
+		Γ:             Γ,
+		state:         &xHat,
+		meas:          realObservation,
+		innov:         &innov,
+		Δobs:          &y,
+		covar:         PSym,
+		predCovar:     PBarSym,
+		gain:          K,
+		labels:        kf.measurementLabels(),
+		iterations:    iterations,
+		considerCovar: Pc,
+		considerCross: Pxc,
+		epoch:         kf.advance(),
 	}
-	est = &HybridKFEstimate{&Φ, Γ, &xHat, realObservation, &innov, &y, PSym, PBarSym, &K}
 	kf.prevEst = est.(*HybridKFEstimate)
 	kf.step++
 	kf.sncEnabled = false
+	kf.dmcQ = nil
+	kf.Θ, kf.Hc = nil, nil
 	kf.locked = true
 	return
 }
 
+// iteratedUpdate computes the IEKF measurement update by relinearising the measurement model around X_i = Xref + x_i,
+// starting from the reference (x_0 = 0), such that the first iteration is the usual update:
+// x_{i+1} = x̄ + K_i*(y - G(X_i) - H_i*(x̄ - x_i)), where K_i = P̄*H_iᵀ*(H_i*P̄*H_iᵀ + R)⁻¹, or its correlated noise
+// counterpart if the cross covariance M is not nil.
+// Returns the updated state deviation, the gain and H̃ of the last iteration, and the number of iterations.
+func (kf *HybridKF) iteratedUpdate(xBar *mat64.Vector, PBar *mat64.Dense, realObservation, computedObservation *mat64.Vector, M mat64.Matrix) (*mat64.Vector, *mat64.Dense, *mat64.Dense, int, error) {
+	R := kf.measurementNoise()
+	xi := mat64.NewVector(xBar.Len(), nil)
+	G := computedObservation
+	H := kf.Htilde
+	for iteration := 1; ; iteration++ {
+		K, _, ierr := kalmanGain(PBar, H, R, M)
+		if ierr != nil {
+			return nil, nil, nil, 0, fmt.Errorf("could not invert `H*P_kp1_minus*H' + R` at k=%d (iteration %d): %s", kf.step, iteration, ierr)
+		}
+		var δx, HΔx, innov, xNext, Δx mat64.Vector
+		δx.SubVec(xBar, xi)
+		HΔx.MulVec(H, &δx)
+		innov.SubVec(realObservation, G)
+		innov.SubVec(&innov, &HΔx)
+		xNext.MulVec(K, &innov)
+		xNext.AddVec(xBar, &xNext)
+		Δx.SubVec(&xNext, xi)
+		if iteration >= kf.iekfMaxIter || mat64.Norm(&Δx, 2) < kf.iekfTol {
+			return &xNext, K, H, iteration, nil
+		}
+		// Relinearise around the updated state.
+		xi = &xNext
+		X := mat64.NewVector(xi.Len(), nil)
+		X.AddVec(kf.iekfRef, xi)
+		var err error
+		if G, H, err = kf.iekfModel.Observe(X, kf.iekfEpoch); err != nil {
+			return nil, nil, nil, 0, fmt.Errorf("could not compute observation at iteration %d: %s", iteration, err)
+		}
+		if err = checkMatDims(G, kf.Htilde, "computed observation", "H", rows2rows); err != nil {
+			return nil, nil, nil, 0, err
+		}
+		if err = checkMatDims(H, kf.Htilde, "H (iterated)", "H", rowsAndcols); err != nil {
+			return nil, nil, nil, 0, err
+		}
+	}
+}
+
 // SmoothAll will smooth all the previous estimates using the provided data. Returns the smoothed estimates.
 // Will return an error if there are more estimates than there should be.
+// Estimates computed with SNC enabled (cf. PreparePNT) are smoothed with the Rauch–Tung–Striebel smoother,
+// which relies on the predicted covariance stored in each estimate; the others are simply mapped back with Φ⁻¹.
+// The consider covariances are not smoothed.
 // WARNING: overwrites the provided array of estimates.
 func (kf *HybridKF) SmoothAll(estimates []*HybridKFEstimate) (err error) {
 	if len(estimates) != kf.step {
@@ -231,7 +728,38 @@ func (kf *HybridKF) SmoothAll(estimates []*HybridKFEstimate) (err error) {
 			estimates[k].state = &xHat
 			estimates[k].covar = Pkl
 		} else {
-			panic("not yet implemented")
+			// Rauch–Tung–Striebel: S_k = P_k Φᵀ (\bar{P}_{k+1})⁻¹, where \bar{P}_{k+1} includes ΓQΓᵀ.
+			// Sᵀ is solved from \bar{P}_{k+1} Sᵀ = Φ P_k with the Cholesky factor instead of inverting \bar{P}_{k+1}.
+			estimateK := estimates[k]
+			var chol mat64.Cholesky
+			if ok := chol.Factorize(estimateKp1.PredCovariance()); !ok {
+				return fmt.Errorf("predicted covariance at k=%d is not positive definite", k+1)
+			}
+			var ΦP, St mat64.Dense
+			ΦP.Mul(estimateKp1.Φ, estimateK.Covariance())
+			if ierr := St.SolveCholesky(&chol, &ΦP); ierr != nil {
+				return fmt.Errorf("predicted covariance at k=%d is not invertible: %s", k+1, ierr)
+			}
+			S := St.T()
+			// x_{k}^{l} = x_{k} + S_k (x_{k+1}^{l} - Φ x_{k})
+			var xBar, Δx, xHat mat64.Vector
+			xBar.MulVec(estimateKp1.Φ, estimateK.State())
+			Δx.SubVec(estimateKp1.State(), &xBar)
+			xHat.MulVec(S, &Δx)
+			xHat.AddVec(estimateK.State(), &xHat)
+			// P_{k}^{l} = P_{k} + S_k (P_{k+1}^{l} - \bar{P}_{k+1}) S_kᵀ
+			var ΔP, SΔP, SΔPSt, Pkl mat64.Dense
+			ΔP.Sub(estimateKp1.Covariance(), estimateKp1.PredCovariance())
+			SΔP.Mul(S, &ΔP)
+			SΔPSt.Mul(&SΔP, &St)
+			Pkl.Add(estimateK.Covariance(), &SΔPSt)
+			PklSym, serr := AsSymDense(&Pkl)
+			if serr != nil {
+				err = serr
+				return
+			}
+			estimates[k].state = &xHat
+			estimates[k].covar = PklSym
 		}
 	}
 	return
@@ -244,6 +772,12 @@ type HybridKFEstimate struct {
 	state, meas, innov, Δobs *mat64.Vector
 	covar, predCovar         mat64.Symmetric
 	gain                     mat64.Matrix
+	rejected                 bool
+	labels                   []string
+	iterations               int
+	considerCovar            mat64.Symmetric
+	considerCross            *mat64.Dense
+	epoch                    time.Time
 }
 
 // IsWithinNσ returns whether the estimation is within the 2σ bounds.
@@ -277,6 +811,26 @@ func (e HybridKFEstimate) Innovation() *mat64.Vector {
 	return e.innov
 }
 
+// Rejected implements the Estimate interface.
+func (e HybridKFEstimate) Rejected() bool {
+	return e.rejected
+}
+
+// Epoch implements the Estimate interface.
+func (e HybridKFEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
+// MeasurementLabels implements the LabeledEstimate interface.
+func (e HybridKFEstimate) MeasurementLabels() []string {
+	return e.labels
+}
+
+// Iterations returns the number of IEKF iterations of the measurement update (zero if not iterated).
+func (e HybridKFEstimate) Iterations() int {
+	return e.iterations
+}
+
 // ObservationDev returns the observation deviation.
 func (e HybridKFEstimate) ObservationDev() *mat64.Vector {
 	return e.Δobs
@@ -292,6 +846,18 @@ func (e HybridKFEstimate) PredCovariance() mat64.Symmetric {
 	return e.predCovar
 }
 
+// ConsiderCovariance returns the consider covariance, i.e. the covariance of the state which accounts for the
+// uncertainty of the consider parameters, or nil if the consider analysis is disabled.
+func (e HybridKFEstimate) ConsiderCovariance() mat64.Symmetric {
+	return e.considerCovar
+}
+
+// ConsiderCrossCovariance returns the cross covariance of the state and the consider parameters, or nil if the
+// consider analysis is disabled.
+func (e HybridKFEstimate) ConsiderCrossCovariance() *mat64.Dense {
+	return e.considerCross
+}
+
 // Gain the Estimate interface.
 func (e HybridKFEstimate) Gain() mat64.Matrix {
 	return e.gain
@@ -4,11 +4,10 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"sync"
 	"testing"
 	"time"
 
-	"github.com/ChristopherRabotin/smd"
+	"github.com/ChristopherRabotin/gokalman/od"
 	"github.com/gonum/matrix/mat64"
 )
 
@@ -56,9 +55,74 @@ func TestHybridBasic(t *testing.T) {
 func TestCKFFull(t *testing.T) {
 	hybridFullODExample(-15, 0, -15, false, false, false, t)
 	hybridFullODExample(-15, 0, -15, true, false, false, t) // Smoothing
-	t.Skip("Skipping broken SNC test (not high priority right now)")
-	hybridFullODExample(-15, 0, -15, false, true, false, t) // SNC
-	hybridFullODExample(-15, 0, -15, false, true, true, t)  // SNC RIC
+	hybridFullODExample(-15, 0, 15, false, true, false, t)  // SNC
+	hybridFullODExample(-15, 0, 15, false, true, true, t)   // SNC RIC
+	hybridFullODExample(-15, 0, 15, true, true, false, t)   // SNC and smoothing
+}
+
+func TestHybridSmoothSNC(t *testing.T) {
+	// 1D constant velocity with white acceleration noise, observed in position only.
+	Δt := 1.0
+	Φ := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	Γ := mat64.NewDense(2, 1, []float64{Δt * Δt / 2, Δt})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	R := mat64.NewSymDense(1, []float64{0.25})
+	ys := []float64{0.3, 1.1, 1.8, 3.4, 3.9, 5.2, 5.8, 7.1, 8.3, 8.8, 10.4, 10.9}
+	smooth := func(q float64) ([]*HybridKFEstimate, []*HybridKFEstimate) {
+		noise := NewNoiseless(mat64.NewSymDense(1, []float64{q}), R)
+		kf, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 10), noise, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered := make([]*HybridKFEstimate, len(ys))
+		smoothed := make([]*HybridKFEstimate, len(ys))
+		for k, y := range ys {
+			kf.Prepare(Φ, H)
+			kf.PreparePNT(Γ)
+			var est Estimate
+			if k == 6 {
+				// Check that predictions are also smoothed.
+				est, err = kf.Predict()
+			} else {
+				est, err = kf.Update(mat64.NewVector(1, []float64{y}), mat64.NewVector(1, nil))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			filtered[k] = est.(*HybridKFEstimate)
+			smoothedEst := *filtered[k]
+			smoothed[k] = &smoothedEst
+		}
+		if err := kf.SmoothAll(smoothed); err != nil {
+			t.Fatal(err)
+		}
+		return filtered, smoothed
+	}
+
+	filtered, smoothed := smooth(1e-2)
+	last := len(ys) - 1
+	if !mat64.Equal(filtered[last].State(), smoothed[last].State()) {
+		t.Fatal("last estimate should not be modified by the smoother")
+	}
+	for k := range ys {
+		for i := 0; i < 2; i++ {
+			if smoothed[k].Covariance().At(i, i) > filtered[k].Covariance().At(i, i)+1e-12 {
+				t.Fatalf("k=%d: smoothed variance #%d larger than the filtered one", k, i)
+			}
+		}
+	}
+
+	// Without process noise, the RTS smoother is the same as mapping the last estimate back with Φ⁻¹.
+	_, smoothedNoQ := smooth(0)
+	var Φinv mat64.Dense
+	Φinv.Inverse(Φ)
+	for k := last - 1; k >= 0; k-- {
+		var xExp mat64.Vector
+		xExp.MulVec(&Φinv, smoothedNoQ[k+1].State())
+		if !mat64.EqualApprox(&xExp, smoothedNoQ[k].State(), 1e-8) {
+			t.Fatalf("k=%d: RTS without process noise differs from backward propagation", k)
+		}
+	}
 }
 
 func TestEKFFull(t *testing.T) {
@@ -71,87 +135,50 @@ func hybridFullODExample(ekfTrigger int, ekfDisableTime, sncDisableTime float64,
 	startDT := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
 	endDT := startDT.Add(time.Duration(12) * time.Hour)
 	// Define the orbits
-	leo := smd.NewOrbitFromOE(7000, 0.001, 30, 80, 40, 0, smd.Earth)
+	leo := od.StateFromOE(7000, 0.001, 30, 80, 40, 0, od.EarthMu)
 
 	// Define the stations
 	σρ := math.Pow(1e-3, 2)    // m , but all measurements in km.
 	σρDot := math.Pow(1e-3, 2) // m/s , but all measurements in km/s.
-	st1 := smd.NewStation("st1", 0, 10, -35.398333, 148.981944, σρ, σρDot)
-	st2 := smd.NewStation("st2", 0, 10, 40.427222, 355.749444, σρ, σρDot)
-	st3 := smd.NewStation("st3", 0, 10, 35.247164, 243.205, σρ, σρDot)
-	stations := []smd.Station{st1, st2, st3}
-
-	measurements := make(map[time.Time]smd.Measurement)
-	measurementTimes := []time.Time{}
-	numMeasurements := 0 // Easier to count them here than to iterate the map to count.
-
-	// Define the special export functions
-	export := smd.ExportConfig{Filename: "CKFFullOD", Cosmo: false, AsCSV: true, Timestamp: false}
-	export.CSVAppendHdr = func() string {
-		hdr := "secondsSinceEpoch,"
-		for _, st := range stations {
-			hdr += fmt.Sprintf("%sRange,%sRangeRate,%sNoisyRange,%sNoisyRangeRate,", st.Name, st.Name, st.Name, st.Name)
-		}
-		return hdr[:len(hdr)-1] // Remove trailing comma
-	}
-	export.CSVAppend = func(state smd.State) string {
-		Δt := state.DT.Sub(startDT).Seconds()
-		str := fmt.Sprintf("%f,", Δt)
-		θgst := Δt * smd.EarthRotationRate
-		roundedDT := state.DT.Truncate(time.Second)
-		// Compute visibility for each station.
-		for _, st := range stations {
-			measurement := st.PerformMeasurement(θgst, state)
-			if measurement.Visible {
-				// Sanity check
-				if _, exists := measurements[roundedDT]; exists {
-					t.Fatalf("already have a measurement for %s", state.DT)
-				}
-				measurements[roundedDT] = measurement
-				measurementTimes = append(measurementTimes, roundedDT)
-				numMeasurements++
-				str += measurement.CSV()
-			} else {
-				str += ",,,,"
-			}
-		}
-		return str[:len(str)-1] // Remove trailing comma
-	}
+	stations := odStations(σρ, σρDot, startDT)
 
-	// Generate the true orbit -- Mtrue
-	var ekfWG sync.WaitGroup
+	// Generate the true orbit and the measurements.
 	timeStep := 1 * time.Second
 	scName := "LEO"
-	smd.NewPreciseMission(smd.NewEmptySC(scName, 0), leo, startDT, endDT, smd.Perturbations{Jn: 2}, timeStep, false, export).Propagate()
+	measurements, err := simulateOD(leo, stations, startDT, endDT, timeStep)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
 
 	// Let's mark those as the truth so we can plot that.
 	stateTruth := make([]*mat64.Vector, len(measurements))
 	truthMeas := make([]*mat64.Vector, len(measurements))
-	for measNo, measTime := range measurementTimes {
-		measurement := measurements[measTime]
-		stateTruth[measNo] = measurement.State.Vector()
-		truthMeas[measNo] = measurement.StateVector()
+	measurementsAt := make(map[time.Time][]odMeasurement)
+	for measNo, measurement := range measurements {
+		stateTruth[measNo] = measurement.state
+		truthMeas[measNo] = measurement.observation
+		measurementsAt[measurement.epoch] = append(measurementsAt[measurement.epoch], measurement)
 	}
 	t.Logf("Generated %d measurements", len(measurements))
 	truth := NewBatchGroundTruth(stateTruth, truthMeas)
 
-	// Compute number of states which will be generated.
-	numStates := int((measurementTimes[len(measurementTimes)-1].Sub(measurementTimes[0])).Seconds()/timeStep.Seconds()) + 2
-	residuals := make([]*mat64.Vector, numStates)
-	estHistory := make([]*HybridKFEstimate, numStates)
-	stateHistory := make([]*mat64.Vector, numStates) // Stores the histories of the orbit estimate (to post compute the truth)
+	residuals := []*mat64.Vector{}
+	estHistory := []*HybridKFEstimate{}
+	stateHistory := []*mat64.Vector{} // Stores the histories of the orbit estimate (to post compute the truth)
 
 	// Get the first measurement as an initial orbit estimation.
-	firstDT := measurementTimes[0]
-	estOrbit := measurements[firstDT].State.Orbit
+	firstDT := measurements[0].epoch
+	lastDT := measurements[len(measurements)-1].epoch
+	estOrbit := measurements[0].state
 	// TODO: Add noise to initial orbit estimate.
 
-	// Perturbations in the estimate
-	estPerts := smd.Perturbations{Jn: 2}
-
-	stateEstChan := make(chan (smd.State), 1)
-	mEst := smd.NewPreciseMission(smd.NewEmptySC(scName+"Est", 0), &estOrbit, firstDT, firstDT.Add(-1), estPerts, timeStep, true, smd.ExportConfig{})
-	mEst.RegisterStateChan(stateEstChan)
+	// Dynamics of the estimate
+	gravity := od.NewTwoBodyJ2()
+	dynamics, err := NewRK45(gravity.Derivatives, gravity.Jacobian, 1e-10)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dynamics.Epoch = startDT
 
 	// KF filter initialization stuff.
 
@@ -202,53 +229,30 @@ func hybridFullODExample(ekfTrigger int, ekfDisableTime, sncDisableTime float64,
 	var prevDT time.Time
 	var ckfMeasNo = 0
 	measNo := 0
-	stateNo := 0
 	kf, _, err := NewHybridKF(mat64.NewVector(6, nil), prevP, noiseKF, 2)
-	kf.sncEnabled = sncEnabled
-
 	if err != nil {
 		t.Fatalf("%s", err)
 	}
-
-	// Go-routine to advance propagation.
-	if ekfTrigger <= 0 {
-		go mEst.PropagateUntil(measurementTimes[len(measurementTimes)-1].Add(timeStep), true)
-	} else {
-		// Go step by step because the orbit pointer needs to be updated.
-		go func() {
-			for i, measurementTime := range measurementTimes {
-				ekfWG.Wait()
-				ekfWG.Add(1)
-				mEst.PropagateUntil(measurementTime, i == len(measurementTimes)-1)
-			}
-		}()
+	kf.SetEpoch(firstDT)
+	station := &od.Station{} // Measurement model of the station tracking the spacecraft.
+	if err = kf.SetModels(dynamics, station, estOrbit); err != nil {
+		t.Fatalf("%s", err)
 	}
 
 	// Now let's do the filtering.
-	for {
-		state, more := <-stateEstChan
-		if !more {
-			break
-		}
-		stateNo++
-		roundedDT := state.DT.Truncate(time.Second)
-		measurement, exists := measurements[roundedDT]
+	for dt := firstDT; !dt.After(lastDT); dt = dt.Add(timeStep) {
+		stepMeasurements, exists := measurementsAt[dt]
 		if !exists {
-			if measNo == 0 {
-				time.Sleep(time.Second)
-				t.Fatalf("should start KF at first measurement: \n%s (got)\n%s (exp)", roundedDT, measurementTimes[0])
-			}
 			// There is no truth measurement here, let's only predict the KF covariance.
-			kf.Prepare(state.Φ, nil)
-			estI, perr := kf.Predict()
+			estI, perr := kf.Step(dt, nil)
 			if perr != nil {
 				t.Fatalf("[ERR!] (#%04d)\n%s", measNo, perr)
 			}
 			est := estI.(*HybridKFEstimate)
 			if smoothing {
 				// Save to history in order to perform smoothing.
-				estHistory[stateNo-1] = est
-				stateHistory[stateNo-1] = nil
+				estHistory = append(estHistory, est)
+				stateHistory = append(stateHistory, nil)
 			} else {
 				// Stream to CSV file
 				estChan <- truth.ErrorWithOffset(-1, est, nil)
@@ -256,120 +260,100 @@ func hybridFullODExample(ekfTrigger int, ekfDisableTime, sncDisableTime float64,
 			continue
 		}
 
-		if roundedDT != measurementTimes[measNo] {
-			t.Fatalf("[ERR!] %04d delta = %s\tstate=%s\tmeas=%s", measNo, state.DT.Sub(measurementTimes[measNo]), state.DT, measurementTimes[measNo])
-		}
-		if measNo == 0 {
-			prevDT = measurement.State.DT
-		}
-
-		// Let's perform a full update since there is a measurement.
-		ΔtDuration := measurement.State.DT.Sub(prevDT)
-		Δt := ΔtDuration.Seconds() // Everything is in seconds.
-		// Informational messages.
-		if !kf.EKFEnabled() && ckfMeasNo == ekfTrigger {
-			// Switch KF to EKF mode
-			kf.EnableEKF()
-			t.Logf("[info] #%04d EKF now enabled\n", measNo)
-		} else if kf.EKFEnabled() && ekfDisableTime > 0 && Δt > ekfDisableTime {
-			// Switch KF back to CKF mode
-			kf.DisableEKF()
-			ckfMeasNo = 0
-			t.Logf("[info] #%04d EKF now disabled (Δt=%s)\n", measNo, ΔtDuration)
-		}
+		for _, measurement := range stepMeasurements {
+			if measNo == 0 {
+				prevDT = measurement.epoch
+			}
 
-		if measurement.Station.Name != prevStationName {
-			t.Logf("[info] #%04d %s in visibility of %s (T+%s)\n", measNo, scName, measurement.Station.Name, measurement.State.DT.Sub(firstDT))
-			prevStationName = measurement.Station.Name
-		}
+			// Let's perform a full update since there is a measurement.
+			ΔtDuration := measurement.epoch.Sub(prevDT)
+			Δt := ΔtDuration.Seconds() // Everything is in seconds.
+			// Informational messages.
+			if !kf.EKFEnabled() && ckfMeasNo == ekfTrigger {
+				// Switch KF to EKF mode
+				kf.EnableEKF()
+				t.Logf("[info] #%04d EKF now enabled\n", measNo)
+			} else if kf.EKFEnabled() && ekfDisableTime > 0 && Δt > ekfDisableTime {
+				// Switch KF back to CKF mode
+				kf.DisableEKF()
+				ckfMeasNo = 0
+				t.Logf("[info] #%04d EKF now disabled (Δt=%s)\n", measNo, ΔtDuration)
+			}
 
-		// Compute "real" measurement
-		computedObservation := measurement.Station.PerformMeasurement(measurement.Timeθgst, state)
-		if !computedObservation.Visible {
-			t.Logf("[WARN] #%04d %s station %s should see the SC but does not\n", measNo, state.DT, measurement.Station.Name)
-			visibilityErrors++
-		}
+			if measurement.station.Name != prevStationName {
+				t.Logf("[info] #%04d %s in visibility of %s (T+%s)\n", measNo, scName, measurement.station.Name, measurement.epoch.Sub(firstDT))
+				prevStationName = measurement.station.Name
+			}
 
-		Htilde := computedObservation.HTilde()
-		kf.Prepare(state.Φ, Htilde)
-		if sncEnabled {
-			if Δt < sncDisableTime {
-				if sncRIC {
-					// Build the RIC DCM
-					rUnit := smd.Unit(state.Orbit.R())
-					cUnit := smd.Unit(state.Orbit.H())
-					iUnit := smd.Unit(smd.Cross(rUnit, cUnit))
-					dcmVals := make([]float64, 9)
-					for i := 0; i < 3; i++ {
-						dcmVals[i] = rUnit[i]
-						dcmVals[i+3] = cUnit[i]
-						dcmVals[i+6] = iUnit[i]
-					}
-					// Update the Q matrix in the PQW
-					dcm := mat64.NewDense(3, 3, dcmVals)
-					var QECI, QECI0 mat64.Dense
-					QECI0.Mul(noiseQ, dcm.T())
-					QECI.Mul(dcm, &QECI0)
-					QECISym, err := AsSymDense(&QECI)
-					if err != nil {
-						t.Logf("[ERR!] QECI is not symmertric!")
-						panic(err)
+			if sncEnabled {
+				if Δt < sncDisableTime {
+					if sncRIC {
+						// Update the Q matrix from the RIC frame to the inertial frame
+						dcm := od.RIC(kf.Reference())
+						var QECI, QECI0 mat64.Dense
+						QECI0.Mul(noiseQ, dcm.T())
+						QECI.Mul(dcm, &QECI0)
+						QECISym, err := AsSymDense(&QECI)
+						if err != nil {
+							t.Logf("[ERR!] QECI is not symmertric!")
+							panic(err)
+						}
+						kf.SetNoise(NewNoiseless(QECISym, noiseR))
 					}
-					kf.SetNoise(NewNoiseless(QECISym, noiseR))
+					// Only enable SNC for small time differences between measurements.
+					Γtop := ScaledDenseIdentity(3, math.Pow(Δt, 2)/2)
+					Γbot := ScaledDenseIdentity(3, Δt)
+					Γ := mat64.NewDense(6, 3, nil)
+					Γ.Stack(Γtop, Γbot)
+					kf.PreparePNT(Γ)
 				}
-				// Only enable SNC for small time differences between measurements.
-				Γtop := ScaledDenseIdentity(3, math.Pow(Δt, 2)/2)
-				Γbot := ScaledDenseIdentity(3, Δt)
-				Γ := mat64.NewDense(6, 3, nil)
-				Γ.Stack(Γtop, Γbot)
-				kf.PreparePNT(Γ)
 			}
-		}
-		estI, err := kf.Update(measurement.StateVector(), computedObservation.StateVector())
-		if err != nil {
-			t.Fatalf("[ERR!] %s", err)
-		}
-		est := estI.(*HybridKFEstimate)
-		if !est.IsWithin2σ() {
-			t.Logf("[WARN] #%04d @ %s: not within 2-sigma", measNo, state.DT)
-		}
-		if stateNo == 1 {
-			t.Logf("\n%s", est)
-		}
-		prevP = est.Covariance().(*mat64.SymDense)
-		// Compute residual
-		residual := mat64.NewVector(2, nil)
-		residual.MulVec(Htilde, est.State())
-		residual.AddScaledVec(residual, -1, est.ObservationDev())
-		residual.ScaleVec(-1, residual)
-		residuals[stateNo] = residual
+			*station = measurement.station
+			estI, err := kf.Step(measurement.epoch, measurement.observation)
+			if err != nil {
+				t.Fatalf("[ERR!] %s", err)
+			}
+			est := estI.(*HybridKFEstimate)
+			if !est.IsWithin2σ() {
+				t.Logf("[WARN] #%04d @ %s: not within 2-sigma", measNo, dt)
+			}
+			if measNo == 0 {
+				t.Logf("\n%s", est)
+			}
+			// The full state estimate is the reference in EKF mode, and the reference plus the deviation otherwise.
+			offset := kf.Reference()
+			if kf.EKFEnabled() {
+				offset = mat64.NewVector(6, nil)
+				offset.SubVec(kf.Reference(), est.State())
+			}
+			stateEst := mat64.NewVector(6, nil)
+			stateEst.AddVec(offset, est.State())
+			if !measurement.station.Visible(stateEst, dt) {
+				t.Logf("[WARN] #%04d %s station %s should see the SC but does not\n", measNo, dt, measurement.station.Name)
+				visibilityErrors++
+			}
+			// Compute post-fit residual
+			computedObservation, _, err := measurement.station.Observe(stateEst, dt)
+			if err != nil {
+				t.Fatalf("[ERR!] %s", err)
+			}
+			residual := mat64.NewVector(2, nil)
+			residual.SubVec(measurement.observation, computedObservation)
+			residuals = append(residuals, residual)
 
-		if smoothing {
-			// Save to history in order to perform smoothing.
-			estHistory[stateNo-1] = est
-			stateHistory[stateNo-1] = state.Vector()
-		} else {
-			// Stream to CSV file
-			estChan <- truth.ErrorWithOffset(measNo, est, state.Vector())
-		}
-		prevDT = measurement.State.DT
-
-		// If in EKF, update the reference trajectory.
-		if kf.EKFEnabled() {
-			// Update the state from the error.
-			R, V := state.Orbit.RV()
-			for i := 0; i < 3; i++ {
-				R[i] += est.State().At(i, 0)
-				V[i] += est.State().At(i+3, 0)
+			if smoothing {
+				// Save to history in order to perform smoothing.
+				estHistory = append(estHistory, est)
+				stateHistory = append(stateHistory, offset)
+			} else {
+				// Stream to CSV file
+				estChan <- truth.ErrorWithOffset(measNo, est, offset)
 			}
-			mEst.Orbit = smd.NewOrbitFromRV(R, V, smd.Earth)
-		}
-		ckfMeasNo++
-		measNo++
-		if ekfTrigger > 0 {
-			ekfWG.Done()
+			prevDT = measurement.epoch
+			ckfMeasNo++
+			measNo++
 		}
-	} // end while true
+	}
 
 	if smoothing {
 		fmt.Println("[INFO] Smoothing started")
@@ -400,8 +384,61 @@ func hybridFullODExample(ekfTrigger int, ekfDisableTime, sncDisableTime float64,
 		severity = "WARNING"
 	}
 	t.Logf("[%s] %d visibility errors\n", severity, visibilityErrors)
-	// Write the residuals to a CSV file
-	f, ferr := os.Create("./hkf-residuals.csv")
+	writeODResiduals("./hkf-residuals.csv", residuals)
+}
+
+// odMeasurement is a simulated range and range-rate measurement of a ground station.
+type odMeasurement struct {
+	station            od.Station
+	epoch              time.Time
+	state, observation *mat64.Vector // True state and observation
+}
+
+// odStations returns the three stations used in the OD examples.
+func odStations(σρ, σρDot float64, epoch time.Time) []od.Station {
+	st1 := od.NewStation("st1", 0, 10, -35.398333, 148.981944, σρ, σρDot)
+	st2 := od.NewStation("st2", 0, 10, 40.427222, 355.749444, σρ, σρDot)
+	st3 := od.NewStation("st3", 0, 10, 35.247164, 243.205, σρ, σρDot)
+	stations := []od.Station{st1, st2, st3}
+	for i := range stations {
+		stations[i].Epoch = epoch
+	}
+	return stations
+}
+
+// simulateOD propagates the true orbit with two-body and J2 dynamics from X0 and returns the measurements of each
+// station which sees the spacecraft, every timeStep between startDT and endDT.
+func simulateOD(X0 *mat64.Vector, stations []od.Station, startDT, endDT time.Time, timeStep time.Duration) ([]odMeasurement, error) {
+	gravity := od.NewTwoBodyJ2()
+	integrator, err := NewRK45(gravity.Derivatives, nil, 1e-10)
+	if err != nil {
+		return nil, err
+	}
+	measurements := []odMeasurement{}
+	X := X0
+	for dt := startDT; !dt.After(endDT); dt = dt.Add(timeStep) {
+		if dt.After(startDT) {
+			if X, _, err = integrator.Integrate(X, 0, timeStep.Seconds()); err != nil {
+				return nil, err
+			}
+		}
+		for _, st := range stations {
+			if !st.Visible(X, dt) {
+				continue
+			}
+			y, _, err := st.Observe(X, dt)
+			if err != nil {
+				return nil, err
+			}
+			measurements = append(measurements, odMeasurement{st, dt, X, y})
+		}
+	}
+	return measurements, nil
+}
+
+// writeODResiduals writes the range and range-rate residuals to a CSV file.
+func writeODResiduals(filename string, residuals []*mat64.Vector) {
+	f, ferr := os.Create(filename)
 	if ferr != nil {
 		panic(ferr)
 	}
@@ -417,3 +454,150 @@ func hybridFullODExample(ekfTrigger int, ekfDisableTime, sncDisableTime float64,
 		}
 	}
 }
+
+func TestHybridIEKF(t *testing.T) {
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Close approach of the station, where the range is highly nonlinear.
+	station := rangeStation{0.1}
+	truth := mat64.NewVector(2, []float64{0.3, 0})
+	Xref := mat64.NewVector(2, []float64{1, 0})
+	y, _, _ := station.Observe(truth, t0)
+	G, H, _ := station.Observe(Xref, t0)
+	P0 := ScaledIdentity(2, 1)
+	noise := NewNoiseless(ScaledIdentity(2, 1e-6), ScaledIdentity(1, 1e-4))
+	newKF := func() *HybridKF {
+		kf, _, err := NewHybridKF(mat64.NewVector(2, nil), P0, noise, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kf
+	}
+	postFit := func(est Estimate) float64 {
+		X := mat64.NewVector(2, nil)
+		X.AddVec(Xref, est.State())
+		Gest, _, _ := station.Observe(X, t0)
+		return math.Abs(y.At(0, 0) - Gest.At(0, 0))
+	}
+
+	ekf := newKF()
+	ekf.Prepare(DenseIdentity(2), H)
+	ekfEst, err := ekf.Update(y, G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ekfEst.(*HybridKFEstimate).Iterations() != 0 {
+		t.Fatal("EKF update should not be iterated")
+	}
+
+	// A single iteration is the usual update.
+	single := newKF()
+	if err = single.EnableIEKF(1e-12, 1); err != nil {
+		t.Fatal(err)
+	}
+	single.Prepare(DenseIdentity(2), H)
+	if _, err = single.Update(y, G); err == nil {
+		t.Fatal("IEKF update without a reference should fail")
+	}
+	single.Prepare(DenseIdentity(2), H)
+	if err = single.PrepareIterated(station, mat64.NewVector(3, nil), t0); err == nil {
+		t.Fatal("reference of a different size than the state should fail")
+	}
+	if err = single.PrepareIterated(nil, Xref, t0); err == nil {
+		t.Fatal("IEKF without a measurement model should fail")
+	}
+	if err = single.PrepareIterated(station, Xref, t0); err != nil {
+		t.Fatal(err)
+	}
+	singleEst, err := single.Update(y, G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if singleEst.(*HybridKFEstimate).Iterations() != 1 {
+		t.Fatalf("expected one iteration, got %d", singleEst.(*HybridKFEstimate).Iterations())
+	}
+	if !mat64.EqualApprox(singleEst.State(), ekfEst.State(), 1e-12) || !mat64.EqualApprox(singleEst.Covariance(), ekfEst.Covariance(), 1e-12) {
+		t.Fatalf("single IEKF iteration differs from the EKF update\n%s\n%s", singleEst, ekfEst)
+	}
+
+	// Iterating relinearises around the updated state and reduces the post-fit residual.
+	iekf := newKF()
+	if err = iekf.EnableIEKF(1e-10, 20); err != nil {
+		t.Fatal(err)
+	}
+	if !iekf.IEKFEnabled() {
+		t.Fatal("IEKF should be enabled")
+	}
+	iekf.Prepare(DenseIdentity(2), H)
+	iekf.PrepareIterated(station, Xref, t0)
+	iekfEst, err := iekf.Update(y, G)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterations := iekfEst.(*HybridKFEstimate).Iterations()
+	if iterations < 2 || iterations >= 20 {
+		t.Fatalf("IEKF should converge in a few iterations, got %d", iterations)
+	}
+	if postFit(iekfEst) >= postFit(ekfEst) {
+		t.Fatalf("IEKF post-fit residual %e should be smaller than the EKF one %e", postFit(iekfEst), postFit(ekfEst))
+	}
+	if math.Abs(Xref.At(0, 0)+iekfEst.State().At(0, 0)-truth.At(0, 0)) > 0.01 {
+		t.Fatalf("IEKF did not converge to the truth:\n%s", iekfEst)
+	}
+	// The converged estimate is a fixed point of the iteration.
+	X := mat64.NewVector(2, nil)
+	X.AddVec(Xref, iekfEst.State())
+	Gi, Hi, _ := station.Observe(X, t0)
+	var HP, HPHt mat64.Dense
+	HP.Mul(Hi, P0)
+	HPHt.Mul(&HP, Hi.T())
+	innov := y.At(0, 0) - Gi.At(0, 0) + mat64.Dot(Hi.RowView(0), iekfEst.State())
+	expPos := P0.At(0, 0) * Hi.At(0, 0) / (HPHt.At(0, 0) + 1e-4) * innov
+	if math.Abs(expPos-iekfEst.State().At(0, 0)) > 1e-8 {
+		t.Fatalf("IEKF estimate is not a fixed point: %f != %f", expPos, iekfEst.State().At(0, 0))
+	}
+
+	// Errors
+	if err = iekf.EnableIEKF(0, 10); err == nil {
+		t.Fatal("zero tolerance should fail")
+	}
+	if err = iekf.EnableIEKF(1e-10, 0); err == nil {
+		t.Fatal("zero iterations should fail")
+	}
+	iekf.EnableSequential()
+	iekf.Prepare(DenseIdentity(2), H)
+	iekf.PrepareIterated(station, Xref, t0)
+	if _, err = iekf.Update(y, G); err == nil {
+		t.Fatal("IEKF with sequential measurements should fail")
+	}
+	iekf.DisableSequential()
+	iekf.DisableIEKF()
+	if iekf.IEKFEnabled() {
+		t.Fatal("IEKF should be disabled")
+	}
+
+	// With the models, Step relinearises around the updated reference.
+	dynamics := constantVelocity{}
+	truth = mat64.NewVector(2, []float64{-2, 0.4})
+	stepKF := newKF()
+	stepKF.SetEpoch(t0)
+	stepKF.EnableEKF()
+	stepKF.EnableIEKF(1e-10, 10)
+	if err = stepKF.SetModels(dynamics, station, mat64.NewVector(2, []float64{-1.5, 0.5})); err != nil {
+		t.Fatal(err)
+	}
+	for s := 1; s <= 10; s++ {
+		epoch := t0.Add(time.Duration(s) * time.Second)
+		Xtrue, _, _ := dynamics.Propagate(truth, t0, epoch)
+		yk, _, _ := station.Observe(Xtrue, epoch)
+		est, serr := stepKF.Step(epoch, yk)
+		if serr != nil {
+			t.Fatalf("s=%d: %s", s, serr)
+		}
+		if est.(*HybridKFEstimate).Iterations() < 1 {
+			t.Fatalf("s=%d: Step did not iterate", s)
+		}
+		if s == 10 && math.Abs(stepKF.Reference().At(0, 0)-Xtrue.At(0, 0)) > 1e-2 {
+			t.Fatalf("IEKF reference %f did not converge to the truth %f", stepKF.Reference().At(0, 0), Xtrue.At(0, 0))
+		}
+	}
+}
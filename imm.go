@@ -0,0 +1,348 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// NewIMM returns a new Interacting Multiple Model estimator, which runs one filter per mode (e.g. coasting and
+// manoeuvring) and combines their estimates given the probability of each mode.
+// Parameters:
+// - x0: initial state estimate, shared by all the modes
+// - P0: initial covariance, shared by all the modes
+// - filters: one LDKF per mode (Vanilla, SquareRoot or Information), whose estimates are replaced by x0 and P0
+// - Π: Markov mode transition matrix, where Π_ij is the probability to switch from mode i to mode j at each step
+// - μ0: initial mode probabilities
+func NewIMM(x0 *mat64.Vector, P0 mat64.Symmetric, filters []LDKF, Π mat64.Matrix, μ0 []float64) (*IMM, *IMMEstimate, error) {
+	r := len(filters)
+	if r < 2 {
+		return nil, nil, errors.New("the IMM requires at least two modes")
+	}
+	if err := checkMatDims(x0, P0, "x0", "P0", rows2cols); err != nil {
+		return nil, nil, err
+	}
+	if rΠ, cΠ := Π.Dims(); rΠ != r || cΠ != r {
+		return nil, nil, fmt.Errorf("Π must be %dx%d, got %dx%d", r, r, rΠ, cΠ)
+	}
+	for i := 0; i < r; i++ {
+		sum := 0.0
+		for j := 0; j < r; j++ {
+			if Π.At(i, j) < 0 {
+				return nil, nil, fmt.Errorf("Π(%d, %d) is negative", i, j)
+			}
+			sum += Π.At(i, j)
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			return nil, nil, fmt.Errorf("row %d of Π sums to %f instead of one", i, sum)
+		}
+	}
+	if len(μ0) != r {
+		return nil, nil, fmt.Errorf("%d initial mode probabilities for %d modes", len(μ0), r)
+	}
+	sum := 0.0
+	for _, μ := range μ0 {
+		if μ < 0 {
+			return nil, nil, errors.New("mode probabilities must be positive")
+		}
+		sum += μ
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		return nil, nil, fmt.Errorf("initial mode probabilities sum to %f instead of one", sum)
+	}
+	imm := &IMM{filters, mat64.DenseCopyOf(Π), append([]float64(nil), μ0...), x0, P0, nil, nil, nil, nil, 0}
+	if err := imm.Reset(); err != nil {
+		return nil, nil, err
+	}
+	return imm, imm.prevEst, nil
+}
+
+// IMM defines an Interacting Multiple Model estimator. Use NewIMM to initialize.
+// Each step, the estimates of the modes are mixed given the mode transition probabilities, each filter is updated
+// from its mixed estimate, the mode probabilities are updated with the likelihood of the measurement in each
+// mode, and the estimates of the modes are combined.
+type IMM struct {
+	filters []LDKF
+	Π       *mat64.Dense
+	μ0      []float64
+	x0      *mat64.Vector
+	P0      mat64.Symmetric
+	μ       []float64         // Current mode probabilities.
+	xModes  []*mat64.Vector   // Current state estimate of each mode.
+	PModes  []mat64.Symmetric // Current covariance of each mode.
+	prevEst *IMMEstimate
+	step    int
+}
+
+func (imm *IMM) String() string {
+	return fmt.Sprintf("IMM [k=%d] μ=%v\nΠ=%v", imm.step, imm.μ, mat64.Formatted(imm.Π, mat64.Prefix("  ")))
+}
+
+// Filters returns the filter of each mode.
+func (imm *IMM) Filters() []LDKF {
+	return imm.filters
+}
+
+// ModeProbabilities returns the current probability of each mode.
+func (imm *IMM) ModeProbabilities() []float64 {
+	return imm.μ
+}
+
+// Reset reinitializes the IMM and all of its filters with the initial estimate and mode probabilities.
+func (imm *IMM) Reset() error {
+	r := len(imm.filters)
+	imm.xModes = make([]*mat64.Vector, r)
+	imm.PModes = make([]mat64.Symmetric, r)
+	for j, kf := range imm.filters {
+		kf.Reset()
+		if err := kf.SetEstimate(imm.x0, imm.P0); err != nil {
+			return fmt.Errorf("mode #%d: %s", j, err)
+		}
+		imm.xModes[j] = imm.x0
+		imm.PModes[j] = imm.P0
+	}
+	imm.μ = append([]float64(nil), imm.μ0...)
+	m, _ := imm.filters[0].GetMeasurementMatrix().Dims()
+	imm.prevEst = &IMMEstimate{imm.x0, mat64.NewVector(m, nil), mat64.NewVector(m, nil), imm.P0, imm.P0, imm.μ, nil, time.Time{}}
+	imm.step = 0
+	return nil
+}
+
+// Update computes the IMM estimate from the measurement and control vector, which are provided to all the modes.
+func (imm *IMM) Update(measurement, control *mat64.Vector) (est Estimate, err error) {
+	r := len(imm.filters)
+	n := imm.prevEst.state.Len()
+
+	// Mixing: c_j is the predicted probability of mode j, and μ_ij the probability of mode i given mode j.
+	c := make([]float64, r)
+	for j := 0; j < r; j++ {
+		for i := 0; i < r; i++ {
+			c[j] += imm.Π.At(i, j) * imm.μ[i]
+		}
+	}
+	modes := make([]Estimate, r)
+	logΛ := make([]float64, r)
+	predStates := make([]*mat64.Vector, r)
+	predCovars := make([]mat64.Symmetric, r)
+	predMeas := make([]*mat64.Vector, r)
+	for j, kf := range imm.filters {
+		μij := make([]float64, r)
+		if c[j] > 0 {
+			for i := 0; i < r; i++ {
+				μij[i] = imm.Π.At(i, j) * imm.μ[i] / c[j]
+			}
+		} else {
+			μij[j] = 1 // This mode cannot be reached, so it is not mixed.
+		}
+		x0j, P0j := gaussianMixture(imm.xModes, imm.PModes, μij)
+		if err = kf.SetEstimate(x0j, P0j); err != nil {
+			return nil, fmt.Errorf("mode #%d at k=%d: %s", j, imm.step, err)
+		}
+		// Prediction of the mixed estimate, used for the likelihood of the measurement in this mode.
+		F, ferr := stateTransition(kf)
+		if ferr != nil {
+			return nil, fmt.Errorf("mode #%d at k=%d: %s", j, imm.step, ferr)
+		}
+		predStates[j] = mat64.NewVector(n, nil)
+		predStates[j].MulVec(F, x0j)
+		if G := kf.GetInputControl(); !IsNil(G) && control != nil {
+			var Gu mat64.Vector
+			Gu.MulVec(G, control)
+			predStates[j].AddVec(predStates[j], &Gu)
+		}
+		if modes[j], err = kf.Update(measurement, control); err != nil {
+			return nil, fmt.Errorf("mode #%d at k=%d: %s", j, imm.step, err)
+		}
+		predCovars[j] = modes[j].PredCovariance()
+		H := kf.GetMeasurementMatrix()
+		predMeas[j] = mat64.NewVector(measurement.Len(), nil)
+		predMeas[j].MulVec(H, predStates[j])
+		var innov mat64.Vector
+		innov.SubVec(measurement, predMeas[j])
+		var PHt, S mat64.Dense
+		PHt.Mul(predCovars[j], H.T())
+		S.Mul(H, &PHt)
+		S.Add(&S, kf.GetNoise().MeasurementMatrix())
+		if logΛ[j], err = gaussianLogLikelihood(&innov, &S); err != nil {
+			return nil, fmt.Errorf("mode #%d at k=%d: %s", j, imm.step, err)
+		}
+	}
+
+	// Mode probabilities update, in log space to avoid underflows.
+	maxLog := math.Inf(-1)
+	for j := range logΛ {
+		if c[j] > 0 {
+			maxLog = math.Max(maxLog, logΛ[j])
+		}
+	}
+	μ := make([]float64, r)
+	sum := 0.0
+	for j := range μ {
+		if c[j] > 0 {
+			μ[j] = c[j] * math.Exp(logΛ[j]-maxLog)
+		}
+		sum += μ[j]
+	}
+	if sum == 0 || math.IsNaN(sum) {
+		return nil, fmt.Errorf("all modes have a zero probability at k=%d", imm.step)
+	}
+	for j := range μ {
+		μ[j] /= sum
+	}
+	imm.μ = μ
+
+	// Combination of the estimates of each mode.
+	for j, modeEst := range modes {
+		imm.xModes[j] = modeEst.State()
+		imm.PModes[j] = modeEst.Covariance()
+	}
+	x, P := gaussianMixture(imm.xModes, imm.PModes, μ)
+	_, PBar := gaussianMixture(predStates, predCovars, c)
+	yHat := weightedMean(predMeas, c)
+	var innov mat64.Vector
+	innov.SubVec(measurement, yHat)
+	est = &IMMEstimate{x, yHat, &innov, P, PBar, μ, modes, modes[0].Epoch()}
+	imm.prevEst = est.(*IMMEstimate)
+	imm.step++
+	return
+}
+
+// stateTransition returns the F matrix of the filter, knowing that the Information filter only stores its inverse.
+func stateTransition(kf LDKF) (mat64.Matrix, error) {
+	info, ok := kf.(*Information)
+	if !ok {
+		return kf.GetStateTransition(), nil
+	}
+	n, _ := info.Finv.Dims()
+	var F mat64.Dense
+	if err := F.Solve(info.Finv, DenseIdentity(n)); err != nil {
+		return nil, fmt.Errorf("could not invert the inverse of F: %s", err)
+	}
+	return &F, nil
+}
+
+// gaussianMixture returns the mean and covariance of the mixture of Gaussians of the provided means, covariances
+// and weights, i.e. Σ w_i*x_i and Σ w_i*(P_i + (x_i - x)(x_i - x)').
+func gaussianMixture(means []*mat64.Vector, covars []mat64.Symmetric, weights []float64) (*mat64.Vector, *mat64.SymDense) {
+	x := weightedMean(means, weights)
+	n := x.Len()
+	P := mat64.NewDense(n, n, nil)
+	for i, xi := range means {
+		if weights[i] == 0 {
+			continue
+		}
+		var δ mat64.Vector
+		δ.SubVec(xi, x)
+		var spread mat64.Dense
+		spread.Clone(covars[i])
+		spread.RankOne(&spread, 1, &δ, &δ)
+		spread.Scale(weights[i], &spread)
+		P.Add(P, &spread)
+	}
+	return x, symmetrize(P)
+}
+
+// gaussianLogLikelihood returns the log of the normal density of the innovation of covariance S.
+func gaussianLogLikelihood(innov *mat64.Vector, S mat64.Matrix) (float64, error) {
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(symmetrize(S)); !ok {
+		return 0, errors.New("innovation covariance is not positive definite")
+	}
+	var SInvInnov mat64.Vector
+	if err := SInvInnov.SolveCholeskyVec(&chol, innov); err != nil {
+		return 0, err
+	}
+	m := float64(innov.Len())
+	return -0.5 * (mat64.Dot(innov, &SInvInnov) + chol.LogDet() + m*math.Log(2*math.Pi)), nil
+}
+
+// IMMEstimate is the output of each update of the IMM, i.e. the combination of the estimates of each mode.
+// It implements the Estimate interface.
+type IMMEstimate struct {
+	state, meas, innov *mat64.Vector
+	covar, predCovar   mat64.Symmetric
+	μ                  []float64
+	modes              []Estimate
+	epoch              time.Time
+}
+
+// IsWithinNσ returns whether the estimation is within the 2σ bounds.
+func (e IMMEstimate) IsWithinNσ(N float64) bool {
+	for i := 0; i < e.state.Len(); i++ {
+		nσ := N * math.Sqrt(e.covar.At(i, i))
+		if e.state.At(i, 0) > nσ || e.state.At(i, 0) < -nσ {
+			return false
+		}
+	}
+	return true
+}
+
+// IsWithin2σ returns whether the estimation is within the 2σ bounds.
+func (e IMMEstimate) IsWithin2σ() bool {
+	return e.IsWithinNσ(2)
+}
+
+// State implements the Estimate interface.
+func (e IMMEstimate) State() *mat64.Vector {
+	return e.state
+}
+
+// Measurement implements the Estimate interface, and returns the predicted measurement combined over the modes.
+func (e IMMEstimate) Measurement() *mat64.Vector {
+	return e.meas
+}
+
+// Innovation implements the Estimate interface.
+func (e IMMEstimate) Innovation() *mat64.Vector {
+	return e.innov
+}
+
+// Covariance implements the Estimate interface.
+func (e IMMEstimate) Covariance() mat64.Symmetric {
+	return e.covar
+}
+
+// PredCovariance implements the Estimate interface.
+func (e IMMEstimate) PredCovariance() mat64.Symmetric {
+	return e.predCovar
+}
+
+// Rejected implements the Estimate interface, and returns whether the measurement was rejected in all the modes.
+func (e IMMEstimate) Rejected() bool {
+	if len(e.modes) == 0 {
+		return false
+	}
+	for _, mode := range e.modes {
+		if !mode.Rejected() {
+			return false
+		}
+	}
+	return true
+}
+
+// Epoch implements the Estimate interface.
+func (e IMMEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
+// ModeProbabilities returns the probability of each mode.
+func (e IMMEstimate) ModeProbabilities() []float64 {
+	return e.μ
+}
+
+// Modes returns the estimate of each mode.
+func (e IMMEstimate) Modes() []Estimate {
+	return e.modes
+}
+
+func (e IMMEstimate) String() string {
+	state := mat64.Formatted(e.State(), mat64.Prefix("  "))
+	meas := mat64.Formatted(e.Measurement(), mat64.Prefix("  "))
+	covar := mat64.Formatted(e.Covariance(), mat64.Prefix("  "))
+	innov := mat64.Formatted(e.Innovation(), mat64.Prefix("  "))
+	predp := mat64.Formatted(e.PredCovariance(), mat64.Prefix("   "))
+	return fmt.Sprintf("{\ns=%v\ny=%v\nP=%v\nP-=%v\ni=%v\nμ=%v\n}", state, meas, covar, predp, innov, e.μ)
+}
@@ -0,0 +1,194 @@
+package gokalman
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// coastManoeuvreModes returns the vanilla filters of a coasting mode (small process noise) and of a manoeuvring
+// mode (large process noise on the velocity) of the 1D robot, observed by its position.
+func coastManoeuvreModes(t *testing.T, x0 *mat64.Vector, P0 mat64.Symmetric) []LDKF {
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	R := mat64.NewSymDense(1, []float64{1e-4})
+	coast, _, err := NewVanilla(x0, P0, F, G, H, NewNoiseless(mat64.NewSymDense(2, []float64{1e-8, 0, 0, 1e-6}), R))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manoeuvre, _, err := NewVanilla(x0, P0, F, G, H, NewNoiseless(mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-2}), R))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []LDKF{coast, manoeuvre}
+}
+
+func TestIMMManoeuvre(t *testing.T) {
+	// The robot coasts, accelerates at 1 m/s² during three seconds, and coasts again.
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 0.1)
+	Π := mat64.NewDense(2, 2, []float64{0.95, 0.05, 0.05, 0.95})
+	imm, _, err := NewIMM(x0, P0, coastManoeuvreModes(t, x0, P0), Π, []float64{0.5, 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reference coasting filter without any manoeuvre mode.
+	single := coastManoeuvreModes(t, x0, P0)[0]
+	F, G, _ := Robot1DMatrices()
+	rng := rand.New(rand.NewSource(1))
+	truth := mat64.NewVector(2, []float64{0, 1})
+	ctrl := mat64.NewVector(1, nil) // The filters do not know about the manoeuvre.
+	var immSqErr, singleSqErr float64
+	var μCoast, μManoeuvre float64 // Sums of the probability of the right mode while coasting and manoeuvring.
+	for k := 0; k < 150; k++ {
+		accel := 0.0
+		if k >= 50 && k < 80 {
+			accel = 1
+		}
+		var Fx, Gu mat64.Vector
+		Fx.MulVec(F, truth)
+		Gu.MulVec(G, mat64.NewVector(1, []float64{accel}))
+		truth.AddVec(&Fx, &Gu)
+		y := mat64.NewVector(1, []float64{truth.At(0, 0) + 0.01*rng.NormFloat64()})
+		est, err := imm.Update(y, ctrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sEst, err := single.Update(y, ctrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		μ := est.(*IMMEstimate).ModeProbabilities()
+		if math.Abs(μ[0]+μ[1]-1) > 1e-12 {
+			t.Fatalf("k=%d: mode probabilities do not sum to one: %v", k, μ)
+		}
+		if k >= 55 && k < 80 {
+			μManoeuvre += μ[1]
+		} else if (k >= 20 && k < 50) || k >= 120 {
+			μCoast += μ[0]
+		}
+		if k >= 50 && k < 100 {
+			immSqErr += math.Pow(est.State().At(1, 0)-truth.At(1, 0), 2)
+			singleSqErr += math.Pow(sEst.State().At(1, 0)-truth.At(1, 0), 2)
+		}
+		if len(est.(*IMMEstimate).Modes()) != 2 {
+			t.Fatalf("k=%d: expected the estimates of both modes", k)
+		}
+	}
+	if μManoeuvre/25 < 0.7 {
+		t.Fatalf("manoeuvre was not detected: average μ=%f", μManoeuvre/25)
+	}
+	if μCoast/60 < 0.7 {
+		t.Fatalf("coasting was not detected: average μ=%f", μCoast/60)
+	}
+	if immSqErr > 0.1*singleSqErr {
+		t.Fatalf("the IMM velocity error is not much smaller than that of the coasting filter: %f vs %f", immSqErr, singleSqErr)
+	}
+	// Reset must restore the initial mode probabilities.
+	if err = imm.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if μ := imm.ModeProbabilities(); μ[0] != 0.5 || μ[1] != 0.5 {
+		t.Fatalf("invalid mode probabilities after reset: %v", μ)
+	}
+}
+
+func TestIMMIdenticalModes(t *testing.T) {
+	// With identical modes, the IMM must match each of its filters, whatever their type.
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	noise := NewNoiseless(mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3}), mat64.NewSymDense(1, []float64{0.1}))
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 10)
+	vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqrt, _, err := NewSquareRoot(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := NewInformationFromState(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference, _, err := NewVanilla(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Π := mat64.NewDense(3, 3, []float64{0.8, 0.1, 0.1, 0.1, 0.8, 0.1, 0.1, 0.1, 0.8})
+	imm, _, err := NewIMM(x0, P0, []LDKF{vanilla, sqrt, info}, Π, []float64{0.2, 0.3, 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 50; k++ {
+		y := mat64.NewVector(1, []float64{0.035*float64(k) + 0.1*math.Sin(float64(k))})
+		u := mat64.NewVector(1, []float64{math.Cos(0.075 * float64(k+1))})
+		rEst, err := reference.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		est, err := imm.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mat64.EqualApprox(rEst.State(), est.State(), 1e-6) {
+			t.Fatalf("k=%d: states differ\n%v\n%v", k, mat64.Formatted(rEst.State()), mat64.Formatted(est.State()))
+		}
+		if !mat64.EqualApprox(rEst.Covariance(), est.Covariance(), 1e-6) {
+			t.Fatalf("k=%d: covariances differ\n%v\n%v", k, mat64.Formatted(rEst.Covariance()), mat64.Formatted(est.Covariance()))
+		}
+		if !mat64.EqualApprox(rEst.PredCovariance(), est.PredCovariance(), 1e-6) {
+			t.Fatalf("k=%d: prediction covariances differ\n%v\n%v", k, mat64.Formatted(rEst.PredCovariance()), mat64.Formatted(est.PredCovariance()))
+		}
+		if !mat64.EqualApprox(rEst.Innovation(), est.Innovation(), 1e-6) {
+			t.Fatalf("k=%d: innovations differ", k)
+		}
+	}
+	// The mode probabilities converge to the stationary distribution of Π since all modes are equally likely.
+	for j, μ := range imm.ModeProbabilities() {
+		if math.Abs(μ-1.0/3) > 1e-3 {
+			t.Fatalf("mode #%d probability %f is not the stationary one", j, μ)
+		}
+	}
+}
+
+func TestIMMErrors(t *testing.T) {
+	x0 := mat64.NewVector(2, nil)
+	P0 := ScaledIdentity(2, 1)
+	filters := coastManoeuvreModes(t, x0, P0)
+	Π := mat64.NewDense(2, 2, []float64{0.9, 0.1, 0.1, 0.9})
+	if _, _, err := NewIMM(x0, P0, filters[:1], Π, []float64{1}); err == nil {
+		t.Fatal("single mode did not fail")
+	}
+	if _, _, err := NewIMM(x0, ScaledIdentity(3, 1), filters, Π, []float64{0.5, 0.5}); err == nil {
+		t.Fatal("invalid covariance size did not fail")
+	}
+	if _, _, err := NewIMM(x0, P0, filters, DenseIdentity(3), []float64{0.5, 0.5}); err == nil {
+		t.Fatal("invalid transition matrix size did not fail")
+	}
+	if _, _, err := NewIMM(x0, P0, filters, mat64.NewDense(2, 2, []float64{0.9, 0.2, 0.1, 0.9}), []float64{0.5, 0.5}); err == nil {
+		t.Fatal("transition matrix whose rows do not sum to one did not fail")
+	}
+	if _, _, err := NewIMM(x0, P0, filters, mat64.NewDense(2, 2, []float64{1.1, -0.1, 0.1, 0.9}), []float64{0.5, 0.5}); err == nil {
+		t.Fatal("negative transition probability did not fail")
+	}
+	if _, _, err := NewIMM(x0, P0, filters, Π, []float64{0.5, 0.6}); err == nil {
+		t.Fatal("mode probabilities which do not sum to one did not fail")
+	}
+	if _, _, err := NewIMM(x0, P0, filters, Π, []float64{1}); err == nil {
+		t.Fatal("missing mode probability did not fail")
+	}
+	imm, _, err := NewIMM(x0, P0, filters, Π, []float64{0.5, 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = imm.Update(mat64.NewVector(2, nil), mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("invalid measurement size did not fail")
+	}
+	if err = filters[0].SetEstimate(mat64.NewVector(3, nil), ScaledIdentity(3, 1)); err == nil {
+		t.Fatal("invalid estimate size did not fail")
+	}
+}
@@ -1,8 +1,10 @@
 package gokalman
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/gonum/matrix/mat64"
 )
@@ -49,7 +51,7 @@ func NewInformation(i0 *mat64.Vector, I0 mat64.Symmetric, F, G, H mat64.Matrix,
 		fmt.Printf("R *might* not invertible: %s\n", err)
 	}
 
-	return &Information{&Finv, G, H, &Qinv, &Rinv, noise, !IsNil(G), est0, est0, 0}, &est0, nil
+	return &Information{Finv: &Finv, G: G, H: H, Qinv: &Qinv, Rinv: &Rinv, Noise: noise, needCtrl: !IsNil(G), prevEst: est0, initEst: est0}, &est0, nil
 }
 
 // NewInformationFromState returns a new Information KF. To get the next estimate, call
@@ -91,6 +93,10 @@ type Information struct {
 	needCtrl         bool
 	prevEst, initEst InformationEstimate
 	step             int
+	editor           MeasurementEditor
+	sequential       bool          // Process each scalar measurement one at a time.
+	model            TimeStepModel // Computes F and Q of each time update from the time step, if set.
+	timeTagger
 }
 
 func (kf *Information) String() string {
@@ -146,7 +152,85 @@ func (kf *Information) GetNoise() Noise {
 func (kf *Information) Reset() {
 	kf.prevEst = kf.initEst
 	kf.step = 0
+	kf.reset()
 	kf.Noise.Reset()
+	if kf.editor != nil {
+		kf.editor.Reset()
+	}
+}
+
+// SetEstimate replaces the current state estimate and covariance, e.g. after the mixing step of an IMM.
+// The covariance must be positive definite to compute the information matrix.
+func (kf *Information) SetEstimate(x *mat64.Vector, P mat64.Symmetric) error {
+	if err := checkMatDims(x, P, "x", "P", rows2cols); err != nil {
+		return err
+	}
+	if err := checkMatDims(x, kf.prevEst.infoState, "x", "current information state", rows2rows); err != nil {
+		return err
+	}
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(P); !ok {
+		return errors.New("covariance is not positive definite")
+	}
+	n := x.Len()
+	var I mat64.Dense
+	if err := I.SolveCholesky(&chol, DenseIdentity(n)); err != nil {
+		return err
+	}
+	infoMat := symmetrize(&I)
+	var infoState mat64.Vector
+	infoState.MulVec(infoMat, x)
+	kf.prevEst.infoState = &infoState
+	kf.prevEst.infoMat = infoMat
+	kf.prevEst.cachedState = nil
+	kf.prevEst.cachedCovar = nil
+	return nil
+}
+
+// SequentialEnabled returns whether the measurements are processed one scalar at a time.
+func (kf *Information) SequentialEnabled() bool {
+	return kf.sequential
+}
+
+// EnableSequential switches to accumulating the information of each scalar measurement one at a time,
+// which avoids using the inverse of R. If R is not diagonal, the measurements are first whitened with its Cholesky factor.
+// Measurement editing is still applied to the whole measurement vector.
+func (kf *Information) EnableSequential() {
+	kf.sequential = true
+}
+
+// DisableSequential switches back to processing the whole measurement vector at once.
+func (kf *Information) DisableSequential() {
+	kf.sequential = false
+}
+
+// SetMeasurementEditor sets the measurement editing policy used to reject outliers (nil to disable).
+// *NOTE:* Measurements are only edited once the prediction information matrix is invertible.
+func (kf *Information) SetMeasurementEditor(e MeasurementEditor) {
+	kf.editor = e
+}
+
+// SetTimeStepModel sets the model from which F and Q of each time update are computed over the time step to the next
+// epoch (cf. SetNextEpoch), instead of using F and the Noise's Q (nil to disable). The next epoch must then be set
+// before each update, and both F and Q must be invertible.
+func (kf *Information) SetTimeStepModel(m TimeStepModel) {
+	kf.model = m
+}
+
+// modelTimeUpdate returns the inverses of F and Q of the next time update from the TimeStepModel.
+func (kf *Information) modelTimeUpdate() (Finv, Qinv mat64.Matrix, err error) {
+	F, Q, err := kf.timeTagger.modelTimeUpdate(kf.model, kf.prevEst.infoState.Len())
+	if err != nil {
+		return nil, nil, err
+	}
+	var FinvD, QinvD mat64.Dense
+	if ierr := FinvD.Inverse(mat64.DenseCopyOf(F)); ierr != nil {
+		return nil, nil, fmt.Errorf("F of the time update is not invertible: %s", ierr)
+	}
+	if ierr := QinvD.Inverse(mat64.DenseCopyOf(Q)); ierr != nil {
+		return nil, nil, fmt.Errorf("Q of the time update is not invertible: %s", ierr)
+	}
+	return &FinvD, &QinvD, nil
 }
 
 // Update implements the KalmanFilter interface.
@@ -159,39 +243,110 @@ func (kf *Information) Update(measurement, control *mat64.Vector) (est Estimate,
 		return nil, err
 	}
 
-	// zMat computation
-	var zk mat64.Dense
-	zk.Mul(kf.prevEst.infoMat, kf.Finv)
-	zk.Mul(kf.Finv.T(), &zk)
-
-	// Prediction step.
-	// \hat{i}_{k+1}^{-}
-	var zkzkqi mat64.Dense
-
-	zkzkqi.Add(&zk, kf.Qinv)
-	zkzkqi.Inverse(&zkzkqi)
-	zkzkqi.Mul(&zk, &zkzkqi)
-	zkzkqi.Scale(-1.0, &zkzkqi)
-	rzk, _ := zkzkqi.Dims()
-	var iKp1Minus, iKp1Minus1 mat64.Vector
-	iKp1Minus.MulVec(kf.Finv.T(), kf.prevEst.infoState)
-	if kf.needCtrl {
-		iKp1Minus1.MulVec(kf.G, control)
-		iKp1Minus1.MulVec(&zk, &iKp1Minus1)
-		iKp1Minus.AddVec(&iKp1Minus, &iKp1Minus1)
+	// Inverses of the state transition and of the process noise of the time update.
+	Finv, Qinv := kf.Finv, kf.Qinv
+	if kf.model != nil && !kf.sameEpoch() {
+		if Finv, Qinv, err = kf.modelTimeUpdate(); err != nil {
+			return nil, err
+		}
 	}
-	var iKp1MinusM mat64.Dense
-	iKp1MinusM.Add(Identity(rzk), &zkzkqi)
-	iKp1Minus.MulVec(&iKp1MinusM, &iKp1Minus)
 
-	// I_{k+1}^{-}
+	var iKp1Minus mat64.Vector
 	var Ikp1Minus mat64.Dense
-	Ikp1Minus.Mul(&zkzkqi, zk.T())
-	Ikp1Minus.Add(&zk, &Ikp1Minus)
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		iKp1Minus.CloneVec(kf.prevEst.infoState)
+		Ikp1Minus.Clone(kf.prevEst.infoMat)
+	} else {
+		// zMat computation
+		var zk mat64.Dense
+		zk.Mul(kf.prevEst.infoMat, Finv)
+		zk.Mul(Finv.T(), &zk)
+
+		// Prediction step.
+		// \hat{i}_{k+1}^{-}
+		var zkzkqi mat64.Dense
+
+		zkzkqi.Add(&zk, Qinv)
+		zkzkqi.Inverse(&zkzkqi)
+		zkzkqi.Mul(&zk, &zkzkqi)
+		zkzkqi.Scale(-1.0, &zkzkqi)
+		rzk, _ := zkzkqi.Dims()
+		var iKp1Minus1 mat64.Vector
+		iKp1Minus.MulVec(Finv.T(), kf.prevEst.infoState)
+		if kf.needCtrl {
+			iKp1Minus1.MulVec(kf.G, control)
+			iKp1Minus1.MulVec(&zk, &iKp1Minus1)
+			iKp1Minus.AddVec(&iKp1Minus, &iKp1Minus1)
+		}
+		var iKp1MinusM mat64.Dense
+		iKp1MinusM.Add(Identity(rzk), &zkzkqi)
+		iKp1Minus.MulVec(&iKp1MinusM, &iKp1Minus)
+
+		// I_{k+1}^{-}
+		Ikp1Minus.Mul(&zkzkqi, zk.T())
+		Ikp1Minus.Add(&zk, &Ikp1Minus)
+	}
 
 	var ykHat mat64.Vector
 	ykHat.MulVec(kf.H, kf.prevEst.State())
-	ykHat.AddVec(&ykHat, kf.Noise.Measurement(kf.step))
+
+	if kf.editor != nil {
+		var PBar mat64.Dense
+		if ierr := PBar.Inverse(&Ikp1Minus); ierr == nil {
+			var xBar, innov mat64.Vector
+			xBar.MulVec(&PBar, &iKp1Minus)
+			innov.MulVec(kf.H, &xBar)
+			innov.SubVec(measurement, &innov)
+			S, serr := innovationCovariance(kf.H, &PBar, kf.Noise.MeasurementMatrix())
+			if serr != nil {
+				return nil, serr
+			}
+			if kf.editor.Reject(&innov, S) {
+				// Skip the measurement update.
+				Ikp1MinusSym, serr := AsSymDense(&Ikp1Minus)
+				if serr != nil {
+					return nil, serr
+				}
+				infoEst := NewInformationEstimate(&iKp1Minus, &ykHat, Ikp1MinusSym, Ikp1MinusSym)
+				infoEst.rejected = true
+				infoEst.epoch = kf.advance()
+				est = infoEst
+				kf.prevEst = infoEst
+				kf.step++
+				return
+			}
+		}
+	}
+
+	if kf.sequential {
+		Hw, yw, r, _, serr := whitenMeasurement(kf.H, measurement, kf.Noise.MeasurementMatrix())
+		if serr != nil {
+			return nil, serr
+		}
+		ikp1Plus := mat64.NewVector(iKp1Minus.Len(), nil)
+		ikp1Plus.CloneVec(&iKp1Minus)
+		Ikp1Plus := mat64.DenseCopyOf(&Ikp1Minus)
+		for i := range r {
+			h := Hw.RowView(i)
+			ikp1Plus.AddScaledVec(ikp1Plus, yw.At(i, 0)/r[i], h)
+			Ikp1Plus.RankOne(Ikp1Plus, 1/r[i], h, h)
+		}
+		Ikp1MinusSym, serr := AsSymDense(&Ikp1Minus)
+		if serr != nil {
+			return nil, serr
+		}
+		Ikp1PlusSym, serr := AsSymDense(Ikp1Plus)
+		if serr != nil {
+			return nil, serr
+		}
+		infoEst := NewInformationEstimate(ikp1Plus, &ykHat, Ikp1PlusSym, Ikp1MinusSym)
+		infoEst.epoch = kf.advance()
+		est = infoEst
+		kf.prevEst = infoEst
+		kf.step++
+		return
+	}
 
 	// Measurement update
 	var HTR mat64.Dense
@@ -220,8 +375,10 @@ func (kf *Information) Update(measurement, control *mat64.Vector) (est Estimate,
 	if err != nil {
 		panic(err)
 	}
-	est = NewInformationEstimate(&ikp1Plus, &ykHat, Ikp1PlusSym, Ikp1MinusSym)
-	kf.prevEst = est.(InformationEstimate)
+	infoEst := NewInformationEstimate(&ikp1Plus, &ykHat, Ikp1PlusSym, Ikp1MinusSym)
+	infoEst.epoch = kf.advance()
+	est = infoEst
+	kf.prevEst = infoEst
 	kf.step++
 	return
 }
@@ -233,6 +390,8 @@ type InformationEstimate struct {
 	infoMat, predInfoMat         mat64.Symmetric
 	cachedState                  *mat64.Vector
 	cachedCovar, predCachedCovar mat64.Symmetric
+	rejected                     bool
+	epoch                        time.Time
 }
 
 // IsWithinNσ returns whether the estimation is within the 2σ bounds.
@@ -273,6 +432,16 @@ func (e InformationEstimate) Innovation() *mat64.Vector {
 	return e.infoState
 }
 
+// Rejected implements the Estimate interface.
+func (e InformationEstimate) Rejected() bool {
+	return e.rejected
+}
+
+// Epoch implements the Estimate interface.
+func (e InformationEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
 // Covariance implements the Estimate interface.
 // *NOTE:* With the IF, one cannot view the covariance matrix until there is enough information.
 func (e InformationEstimate) Covariance() mat64.Symmetric {
@@ -326,5 +495,5 @@ func (e InformationEstimate) String() string {
 
 // NewInformationEstimate initializes a new InformationEstimate.
 func NewInformationEstimate(infoState, meas *mat64.Vector, infoMat, predInfoMat mat64.Symmetric) InformationEstimate {
-	return InformationEstimate{infoState, meas, infoMat, predInfoMat, nil, nil, nil}
+	return InformationEstimate{infoState, meas, infoMat, predInfoMat, nil, nil, nil, false, time.Time{}}
 }
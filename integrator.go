@@ -0,0 +1,238 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// Derivatives returns the time derivative of the state, i.e. ẋ = f(x, t), where t is in seconds.
+type Derivatives func(t float64, x *mat64.Vector) *mat64.Vector
+
+// Jacobian returns the Jacobian of the derivatives, i.e. A(x, t) = ∂f/∂x, where t is in seconds.
+type Jacobian func(t float64, x *mat64.Vector) *mat64.Dense
+
+// butcherTableau defines an explicit Runge-Kutta scheme. If bErr is set, it is the difference between the weights
+// of the solution and those of the embedded lower order solution, used to estimate the local error.
+type butcherTableau struct {
+	c, b, bErr []float64
+	a          [][]float64
+	order      int
+}
+
+var rk4Tableau = butcherTableau{
+	c: []float64{0, 0.5, 0.5, 1},
+	b: []float64{1. / 6, 1. / 3, 1. / 3, 1. / 6},
+	a: [][]float64{
+		{},
+		{0.5},
+		{0, 0.5},
+		{0, 0, 1},
+	},
+	order: 4,
+}
+
+// Dormand-Prince 5(4), cf. "A family of embedded Runge-Kutta formulae" (1980).
+var dopri5Tableau = butcherTableau{
+	c:    []float64{0, 1. / 5, 3. / 10, 4. / 5, 8. / 9, 1, 1},
+	b:    []float64{35. / 384, 0, 500. / 1113, 125. / 192, -2187. / 6784, 11. / 84, 0},
+	bErr: []float64{71. / 57600, 0, -71. / 16695, 71. / 1920, -17253. / 339200, 22. / 525, -1. / 40},
+	a: [][]float64{
+		{},
+		{1. / 5},
+		{3. / 40, 9. / 40},
+		{44. / 45, -56. / 15, 32. / 9},
+		{19372. / 6561, -25360. / 2187, 64448. / 6561, -212. / 729},
+		{9017. / 3168, -355. / 33, 46732. / 5247, 49. / 176, -5103. / 18656},
+		{35. / 384, 0, 500. / 1113, 125. / 192, -2187. / 6784, 11. / 84},
+	},
+	order: 5,
+}
+
+// Integrator numerically integrates the state together with the variational equations Φ̇ = A(x, t)*Φ,
+// which yields the state transition matrix needed by the filters (e.g. HybridKF.Prepare).
+// It implements the DynamicsModel interface. Use NewRK4 or NewRK45 to initialize.
+type Integrator struct {
+	Epoch     time.Time // Reference epoch of the time (in seconds) passed to f and A when used as a DynamicsModel.
+	f         Derivatives
+	A         Jacobian
+	tableau   butcherTableau
+	step      float64 // Fixed step, or initial step if adaptive.
+	tolerance float64 // Relative and absolute tolerance of the adaptive step, zero for a fixed step.
+	minStep   float64
+	maxSteps  int
+}
+
+// NewRK4 returns a new fixed step fourth order Runge-Kutta integrator. A may be nil if the STM is not needed.
+// The last step is shortened to end exactly at the requested time.
+func NewRK4(f Derivatives, A Jacobian, step float64) (*Integrator, error) {
+	if f == nil {
+		return nil, errors.New("the derivatives must be provided")
+	}
+	if step <= 0 {
+		return nil, errors.New("step must be strictly positive")
+	}
+	return &Integrator{time.Time{}, f, A, rk4Tableau, step, 0, 0, 0}, nil
+}
+
+// NewRK45 returns a new adaptive step Dormand-Prince integrator, whose local error on each component of the state
+// and of the STM is kept below tolerance*(1 + |component|). A may be nil if the STM is not needed.
+func NewRK45(f Derivatives, A Jacobian, tolerance float64) (*Integrator, error) {
+	if f == nil {
+		return nil, errors.New("the derivatives must be provided")
+	}
+	if tolerance <= 0 {
+		return nil, errors.New("tolerance must be strictly positive")
+	}
+	return &Integrator{time.Time{}, f, A, dopri5Tableau, 1, tolerance, 1e-12, 1e6}, nil
+}
+
+func (i *Integrator) String() string {
+	if i.tolerance == 0 {
+		return fmt.Sprintf("RK4 [h=%f s]", i.step)
+	}
+	return fmt.Sprintf("RK45 [tol=%e]", i.tolerance)
+}
+
+// Integrate integrates the state x0 from t0 to t1 (in seconds), which may be before t0.
+// Returns the state at t1 and the state transition matrix Φ(t1, t0), which is nil if A was not provided.
+func (i *Integrator) Integrate(x0 *mat64.Vector, t0, t1 float64) (*mat64.Vector, *mat64.Dense, error) {
+	n := x0.Len()
+	withSTM := i.A != nil
+	size := n
+	if withSTM {
+		size += n * n
+	}
+	// The integrated state is z = [x; Φ] with Φ stored row major.
+	z := make([]float64, size)
+	for j := 0; j < n; j++ {
+		z[j] = x0.At(j, 0)
+	}
+	if withSTM {
+		for j := 0; j < n; j++ {
+			z[n+j*n+j] = 1
+		}
+	}
+	dir := 1.0
+	if t1 < t0 {
+		dir = -1
+	}
+	h := math.Min(i.step, math.Abs(t1-t0))
+	t := t0
+	for steps := 0; dir*(t1-t) > 0; steps++ {
+		if i.maxSteps > 0 && steps >= i.maxSteps {
+			return nil, nil, fmt.Errorf("maximum number of steps reached at t=%f", t)
+		}
+		if h > dir*(t1-t) {
+			h = dir * (t1 - t)
+		}
+		zNew, errRatio, err := i.rkStep(n, t, z, dir*h)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i.tolerance == 0 {
+			t += dir * h
+			z = zNew
+			continue
+		}
+		// Adaptive step: accept the step if the error is within the tolerance, and adapt the next step.
+		scale := 0.9 * math.Pow(math.Max(errRatio, 1e-10), -1/float64(i.tableau.order))
+		scale = math.Min(5, math.Max(0.2, scale))
+		if errRatio <= 1 {
+			t += dir * h
+			z = zNew
+			h *= scale
+			continue
+		}
+		if h *= scale; h < i.minStep {
+			return nil, nil, fmt.Errorf("step size below %e at t=%f", i.minStep, t)
+		}
+	}
+	x := mat64.NewVector(n, z[:n])
+	if !withSTM {
+		return x, nil, nil
+	}
+	return x, mat64.NewDense(n, n, z[n:]), nil
+}
+
+// Propagate implements the DynamicsModel interface, where the time passed to f and A is in seconds since the Epoch.
+func (i *Integrator) Propagate(X *mat64.Vector, t0, t1 time.Time) (*mat64.Vector, *mat64.Dense, error) {
+	if i.A == nil {
+		return nil, nil, errors.New("the Jacobian must be provided to compute the STM")
+	}
+	return i.Integrate(X, t0.Sub(i.Epoch).Seconds(), t1.Sub(i.Epoch).Seconds())
+}
+
+// rkStep computes a single step of size h from z at t. Returns the new state and, if the tableau has an embedded
+// solution, the ratio of the estimated local error to the tolerance.
+func (i *Integrator) rkStep(n int, t float64, z []float64, h float64) ([]float64, float64, error) {
+	stages := len(i.tableau.c)
+	k := make([][]float64, stages)
+	zi := make([]float64, len(z))
+	for s := 0; s < stages; s++ {
+		copy(zi, z)
+		for j, a := range i.tableau.a[s] {
+			if a == 0 {
+				continue
+			}
+			for l := range zi {
+				zi[l] += h * a * k[j][l]
+			}
+		}
+		var err error
+		if k[s], err = i.derivatives(n, t+i.tableau.c[s]*h, zi); err != nil {
+			return nil, 0, err
+		}
+	}
+	zNew := make([]float64, len(z))
+	copy(zNew, z)
+	for s, b := range i.tableau.b {
+		for l := range zNew {
+			zNew[l] += h * b * k[s][l]
+		}
+	}
+	if i.tableau.bErr == nil {
+		return zNew, 0, nil
+	}
+	errRatio := 0.0
+	for l := range zNew {
+		e := 0.0
+		for s, b := range i.tableau.bErr {
+			e += h * b * k[s][l]
+		}
+		tol := i.tolerance * (1 + math.Max(math.Abs(z[l]), math.Abs(zNew[l])))
+		errRatio = math.Max(errRatio, math.Abs(e)/tol)
+	}
+	return zNew, errRatio, nil
+}
+
+// derivatives returns ż = [f(x, t); A(x, t)*Φ] of z = [x; Φ].
+func (i *Integrator) derivatives(n int, t float64, z []float64) ([]float64, error) {
+	x := mat64.NewVector(n, z[:n])
+	xDot := i.f(t, x)
+	if xDot.Len() != n {
+		return nil, fmt.Errorf("derivatives of size %d instead of %d", xDot.Len(), n)
+	}
+	zDot := make([]float64, len(z))
+	for j := 0; j < n; j++ {
+		zDot[j] = xDot.At(j, 0)
+	}
+	if len(z) == n {
+		return zDot, nil
+	}
+	A := i.A(t, x)
+	if err := checkMatDims(A, x, "A", "x", cols2rows); err != nil {
+		return nil, err
+	}
+	var ΦDot mat64.Dense
+	ΦDot.Mul(A, mat64.NewDense(n, n, z[n:]))
+	for r := 0; r < n; r++ {
+		for c := 0; c < n; c++ {
+			zDot[n+r*n+c] = ΦDot.At(r, c)
+		}
+	}
+	return zDot, nil
+}
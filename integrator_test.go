@@ -0,0 +1,115 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestIntegratorHarmonicOscillator(t *testing.T) {
+	// ẍ = -ω²x, whose solution and STM are known analytically.
+	ω := 2.0
+	f := func(t float64, x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(2, []float64{x.At(1, 0), -ω * ω * x.At(0, 0)})
+	}
+	A := func(t float64, x *mat64.Vector) *mat64.Dense {
+		return mat64.NewDense(2, 2, []float64{0, 1, -ω * ω, 0})
+	}
+	x0 := mat64.NewVector(2, []float64{1, 0.5})
+	exact := func(Δt float64) (*mat64.Vector, *mat64.Dense) {
+		c, s := math.Cos(ω*Δt), math.Sin(ω*Δt)
+		Φ := mat64.NewDense(2, 2, []float64{c, s / ω, -ω * s, c})
+		var x mat64.Vector
+		x.MulVec(Φ, x0)
+		return &x, Φ
+	}
+	rk4, err := NewRK4(f, A, 1e-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rk45, err := NewRK45(f, A, 1e-12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, integrator := range []*Integrator{rk4, rk45} {
+		for _, Δt := range []float64{0.25, 3.3, -1.7} {
+			x, Φ, err := integrator.Integrate(x0, 10, 10+Δt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			xExp, ΦExp := exact(Δt)
+			if !mat64.EqualApprox(x, xExp, 1e-9) {
+				t.Fatalf("%s Δt=%f: invalid state\n%v\n%v", integrator, Δt, mat64.Formatted(x.T()), mat64.Formatted(xExp.T()))
+			}
+			if !mat64.EqualApprox(Φ, ΦExp, 1e-9) {
+				t.Fatalf("%s Δt=%f: invalid STM\n%v\n%v", integrator, Δt, mat64.Formatted(Φ), mat64.Formatted(ΦExp))
+			}
+		}
+	}
+	// Without the Jacobian, only the state is integrated.
+	rk4NoSTM, err := NewRK4(f, nil, 1e-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, Φ, err := rk4NoSTM.Integrate(x0, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xExp, _ := exact(1); Φ != nil || !mat64.EqualApprox(x, xExp, 1e-9) {
+		t.Fatal("invalid integration without the STM")
+	}
+	if _, _, err := rk4NoSTM.Propagate(x0, time.Time{}, time.Time{}.Add(time.Second)); err == nil {
+		t.Fatal("propagation without the Jacobian should fail")
+	}
+}
+
+func TestIntegratorErrors(t *testing.T) {
+	f := func(t float64, x *mat64.Vector) *mat64.Vector {
+		return x
+	}
+	if _, err := NewRK4(nil, nil, 1); err == nil {
+		t.Fatal("missing derivatives should fail")
+	}
+	if _, err := NewRK4(f, nil, 0); err == nil {
+		t.Fatal("null step should fail")
+	}
+	if _, err := NewRK45(f, nil, 0); err == nil {
+		t.Fatal("null tolerance should fail")
+	}
+	wrongSize, _ := NewRK4(func(t float64, x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(3, nil)
+	}, nil, 1)
+	if _, _, err := wrongSize.Integrate(mat64.NewVector(2, nil), 0, 1); err == nil {
+		t.Fatal("derivatives of a different size should fail")
+	}
+}
+
+func TestIntegratorDynamicsModel(t *testing.T) {
+	// The integrator must drive the HybridKF through the DynamicsModel interface like the analytical model.
+	implements := func(DynamicsModel) {}
+	integrator, err := NewRK45(func(t float64, x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(2, []float64{x.At(1, 0), 0})
+	}, func(t float64, x *mat64.Vector) *mat64.Dense {
+		return mat64.NewDense(2, 2, []float64{0, 1, 0, 0})
+	}, 1e-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	implements(integrator)
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	integrator.Epoch = t0
+	X := mat64.NewVector(2, []float64{3, 0.7})
+	for _, Δt := range []float64{0, 1.5, 30} {
+		t1 := t0.Add(time.Duration(Δt * float64(time.Second)))
+		X1, Φ, err := integrator.Propagate(X, t0, t1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		X1Exp, ΦExp, _ := constantVelocity{}.Propagate(X, t0, t1)
+		if !mat64.EqualApprox(X1, X1Exp, 1e-9) || !mat64.EqualApprox(Φ, ΦExp, 1e-9) {
+			t.Fatalf("Δt=%f: propagation differs from the analytical model", Δt)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package gokalman
 
-import "github.com/gonum/matrix/mat64"
+import (
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
 
 // FilterType allows for quick comparison of filters.
 type FilterType uint8
@@ -15,6 +19,10 @@ func (f FilterType) String() string {
 		return "UKF"
 	case SRIFType:
 		return "SRIF"
+	case EnKFType:
+		return "EnKF"
+	case PFType:
+		return "PF"
 	default:
 		panic("unknown filter")
 	}
@@ -29,6 +37,10 @@ const (
 	UKFType
 	// SRIFType definition would be a tautology
 	SRIFType
+	// EnKFType definition would be a tautology
+	EnKFType
+	// PFType definition would be a tautology
+	PFType
 )
 
 // LDKF defines a linear dynamics Kalman Filter.
@@ -42,6 +54,7 @@ type LDKF interface {
 	SetInputControl(mat64.Matrix)
 	SetMeasurementMatrix(mat64.Matrix)
 	SetNoise(Noise)
+	SetEstimate(x *mat64.Vector, P mat64.Symmetric) error // Replaces the current estimate, e.g. in an IMM.
 	Reset()
 	String() string
 }
@@ -59,6 +72,16 @@ type NLDKF interface {
 	SetNoise(n Noise)
 }
 
+// SPKF defines a sigma point Kalman Filter for non-linear dynamical systems.
+// Unlike NLDKF, it is provided the non-linear propagation and measurement
+// functions directly and therefore does not need Φ or H̃.
+type SPKF interface {
+	Predict() (est Estimate, err error)
+	Update(realObservation *mat64.Vector) (est Estimate, err error)
+	PreparePNT(Γ *mat64.Dense)
+	SetNoise(n Noise)
+}
+
 // Estimate is returned from Update() in any KF.
 // This allows to avoid some computations in other filters, e.g. in the Information filter.
 type Estimate interface {
@@ -68,5 +91,7 @@ type Estimate interface {
 	Innovation() *mat64.Vector       // Returns y_{k} - H*\hat{x}_{k+1}^{-}
 	Covariance() mat64.Symmetric     // Return P_{k+1}^{+}
 	PredCovariance() mat64.Symmetric // Return P_{k+1}^{-}
+	Rejected() bool                  // Returns whether the measurement was rejected by the MeasurementEditor.
+	Epoch() time.Time                // Returns the epoch of the estimate (cf. SetNextEpoch on the filters).
 	String() string                  // Must implement the stringer interface.
 }
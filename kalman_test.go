@@ -15,6 +15,13 @@ func TestImplementsNLDKF(t *testing.T) {
 	implements(new(HybridKF))
 }
 
+func TestImplementsSPKF(t *testing.T) {
+	implements := func(SPKF) {}
+	implements(new(UKF))
+	implements(new(EnKF))
+	implements(new(ParticleFilter))
+}
+
 func TestImplementsEst(t *testing.T) {
 	implements := func(Estimate) {}
 	implements(VanillaEstimate{})
@@ -22,4 +29,8 @@ func TestImplementsEst(t *testing.T) {
 	implements(SquareRootEstimate{})
 	implements(HybridKFEstimate{})
 	implements(SRIFEstimate{})
+	implements(UKFEstimate{})
+	implements(EnKFEstimate{})
+	implements(ParticleEstimate{})
+	implements(IMMEstimate{})
 }
@@ -0,0 +1,37 @@
+package gokalman
+
+import (
+	"errors"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// MeasurementDescriptor describes the measurement of a single update, whose size and noise may differ from one update
+// to the next (e.g. range only, range rate only, or both depending on the tracking station).
+type MeasurementDescriptor struct {
+	Labels []string        // Label of each measurement component, e.g. "range" and "range rate", used by the exporters.
+	Htilde *mat64.Dense    // Measurement sensitivity matrix.
+	R      mat64.Symmetric // Measurement noise, used instead of the Noise's measurement matrix.
+}
+
+// NewMeasurementDescriptor returns a new MeasurementDescriptor after checking the dimensions.
+func NewMeasurementDescriptor(labels []string, Htilde *mat64.Dense, R mat64.Symmetric) (*MeasurementDescriptor, error) {
+	if err := checkMatDims(Htilde, R, "H", "R", rows2rows); err != nil {
+		return nil, err
+	}
+	if r, _ := Htilde.Dims(); len(labels) != r {
+		return nil, errors.New("there must be one label per measurement component")
+	}
+	return &MeasurementDescriptor{labels, Htilde, R}, nil
+}
+
+// Size returns the size of the measurement vector.
+func (m *MeasurementDescriptor) Size() int {
+	return len(m.Labels)
+}
+
+// LabeledEstimate is implemented by estimates which know the label of each of their measurement components.
+type LabeledEstimate interface {
+	Estimate
+	MeasurementLabels() []string // Returns nil if the measurement components are not labeled.
+}
@@ -0,0 +1,123 @@
+package gokalman
+
+import (
+	"os"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestMeasurementDescriptor(t *testing.T) {
+	H := mat64.NewDense(2, 2, []float64{1, 0, 0, 1})
+	if _, err := NewMeasurementDescriptor([]string{"range", "range rate"}, H, ScaledIdentity(1, 1)); err == nil {
+		t.Fatal("H and R of different sizes should fail")
+	}
+	if _, err := NewMeasurementDescriptor([]string{"range"}, H, ScaledIdentity(2, 1)); err == nil {
+		t.Fatal("missing label should fail")
+	}
+	m, err := NewMeasurementDescriptor([]string{"range", "range rate"}, H, ScaledIdentity(2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != 2 {
+		t.Fatalf("invalid size %d", m.Size())
+	}
+}
+
+func TestVariableMeasurements(t *testing.T) {
+	// 1D constant velocity observed in position only, velocity only, or both.
+	Φ := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 1)
+	Q := ScaledIdentity(2, 1e-3)
+	noise := NewNoiseless(Q, ScaledIdentity(1, 0.1))
+	pos, err := NewMeasurementDescriptor([]string{"position"}, mat64.NewDense(1, 2, []float64{1, 0}), ScaledIdentity(1, 0.1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vel, err := NewMeasurementDescriptor([]string{"velocity"}, mat64.NewDense(1, 2, []float64{0, 1}), ScaledIdentity(1, 0.05))
+	if err != nil {
+		t.Fatal(err)
+	}
+	both, err := NewMeasurementDescriptor([]string{"position", "velocity"}, DenseIdentity(2), mat64.NewSymDense(2, []float64{0.1, 0.01, 0.01, 0.05}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	descs := []*MeasurementDescriptor{pos, vel, both, pos, both, vel}
+	ys := [][]float64{{1.1}, {0.9}, {3.0, 1.1}, {4.1}, {5.0, 0.95}, {1.05}}
+
+	hkf, _, err := NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srif, _, err := NewSRIF(x0, P0, 1, false, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reference is a HybridKF whose noise is updated prior to each measurement.
+	ref, _, err := NewHybridKF(x0, P0, noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter, err := NewMeasurementCSVExporter([]string{"position", "velocity"}, ".", "meas.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(exporter.hdlr.Name())
+	defer exporter.Close()
+	for k, desc := range descs {
+		y := mat64.NewVector(desc.Size(), ys[k])
+		computed := mat64.NewVector(desc.Size(), nil)
+		ref.SetNoise(NewNoiseless(Q, desc.R))
+		ref.Prepare(Φ, desc.Htilde)
+		ref.PreparePNT(DenseIdentity(2))
+		refEst, err := ref.Update(y, computed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := hkf.PrepareMeasurement(Φ, desc); err != nil {
+			t.Fatal(err)
+		}
+		hkf.PreparePNT(DenseIdentity(2))
+		hEst, err := hkf.Update(y, computed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srif.PrepareMeasurement(Φ, desc); err != nil {
+			t.Fatal(err)
+		}
+		srif.PreparePNT(DenseIdentity(2))
+		sEst, err := srif.Update(y, computed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, est := range map[string]Estimate{"HybridKF": hEst, "SRIF": sEst} {
+			if !mat64.EqualApprox(est.State(), refEst.State(), 1e-10) {
+				t.Fatalf("%s k=%d: state differs\n%v\n%v", name, k, mat64.Formatted(est.State().T()), mat64.Formatted(refEst.State().T()))
+			}
+			if !mat64.EqualApprox(est.Covariance(), refEst.Covariance(), 1e-10) {
+				t.Fatalf("%s k=%d: covariance differs\n%v\n%v", name, k, mat64.Formatted(est.Covariance()), mat64.Formatted(refEst.Covariance()))
+			}
+			labels := est.(LabeledEstimate).MeasurementLabels()
+			if len(labels) != desc.Size() || labels[0] != desc.Labels[0] {
+				t.Fatalf("%s k=%d: invalid labels %v", name, k, labels)
+			}
+			if err := exporter.Write(est); err != nil {
+				t.Fatalf("%s k=%d: %s", name, k, err)
+			}
+		}
+	}
+	// The measurement must match H.
+	if err := hkf.PrepareMeasurement(Φ, pos); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hkf.Update(mat64.NewVector(2, nil), mat64.NewVector(2, nil)); err == nil {
+		t.Fatal("observation of a different size than H should fail")
+	}
+	if err := srif.PrepareMeasurement(DenseIdentity(3), pos); err == nil {
+		t.Fatal("H and Φ of different sizes should fail")
+	}
+	if err := exporter.Write(VanillaEstimate{meas: mat64.NewVector(1, nil)}); err == nil {
+		t.Fatal("unlabeled measurement of a different size than the exporter's labels should fail")
+	}
+}
@@ -0,0 +1,21 @@
+package gokalman
+
+import (
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// DynamicsModel defines the non-linear dynamics of the full state, used by the filters to propagate their reference
+// trajectory instead of relying on the caller to provide Φ.
+type DynamicsModel interface {
+	// Propagate returns the state at t1 and the state transition matrix Φ(t1, t0) from the state X at t0.
+	Propagate(X *mat64.Vector, t0, t1 time.Time) (*mat64.Vector, *mat64.Dense, error)
+}
+
+// MeasurementModel defines the non-linear measurement of the full state, used by the filters to compute the
+// observations instead of relying on the caller to provide the computed observation and H̃.
+type MeasurementModel interface {
+	// Observe returns the computed observation G(X, t) and its Jacobian H̃ = ∂G/∂X at the state X at t.
+	Observe(X *mat64.Vector, t time.Time) (*mat64.Vector, *mat64.Dense, error)
+}
@@ -0,0 +1,196 @@
+package gokalman
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// constantVelocity is a DynamicsModel of a 1D object moving at constant velocity.
+type constantVelocity struct{}
+
+func (constantVelocity) Propagate(X *mat64.Vector, t0, t1 time.Time) (*mat64.Vector, *mat64.Dense, error) {
+	Δt := t1.Sub(t0).Seconds()
+	Φ := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	var X1 mat64.Vector
+	X1.MulVec(Φ, X)
+	return &X1, Φ, nil
+}
+
+// rangeStation is a MeasurementModel of the range to that object from a station offset by d.
+type rangeStation struct {
+	d float64
+}
+
+func (s rangeStation) Observe(X *mat64.Vector, t time.Time) (*mat64.Vector, *mat64.Dense, error) {
+	ρ := math.Sqrt(X.At(0, 0)*X.At(0, 0) + s.d*s.d)
+	return mat64.NewVector(1, []float64{ρ}), mat64.NewDense(1, 2, []float64{X.At(0, 0) / ρ, 0}), nil
+}
+
+func TestHybridKFModels(t *testing.T) {
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	dynamics := constantVelocity{}
+	station := rangeStation{5}
+	truth := mat64.NewVector(2, []float64{3, 0.7})
+	Xref0 := mat64.NewVector(2, []float64{2.5, 0.6})
+	P0 := ScaledIdentity(2, 1)
+	noise := NewNoiseless(ScaledIdentity(2, 1e-6), ScaledIdentity(1, 1e-4))
+	steps := []float64{1, 2, 3.5, 5, 5, 7, 8, 10, 11, 12}
+
+	for _, ekf := range []bool{false, true} {
+		kf, _, err := NewHybridKF(mat64.NewVector(2, nil), P0, noise, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kf.Step(t0, nil); err == nil {
+			t.Fatal("stepping without models should fail")
+		}
+		if err := kf.SetModels(dynamics, station, mat64.NewVector(3, nil)); err == nil {
+			t.Fatal("reference of a different size than the state should fail")
+		}
+		if err := kf.SetModels(dynamics, station, Xref0); err != nil {
+			t.Fatal(err)
+		}
+		kf.SetEpoch(t0)
+		// The reference filter is wired by hand.
+		manual, _, err := NewHybridKF(mat64.NewVector(2, nil), P0, noise, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		Xref := mat64.NewVector(2, nil)
+		Xref.CloneVec(Xref0)
+		if ekf {
+			kf.EnableEKF()
+			manual.EnableEKF()
+		}
+		prevEpoch := t0
+		var est Estimate
+		for k, s := range steps {
+			epoch := t0.Add(time.Duration(s * float64(time.Second)))
+			Xtrue, _, _ := dynamics.Propagate(truth, t0, epoch)
+			y, _, _ := station.Observe(Xtrue, epoch)
+			if est, err = kf.Step(epoch, y); err != nil {
+				t.Fatalf("k=%d: %s", k, err)
+			}
+			if !est.Epoch().Equal(epoch) {
+				t.Fatalf("k=%d: invalid epoch %s", k, est.Epoch())
+			}
+			X, Φ, _ := dynamics.Propagate(Xref, prevEpoch, epoch)
+			computed, H, _ := station.Observe(X, epoch)
+			manual.Prepare(Φ, H)
+			manualEst, err := manual.Update(y, computed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ekf {
+				X.AddVec(X, manualEst.State())
+			}
+			Xref = X
+			prevEpoch = epoch
+			if !mat64.EqualApprox(est.State(), manualEst.State(), 1e-12) || !mat64.EqualApprox(kf.Reference(), Xref, 1e-12) {
+				t.Fatalf("k=%d: Step differs from the manual wiring\n%s\n%s", k, est, manualEst)
+			}
+		}
+		var Xhat mat64.Vector
+		Xhat.AddVec(kf.Reference(), est.State())
+		if ekf {
+			Xhat.CloneVec(kf.Reference())
+		}
+		Xtrue, _, _ := dynamics.Propagate(truth, t0, kf.Epoch())
+		if !mat64.EqualApprox(&Xhat, Xtrue, 1e-2) {
+			t.Fatalf("ekf=%v: estimate did not converge\n%v\n%v", ekf, mat64.Formatted(Xhat.T()), mat64.Formatted(Xtrue.T()))
+		}
+		// Prediction only, which must not assume the size of the state.
+		if est, err = kf.Step(kf.Epoch().Add(time.Second), nil); err != nil {
+			t.Fatal(err)
+		}
+		if est.State().Len() != 2 {
+			t.Fatalf("invalid predicted state size %d", est.State().Len())
+		}
+		if _, err = kf.Step(t0, nil); err == nil {
+			t.Fatal("stepping backwards should fail")
+		}
+	}
+}
+
+// failingStation is a MeasurementModel which cannot compute any observation.
+type failingStation struct{}
+
+func (failingStation) Observe(X *mat64.Vector, t time.Time) (*mat64.Vector, *mat64.Dense, error) {
+	return nil, nil, errors.New("station not visible")
+}
+
+func TestHybridKFStepErrors(t *testing.T) {
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	noise := NewNoiseless(ScaledIdentity(2, 1e-6), ScaledIdentity(1, 1e-4))
+	kf, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 1), noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kf.SetModels(constantVelocity{}, failingStation{}, mat64.NewVector(2, []float64{1, 1})); err != nil {
+		t.Fatal(err)
+	}
+	kf.SetEpoch(t0)
+	if _, err := kf.Step(t0.Add(time.Second), mat64.NewVector(1, []float64{5})); err == nil {
+		t.Fatal("step with a failing measurement model should fail")
+	}
+	if kf.TimeStep() != 0 || !kf.Epoch().Equal(t0) {
+		t.Fatalf("next epoch should be unset after a failed step (Δt=%f)", kf.TimeStep())
+	}
+}
+
+func TestHybridKFStepEKFSwitch(t *testing.T) {
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	dynamics := constantVelocity{}
+	station := rangeStation{5}
+	truth := mat64.NewVector(2, []float64{3, 0.7})
+	noise := NewNoiseless(ScaledIdentity(2, 1e-6), ScaledIdentity(1, 1e-4))
+	kf, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 1), noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kf.SetModels(dynamics, station, mat64.NewVector(2, []float64{2.5, 0.6})); err != nil {
+		t.Fatal(err)
+	}
+	kf.SetEpoch(t0)
+	// Full state estimate: the reference already includes the estimate in EKF mode.
+	full := func(est Estimate) *mat64.Vector {
+		X := mat64.NewVector(2, nil)
+		X.CloneVec(kf.Reference())
+		if !kf.EKFEnabled() {
+			X.AddVec(X, est.State())
+		}
+		return X
+	}
+	var est Estimate
+	for k := 1; k <= 12; k++ {
+		if k == 3 || k == 6 || k == 9 {
+			// Switching mode must not change the full state estimate.
+			prevX := full(est)
+			if k == 6 {
+				kf.DisableEKF()
+			} else {
+				kf.EnableEKF()
+			}
+			if est, err = kf.Step(kf.Epoch(), nil); err != nil {
+				t.Fatal(err)
+			}
+			if X := full(est); !mat64.EqualApprox(X, prevX, 1e-12) {
+				t.Fatalf("k=%d: full state changed when switching mode\n%v\n%v", k, mat64.Formatted(X.T()), mat64.Formatted(prevX.T()))
+			}
+		}
+		epoch := t0.Add(time.Duration(k) * time.Second)
+		Xtrue, _, _ := dynamics.Propagate(truth, t0, epoch)
+		y, _, _ := station.Observe(Xtrue, epoch)
+		if est, err = kf.Step(epoch, y); err != nil {
+			t.Fatalf("k=%d: %s", k, err)
+		}
+	}
+	Xtrue, _, _ := dynamics.Propagate(truth, t0, kf.Epoch())
+	if X := full(est); !mat64.EqualApprox(X, Xtrue, 1e-2) {
+		t.Fatalf("estimate did not converge\n%v\n%v", mat64.Formatted(X.T()), mat64.Formatted(Xtrue.T()))
+	}
+}
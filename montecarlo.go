@@ -3,6 +3,7 @@ package gokalman
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/gonum/matrix/mat64"
 	"github.com/gonum/stat"
@@ -88,8 +89,40 @@ func (mc MonteCarloRuns) AsCSV(headers []string) []string {
 	return rtn
 }
 
-// NewMonteCarloRuns run monte carlos on the provided filter.
+// NewMonteCarloRuns run monte carlos on the provided filter. Each run simulates the true states and measurements
+// from the initial state, dynamics and Noise of the filter (cf. Simulator), and stores them with the covariances
+// of the filter, which must be a pure predictor.
+// If the Noise is a Seeder, the seed of each run is derived from a seed taken from the time (cf. MonteCarloSeed),
+// otherwise a seeded noise would return the same samples in every run since it is reseeded on each reset.
 func NewMonteCarloRuns(samples, steps, rowsH int, controls []*mat64.Vector, kf *Vanilla) MonteCarloRuns {
+	if _, ok := kf.Noise.(Seeder); ok {
+		seed := time.Now().UnixNano()
+		return newMonteCarloRuns(samples, steps, rowsH, controls, kf, &seed)
+	}
+	return newMonteCarloRuns(samples, steps, rowsH, controls, kf, nil)
+}
+
+// NewSeededMonteCarloRuns is like NewMonteCarloRuns but seeds the Noise of the filter, which must be a Seeder, with
+// the seed of each run derived from the provided seed (cf. MonteCarloSeed), such that the runs are reproducible.
+// A run is replayed by seeding the noise of a Simulator with the Seed of that run.
+func NewSeededMonteCarloRuns(seed int64, samples, steps, rowsH int, controls []*mat64.Vector, kf *Vanilla) MonteCarloRuns {
+	if _, ok := kf.Noise.(Seeder); !ok {
+		panic("the noise of the Kalman filter must be a Seeder for seeded Monte Carlo runs")
+	}
+	return newMonteCarloRuns(samples, steps, rowsH, controls, kf, &seed)
+}
+
+// MonteCarloSeed returns the seed of the provided run derived from the seed of all the runs, such that the runs are
+// independent from each other.
+func MonteCarloSeed(seed int64, run int) int64 {
+	// SplitMix64 finalizer of the run index offset by the golden ratio.
+	z := uint64(seed) + uint64(run+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+func newMonteCarloRuns(samples, steps, rowsH int, controls []*mat64.Vector, kf *Vanilla, seed *int64) MonteCarloRuns {
 	if !kf.predictionOnly {
 		panic("the Kalman filter needed for the Monte Carlo runs must be a pure predictor")
 	}
@@ -105,15 +138,31 @@ func NewMonteCarloRuns(samples, steps, rowsH int, controls []*mat64.Vector, kf *
 	} else if len(controls) != steps {
 		panic("must provide as much control vectors as steps, or just one control vector")
 	}
+	sim, err := NewSimulator(kf.initEst.State(), kf.F, kf.G, kf.H, kf.Noise)
+	if err != nil {
+		panic(err)
+	}
 	for sample := 0; sample < samples; sample++ {
 		MCRun := MonteCarloRun{Estimates: make([]Estimate, steps)}
+		if seed != nil {
+			MCRun.Seed = MonteCarloSeed(*seed, sample)
+			kf.Noise.(Seeder).Seed(MCRun.Seed)
+		}
 		for k := 0; k < steps; k++ {
 			est, _ := kf.Update(mat64.NewVector(rowsH, nil), controls[k])
-			MCRun.Estimates[k] = est
+			state, meas, serr := sim.Step(controls[k])
+			if serr != nil {
+				panic(fmt.Errorf("k=%d: %s", k, serr))
+			}
+			vEst := est.(VanillaEstimate)
+			vEst.state = state
+			vEst.meas = meas
+			MCRun.Estimates[k] = vEst
 		}
 		runs[sample] = MCRun
-		// Must reinitialize the KF at every new sample.
+		// Must reinitialize the KF and the simulator at every new sample.
 		kf.Reset()
+		sim.Reset()
 	}
 	return MonteCarloRuns{samples, steps, runs}
 }
@@ -121,4 +170,5 @@ func NewMonteCarloRuns(samples, steps, rowsH int, controls []*mat64.Vector, kf *
 // MonteCarloRun stores the results of an MC run.
 type MonteCarloRun struct {
 	Estimates []Estimate
+	Seed      int64 // Seed of the noise of this run, if the noise is a Seeder.
 }
@@ -87,3 +87,71 @@ func TestMCRuns(t *testing.T) {
 	})
 
 }
+
+func TestSeededMCRuns(t *testing.T) {
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Q := mat64.NewSymDense(2, []float64{1e-3, 0, 0, 1e-2})
+	R := mat64.NewSymDense(1, []float64{0.1})
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 1)
+	u := []*mat64.Vector{mat64.NewVector(1, nil)} // A single control vector means zero controls.
+	runMC := func(seed int64) MonteCarloRuns {
+		kf, _, err := NewPurePredictorVanilla(x0, P0, F, G, H, NewAWGN(Q, R))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewSeededMonteCarloRuns(seed, 4, 10, 1, u, kf)
+	}
+	runs := runMC(7)
+	again := runMC(7)
+	other := runMC(8)
+	seeds := make(map[int64]bool)
+	for r, run := range runs.Runs {
+		if run.Seed != MonteCarloSeed(7, r) {
+			t.Fatalf("run #%d: invalid seed", r)
+		}
+		if seeds[run.Seed] {
+			t.Fatalf("run #%d: seed already used by another run", r)
+		}
+		seeds[run.Seed] = true
+		for k, est := range run.Estimates {
+			if !mat64.Equal(est.State(), again.Runs[r].Estimates[k].State()) || !mat64.Equal(est.Measurement(), again.Runs[r].Estimates[k].Measurement()) {
+				t.Fatalf("run #%d k=%d: same seed returned a different run", r, k)
+			}
+		}
+		if mat64.Equal(run.Estimates[9].State(), other.Runs[r].Estimates[9].State()) {
+			t.Fatalf("run #%d: different seeds returned the same run", r)
+		}
+	}
+	// A run is replayed with a simulator seeded with the seed of that run.
+	sim, err := NewSimulator(x0, F, G, H, NewAWGN(Q, R))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Noise.(Seeder).Seed(runs.Runs[2].Seed)
+	truth, err := sim.Run(10, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, state := range truth.States() {
+		if !mat64.Equal(state, runs.Runs[2].Estimates[k].State()) {
+			t.Fatalf("k=%d: replayed run differs", k)
+		}
+	}
+	assertPanic(t, func() {
+		kf, _, _ := NewPurePredictorVanilla(x0, P0, F, G, H, NewNoiseless(Q, R))
+		NewSeededMonteCarloRuns(7, 4, 10, 1, u, kf)
+	})
+	// The runs of a seeded noise must differ even if not seeded by the Monte Carlo.
+	kf, _, err := NewPurePredictorVanilla(x0, P0, F, G, H, NewSeededAWGN(Q, R, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unseeded := NewMonteCarloRuns(4, 10, 1, u, kf)
+	for r := 1; r < len(unseeded.Runs); r++ {
+		if unseeded.Runs[r].Seed == unseeded.Runs[0].Seed || mat64.Equal(unseeded.Runs[r].Estimates[9].State(), unseeded.Runs[0].Estimates[9].State()) {
+			t.Fatalf("run #%d: same run as the first one", r)
+		}
+	}
+}
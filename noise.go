@@ -1,6 +1,7 @@
 package gokalman
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -10,18 +11,29 @@ import (
 )
 
 // Noise allows to handle the noise for a KF.
+// The process noise w_k is that of the time update from step k to k+1, i.e. x_{k+1} = F*x_k + G*u_k + w_k, and the
+// measurement noise v_{k+1} is that of the measurement at step k+1, i.e. y_{k+1} = H*x_{k+1} + v_{k+1}. Hence, the
+// cross covariance S = E[w_k*v_{k+1}ᵀ] correlates the process noise of a time update with the measurement noise of the
+// measurement which follows it.
 type Noise interface {
 	Process(k int) *mat64.Vector        // Returns the process noise w at step k
 	Measurement(k int) *mat64.Vector    // Returns the measurement noise w at step k
 	ProcessMatrix() mat64.Symmetric     // Returns the process noise matrix Q
 	MeasurementMatrix() mat64.Symmetric // Returns the measurement noise matrix R
+	CrossCovariance() mat64.Matrix      // Returns the cross covariance S = E[w_k*v_{k+1}ᵀ], or nil if w and v are independent
 	Reset()                             // Reinitializes the noise
 	String() string                     // Stringer interface implementation
 }
 
+// Seeder is implemented by the noise sources whose samples can be reproduced.
+type Seeder interface {
+	Seed(seed int64) // Reseeds the noise: the samples following a Seed or Reset call are then always the same.
+}
+
 // Noiseless is noiseless and implements the Noise interface.
 type Noiseless struct {
 	Q, R                         mat64.Symmetric
+	S                            mat64.Matrix // Cross covariance, nil if the process and measurement noises are independent
 	processSize, measurementSize int
 }
 
@@ -32,7 +44,18 @@ func NewNoiseless(Q, R mat64.Symmetric) *Noiseless {
 	}
 	rQ, _ := Q.Dims()
 	rR, _ := R.Dims()
-	return &Noiseless{Q, R, rQ, rR}
+	return &Noiseless{Q, R, nil, rQ, rR}
+}
+
+// NewCorrelatedNoiseless creates new Noiseless noise from the provided Q and R, and the cross covariance S (cf. Noise).
+// Returns an error if S is not of size rows(Q) x rows(R).
+func NewCorrelatedNoiseless(Q, R mat64.Symmetric, S mat64.Matrix) (*Noiseless, error) {
+	n := NewNoiseless(Q, R)
+	if err := checkCrossCovariance(Q, R, S); err != nil {
+		return nil, err
+	}
+	n.S = S
+	return n, nil
 }
 
 // Process returns a vector of the correct size.
@@ -55,6 +78,11 @@ func (n Noiseless) MeasurementMatrix() mat64.Symmetric {
 	return n.R
 }
 
+// CrossCovariance implements the Noise interface.
+func (n Noiseless) CrossCovariance() mat64.Matrix {
+	return n.S
+}
+
 // Reset does nothing for a Noiseless signal.
 func (n Noiseless) Reset() {}
 
@@ -97,6 +125,11 @@ func (n BatchNoise) MeasurementMatrix() mat64.Symmetric {
 	return mat64.NewSymDense(rows, nil)
 }
 
+// CrossCovariance implements the Noise interface.
+func (n BatchNoise) CrossCovariance() mat64.Matrix {
+	return nil
+}
+
 // Reset does nothing for a BatchNoise signal.
 func (n BatchNoise) Reset() {}
 
@@ -105,20 +138,94 @@ func (n BatchNoise) String() string {
 	return "BatchNoise"
 }
 
+// randSource is the source of the samples of a noise, which is reseeded on each reset if seeded.
+type randSource struct {
+	src    rand.Source // Source of the samples, or nil to seed a new source from the time on each reset.
+	seed   int64
+	seeded bool // Whether the source is reseeded with seed on each reset.
+}
+
+// rng returns the random number generator to use after a reset.
+func (s *randSource) rng() *rand.Rand {
+	if s.src == nil {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if s.seeded {
+		s.src.Seed(s.seed)
+	}
+	return rand.New(s.src)
+}
+
+// setSeed seeds the source, such that rng always returns the same sequence.
+func (s *randSource) setSeed(seed int64) {
+	if s.src == nil {
+		s.src = rand.NewSource(seed)
+	}
+	s.seed = seed
+	s.seeded = true
+}
+
 // AWGN implements the Noise interface and generates an Additive white Gaussian noise.
+// If the noise is correlated (cf. NewCorrelatedAWGN), the process and measurement noises are drawn jointly, such that
+// the measurement noise following a process noise sample is correlated with it, as in a Simulator step.
 type AWGN struct {
 	Q, R        mat64.Symmetric
+	S           mat64.Matrix // Cross covariance, nil if the process and measurement noises are independent
 	process     *distmv.Normal
 	measurement *distmv.Normal
+	joint       *distmv.Normal // Joint distribution of the process and measurement noises, nil if independent
+	v           *mat64.Vector  // Measurement noise drawn with the latest process noise, until returned by Measurement
+	randSource
 }
 
-// NewAWGN creates new AWGN noise from the provided Q and R.
+// NewAWGN creates new AWGN noise from the provided Q and R, seeded from the time.
 func NewAWGN(Q, R mat64.Symmetric) *AWGN {
-	n := &AWGN{Q, R, nil, nil}
+	n := &AWGN{Q, R, nil, nil, nil, nil, nil, randSource{}}
+	n.Reset()
+	return n
+}
+
+// NewSeededAWGN creates new AWGN noise from the provided Q and R, whose samples are reproduced from the seed after
+// each reset.
+func NewSeededAWGN(Q, R mat64.Symmetric, seed int64) *AWGN {
+	n := &AWGN{Q, R, nil, nil, nil, nil, nil, randSource{rand.NewSource(seed), seed, true}}
+	n.Reset()
+	return n
+}
+
+// NewAWGNFromSource creates new AWGN noise from the provided Q and R, which draws its samples from src.
+// The source is not reseeded on reset: call Seed for the samples to be reproduced after each reset.
+func NewAWGNFromSource(Q, R mat64.Symmetric, src rand.Source) *AWGN {
+	if src == nil {
+		panic("source must be specified")
+	}
+	n := &AWGN{Q, R, nil, nil, nil, nil, nil, randSource{src, 0, false}}
 	n.Reset()
 	return n
 }
 
+// NewCorrelatedAWGN creates new AWGN noise from the provided Q and R, and the cross covariance S (cf. Noise), seeded
+// from the time. Returns an error if S is not of size rows(Q) x rows(R), or if the joint covariance [Q S; Sᵀ R] of the
+// process and measurement noises is not positive definite.
+func NewCorrelatedAWGN(Q, R mat64.Symmetric, S mat64.Matrix) (*AWGN, error) {
+	if err := checkCrossCovariance(Q, R, S); err != nil {
+		return nil, err
+	}
+	var chol mat64.Cholesky
+	if !chol.Factorize(jointCovariance(Q, R, S)) {
+		return nil, errors.New("joint covariance of the process and measurement noises is not positive definite")
+	}
+	n := &AWGN{Q, R, S, nil, nil, nil, nil, randSource{}}
+	n.Reset()
+	return n, nil
+}
+
+// Seed implements the Seeder interface.
+func (n *AWGN) Seed(seed int64) {
+	n.setSeed(seed)
+	n.Reset()
+}
+
 // ProcessMatrix implements the Noise interface.
 func (n *AWGN) ProcessMatrix() mat64.Symmetric {
 	return n.Q
@@ -129,36 +236,68 @@ func (n *AWGN) MeasurementMatrix() mat64.Symmetric {
 	return n.R
 }
 
+// CrossCovariance implements the Noise interface.
+func (n *AWGN) CrossCovariance() mat64.Matrix {
+	return n.S
+}
+
 // Process implements the Noise interface.
 func (n *AWGN) Process(k int) *mat64.Vector {
+	if n.joint != nil {
+		sizeQ, _ := n.Q.Dims()
+		r := n.joint.Rand(nil)
+		n.v = mat64.NewVector(len(r)-sizeQ, r[sizeQ:])
+		return mat64.NewVector(sizeQ, r[:sizeQ])
+	}
 	r := n.process.Rand(nil)
 	return mat64.NewVector(len(r), r)
 }
 
 // Measurement implements the Noise interface.
 func (n *AWGN) Measurement(k int) *mat64.Vector {
+	if n.joint != nil {
+		if v := n.v; v != nil {
+			n.v = nil
+			return v
+		}
+		sizeQ, _ := n.Q.Dims()
+		r := n.joint.Rand(nil)
+		return mat64.NewVector(len(r)-sizeQ, r[sizeQ:])
+	}
 	r := n.measurement.Rand(nil)
 	return mat64.NewVector(len(r), r)
 }
 
-// Reset does nothing for a Noiseless signal.
+// Reset reinitializes the distributions, from the seed if the noise is seeded.
 func (n *AWGN) Reset() {
-	seed := rand.New(rand.NewSource(time.Now().UnixNano()))
+	rng := n.rng()
 	sizeQ, _ := n.Q.Dims()
-	process, ok := distmv.NewNormal(make([]float64, sizeQ), n.Q, seed)
+	process, ok := distmv.NewNormal(make([]float64, sizeQ), n.Q, rng)
 	if !ok {
 		panic("process noise invalid")
 	}
 	sizeR, _ := n.R.Dims()
-	meas, ok := distmv.NewNormal(make([]float64, sizeR), n.R, seed)
+	meas, ok := distmv.NewNormal(make([]float64, sizeR), n.R, rng)
 	if !ok {
 		panic("measurement noise invalid")
 	}
 	n.process = process
 	n.measurement = meas
+	n.joint = nil
+	n.v = nil
+	if n.S != nil {
+		joint, ok := distmv.NewNormal(make([]float64, sizeQ+sizeR), jointCovariance(n.Q, n.R, n.S), rng)
+		if !ok {
+			panic("joint process and measurement noise invalid")
+		}
+		n.joint = joint
+	}
 }
 
 // String implements the Stringer interface.
 func (n AWGN) String() string {
+	if n.S != nil {
+		return fmt.Sprintf("AWGN{\nQ=%v\nR=%v\nS=%v}\n", mat64.Formatted(n.Q, mat64.Prefix("  ")), mat64.Formatted(n.R, mat64.Prefix("  ")), mat64.Formatted(n.S, mat64.Prefix("  ")))
+	}
 	return fmt.Sprintf("AWGN{\nQ=%v\nR=%v}\n", mat64.Formatted(n.Q, mat64.Prefix("  ")), mat64.Formatted(n.R, mat64.Prefix("  ")))
 }
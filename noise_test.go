@@ -1,6 +1,7 @@
 package gokalman
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/gonum/matrix/mat64"
@@ -168,3 +169,58 @@ func TestAWGN(t *testing.T) {
 		}
 	}
 }
+
+func TestAWGNSeeded(t *testing.T) {
+	Q := mat64.NewSymDense(2, []float64{1, 0.1, 0.1, 1})
+	R := mat64.NewSymDense(1, []float64{0.5})
+	samples := func(n *AWGN) []float64 {
+		var s []float64
+		for k := 0; k < 5; k++ {
+			s = append(s, n.Process(k).At(0, 0), n.Process(k).At(1, 0), n.Measurement(k).At(0, 0))
+		}
+		return s
+	}
+	equal := func(a, b []float64) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	n1 := NewSeededAWGN(Q, R, 42)
+	s1 := samples(n1)
+	if s2 := samples(NewSeededAWGN(Q, R, 42)); !equal(s1, s2) {
+		t.Fatal("same seed returned different samples")
+	}
+	if s2 := samples(NewSeededAWGN(Q, R, 43)); equal(s1, s2) {
+		t.Fatal("different seeds returned the same samples")
+	}
+	// A reset replays the same samples.
+	n1.Reset()
+	if s2 := samples(n1); !equal(s1, s2) {
+		t.Fatal("reset of a seeded noise did not replay the samples")
+	}
+	// An injected source is used as is, and can be seeded.
+	n2 := NewAWGNFromSource(Q, R, rand.NewSource(42))
+	if s2 := samples(n2); !equal(s1, s2) {
+		t.Fatal("injected source returned different samples")
+	}
+	n2.Reset()
+	if s2 := samples(n2); equal(s1, s2) {
+		t.Fatal("reset of an unseeded source replayed the samples")
+	}
+	var seeder Seeder = n2
+	seeder.Seed(42)
+	if s2 := samples(n2); !equal(s1, s2) {
+		t.Fatal("seeding the noise did not replay the samples")
+	}
+	n3 := NewAWGN(Q, R)
+	n3.Seed(42)
+	if s2 := samples(n3); !equal(s1, s2) {
+		t.Fatal("seeding a time seeded noise did not replay the samples")
+	}
+	assertPanic(t, func() {
+		NewAWGNFromSource(Q, R, nil)
+	})
+}
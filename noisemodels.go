@@ -0,0 +1,372 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// NoiseSequence generates the samples of a single noise channel (process or measurement), which may be correlated
+// in time. Each call to Next returns the following sample of the sequence.
+type NoiseSequence interface {
+	Next() *mat64.Vector                 // Returns the next sample
+	Covariance() mat64.Symmetric         // Returns the equivalent discrete covariance of the driving white noise
+	MarginalCovariance() mat64.Symmetric // Returns the covariance of the next sample itself
+	Size() int                           // Returns the size of the samples
+	Reset()                              // Restarts the sequence
+	Seeder
+	String() string
+}
+
+// noiseFactor returns L such that L*Lᵀ = Σ, used to sample from N(0, Σ). Σ must be either positive definite or
+// diagonal, possibly with zero variances.
+func noiseFactor(Σ mat64.Symmetric) (*mat64.Dense, error) {
+	n := Σ.Symmetric()
+	L := mat64.NewDense(n, n, nil)
+	diagonal := true
+	for i := 0; i < n && diagonal; i++ {
+		for j := i + 1; j < n; j++ {
+			if Σ.At(i, j) != 0 {
+				diagonal = false
+				break
+			}
+		}
+	}
+	if diagonal {
+		for i := 0; i < n; i++ {
+			if Σ.At(i, i) < 0 {
+				return nil, fmt.Errorf("variance #%d is negative", i)
+			}
+			L.Set(i, i, math.Sqrt(Σ.At(i, i)))
+		}
+		return L, nil
+	}
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(Σ); !ok {
+		return nil, errors.New("covariance is neither diagonal nor positive definite")
+	}
+	var LTri mat64.TriDense
+	LTri.LFromCholesky(&chol)
+	L.Copy(&LTri)
+	return L, nil
+}
+
+// MarkovNoise is a first order Markov noise sequence: b_{k+1} = Φ*b_k + w_k, where Φ is diagonal, w_k ~ N(0, Qd)
+// and b_0 ~ N(0, P0). Its samples are b_1, b_2, etc. It models the usual sensor and clock errors: use
+// NewWhiteNoise, NewGaussMarkovNoise, NewRandomWalkNoise or NewBiasNoise to initialize, and NewSumNoise to compose.
+// The noise is seeded from the time unless Seed is called.
+type MarkovNoise struct {
+	name   string
+	φ      []float64       // Diagonal of the transition Φ
+	Qd, P0 mat64.Symmetric // Covariance of the driving white noise and initial covariance
+	L, L0  *mat64.Dense    // Factors of Qd and P0
+	b      *mat64.Vector   // Current value of the sequence
+	Pb     *mat64.SymDense // Covariance of b, propagated from P0
+	rand   *rand.Rand
+	randSource
+}
+
+func newMarkovNoise(name string, φ []float64, Qd, P0 mat64.Symmetric) (*MarkovNoise, error) {
+	L, err := noiseFactor(Qd)
+	if err != nil {
+		return nil, fmt.Errorf("%s driving noise: %s", name, err)
+	}
+	L0, err := noiseFactor(P0)
+	if err != nil {
+		return nil, fmt.Errorf("%s initial covariance: %s", name, err)
+	}
+	n := &MarkovNoise{name, φ, Qd, P0, L, L0, nil, nil, nil, randSource{}}
+	n.Reset()
+	return n, nil
+}
+
+// NewWhiteNoise returns a new white noise sequence of covariance Σ, i.e. Φ = 0 and Qd = Σ.
+func NewWhiteNoise(Σ mat64.Symmetric) (*MarkovNoise, error) {
+	n := Σ.Symmetric()
+	return newMarkovNoise("White", make([]float64, n), Σ, mat64.NewSymDense(n, nil))
+}
+
+// NewGaussMarkovNoise returns a new first order Gauss–Markov noise sequence sampled every Δt, with the time
+// constants τ and steady state standard deviations σ of each component (e.g. a gyro bias instability).
+// Then Φ = e^{-Δt/τ} and Qd = σ²(1 - e^{-2Δt/τ}), and the sequence starts in its steady state, i.e. P0 = σ².
+func NewGaussMarkovNoise(τ, σ []float64, Δt float64) (*MarkovNoise, error) {
+	if len(τ) == 0 || len(τ) != len(σ) {
+		return nil, fmt.Errorf("τ and σ must have the same non zero length: %d != %d", len(τ), len(σ))
+	}
+	if Δt <= 0 {
+		return nil, errors.New("Δt must be strictly positive")
+	}
+	n := len(τ)
+	φ := make([]float64, n)
+	Qd := mat64.NewSymDense(n, nil)
+	P0 := mat64.NewSymDense(n, nil)
+	for i := range τ {
+		if τ[i] <= 0 {
+			return nil, fmt.Errorf("time constant τ[%d] must be strictly positive", i)
+		}
+		φ[i] = math.Exp(-Δt / τ[i])
+		Qd.SetSym(i, i, σ[i]*σ[i]*(1-φ[i]*φ[i]))
+		P0.SetSym(i, i, σ[i]*σ[i])
+	}
+	return newMarkovNoise("GaussMarkov", φ, Qd, P0)
+}
+
+// NewRandomWalkNoise returns a new random walk noise sequence sampled every Δt, with the power spectral densities q
+// of each component (e.g. a clock frequency drift). Then Φ = I and Qd = q*Δt, and the sequence starts at zero.
+func NewRandomWalkNoise(q []float64, Δt float64) (*MarkovNoise, error) {
+	if len(q) == 0 {
+		return nil, errors.New("at least one power spectral density must be provided")
+	}
+	if Δt <= 0 {
+		return nil, errors.New("Δt must be strictly positive")
+	}
+	n := len(q)
+	φ := make([]float64, n)
+	Qd := mat64.NewSymDense(n, nil)
+	for i := range q {
+		φ[i] = 1
+		Qd.SetSym(i, i, q[i]*Δt)
+	}
+	return newMarkovNoise("RandomWalk", φ, Qd, mat64.NewSymDense(n, nil))
+}
+
+// NewBiasNoise returns a new random constant noise sequence, drawn on each reset with the standard deviations σ of
+// each component (e.g. a turn-on bias). Then Φ = I, Qd = 0 and P0 = σ².
+func NewBiasNoise(σ []float64) (*MarkovNoise, error) {
+	if len(σ) == 0 {
+		return nil, errors.New("at least one standard deviation must be provided")
+	}
+	n := len(σ)
+	φ := make([]float64, n)
+	P0 := mat64.NewSymDense(n, nil)
+	for i := range σ {
+		φ[i] = 1
+		P0.SetSym(i, i, σ[i]*σ[i])
+	}
+	return newMarkovNoise("Bias", φ, mat64.NewSymDense(n, nil), P0)
+}
+
+// sample returns L*w where w ~ N(0, I).
+func (n *MarkovNoise) sample(L *mat64.Dense) *mat64.Vector {
+	w := mat64.NewVector(len(n.φ), nil)
+	for i := 0; i < len(n.φ); i++ {
+		w.SetVec(i, n.rand.NormFloat64())
+	}
+	var Lw mat64.Vector
+	Lw.MulVec(L, w)
+	return &Lw
+}
+
+// Next implements the NoiseSequence interface.
+func (n *MarkovNoise) Next() *mat64.Vector {
+	w := n.sample(n.L)
+	b := mat64.NewVector(len(n.φ), nil)
+	for i, φ := range n.φ {
+		b.SetVec(i, φ*n.b.At(i, 0)+w.At(i, 0))
+	}
+	n.b = b
+	n.Pb = n.nextCovariance()
+	return mat64.NewVector(b.Len(), append([]float64(nil), b.RawVector().Data...))
+}
+
+// Covariance implements the NoiseSequence interface: the covariance Qd of the driving noise w_k.
+func (n *MarkovNoise) Covariance() mat64.Symmetric {
+	return n.Qd
+}
+
+// MarginalCovariance implements the NoiseSequence interface: Φ*P*Φ + Qd where P is the covariance of the latest
+// sample (P0 after a reset). It is σ² for a Gauss–Markov noise in its steady state, P0 for a bias and it grows
+// linearly for a random walk.
+func (n *MarkovNoise) MarginalCovariance() mat64.Symmetric {
+	return n.nextCovariance()
+}
+
+// nextCovariance returns the covariance of the next sample, Φ*Pb*Φ + Qd.
+func (n *MarkovNoise) nextCovariance() *mat64.SymDense {
+	size := len(n.φ)
+	P := mat64.NewSymDense(size, nil)
+	for i := 0; i < size; i++ {
+		for j := i; j < size; j++ {
+			P.SetSym(i, j, n.φ[i]*n.φ[j]*n.Pb.At(i, j)+n.Qd.At(i, j))
+		}
+	}
+	return P
+}
+
+// InitialCovariance returns the covariance P0 of b_0.
+func (n *MarkovNoise) InitialCovariance() mat64.Symmetric {
+	return n.P0
+}
+
+// Transition returns the transition Φ of the sequence, e.g. to augment the state of a filter with this noise.
+func (n *MarkovNoise) Transition() *mat64.Dense {
+	Φ := mat64.NewDense(len(n.φ), len(n.φ), nil)
+	for i, φ := range n.φ {
+		Φ.Set(i, i, φ)
+	}
+	return Φ
+}
+
+// Size implements the NoiseSequence interface.
+func (n *MarkovNoise) Size() int {
+	return len(n.φ)
+}
+
+// Reset implements the NoiseSequence interface: b_0 is drawn again.
+func (n *MarkovNoise) Reset() {
+	n.rand = n.rng()
+	n.b = n.sample(n.L0)
+	size := len(n.φ)
+	n.Pb = mat64.NewSymDense(size, nil)
+	for i := 0; i < size; i++ {
+		for j := i; j < size; j++ {
+			n.Pb.SetSym(i, j, n.P0.At(i, j))
+		}
+	}
+}
+
+// Seed implements the Seeder interface.
+func (n *MarkovNoise) Seed(seed int64) {
+	n.setSeed(seed)
+	n.Reset()
+}
+
+// String implements the Stringer interface.
+func (n *MarkovNoise) String() string {
+	return fmt.Sprintf("%s{Φ=%v\nQd=%v}", n.name, n.φ, mat64.Formatted(n.Qd, mat64.Prefix("   ")))
+}
+
+// SumNoise is the sum of independent noise sequences of the same size, e.g. the white, bias instability and random
+// walk components of a gyro. Use NewSumNoise to initialize.
+type SumNoise struct {
+	sequences []NoiseSequence
+}
+
+// NewSumNoise returns the sum of the provided independent noise sequences.
+func NewSumNoise(sequences ...NoiseSequence) (*SumNoise, error) {
+	if len(sequences) == 0 {
+		return nil, errors.New("at least one noise sequence must be provided")
+	}
+	for i, s := range sequences {
+		if s.Size() != sequences[0].Size() {
+			return nil, fmt.Errorf("noise sequence #%d is of size %d instead of %d", i, s.Size(), sequences[0].Size())
+		}
+	}
+	return &SumNoise{sequences}, nil
+}
+
+// Next implements the NoiseSequence interface.
+func (n *SumNoise) Next() *mat64.Vector {
+	sum := mat64.NewVector(n.Size(), nil)
+	for _, s := range n.sequences {
+		sum.AddVec(sum, s.Next())
+	}
+	return sum
+}
+
+// Covariance implements the NoiseSequence interface: the sum of the equivalent discrete covariances.
+func (n *SumNoise) Covariance() mat64.Symmetric {
+	sum := mat64.NewSymDense(n.Size(), nil)
+	for _, s := range n.sequences {
+		sum.AddSym(sum, s.Covariance())
+	}
+	return sum
+}
+
+// MarginalCovariance implements the NoiseSequence interface: the sum of the marginal covariances.
+func (n *SumNoise) MarginalCovariance() mat64.Symmetric {
+	sum := mat64.NewSymDense(n.Size(), nil)
+	for _, s := range n.sequences {
+		sum.AddSym(sum, s.MarginalCovariance())
+	}
+	return sum
+}
+
+// Size implements the NoiseSequence interface.
+func (n *SumNoise) Size() int {
+	return n.sequences[0].Size()
+}
+
+// Reset implements the NoiseSequence interface.
+func (n *SumNoise) Reset() {
+	for _, s := range n.sequences {
+		s.Reset()
+	}
+}
+
+// Seed implements the Seeder interface: each sequence is seeded with a different seed derived from the provided one.
+func (n *SumNoise) Seed(seed int64) {
+	for i, s := range n.sequences {
+		s.Seed(MonteCarloSeed(seed, i))
+	}
+}
+
+// String implements the Stringer interface.
+func (n *SumNoise) String() string {
+	str := "Sum{"
+	for _, s := range n.sequences {
+		str += "\n" + s.String()
+	}
+	return str + "}"
+}
+
+// SequenceNoise implements the Noise interface from a process and a measurement noise sequence. Q is the equivalent
+// discrete covariance of the driving noise of the process sequence (e.g. to augment the state with a coloured process
+// noise), and R is the marginal covariance of the next sample of the measurement sequence, i.e. the covariance of the
+// measurement errors. Use NewSequenceNoise to initialize.
+type SequenceNoise struct {
+	process, measurement NoiseSequence
+}
+
+// NewSequenceNoise returns a new Noise from the provided process and measurement noise sequences.
+func NewSequenceNoise(process, measurement NoiseSequence) (*SequenceNoise, error) {
+	if process == nil || measurement == nil {
+		return nil, errors.New("both the process and the measurement noise sequences must be provided")
+	}
+	return &SequenceNoise{process, measurement}, nil
+}
+
+// Process implements the Noise interface. NOTE: it returns the next sample of the sequence, whatever k.
+func (n *SequenceNoise) Process(k int) *mat64.Vector {
+	return n.process.Next()
+}
+
+// Measurement implements the Noise interface. NOTE: it returns the next sample of the sequence, whatever k.
+func (n *SequenceNoise) Measurement(k int) *mat64.Vector {
+	return n.measurement.Next()
+}
+
+// ProcessMatrix implements the Noise interface: the covariance of the driving noise of the process sequence.
+func (n *SequenceNoise) ProcessMatrix() mat64.Symmetric {
+	return n.process.Covariance()
+}
+
+// MeasurementMatrix implements the Noise interface: the marginal covariance of the next measurement noise sample.
+func (n *SequenceNoise) MeasurementMatrix() mat64.Symmetric {
+	return n.measurement.MarginalCovariance()
+}
+
+// CrossCovariance implements the Noise interface: the sequences are independent.
+func (n *SequenceNoise) CrossCovariance() mat64.Matrix {
+	return nil
+}
+
+// Reset implements the Noise interface.
+func (n *SequenceNoise) Reset() {
+	n.process.Reset()
+	n.measurement.Reset()
+}
+
+// Seed implements the Seeder interface: both sequences are seeded with different seeds derived from the provided one.
+func (n *SequenceNoise) Seed(seed int64) {
+	n.process.Seed(MonteCarloSeed(seed, 0))
+	n.measurement.Seed(MonteCarloSeed(seed, 1))
+}
+
+// String implements the Stringer interface.
+func (n *SequenceNoise) String() string {
+	return fmt.Sprintf("SequenceNoise{\nprocess=%s\nmeasurement=%s}\n", n.process, n.measurement)
+}
@@ -0,0 +1,202 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestGaussMarkovNoise(t *testing.T) {
+	τ, σ, Δt := 5.0, 0.2, 1.0
+	gm, err := NewGaussMarkovNoise([]float64{τ}, []float64{σ}, Δt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm.Seed(2017)
+	φ := math.Exp(-Δt / τ)
+	if gm.Transition().At(0, 0) != φ {
+		t.Fatalf("invalid transition %f instead of %f", gm.Transition().At(0, 0), φ)
+	}
+	if qd := gm.Covariance().At(0, 0); math.Abs(qd-σ*σ*(1-φ*φ)) > 1e-15 {
+		t.Fatalf("invalid equivalent discrete covariance %f", qd)
+	}
+	if P := gm.MarginalCovariance().At(0, 0); math.Abs(P-σ*σ) > 1e-15 {
+		t.Fatalf("invalid marginal covariance %f instead of %f", P, σ*σ)
+	}
+	// The sequence is stationary: check its variance and lag-1 autocorrelation.
+	samples := 50000
+	var sum, sumSq, sumLag, prev float64
+	for k := 0; k < samples; k++ {
+		b := gm.Next().At(0, 0)
+		sum += b
+		sumSq += b * b
+		if k > 0 {
+			sumLag += b * prev
+		}
+		prev = b
+	}
+	mean := sum / float64(samples)
+	variance := sumSq/float64(samples) - mean*mean
+	if math.Abs(variance-σ*σ)/(σ*σ) > 0.1 {
+		t.Fatalf("invalid variance %f instead of %f", variance, σ*σ)
+	}
+	if ρ := sumLag / float64(samples-1) / variance; math.Abs(ρ-φ) > 0.05 {
+		t.Fatalf("invalid autocorrelation %f instead of %f", ρ, φ)
+	}
+}
+
+func TestRandomWalkAndBiasNoise(t *testing.T) {
+	q, Δt := 0.5, 0.1
+	rw, err := NewRandomWalkNoise([]float64{q}, Δt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The variance after k steps is k*q*Δt: the walk is not reset between runs to use a single seeded source.
+	rw.Seed(2017)
+	runs, steps := 2000, 50
+	var sumSq, start float64
+	for r := 0; r < runs; r++ {
+		var b float64
+		for k := 0; k < steps; k++ {
+			b = rw.Next().At(0, 0)
+		}
+		sumSq += (b - start) * (b - start)
+		start = b
+	}
+	expected := float64(steps) * q * Δt
+	if variance := sumSq / float64(runs); math.Abs(variance-expected)/expected > 0.1 {
+		t.Fatalf("invalid random walk variance %f instead of %f", variance, expected)
+	}
+
+	bias, err := NewBiasNoise([]float64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b0 := bias.Next()
+	for k := 0; k < 10; k++ {
+		if !mat64.Equal(b0, bias.Next()) {
+			t.Fatal("bias is not constant")
+		}
+	}
+	bias.Reset()
+	if mat64.Equal(b0, bias.Next()) {
+		t.Fatal("bias was not redrawn on reset")
+	}
+	bias.Seed(1)
+	b0 = bias.Next()
+	bias.Reset()
+	if !mat64.Equal(b0, bias.Next()) {
+		t.Fatal("seeded bias differs after reset")
+	}
+	if !mat64.Equal(bias.Covariance(), mat64.NewSymDense(2, nil)) {
+		t.Fatal("bias must not have any driving noise")
+	}
+	if P0 := bias.InitialCovariance(); P0.At(0, 0) != 1 || P0.At(1, 1) != 4 {
+		t.Fatal("invalid bias initial covariance")
+	}
+	if !mat64.Equal(bias.MarginalCovariance(), bias.InitialCovariance()) {
+		t.Fatal("bias marginal covariance must be its initial covariance")
+	}
+}
+
+func TestSequenceNoise(t *testing.T) {
+	// A gyro: white noise, bias instability and rate random walk.
+	Δt := 0.1
+	white, err := NewWhiteNoise(mat64.NewSymDense(1, []float64{1e-4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm, _ := NewGaussMarkovNoise([]float64{100}, []float64{1e-3}, Δt)
+	rw, _ := NewRandomWalkNoise([]float64{1e-6}, Δt)
+	bias, _ := NewBiasNoise([]float64{1e-2})
+	gyro, err := NewSumNoise(white, gm, rw, bias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expQ := white.Covariance().At(0, 0) + gm.Covariance().At(0, 0) + rw.Covariance().At(0, 0)
+	if math.Abs(gyro.Covariance().At(0, 0)-expQ) > 1e-18 {
+		t.Fatalf("invalid sum covariance %e instead of %e", gyro.Covariance().At(0, 0), expQ)
+	}
+	process, _ := NewWhiteNoise(ScaledIdentity(2, 1e-4))
+	noise, err := NewSequenceNoise(process, gyro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if noise.ProcessMatrix().At(1, 1) != 1e-4 {
+		t.Fatal("invalid Q")
+	}
+	// R is the covariance of the measurement errors: the bias instability and the bias are in their steady state,
+	// and the random walk variance grows with each sample.
+	for k := 1; k <= 3; k++ {
+		expR := 1e-4 + 1e-6 + float64(k)*1e-6*Δt + 1e-4
+		if R := noise.MeasurementMatrix().At(0, 0); math.Abs(R-expR) > 1e-18 {
+			t.Fatalf("k=%d: invalid R %e instead of %e", k, R, expR)
+		}
+		noise.Measurement(k)
+	}
+	noise.Reset()
+	if R := noise.MeasurementMatrix().At(0, 0); math.Abs(R-(2e-4+1e-6+1e-6*Δt)) > 1e-18 {
+		t.Fatalf("invalid R after reset %e", R)
+	}
+	// Seeded simulations are reproduced.
+	noise.Seed(5044)
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	sim, err := NewSimulator(mat64.NewVector(2, nil), F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truth, err := sim.Run(20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Reset()
+	again, err := sim.Run(20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range truth.Measurements() {
+		if !mat64.Equal(truth.Measurements()[k], again.Measurements()[k]) || !mat64.Equal(truth.States()[k], again.States()[k]) {
+			t.Fatalf("k=%d: seeded simulation differs after reset", k)
+		}
+	}
+}
+
+func TestNoiseModelsErrors(t *testing.T) {
+	if _, err := NewGaussMarkovNoise([]float64{1, 2}, []float64{1}, 1); err == nil {
+		t.Fatal("different lengths did not fail")
+	}
+	if _, err := NewGaussMarkovNoise([]float64{0}, []float64{1}, 1); err == nil {
+		t.Fatal("null time constant did not fail")
+	}
+	if _, err := NewGaussMarkovNoise([]float64{1}, []float64{1}, 0); err == nil {
+		t.Fatal("null Δt did not fail")
+	}
+	if _, err := NewRandomWalkNoise(nil, 1); err == nil {
+		t.Fatal("missing power spectral density did not fail")
+	}
+	if _, err := NewRandomWalkNoise([]float64{-1}, 1); err == nil {
+		t.Fatal("negative power spectral density did not fail")
+	}
+	if _, err := NewBiasNoise(nil); err == nil {
+		t.Fatal("missing standard deviation did not fail")
+	}
+	if _, err := NewWhiteNoise(mat64.NewSymDense(2, []float64{1, 2, 2, 1})); err == nil {
+		t.Fatal("non positive definite covariance did not fail")
+	}
+	correlated, err := NewWhiteNoise(mat64.NewSymDense(2, []float64{2, 1, 1, 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bias, _ := NewBiasNoise([]float64{1})
+	if _, err := NewSumNoise(correlated, bias); err == nil {
+		t.Fatal("different sizes did not fail")
+	}
+	if _, err := NewSumNoise(); err == nil {
+		t.Fatal("empty sum did not fail")
+	}
+	if _, err := NewSequenceNoise(bias, nil); err == nil {
+		t.Fatal("missing sequence did not fail")
+	}
+}
@@ -0,0 +1,109 @@
+package od
+
+import (
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// Gravity defines the point mass and J2 gravity field of a central body. Its Derivatives and Jacobian methods are
+// meant to be provided to a numerical integrator (e.g. gokalman.NewRK45) to propagate a state and its STM.
+// The state is [R; V] in an inertial frame, optionally followed by constant parameters (e.g. biases),
+// whose derivatives are zero.
+type Gravity struct {
+	μ, radius, J2 float64
+}
+
+// NewTwoBody returns the two-body dynamics around the Earth.
+func NewTwoBody() Gravity {
+	return Gravity{EarthMu, EarthRadius, 0}
+}
+
+// NewTwoBodyJ2 returns the two-body and J2 dynamics around the Earth.
+func NewTwoBodyJ2() Gravity {
+	return Gravity{EarthMu, EarthRadius, EarthJ2}
+}
+
+// NewGravity returns the gravity of a central body of gravitational parameter μ, equatorial radius and J2.
+func NewGravity(μ, radius, J2 float64) Gravity {
+	return Gravity{μ, radius, J2}
+}
+
+// Acceleration returns the inertial acceleration at the position R.
+func (g Gravity) Acceleration(R []float64) []float64 {
+	x, y, z := R[0], R[1], R[2]
+	r := norm(R)
+	r3 := math.Pow(r, 3)
+	acc := []float64{-g.μ * x / r3, -g.μ * y / r3, -g.μ * z / r3}
+	if g.J2 != 0 {
+		// a_J2 = k*[x*f; y*f; z*(f - 2/r^5)] with f = 5z²/r⁷ - 1/r⁵.
+		k := 1.5 * g.J2 * g.μ * g.radius * g.radius
+		r5 := math.Pow(r, 5)
+		f := 5*z*z/math.Pow(r, 7) - 1/r5
+		acc[0] += k * x * f
+		acc[1] += k * y * f
+		acc[2] += k * z * (f - 2/r5)
+	}
+	return acc
+}
+
+// Derivatives returns the time derivative of the state X (of size 6 or more), i.e. [V; a(R); 0].
+func (g Gravity) Derivatives(t float64, X *mat64.Vector) *mat64.Vector {
+	n := X.Len()
+	R := []float64{X.At(0, 0), X.At(1, 0), X.At(2, 0)}
+	acc := g.Acceleration(R)
+	XDot := mat64.NewVector(n, nil)
+	for i := 0; i < 3; i++ {
+		XDot.SetVec(i, X.At(i+3, 0))
+		XDot.SetVec(i+3, acc[i])
+	}
+	return XDot
+}
+
+// Jacobian returns the analytic Jacobian A = ∂Ẋ/∂X of the state X (of size 6 or more).
+func (g Gravity) Jacobian(t float64, X *mat64.Vector) *mat64.Dense {
+	n := X.Len()
+	R := []float64{X.At(0, 0), X.At(1, 0), X.At(2, 0)}
+	r := norm(R)
+	r3 := math.Pow(r, 3)
+	r5 := math.Pow(r, 5)
+	A := mat64.NewDense(n, n, nil)
+	for i := 0; i < 3; i++ {
+		A.Set(i, i+3, 1)
+		// Point mass: ∂(-μ R_i/r³)/∂R_j = -μ*(δ_ij/r³ - 3 R_i R_j/r⁵)
+		for j := 0; j < 3; j++ {
+			δ := 0.0
+			if i == j {
+				δ = 1
+			}
+			A.Set(i+3, j, -g.μ*(δ/r3-3*R[i]*R[j]/r5))
+		}
+	}
+	if g.J2 == 0 {
+		return A
+	}
+	k := 1.5 * g.J2 * g.μ * g.radius * g.radius
+	z := R[2]
+	r7 := math.Pow(r, 7)
+	r9 := math.Pow(r, 9)
+	// a_J2,i = k*R_i*f_i with f_x = f_y = 5z²/r⁷ - 1/r⁵ and f_z = 5z²/r⁷ - 3/r⁵.
+	f := []float64{5*z*z/r7 - 1/r5, 5*z*z/r7 - 1/r5, 5*z*z/r7 - 3/r5}
+	for i := 0; i < 3; i++ {
+		c := 5.0 // Coefficient of R_j/r⁷ in ∂f_i/∂R_j.
+		if i == 2 {
+			c = 15
+		}
+		for j := 0; j < 3; j++ {
+			dfdRj := -35*z*z*R[j]/r9 + c*R[j]/r7
+			if j == 2 {
+				dfdRj += 10 * z / r7
+			}
+			partial := R[i] * dfdRj
+			if i == j {
+				partial += f[i]
+			}
+			A.Set(i+3, j, A.At(i+3, j)+k*partial)
+		}
+	}
+	return A
+}
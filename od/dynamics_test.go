@@ -0,0 +1,75 @@
+package od
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// numericalJacobian returns the Jacobian of f at X by central finite differences.
+func numericalJacobian(f func(*mat64.Vector) *mat64.Vector, X *mat64.Vector, rows int) *mat64.Dense {
+	n := X.Len()
+	J := mat64.NewDense(rows, n, nil)
+	for j := 0; j < n; j++ {
+		h := 1e-6 * math.Max(1, math.Abs(X.At(j, 0)))
+		Xp := mat64.NewVector(n, nil)
+		Xp.CloneVec(X)
+		Xp.SetVec(j, X.At(j, 0)+h)
+		Xm := mat64.NewVector(n, nil)
+		Xm.CloneVec(X)
+		Xm.SetVec(j, X.At(j, 0)-h)
+		fp, fm := f(Xp), f(Xm)
+		for i := 0; i < rows; i++ {
+			J.Set(i, j, (fp.At(i, 0)-fm.At(i, 0))/(2*h))
+		}
+	}
+	return J
+}
+
+func TestGravityJacobian(t *testing.T) {
+	state := StateFromOE(7000, 0.001, 30, 80, 40, 10, EarthMu)
+	// Augment the state with a constant parameter.
+	X := mat64.NewVector(7, nil)
+	for i := 0; i < 6; i++ {
+		X.SetVec(i, state.At(i, 0))
+	}
+	X.SetVec(6, 1)
+	for _, g := range []Gravity{NewTwoBody(), NewTwoBodyJ2()} {
+		A := g.Jacobian(0, X)
+		Anum := numericalJacobian(func(x *mat64.Vector) *mat64.Vector { return g.Derivatives(0, x) }, X, 7)
+		if !mat64.EqualApprox(A, Anum, 1e-10) {
+			t.Fatalf("J2=%f: invalid Jacobian\n%v\n!=\n%v", g.J2, mat64.Formatted(A), mat64.Formatted(Anum))
+		}
+		if r, c := A.Dims(); r != 7 || c != 7 {
+			t.Fatalf("invalid Jacobian dimensions %dx%d", r, c)
+		}
+	}
+}
+
+func TestGravityAcceleration(t *testing.T) {
+	R := []float64{7000, 0, 0}
+	acc := NewTwoBody().Acceleration(R)
+	if math.Abs(acc[0]+EarthMu/(7000*7000)) > 1e-12 || acc[1] != 0 || acc[2] != 0 {
+		t.Fatalf("invalid two body acceleration %v", acc)
+	}
+	// J2 increases the attraction in the equatorial plane.
+	accJ2 := NewTwoBodyJ2().Acceleration(R)
+	if accJ2[0] >= acc[0] {
+		t.Fatalf("J2 should increase the equatorial attraction: %v vs %v", accJ2, acc)
+	}
+	// And decreases it above the poles.
+	R = []float64{0, 0, 7000}
+	acc = NewTwoBody().Acceleration(R)
+	accJ2 = NewGravity(EarthMu, EarthRadius, EarthJ2).Acceleration(R)
+	if accJ2[2] <= acc[2] {
+		t.Fatalf("J2 should decrease the polar attraction: %v vs %v", accJ2, acc)
+	}
+	X := mat64.NewVector(6, []float64{7000, 0, 0, 1, 2, 3})
+	XDot := NewTwoBodyJ2().Derivatives(0, X)
+	for i := 0; i < 3; i++ {
+		if XDot.At(i, 0) != X.At(i+3, 0) {
+			t.Fatalf("derivative of position should be the velocity: %v", mat64.Formatted(XDot.T()))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package od provides the models needed for orbit determination around the Earth without any external ephemeris:
+// two-body and J2 dynamics with their analytic Jacobian, and a ground station range and range-rate measurement model.
+// All distances are in km, all times in seconds, and all angles in degrees unless noted otherwise.
+package od
+
+import (
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// Earth constants (EGM96 / WGS84).
+const (
+	EarthMu           = 398600.4415          // Gravitational parameter in km^3/s^2.
+	EarthRadius       = 6378.1363            // Equatorial radius in km.
+	EarthJ2           = 1.0826269e-3         // Second zonal harmonic.
+	EarthRotationRate = 7.292115146706979e-5 // Rotation rate in rad/s.
+)
+
+// deg2rad converts degrees to radians.
+func deg2rad(a float64) float64 {
+	return a * math.Pi / 180
+}
+
+// rad2deg converts radians to degrees.
+func rad2deg(a float64) float64 {
+	return a * 180 / math.Pi
+}
+
+// StateFromOE returns the inertial Cartesian state [R; V] of the orbit defined by its semi-major axis a (km),
+// eccentricity e, inclination i, right ascension of the ascending node Ω, argument of periapsis ω and true anomaly ν.
+func StateFromOE(a, e, i, Ω, ω, ν, μ float64) *mat64.Vector {
+	i, Ω, ω, ν = deg2rad(i), deg2rad(Ω), deg2rad(ω), deg2rad(ν)
+	p := a * (1 - e*e)
+	r := p / (1 + e*math.Cos(ν))
+	// Perifocal frame.
+	rPQW := []float64{r * math.Cos(ν), r * math.Sin(ν), 0}
+	vPQW := []float64{-math.Sqrt(μ/p) * math.Sin(ν), math.Sqrt(μ/p) * (e + math.Cos(ν)), 0}
+	// Rotation from the perifocal frame to the inertial frame, i.e. R3(-Ω)*R1(-i)*R3(-ω).
+	sΩ, cΩ := math.Sincos(Ω)
+	si, ci := math.Sincos(i)
+	sω, cω := math.Sincos(ω)
+	dcm := mat64.NewDense(3, 3, []float64{
+		cΩ*cω - sΩ*sω*ci, -cΩ*sω - sΩ*cω*ci, sΩ * si,
+		sΩ*cω + cΩ*sω*ci, -sΩ*sω + cΩ*cω*ci, -cΩ * si,
+		sω * si, cω * si, ci,
+	})
+	R := mat64.NewVector(3, nil)
+	R.MulVec(dcm, mat64.NewVector(3, rPQW))
+	V := mat64.NewVector(3, nil)
+	V.MulVec(dcm, mat64.NewVector(3, vPQW))
+	state := mat64.NewVector(6, nil)
+	for j := 0; j < 3; j++ {
+		state.SetVec(j, R.At(j, 0))
+		state.SetVec(j+3, V.At(j, 0))
+	}
+	return state
+}
+
+// RIC returns the rotation matrix from the radial, in-track and cross-track frame of the orbit of the provided
+// state [R; V; ...] to the inertial frame, i.e. its columns are the radial, in-track and cross-track unit vectors.
+func RIC(state *mat64.Vector) *mat64.Dense {
+	R := []float64{state.At(0, 0), state.At(1, 0), state.At(2, 0)}
+	V := []float64{state.At(3, 0), state.At(4, 0), state.At(5, 0)}
+	r := unit(R)
+	c := unit(cross(R, V))
+	i := cross(c, r)
+	dcm := mat64.NewDense(3, 3, nil)
+	for j := 0; j < 3; j++ {
+		dcm.Set(j, 0, r[j])
+		dcm.Set(j, 1, i[j])
+		dcm.Set(j, 2, c[j])
+	}
+	return dcm
+}
+
+// norm returns the norm of a.
+func norm(a []float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
+
+// dot returns the dot product of a and b.
+func dot(a, b []float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+// cross returns the cross product of a and b.
+func cross(a, b []float64) []float64 {
+	return []float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// unit returns the unit vector of a.
+func unit(a []float64) []float64 {
+	n := norm(a)
+	return []float64{a[0] / n, a[1] / n, a[2] / n}
+}
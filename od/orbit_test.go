@@ -0,0 +1,60 @@
+package od
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestStateFromOE(t *testing.T) {
+	// Circular equatorial orbit at periapsis.
+	state := StateFromOE(7000, 0, 0, 0, 0, 0, EarthMu)
+	vc := math.Sqrt(EarthMu / 7000)
+	exp := mat64.NewVector(6, []float64{7000, 0, 0, 0, vc, 0})
+	if !mat64.EqualApprox(state, exp, 1e-9) {
+		t.Fatalf("circular orbit:\n%v\n!=\n%v", mat64.Formatted(state.T()), mat64.Formatted(exp.T()))
+	}
+	// Conservation of the energy and the angular momentum.
+	a, e, i := 7000.0, 0.001, 30.0
+	for _, ν := range []float64{0, 45, 180, 270} {
+		state = StateFromOE(a, e, i, 80, 40, ν, EarthMu)
+		R := []float64{state.At(0, 0), state.At(1, 0), state.At(2, 0)}
+		V := []float64{state.At(3, 0), state.At(4, 0), state.At(5, 0)}
+		if ξ := dot(V, V)/2 - EarthMu/norm(R); math.Abs(ξ+EarthMu/(2*a)) > 1e-9 {
+			t.Fatalf("ν=%f: invalid energy %f", ν, ξ)
+		}
+		H := cross(R, V)
+		if h := norm(H); math.Abs(h-math.Sqrt(EarthMu*a*(1-e*e))) > 1e-6 {
+			t.Fatalf("ν=%f: invalid angular momentum %f", ν, h)
+		}
+		if inc := rad2deg(math.Acos(H[2] / norm(H))); math.Abs(inc-i) > 1e-9 {
+			t.Fatalf("ν=%f: invalid inclination %f", ν, inc)
+		}
+	}
+}
+
+func TestRIC(t *testing.T) {
+	state := StateFromOE(7000, 0.001, 30, 80, 40, 10, EarthMu)
+	dcm := RIC(state)
+	var I mat64.Dense
+	I.Mul(dcm.T(), dcm)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			exp := 0.0
+			if i == j {
+				exp = 1
+			}
+			if math.Abs(I.At(i, j)-exp) > 1e-12 {
+				t.Fatalf("RIC DCM is not orthonormal:\n%v", mat64.Formatted(dcm))
+			}
+		}
+	}
+	// The radial unit vector is along R.
+	r := norm([]float64{state.At(0, 0), state.At(1, 0), state.At(2, 0)})
+	for i := 0; i < 3; i++ {
+		if math.Abs(dcm.At(i, 0)-state.At(i, 0)/r) > 1e-12 {
+			t.Fatalf("invalid radial unit vector:\n%v", mat64.Formatted(dcm))
+		}
+	}
+}
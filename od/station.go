@@ -0,0 +1,98 @@
+package od
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// Station defines a ground station on a spherical Earth, which measures the range and range-rate of a spacecraft.
+// It implements the gokalman.MeasurementModel interface.
+type Station struct {
+	Name string
+	// Epoch is the epoch at which the Greenwich sidereal angle is zero, i.e. when the Earth fixed and inertial frames match.
+	Epoch                                time.Time
+	altitude, elevationMask, latΦ, longθ float64
+	σρ, σρDot                            float64 // Variances of the range and range-rate measurements.
+}
+
+// NewStation returns a new station at the provided altitude (km), latitude and longitude, which only sees
+// spacecraft above its elevation mask. σρ and σρDot are the variances of the range and range-rate measurements.
+func NewStation(name string, altitude, elevationMask, latΦ, longθ, σρ, σρDot float64) Station {
+	return Station{name, time.Time{}, altitude, elevationMask, latΦ, longθ, σρ, σρDot}
+}
+
+// Noise returns the measurement noise covariance of the station.
+func (s Station) Noise() *mat64.SymDense {
+	return mat64.NewSymDense(2, []float64{s.σρ, 0, 0, s.σρDot})
+}
+
+// Labels returns the labels of the measurements of the station.
+func (s Station) Labels() []string {
+	return []string{s.Name + "-range", s.Name + "-rangeRate"}
+}
+
+// RV returns the inertial position and velocity of the station at t.
+func (s Station) RV(t time.Time) (R, V []float64) {
+	θgst := EarthRotationRate * t.Sub(s.Epoch).Seconds()
+	r := EarthRadius + s.altitude
+	sΦ, cΦ := math.Sincos(deg2rad(s.latΦ))
+	sθ, cθ := math.Sincos(deg2rad(s.longθ) + θgst)
+	R = []float64{r * cΦ * cθ, r * cΦ * sθ, r * sΦ}
+	V = cross([]float64{0, 0, EarthRotationRate}, R)
+	return
+}
+
+// Elevation returns the elevation of the spacecraft at state X (of size 6 or more) above the station horizon at t.
+func (s Station) Elevation(X *mat64.Vector, t time.Time) float64 {
+	R, _ := s.RV(t)
+	ρ := s.rangeVector(X, R)
+	return rad2deg(math.Asin(dot(ρ, unit(R)) / norm(ρ)))
+}
+
+// Visible returns whether the spacecraft at state X is above the elevation mask of the station at t.
+func (s Station) Visible(X *mat64.Vector, t time.Time) bool {
+	return s.Elevation(X, t) >= s.elevationMask
+}
+
+// Observe returns the range and range-rate of the spacecraft at the state X, and the Jacobian H̃ of those with
+// respect to X. States longer than six (e.g. augmented with biases) are supported, and those components
+// are not observed by the station.
+func (s Station) Observe(X *mat64.Vector, t time.Time) (*mat64.Vector, *mat64.Dense, error) {
+	n := X.Len()
+	if n < 6 {
+		return nil, nil, errors.New("state must be at least of size 6")
+	}
+	R, V := s.RV(t)
+	ρ := s.rangeVector(X, R)
+	ρDotVec := []float64{X.At(3, 0) - V[0], X.At(4, 0) - V[1], X.At(5, 0) - V[2]}
+	rng := norm(ρ)
+	rngRate := dot(ρ, ρDotVec) / rng
+	H := mat64.NewDense(2, n, nil)
+	for i := 0; i < 3; i++ {
+		H.Set(0, i, ρ[i]/rng)
+		H.Set(1, i, ρDotVec[i]/rng-rngRate*ρ[i]/(rng*rng))
+		H.Set(1, i+3, ρ[i]/rng)
+	}
+	return mat64.NewVector(2, []float64{rng, rngRate}), H, nil
+}
+
+// Measure returns the range and range-rate of the spacecraft at the state X, with an additive white Gaussian noise
+// drawn from rng given the variances of the station.
+func (s Station) Measure(X *mat64.Vector, t time.Time, rng *rand.Rand) (*mat64.Vector, error) {
+	y, _, err := s.Observe(X, t)
+	if err != nil {
+		return nil, err
+	}
+	y.SetVec(0, y.At(0, 0)+rng.NormFloat64()*math.Sqrt(s.σρ))
+	y.SetVec(1, y.At(1, 0)+rng.NormFloat64()*math.Sqrt(s.σρDot))
+	return y, nil
+}
+
+// rangeVector returns the vector from the station at R to the spacecraft at state X.
+func (s Station) rangeVector(X *mat64.Vector, R []float64) []float64 {
+	return []float64{X.At(0, 0) - R[0], X.At(1, 0) - R[1], X.At(2, 0) - R[2]}
+}
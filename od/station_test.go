@@ -0,0 +1,106 @@
+package od
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestStationObserve(t *testing.T) {
+	epoch := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := NewStation("st1", 0, 10, -35.398333, 148.981944, 1e-6, 1e-6)
+	st.Epoch = epoch
+	dt := epoch.Add(time.Hour)
+	// A spacecraft right above the station is visible at 90 degrees.
+	R, V := st.RV(dt)
+	X := mat64.NewVector(7, nil)
+	for i := 0; i < 3; i++ {
+		X.SetVec(i, R[i]*(1+500/EarthRadius))
+		X.SetVec(i+3, V[i]*(1+500/EarthRadius))
+	}
+	if el := st.Elevation(X, dt); math.Abs(el-90) > 1e-6 || !st.Visible(X, dt) {
+		t.Fatalf("spacecraft should be visible at zenith, got %f", el)
+	}
+	y, H, err := st.Observe(X, dt)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if math.Abs(y.At(0, 0)-500) > 1e-9 || math.Abs(y.At(1, 0)) > 1e-12 {
+		t.Fatalf("invalid observation at zenith: %v", mat64.Formatted(y.T()))
+	}
+	if r, c := H.Dims(); r != 2 || c != 7 {
+		t.Fatalf("invalid H̃ dimensions %dx%d", r, c)
+	}
+	// Check H̃ with finite differences elsewhere.
+	X = StateFromOE(7000, 0.001, 30, 80, 40, 10, EarthMu)
+	_, H, _ = st.Observe(X, dt)
+	Hnum := numericalJacobian(func(x *mat64.Vector) *mat64.Vector {
+		y, _, _ := st.Observe(x, dt)
+		return y
+	}, X, 2)
+	if !mat64.EqualApprox(H, Hnum, 1e-8) {
+		t.Fatalf("invalid H̃\n%v\n!=\n%v", mat64.Formatted(H), mat64.Formatted(Hnum))
+	}
+	// The opposite side of the Earth is not visible.
+	X.ScaleVec(-1, X)
+	for i := 0; i < 3; i++ {
+		X.SetVec(i, -R[i]*(1+500/EarthRadius))
+	}
+	if st.Visible(X, dt) {
+		t.Fatal("spacecraft should not be visible through the Earth")
+	}
+	if _, _, err = st.Observe(mat64.NewVector(3, nil), dt); err == nil {
+		t.Fatal("observing a position only should fail")
+	}
+}
+
+func TestStationRotation(t *testing.T) {
+	st := NewStation("eq", 0, 10, 0, 0, 1e-6, 1e-6)
+	R0, _ := st.RV(st.Epoch)
+	if math.Abs(R0[0]-EarthRadius) > 1e-9 || math.Abs(R0[1]) > 1e-9 || math.Abs(R0[2]) > 1e-9 {
+		t.Fatalf("invalid position at epoch %v", R0)
+	}
+	// A quarter of a sidereal day later.
+	quarter := math.Pi / (2 * EarthRotationRate)
+	R, V := st.RV(st.Epoch.Add(time.Duration(quarter * float64(time.Second))))
+	if math.Abs(R[0]) > 1e-3 || math.Abs(R[1]-EarthRadius) > 1e-3 {
+		t.Fatalf("invalid position after a quarter of a sidereal day %v", R)
+	}
+	if math.Abs(norm(V)-EarthRadius*EarthRotationRate) > 1e-12 {
+		t.Fatalf("invalid velocity %v", V)
+	}
+}
+
+func TestStationMeasure(t *testing.T) {
+	st := NewStation("st1", 0, 10, 0, 0, 1e-2, 1e-4)
+	if !mat64.Equal(st.Noise(), mat64.NewSymDense(2, []float64{1e-2, 0, 0, 1e-4})) {
+		t.Fatalf("invalid noise %v", mat64.Formatted(st.Noise()))
+	}
+	if labels := st.Labels(); len(labels) != 2 || labels[0] != "st1-range" {
+		t.Fatalf("invalid labels %v", labels)
+	}
+	X := StateFromOE(7000, 0.001, 30, 80, 40, 10, EarthMu)
+	y, _, _ := st.Observe(X, st.Epoch)
+	rng := rand.New(rand.NewSource(42))
+	samples := 5000
+	var mean, variance [2]float64
+	for k := 0; k < samples; k++ {
+		yNoisy, err := st.Measure(X, st.Epoch, rng)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		for i := 0; i < 2; i++ {
+			δ := yNoisy.At(i, 0) - y.At(i, 0)
+			mean[i] += δ / float64(samples)
+			variance[i] += δ * δ / float64(samples)
+		}
+	}
+	for i, σ2 := range []float64{1e-2, 1e-4} {
+		if math.Abs(mean[i]) > 3*math.Sqrt(σ2/float64(samples)) || math.Abs(variance[i]-σ2)/σ2 > 0.1 {
+			t.Fatalf("invalid noise statistics of component %d: mean=%e variance=%e", i, mean[i], variance[i])
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+	"github.com/gonum/stat/distmv"
+)
+
+// LogLikelihood returns the natural logarithm of the likelihood p(y|x) of the real observation y given the computed
+// observation yHat = h(x) of a particle. Only the relative values matter, so constant terms may be dropped.
+type LogLikelihood func(y, yHat *mat64.Vector) float64
+
+// NewGaussianLogLikelihood returns the log-likelihood of an additive Gaussian measurement noise of covariance R.
+func NewGaussianLogLikelihood(R mat64.Symmetric) (LogLikelihood, error) {
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(R); !ok {
+		return nil, errors.New("R is not positive definite")
+	}
+	m := R.Symmetric()
+	return func(y, yHat *mat64.Vector) float64 {
+		if y.Len() != m || yHat.Len() != m {
+			return math.Inf(-1)
+		}
+		var innov, RInvInnov mat64.Vector
+		innov.SubVec(y, yHat)
+		if err := RInvInnov.SolveCholeskyVec(&chol, &innov); err != nil {
+			return math.Inf(-1)
+		}
+		return -0.5 * mat64.Dot(&innov, &RInvInnov)
+	}, nil
+}
+
+// Resampler defines a resampling strategy of the particles.
+type Resampler interface {
+	// Resample returns the indexes of the particles which are kept given their normalized weights.
+	// There are as many indexes as weights, and particles may be kept several times.
+	Resample(weights []float64, rng *rand.Rand) []int
+	String() string // Stringer interface implementation
+}
+
+// SystematicResampling draws a single uniform offset for all the particles.
+type SystematicResampling struct{}
+
+// Resample implements the Resampler interface.
+func (SystematicResampling) Resample(weights []float64, rng *rand.Rand) []int {
+	N := len(weights)
+	u := rng.Float64()
+	positions := make([]float64, N)
+	for i := range positions {
+		positions[i] = (float64(i) + u) / float64(N)
+	}
+	return resampleSorted(weights, positions)
+}
+
+func (SystematicResampling) String() string {
+	return "systematic"
+}
+
+// StratifiedResampling draws one uniform sample per stratum [i/N, (i+1)/N).
+type StratifiedResampling struct{}
+
+// Resample implements the Resampler interface.
+func (StratifiedResampling) Resample(weights []float64, rng *rand.Rand) []int {
+	N := len(weights)
+	positions := make([]float64, N)
+	for i := range positions {
+		positions[i] = (float64(i) + rng.Float64()) / float64(N)
+	}
+	return resampleSorted(weights, positions)
+}
+
+func (StratifiedResampling) String() string {
+	return "stratified"
+}
+
+// ResidualResampling keeps floor(N*w_i) copies of each particle, and draws the remaining ones from the
+// residual weights with a multinomial resampling.
+type ResidualResampling struct{}
+
+// Resample implements the Resampler interface.
+func (ResidualResampling) Resample(weights []float64, rng *rand.Rand) []int {
+	N := len(weights)
+	indexes := make([]int, 0, N)
+	residuals := make([]float64, N)
+	residualSum := 0.0
+	for i, w := range weights {
+		copies := int(math.Floor(float64(N) * w))
+		for j := 0; j < copies; j++ {
+			indexes = append(indexes, i)
+		}
+		residuals[i] = float64(N)*w - float64(copies)
+		residualSum += residuals[i]
+	}
+	remaining := N - len(indexes)
+	if remaining == 0 {
+		return indexes
+	}
+	positions := make([]float64, remaining)
+	for i := range positions {
+		positions[i] = rng.Float64()
+	}
+	sort.Float64s(positions)
+	for i := range residuals {
+		residuals[i] /= residualSum
+	}
+	return append(indexes, resampleSorted(residuals, positions)...)
+}
+
+func (ResidualResampling) String() string {
+	return "residual"
+}
+
+// resampleSorted returns the index of the particle of each position in the cumulative distribution of the weights.
+// The positions must be sorted in increasing order.
+func resampleSorted(weights, positions []float64) []int {
+	indexes := make([]int, len(positions))
+	cumulative := weights[0]
+	j := 0
+	for i, u := range positions {
+		for u >= cumulative && j < len(weights)-1 {
+			j++
+			cumulative += weights[j]
+		}
+		indexes[i] = j
+	}
+	return indexes
+}
+
+// NewGaussianParticles returns size particles drawn from N(x0, P0).
+func NewGaussianParticles(x0 *mat64.Vector, P0 mat64.Symmetric, size int, rng *rand.Rand) ([]*mat64.Vector, error) {
+	if err := checkMatDims(x0, P0, "x0", "Covar0", rows2cols); err != nil {
+		return nil, err
+	}
+	dist, ok := distmv.NewNormal(mat64.Col(nil, 0, x0), P0, rng)
+	if !ok {
+		return nil, errors.New("initial covariance is not positive definite")
+	}
+	particles := make([]*mat64.Vector, size)
+	for i := range particles {
+		particles[i] = mat64.NewVector(x0.Len(), dist.Rand(nil))
+	}
+	return particles, nil
+}
+
+// NewParticleFilter returns a new bootstrap Particle Filter (or Sequential Importance Resampling filter), whose
+// particles are propagated with the process noise and weighted by the likelihood of each measurement.
+// The initial particles may be drawn from any distribution (e.g. a multimodal one), and all have the same weight.
+// Parameters:
+// - particles: initial particles (cf. NewGaussianParticles)
+// - f: non-linear propagation function
+// - h: non-linear measurement function
+// - likelihood: log-likelihood of a measurement (Gaussian with the R of the noise if nil)
+// - noise: Noise (Q must be of the size of the state unless PreparePNT is called)
+// - resampler: resampling strategy
+// - rng: source of the noise and resampling samples (seeded with the time if nil)
+// The particles are resampled when the effective sample size is below half of the number of particles,
+// cf. SetResamplingThreshold.
+func NewParticleFilter(particles []*mat64.Vector, f NLPropagation, h NLMeasurement, likelihood LogLikelihood, noise Noise, resampler Resampler, rng *rand.Rand) (*ParticleFilter, *ParticleEstimate, error) {
+	if len(particles) < 2 {
+		return nil, nil, errors.New("the particle filter requires at least two particles")
+	}
+	if f == nil || h == nil {
+		return nil, nil, errors.New("both the propagation and the measurement functions must be provided")
+	}
+	if resampler == nil {
+		return nil, nil, errors.New("a resampler must be provided")
+	}
+	n := particles[0].Len()
+	for i, particle := range particles {
+		if particle.Len() != n {
+			return nil, nil, fmt.Errorf("particle #%d is of size %d instead of %d", i, particle.Len(), n)
+		}
+	}
+	if likelihood == nil {
+		var err error
+		if likelihood, err = NewGaussianLogLikelihood(noise.MeasurementMatrix()); err != nil {
+			return nil, nil, err
+		}
+	}
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	N := len(particles)
+	weights := uniformWeights(N, N)
+	measSize, _ := noise.MeasurementMatrix().Dims()
+	x, P := particleStatistics(particles, weights)
+	est0 := &ParticleEstimate{x, mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), P, P, particles, weights, float64(N), false, time.Time{}}
+	return &ParticleFilter{f, h, likelihood, nil, noise, resampler, rng, 0.5, est0, false, 0, timeTagger{}}, est0, nil
+}
+
+// ParticleFilter defines a bootstrap Particle Filter. Use NewParticleFilter to initialize.
+// The process noise is assumed additive.
+type ParticleFilter struct {
+	F          NLPropagation // Non-linear propagation function
+	H          NLMeasurement // Non-linear measurement function
+	likelihood LogLikelihood
+	Γ          *mat64.Dense
+	Noise      Noise
+	resampler  Resampler
+	rng        *rand.Rand
+	threshold  float64 // Ratio of the number of particles below which the effective sample size triggers a resampling.
+	prevEst    *ParticleEstimate
+	sncEnabled bool // Stores whether we should enable or disable the state noise compensation.
+	step       int
+	timeTagger
+}
+
+func (kf *ParticleFilter) String() string {
+	return fmt.Sprintf("PF [k=%d] %s (N=%d, ESS threshold=%g)\n%s", kf.step, kf.resampler, len(kf.prevEst.particles), kf.threshold, kf.Noise)
+}
+
+// SetNoise updates the Noise.
+func (kf *ParticleFilter) SetNoise(n Noise) {
+	kf.Noise = n
+}
+
+// GetNoise returns the Noise.
+func (kf *ParticleFilter) GetNoise() Noise {
+	return kf.Noise
+}
+
+// SetResamplingThreshold sets the ratio of the number of particles below which the effective sample size triggers a
+// resampling after a measurement update. A ratio of 1 resamples after every measurement.
+func (kf *ParticleFilter) SetResamplingThreshold(ratio float64) error {
+	if ratio <= 0 || ratio > 1 {
+		return errors.New("resampling threshold must be in (0, 1]")
+	}
+	kf.threshold = ratio
+	return nil
+}
+
+// PreparePNT prepares the process noise transition matrix and enabled the SNC
+// for the next update. If not called, the process noise samples are added directly to the particles.
+func (kf *ParticleFilter) PreparePNT(Γ *mat64.Dense) {
+	kf.Γ = Γ
+	kf.sncEnabled = true
+}
+
+// Predict computes only the time update (or prediction).
+func (kf *ParticleFilter) Predict() (est Estimate, err error) {
+	return kf.fullUpdate(true, nil)
+}
+
+// Update computes a full time and measurement update.
+func (kf *ParticleFilter) Update(realObservation *mat64.Vector) (est Estimate, err error) {
+	return kf.fullUpdate(false, realObservation)
+}
+
+// fullUpdate performs all the steps of an update and allows to stop right after the pure prediction (or time update) step.
+func (kf *ParticleFilter) fullUpdate(purePrediction bool, realObservation *mat64.Vector) (est Estimate, err error) {
+	R := kf.Noise.MeasurementMatrix()
+	if !purePrediction {
+		if err = checkMatDims(realObservation, R, "real observation", "R", rows2rows); err != nil {
+			return nil, err
+		}
+	}
+	n := kf.prevEst.state.Len()
+	N := len(kf.prevEst.particles)
+
+	// Time update: propagate each particle and add its process noise.
+	particles := make([]*mat64.Vector, N)
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		for i, particle := range kf.prevEst.particles {
+			particles[i] = mat64.NewVector(n, nil)
+			particles[i].CloneVec(particle)
+		}
+	} else if particles, err = propagateSamples(kf.prevEst.particles, kf.F, kf.Noise.ProcessMatrix(), kf.Γ, kf.sncEnabled, kf.rng); err != nil {
+		return nil, err
+	}
+	kf.sncEnabled = false
+	weights := make([]float64, N)
+	copy(weights, kf.prevEst.weights)
+	xBar, PBar := particleStatistics(particles, weights)
+
+	if purePrediction {
+		measSize, _ := R.Dims()
+		est = &ParticleEstimate{xBar, mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), PBar, PBar, particles, weights, effectiveSampleSize(weights), false, kf.advance()}
+		kf.prevEst = est.(*ParticleEstimate)
+		kf.step++
+		return
+	}
+
+	// Measurement update: weight each particle by its likelihood, in log space to avoid underflows.
+	Y := make([]*mat64.Vector, N)
+	logW := make([]float64, N)
+	maxLogW := math.Inf(-1)
+	for i, particle := range particles {
+		Y[i] = kf.H(particle)
+		if Y[i].Len() != realObservation.Len() {
+			return nil, fmt.Errorf("measurement function returned an observation of size %d instead of %d", Y[i].Len(), realObservation.Len())
+		}
+		logW[i] = math.Log(weights[i]) + kf.likelihood(realObservation, Y[i])
+		maxLogW = math.Max(maxLogW, logW[i])
+	}
+	if math.IsInf(maxLogW, -1) || math.IsNaN(maxLogW) {
+		return nil, fmt.Errorf("all particles have a zero likelihood at k=%d", kf.step)
+	}
+	yHat := weightedMean(Y, weights) // Predicted measurement, with the prior weights.
+	sum := 0.0
+	for i := range weights {
+		weights[i] = math.Exp(logW[i] - maxLogW)
+		sum += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	var innov mat64.Vector
+	innov.SubVec(realObservation, yHat)
+	xHat, P := particleStatistics(particles, weights)
+	ess := effectiveSampleSize(weights)
+	resampled := false
+	if ess < kf.threshold*float64(N) {
+		resampled = true
+		resampledParticles := make([]*mat64.Vector, N)
+		for i, j := range kf.resampler.Resample(weights, kf.rng) {
+			resampledParticles[i] = mat64.NewVector(n, nil)
+			resampledParticles[i].CloneVec(particles[j])
+		}
+		particles = resampledParticles
+		weights = uniformWeights(N, N)
+	}
+	est = &ParticleEstimate{xHat, yHat, &innov, P, PBar, particles, weights, ess, resampled, kf.advance()}
+	kf.prevEst = est.(*ParticleEstimate)
+	kf.step++
+	return
+}
+
+// particleStatistics returns the weighted mean and covariance of the particles, whose weights are normalized.
+func particleStatistics(particles []*mat64.Vector, weights []float64) (*mat64.Vector, *mat64.SymDense) {
+	mean := weightedMean(particles, weights)
+	return mean, symmetrize(weightedCovariance(particles, mean, particles, mean, weights))
+}
+
+// effectiveSampleSize returns 1/Σw² of the normalized weights.
+func effectiveSampleSize(weights []float64) float64 {
+	sum := 0.0
+	for _, w := range weights {
+		sum += w * w
+	}
+	return 1 / sum
+}
+
+// ParticleEstimate is the output of each update state of the ParticleFilter.
+// It implements the Estimate interface and reports the weighted mean and covariance of the particles.
+type ParticleEstimate struct {
+	state, meas, innov *mat64.Vector
+	covar, predCovar   mat64.Symmetric
+	particles          []*mat64.Vector
+	weights            []float64
+	ess                float64
+	resampled          bool
+	epoch              time.Time
+}
+
+// IsWithinNσ returns whether the estimation is within the 2σ bounds.
+func (e ParticleEstimate) IsWithinNσ(N float64) bool {
+	for i := 0; i < e.state.Len(); i++ {
+		nσ := N * math.Sqrt(e.covar.At(i, i))
+		if e.state.At(i, 0) > nσ || e.state.At(i, 0) < -nσ {
+			return false
+		}
+	}
+	return true
+}
+
+// IsWithin2σ returns whether the estimation is within the 2σ bounds.
+func (e ParticleEstimate) IsWithin2σ() bool {
+	return e.IsWithinNσ(2)
+}
+
+// State implements the Estimate interface.
+func (e ParticleEstimate) State() *mat64.Vector {
+	return e.state
+}
+
+// Measurement implements the Estimate interface.
+func (e ParticleEstimate) Measurement() *mat64.Vector {
+	return e.meas
+}
+
+// Innovation implements the Estimate interface.
+func (e ParticleEstimate) Innovation() *mat64.Vector {
+	return e.innov
+}
+
+// Covariance implements the Estimate interface.
+func (e ParticleEstimate) Covariance() mat64.Symmetric {
+	return e.covar
+}
+
+// PredCovariance implements the Estimate interface.
+func (e ParticleEstimate) PredCovariance() mat64.Symmetric {
+	return e.predCovar
+}
+
+// Rejected implements the Estimate interface: the particle filter never rejects a measurement.
+func (e ParticleEstimate) Rejected() bool {
+	return false
+}
+
+// Epoch implements the Estimate interface.
+func (e ParticleEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
+// Particles returns the particles of this estimate, after the resampling if any.
+func (e ParticleEstimate) Particles() []*mat64.Vector {
+	return e.particles
+}
+
+// Weights returns the normalized weights of the particles.
+func (e ParticleEstimate) Weights() []float64 {
+	return e.weights
+}
+
+// EffectiveSampleSize returns the effective sample size of the particles prior to any resampling.
+func (e ParticleEstimate) EffectiveSampleSize() float64 {
+	return e.ess
+}
+
+// Resampled returns whether the particles were resampled.
+func (e ParticleEstimate) Resampled() bool {
+	return e.resampled
+}
+
+func (e ParticleEstimate) String() string {
+	state := mat64.Formatted(e.State(), mat64.Prefix("  "))
+	meas := mat64.Formatted(e.Measurement(), mat64.Prefix("  "))
+	covar := mat64.Formatted(e.Covariance(), mat64.Prefix("  "))
+	innov := mat64.Formatted(e.Innovation(), mat64.Prefix("  "))
+	predp := mat64.Formatted(e.PredCovariance(), mat64.Prefix("   "))
+	return fmt.Sprintf("{\ns=%v\ny=%v\nP=%v\nP-=%v\ni=%v\nESS=%.1f\n}", state, meas, covar, predp, innov, e.ess)
+}
@@ -0,0 +1,258 @@
+package gokalman
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestResamplers(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	N := 1000
+	weights := make([]float64, N)
+	sum := 0.0
+	for i := range weights {
+		if i%10 != 0 {
+			weights[i] = rng.Float64()
+		}
+		sum += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= sum
+	}
+	for _, resampler := range []Resampler{SystematicResampling{}, StratifiedResampling{}, ResidualResampling{}} {
+		indexes := resampler.Resample(weights, rng)
+		if len(indexes) != N {
+			t.Fatalf("%s: %d indexes instead of %d", resampler, len(indexes), N)
+		}
+		counts := make([]int, N)
+		for _, j := range indexes {
+			counts[j]++
+		}
+		for i, c := range counts {
+			expected := float64(N) * weights[i]
+			if weights[i] == 0 && c != 0 {
+				t.Fatalf("%s: particle #%d of zero weight was kept", resampler, i)
+			}
+			switch resampler.(type) {
+			case SystematicResampling:
+				// Systematic resampling keeps either floor(N*w) or ceil(N*w) copies.
+				if float64(c) < math.Floor(expected) || float64(c) > math.Ceil(expected) {
+					t.Fatalf("%s: particle #%d has %d copies instead of %f", resampler, i, c, expected)
+				}
+			case ResidualResampling:
+				if float64(c) < math.Floor(expected) {
+					t.Fatalf("%s: particle #%d has %d copies instead of at least %f", resampler, i, c, math.Floor(expected))
+				}
+			default:
+				if math.Abs(float64(c)-expected) > 2 {
+					t.Fatalf("%s: particle #%d has %d copies instead of about %f", resampler, i, c, expected)
+				}
+			}
+		}
+		// A single particle with all the weight is the only one kept.
+		for _, j := range resampler.Resample([]float64{0, 0, 1, 0}, rng) {
+			if j != 2 {
+				t.Fatalf("%s: kept particle %d of zero weight", resampler, j)
+			}
+		}
+	}
+}
+
+func TestParticleFilterLinear(t *testing.T) {
+	// With linear dynamics and measurements, a large number of particles must approximate the vanilla KF.
+	F, H, f, h := linearRobot()
+	_, G, _ := Robot1DMatrices()
+	Q := mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3})
+	R := mat64.NewSymDense(1, []float64{0.1})
+	noise := NewNoiseless(Q, R)
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 1)
+	for _, resampler := range []Resampler{SystematicResampling{}, StratifiedResampling{}, ResidualResampling{}} {
+		rng := rand.New(rand.NewSource(2))
+		vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+		if err != nil {
+			t.Fatal(err)
+		}
+		particles, err := NewGaussianParticles(x0, P0, 5000, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pf, _, err := NewParticleFilter(particles, f, h, nil, noise, resampler, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resamplings := 0
+		for k := 0; k < 50; k++ {
+			y := mat64.NewVector(1, []float64{0.035*float64(k) + 0.1*math.Sin(float64(k))})
+			vEst, err := vanilla.Update(y, mat64.NewVector(1, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			est, err := pf.Update(y)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pEst := est.(*ParticleEstimate)
+			if pEst.Resampled() {
+				resamplings++
+				if pEst.EffectiveSampleSize() >= 2500 {
+					t.Fatalf("%s k=%d: resampled with an ESS of %f", resampler, k, pEst.EffectiveSampleSize())
+				}
+			}
+			for i := 0; i < 2; i++ {
+				σ := math.Sqrt(vEst.Covariance().At(i, i))
+				if math.Abs(vEst.State().At(i, 0)-pEst.State().At(i, 0)) > 0.2*σ {
+					t.Fatalf("%s k=%d: state #%d differs\n%v\n%v", resampler, k, i, mat64.Formatted(vEst.State()), mat64.Formatted(pEst.State()))
+				}
+				if ratio := pEst.Covariance().At(i, i) / vEst.Covariance().At(i, i); ratio < 0.75 || ratio > 1.25 {
+					t.Fatalf("%s k=%d: variance #%d differs by a ratio of %f", resampler, k, i, ratio)
+				}
+			}
+		}
+		if resamplings == 0 {
+			t.Fatalf("%s: particles were never resampled", resampler)
+		}
+	}
+}
+
+func TestParticleFilterBimodal(t *testing.T) {
+	// The robot is either around -2 and moving right, or around +2 and moving left. Its position is only observed
+	// through its square, so both modes remain, which a Gaussian filter cannot represent.
+	_, _, f, _ := linearRobot()
+	h := func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(1, []float64{x.At(0, 0) * x.At(0, 0)})
+	}
+	rng := rand.New(rand.NewSource(3))
+	particles := make([]*mat64.Vector, 2000)
+	for i := range particles {
+		mode := 1.0
+		if i%2 == 0 {
+			mode = -1
+		}
+		particles[i] = mat64.NewVector(2, []float64{2*mode + 0.1*rng.NormFloat64(), -mode + 0.05*rng.NormFloat64()})
+	}
+	noise := NewNoiseless(mat64.NewSymDense(2, []float64{1e-5, 0, 0, 1e-5}), mat64.NewSymDense(1, []float64{0.01}))
+	pf, _, err := NewParticleFilter(particles, f, h, nil, noise, SystematicResampling{}, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truth := mat64.NewVector(2, []float64{-2, 1})
+	var est Estimate
+	for k := 0; k < 10; k++ {
+		truth = f(truth)
+		if est, err = pf.Update(h(truth)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if math.Abs(est.State().At(0, 0)) > 0.5 {
+		t.Fatalf("the mean of both modes should be near the origin: %f", est.State().At(0, 0))
+	}
+	pEst := est.(*ParticleEstimate)
+	mirrored := 0.0
+	for i, particle := range pEst.Particles() {
+		x := particle.At(0, 0)
+		if math.Abs(math.Abs(x)-math.Abs(truth.At(0, 0))) > 0.2 {
+			t.Fatalf("particle #%d is in neither mode: %f", i, x)
+		}
+		if x > 0 {
+			mirrored += pEst.Weights()[i]
+		}
+	}
+	if mirrored < 0.3 || mirrored > 0.7 {
+		t.Fatalf("the filter did not keep both modes: %f of the weight is in the mirrored mode", mirrored)
+	}
+}
+
+func TestParticleFilterPredict(t *testing.T) {
+	_, _, f, h := linearRobot()
+	noise := NewNoiseless(mat64.NewSymDense(2, nil), mat64.NewSymDense(1, []float64{0.1}))
+	rng := rand.New(rand.NewSource(4))
+	particles, err := NewGaussianParticles(mat64.NewVector(2, []float64{0, 1}), ScaledIdentity(2, 1), 100, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The log-likelihood only needs to be defined up to a constant.
+	likelihood := func(y, yHat *mat64.Vector) float64 {
+		return -math.Abs(y.At(0, 0) - yHat.At(0, 0))
+	}
+	pf, est0, err := NewParticleFilter(particles, f, h, likelihood, noise, StratifiedResampling{}, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pf.SetResamplingThreshold(1); err != nil {
+		t.Fatal(err)
+	}
+	pred, err := pf.Predict()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without process noise, the prediction is exactly the propagation of each particle.
+	for i, particle := range pred.(*ParticleEstimate).Particles() {
+		if !mat64.EqualApprox(particle, f(est0.Particles()[i]), 1e-12) {
+			t.Fatalf("particle #%d was not propagated", i)
+		}
+	}
+	est, err := pf.Update(mat64.NewVector(1, []float64{0.2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !est.(*ParticleEstimate).Resampled() {
+		t.Fatal("a threshold of one must resample after every measurement")
+	}
+	for _, w := range est.(*ParticleEstimate).Weights() {
+		if w != 0.01 {
+			t.Fatalf("resampled particles must have the same weight: %f", w)
+		}
+	}
+}
+
+func TestParticleFilterErrors(t *testing.T) {
+	_, _, f, h := linearRobot()
+	noise := NewNoiseless(ScaledIdentity(2, 1e-4), mat64.NewSymDense(1, []float64{0.1}))
+	rng := rand.New(rand.NewSource(5))
+	particles, err := NewGaussianParticles(mat64.NewVector(2, nil), ScaledIdentity(2, 1), 10, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewGaussianParticles(mat64.NewVector(2, nil), ScaledIdentity(3, 1), 10, rng); err == nil {
+		t.Fatal("invalid covariance size did not fail")
+	}
+	if _, _, err = NewParticleFilter(particles[:1], f, h, nil, noise, SystematicResampling{}, rng); err == nil {
+		t.Fatal("single particle did not fail")
+	}
+	if _, _, err = NewParticleFilter(particles, f, nil, nil, noise, SystematicResampling{}, rng); err == nil {
+		t.Fatal("missing measurement function did not fail")
+	}
+	if _, _, err = NewParticleFilter(particles, f, h, nil, noise, nil, rng); err == nil {
+		t.Fatal("missing resampler did not fail")
+	}
+	if _, _, err = NewParticleFilter(append(particles, mat64.NewVector(3, nil)), f, h, nil, noise, SystematicResampling{}, rng); err == nil {
+		t.Fatal("particles of different sizes did not fail")
+	}
+	if _, _, err = NewParticleFilter(particles, f, h, nil, NewNoiseless(ScaledIdentity(2, 1e-4), mat64.NewSymDense(1, nil)), SystematicResampling{}, rng); err == nil {
+		t.Fatal("singular R did not fail")
+	}
+	pf, _, err := NewParticleFilter(particles, f, h, nil, noise, SystematicResampling{}, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pf.SetResamplingThreshold(0); err == nil {
+		t.Fatal("zero threshold did not fail")
+	}
+	if _, err = pf.Update(mat64.NewVector(2, nil)); err == nil {
+		t.Fatal("invalid observation size did not fail")
+	}
+	// A likelihood of zero for all particles cannot be normalized.
+	impossible := func(y, yHat *mat64.Vector) float64 {
+		return math.Inf(-1)
+	}
+	if pf, _, err = NewParticleFilter(particles, f, h, impossible, noise, SystematicResampling{}, rng); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pf.Update(mat64.NewVector(1, nil)); err == nil {
+		t.Fatal("zero likelihood did not fail")
+	}
+}
@@ -0,0 +1,166 @@
+package gokalman
+
+import (
+	"errors"
+	"math"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// whitenMeasurement returns the measurement sensitivity matrix and the innovation such that their measurement noise is diagonal,
+// and the variance of each scalar measurement. If R is not diagonal, they are whitened with the inverse of the lower Cholesky
+// factor L of R, which is also returned (nil otherwise).
+func whitenMeasurement(H mat64.Matrix, innov *mat64.Vector, R mat64.Symmetric) (Hw *mat64.Dense, innovW *mat64.Vector, r []float64, Linv *mat64.Dense, err error) {
+	if err = checkMatDims(H, innov, "H", "innovation", rows2rows); err != nil {
+		return
+	}
+	if err = checkMatDims(R, innov, "R", "innovation", rows2rows); err != nil {
+		return
+	}
+	p := innov.Len()
+	r = make([]float64, p)
+	if isDiagonal(R) {
+		for i := 0; i < p; i++ {
+			r[i] = R.At(i, i)
+		}
+		return mat64.DenseCopyOf(H), innov, r, nil, nil
+	}
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(R); !ok {
+		return nil, nil, nil, nil, errors.New("measurement noise is not positive definite")
+	}
+	var L mat64.TriDense
+	L.LFromCholesky(&chol)
+	Linv = mat64.NewDense(p, p, nil)
+	if err = Linv.Inverse(&L); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	Hw = &mat64.Dense{}
+	Hw.Mul(Linv, H)
+	innovW = mat64.NewVector(p, nil)
+	innovW.MulVec(Linv, innov)
+	for i := 0; i < p; i++ {
+		r[i] = 1
+	}
+	return Hw, innovW, r, Linv, nil
+}
+
+// isDiagonal returns whether the provided symmetric matrix is diagonal.
+func isDiagonal(m mat64.Symmetric) bool {
+	n := m.Symmetric()
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if m.At(i, j) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sequentialUpdate performs the measurement update by processing each scalar measurement one at a time, which avoids
+// the inversion of H*\bar{P}*H' + R. The innovation is y - H*\bar{x}. Each scalar measurement is edited on its own with
+// the provided editor (if any), and rejected is true if any scalar measurement was rejected.
+// Returns the updated state, covariance (Joseph form) and the equivalent Kalman gain.
+func sequentialUpdate(xBar *mat64.Vector, PBar mat64.Matrix, H mat64.Matrix, innov *mat64.Vector, R mat64.Symmetric, editor MeasurementEditor) (x *mat64.Vector, P *mat64.SymDense, K *mat64.Dense, rejected bool, err error) {
+	Hw, innovW, r, Linv, err := whitenMeasurement(H, innov, R)
+	if err != nil {
+		return
+	}
+	n := xBar.Len()
+	δx := mat64.NewVector(n, nil)
+	Pk := mat64.DenseCopyOf(PBar)
+	for i := range r {
+		h := Hw.RowView(i)
+		// Residual of this scalar measurement given the previous scalar updates.
+		ν := innovW.At(i, 0) - mat64.Dot(h, δx)
+		var Ph mat64.Vector
+		Ph.MulVec(Pk, h)
+		s := mat64.Dot(h, &Ph) + r[i]
+		if editor != nil && editor.Reject(mat64.NewVector(1, []float64{ν}), mat64.NewSymDense(1, []float64{s})) {
+			rejected = true
+			// Do not account for this measurement in the gain either.
+			for j := 0; j < n; j++ {
+				Hw.Set(i, j, 0)
+			}
+			continue
+		}
+		var k mat64.Vector
+		k.ScaleVec(1/s, &Ph)
+		δx.AddScaledVec(δx, ν, &k)
+		// Joseph form: P = (I - k*h')*P*(I - k*h')' + k*r*k'
+		IKH := DenseIdentity(n)
+		IKH.RankOne(IKH, -1, &k, h)
+		var IKHP mat64.Dense
+		IKHP.Mul(IKH, Pk)
+		Pk.Mul(&IKHP, IKH.T())
+		Pk.RankOne(Pk, r[i], &k, &k)
+	}
+	x = mat64.NewVector(n, nil)
+	x.AddVec(xBar, δx)
+	if P, err = AsSymDense(Pk); err != nil {
+		return
+	}
+	// Equivalent gain: K = P*H'*R^-1 (with the whitened H and R).
+	p := len(r)
+	var PHt mat64.Dense
+	PHt.Mul(Pk, Hw.T())
+	K = mat64.NewDense(n, p, nil)
+	for j := 0; j < p; j++ {
+		for i := 0; i < n; i++ {
+			K.Set(i, j, PHt.At(i, j)/r[j])
+		}
+	}
+	if Linv != nil {
+		K.Mul(mat64.DenseCopyOf(K), Linv)
+	}
+	return
+}
+
+// sequentialSqrtUpdate is the square root equivalent of sequentialUpdate using Potter's algorithm, where \bar{P} = S*S'.
+// Returns the updated state, square root of the covariance (which is not triangular) and the equivalent Kalman gain.
+func sequentialSqrtUpdate(xBar *mat64.Vector, S mat64.Matrix, H mat64.Matrix, innov *mat64.Vector, R mat64.Symmetric, editor MeasurementEditor) (x *mat64.Vector, Sk *mat64.Dense, K *mat64.Dense, rejected bool, err error) {
+	Hw, innovW, r, Linv, err := whitenMeasurement(H, innov, R)
+	if err != nil {
+		return
+	}
+	n := xBar.Len()
+	δx := mat64.NewVector(n, nil)
+	Sk = mat64.DenseCopyOf(S)
+	for i := range r {
+		h := Hw.RowView(i)
+		ν := innovW.At(i, 0) - mat64.Dot(h, δx)
+		var φ mat64.Vector
+		φ.MulVec(Sk.T(), h)
+		a := mat64.Dot(&φ, &φ) + r[i]
+		if editor != nil && editor.Reject(mat64.NewVector(1, []float64{ν}), mat64.NewSymDense(1, []float64{a})) {
+			rejected = true
+			for j := 0; j < n; j++ {
+				Hw.Set(i, j, 0)
+			}
+			continue
+		}
+		var k mat64.Vector
+		k.MulVec(Sk, &φ)
+		k.ScaleVec(1/a, &k)
+		δx.AddScaledVec(δx, ν, &k)
+		// S = S - k*φ'/(1 + sqrt(r/a))
+		Sk.RankOne(Sk, -1/(1+math.Sqrt(r[i]/a)), &k, &φ)
+	}
+	x = mat64.NewVector(n, nil)
+	x.AddVec(xBar, δx)
+	var P, PHt mat64.Dense
+	P.Mul(Sk, Sk.T())
+	PHt.Mul(&P, Hw.T())
+	p := len(r)
+	K = mat64.NewDense(n, p, nil)
+	for j := 0; j < p; j++ {
+		for i := 0; i < n; i++ {
+			K.Set(i, j, PHt.At(i, j)/r[j])
+		}
+	}
+	if Linv != nil {
+		K.Mul(mat64.DenseCopyOf(K), Linv)
+	}
+	return
+}
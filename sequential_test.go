@@ -0,0 +1,164 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestSequentialMeasurements(t *testing.T) {
+	// 1D constant velocity observed in position and in position + velocity.
+	F := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	G := mat64.NewDense(2, 1, nil)
+	H := mat64.NewDense(2, 2, []float64{1, 0, 1, 1})
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 1)
+	Q := ScaledIdentity(2, 1e-3)
+	ys := [][]float64{{1.1, 2.2}, {1.9, 2.8}, {3.0, 4.1}, {4.1, 4.9}, {5.0, 6.1}}
+	measure := func(x *mat64.Vector) *mat64.Vector {
+		var y mat64.Vector
+		y.MulVec(H, x)
+		return &y
+	}
+	propagate := func(x *mat64.Vector) *mat64.Vector {
+		var xKp1 mat64.Vector
+		xKp1.MulVec(F, x)
+		return &xKp1
+	}
+
+	for _, R := range []*mat64.SymDense{mat64.NewSymDense(2, []float64{0.1, 0, 0, 0.2}), mat64.NewSymDense(2, []float64{0.1, 0.05, 0.05, 0.2})} {
+		noise := NewNoiseless(Q, R)
+		type filter struct {
+			update      func(y *mat64.Vector) (Estimate, error)
+			enable      func()
+			enabled     func() bool
+			compareGain bool
+		}
+		newFilters := map[string]func() filter{
+			"Vanilla": func() filter {
+				kf, _, err := NewVanilla(x0, P0, F, G, H, noise)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return filter{func(y *mat64.Vector) (Estimate, error) {
+					return kf.Update(y, mat64.NewVector(1, nil))
+				}, kf.EnableSequential, kf.SequentialEnabled, true}
+			},
+			"SquareRoot": func() filter {
+				kf, _, err := NewSquareRoot(x0, P0, F, G, H, noise)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return filter{func(y *mat64.Vector) (Estimate, error) {
+					return kf.Update(y, mat64.NewVector(1, nil))
+				}, kf.EnableSequential, kf.SequentialEnabled, true}
+			},
+			"Information": func() filter {
+				kf, _, err := NewInformationFromState(x0, P0, F, G, H, noise)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return filter{func(y *mat64.Vector) (Estimate, error) {
+					return kf.Update(y, mat64.NewVector(1, nil))
+				}, kf.EnableSequential, kf.SequentialEnabled, false}
+			},
+			"HybridKF": func() filter {
+				kf, _, err := NewHybridKF(x0, P0, noise, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return filter{func(y *mat64.Vector) (Estimate, error) {
+					kf.Prepare(F, H)
+					kf.PreparePNT(DenseIdentity(2))
+					return kf.Update(y, mat64.NewVector(2, nil))
+				}, kf.EnableSequential, kf.SequentialEnabled, true}
+			},
+			"SRIF": func() filter {
+				kf, _, err := NewSRIF(x0, P0, 2, false, noise)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return filter{func(y *mat64.Vector) (Estimate, error) {
+					kf.Prepare(F, H)
+					kf.PreparePNT(DenseIdentity(2))
+					return kf.Update(y, mat64.NewVector(2, nil))
+				}, kf.EnableSequential, kf.SequentialEnabled, false}
+			},
+			"UKF": func() filter {
+				kf, _, err := NewUKF(x0, P0, propagate, measure, noise, NewJulierSigmaPoints(1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return filter{kf.Update, kf.EnableSequential, kf.SequentialEnabled, true}
+			},
+		}
+		for name, newFilter := range newFilters {
+			vector := newFilter()
+			sequential := newFilter()
+			if sequential.enabled() {
+				t.Fatalf("%s: sequential processing should be disabled by default", name)
+			}
+			sequential.enable()
+			if !sequential.enabled() {
+				t.Fatalf("%s: sequential processing should be enabled", name)
+			}
+			for k, y := range ys {
+				yk := mat64.NewVector(2, y)
+				vEst, err := vector.update(yk)
+				if err != nil {
+					t.Fatalf("%s k=%d: %s", name, k, err)
+				}
+				sEst, err := sequential.update(yk)
+				if err != nil {
+					t.Fatalf("%s k=%d: %s", name, k, err)
+				}
+				if !mat64.EqualApprox(vEst.State(), sEst.State(), 1e-10) {
+					t.Fatalf("%s k=%d: sequential state differs\n%v\n%v", name, k, mat64.Formatted(vEst.State().T()), mat64.Formatted(sEst.State().T()))
+				}
+				if !mat64.EqualApprox(vEst.Covariance(), sEst.Covariance(), 1e-10) {
+					t.Fatalf("%s k=%d: sequential covariance differs\n%v\n%v", name, k, mat64.Formatted(vEst.Covariance()), mat64.Formatted(sEst.Covariance()))
+				}
+				if !vector.compareGain {
+					continue
+				}
+				type gainer interface {
+					Gain() mat64.Matrix
+				}
+				if !mat64.EqualApprox(vEst.(gainer).Gain(), sEst.(gainer).Gain(), 1e-10) {
+					t.Fatalf("%s k=%d: sequential gain differs\n%v\n%v", name, k, mat64.Formatted(vEst.(gainer).Gain()), mat64.Formatted(sEst.(gainer).Gain()))
+				}
+			}
+		}
+	}
+}
+
+func TestSequentialEditing(t *testing.T) {
+	// Only the second scalar measurement is an outlier: the first one must still be used.
+	F := mat64.NewDense(2, 2, []float64{1, 1, 0, 1})
+	H := mat64.NewDense(2, 2, []float64{1, 0, 0, 1})
+	noise := NewNoiseless(ScaledIdentity(2, 1e-4), ScaledIdentity(2, 0.1))
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	P0 := ScaledIdentity(2, 1)
+	kf, _, err := NewHybridKF(x0, P0, noise, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf.EnableSequential()
+	kf.SetMeasurementEditor(NewNσEditor(5, 0))
+	kf.Prepare(F, H)
+	kf.PreparePNT(DenseIdentity(2))
+	est, err := kf.Update(mat64.NewVector(2, []float64{1.5, 1e3}), mat64.NewVector(2, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !est.Rejected() {
+		t.Fatal("outlier was not rejected")
+	}
+	if math.Abs(est.State().At(1, 0)-1) > 0.5 {
+		t.Fatalf("state was updated with the outlier\n%s", est)
+	}
+	if est.State().At(0, 0) <= 1 || est.Covariance().At(0, 0) >= est.PredCovariance().At(0, 0) {
+		t.Fatalf("state was not updated with the valid measurement\n%s", est)
+	}
+}
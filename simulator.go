@@ -0,0 +1,150 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// NewSimulator returns a new simulator of a linear system, which generates the true states with the sampled
+// process noise and the noisy measurements of these states, independently of any filter.
+// Parameters:
+// - x0: initial true state
+// - F: state update matrix
+// - G: control matrix (if all zeros, then control vector will not be used)
+// - H: measurement update matrix
+// - noise: Noise whose samples are added to the states and the measurements (e.g. AWGN)
+func NewSimulator(x0 *mat64.Vector, F, G, H mat64.Matrix, noise Noise) (*Simulator, error) {
+	if err := checkMatDims(F, x0, "F", "x0", cols2rows); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(F, F, "F", "F", rows2cols); err != nil {
+		return nil, err
+	}
+	if err := checkMatDims(H, x0, "H", "x0", cols2rows); err != nil {
+		return nil, err
+	}
+	needCtrl := !IsNil(G)
+	if needCtrl {
+		if err := checkMatDims(G, x0, "G", "x0", rows2rows); err != nil {
+			return nil, err
+		}
+	}
+	propagate := func(x, u *mat64.Vector) *mat64.Vector {
+		var xKp1 mat64.Vector
+		xKp1.MulVec(F, x)
+		if needCtrl && u != nil {
+			var Gu mat64.Vector
+			Gu.MulVec(G, u)
+			xKp1.AddVec(&xKp1, &Gu)
+		}
+		return &xKp1
+	}
+	observe := func(x *mat64.Vector) *mat64.Vector {
+		var y mat64.Vector
+		y.MulVec(H, x)
+		return &y
+	}
+	return newSimulator(x0, propagate, observe, noise)
+}
+
+// NewNLSimulator returns a new simulator of a non-linear system: x_{k+1} = f(x_k) + w_k and y_k = h(x_k) + v_k.
+// The control vectors provided to Step and Run are ignored.
+func NewNLSimulator(x0 *mat64.Vector, f NLPropagation, h NLMeasurement, noise Noise) (*Simulator, error) {
+	if f == nil || h == nil {
+		return nil, errors.New("both the propagation and the measurement functions must be provided")
+	}
+	propagate := func(x, u *mat64.Vector) *mat64.Vector {
+		return f(x)
+	}
+	return newSimulator(x0, propagate, h, noise)
+}
+
+func newSimulator(x0 *mat64.Vector, propagate func(x, u *mat64.Vector) *mat64.Vector, observe func(x *mat64.Vector) *mat64.Vector, noise Noise) (*Simulator, error) {
+	if noise == nil {
+		return nil, errors.New("noise must be provided")
+	}
+	if err := checkMatDims(x0, noise.ProcessMatrix(), "x0", "Q", rows2rows); err != nil {
+		return nil, err
+	}
+	x := mat64.NewVector(x0.Len(), nil)
+	x.CloneVec(x0)
+	return &Simulator{propagate, observe, noise, x0, x, 0}, nil
+}
+
+// Simulator generates the truth of a system for testing the filters: the state is propagated with the sampled
+// process noise, and the measurements are computed from the true state with the sampled measurement noise.
+// The filters themselves never sample the noise. Use NewSimulator or NewNLSimulator to initialize.
+type Simulator struct {
+	propagate func(x, u *mat64.Vector) *mat64.Vector
+	observe   func(x *mat64.Vector) *mat64.Vector
+	Noise     Noise
+	x0, x     *mat64.Vector
+	step      int
+}
+
+func (s *Simulator) String() string {
+	return fmt.Sprintf("Simulator [k=%d]\n%s", s.step, s.Noise)
+}
+
+// State returns the current true state.
+func (s *Simulator) State() *mat64.Vector {
+	return s.x
+}
+
+// Step propagates the true state with the process noise, i.e. x_{k+1} = F*x_k + G*u_k + w_k, and returns it with
+// its noisy measurement, i.e. y_{k+1} = H*x_{k+1} + v_{k+1}. The control may be nil.
+func (s *Simulator) Step(control *mat64.Vector) (state, measurement *mat64.Vector, err error) {
+	state = s.propagate(s.x, control)
+	if err = checkMatDims(state, s.x, "propagated state", "state", rows2rows); err != nil {
+		return nil, nil, err
+	}
+	w := s.Noise.Process(s.step)
+	if err = checkMatDims(state, w, "state", "process noise", rows2rows); err != nil {
+		return nil, nil, err
+	}
+	state.AddVec(state, w)
+	measurement = s.observe(state)
+	v := s.Noise.Measurement(s.step)
+	if err = checkMatDims(measurement, v, "measurement", "measurement noise", rows2rows); err != nil {
+		return nil, nil, err
+	}
+	measurement.AddVec(measurement, v)
+	s.x = state
+	s.step++
+	return state, measurement, nil
+}
+
+// Run simulates the provided number of steps with the provided controls (nil, one for all steps, or one per step),
+// and returns the true states and measurements as a ground truth.
+func (s *Simulator) Run(steps int, controls []*mat64.Vector) (*BatchGroundTruth, error) {
+	if len(controls) > 1 && len(controls) != steps {
+		return nil, errors.New("must provide as much control vectors as steps, or just one control vector")
+	}
+	states := make([]*mat64.Vector, steps)
+	measurements := make([]*mat64.Vector, steps)
+	for k := 0; k < steps; k++ {
+		var control *mat64.Vector
+		if len(controls) == 1 {
+			control = controls[0]
+		} else if len(controls) == steps {
+			control = controls[k]
+		}
+		state, measurement, err := s.Step(control)
+		if err != nil {
+			return nil, fmt.Errorf("k=%d: %s", k, err)
+		}
+		states[k] = state
+		measurements[k] = measurement
+	}
+	return NewBatchGroundTruth(states, measurements), nil
+}
+
+// Reset reinitializes the simulator with its initial state and resets its noise.
+func (s *Simulator) Reset() {
+	s.x = mat64.NewVector(s.x0.Len(), nil)
+	s.x.CloneVec(s.x0)
+	s.step = 0
+	s.Noise.Reset()
+}
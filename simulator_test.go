@@ -0,0 +1,176 @@
+package gokalman
+
+import (
+	"testing"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestSimulator(t *testing.T) {
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	x0 := mat64.NewVector(2, []float64{0, 1})
+	steps := 5
+	process := make([]*mat64.Vector, steps)
+	measurements := make([]*mat64.Vector, steps)
+	for k := 0; k < steps; k++ {
+		process[k] = mat64.NewVector(2, []float64{0.01 * float64(k), -0.02})
+		measurements[k] = mat64.NewVector(1, []float64{0.1 * float64(k)})
+	}
+	sim, err := NewSimulator(x0, F, G, H, BatchNoise{process, measurements})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mat64.NewVector(1, []float64{0.5})
+	truth, err := sim.Run(steps, []*mat64.Vector{u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(truth.States()) != steps || len(truth.Measurements()) != steps {
+		t.Fatalf("expected %d states and measurements", steps)
+	}
+	// x_{k+1} = F*x_k + G*u_k + w_k and y_{k+1} = H*x_{k+1} + v_{k+1}
+	x := x0
+	for k := 0; k < steps; k++ {
+		var xKp1, Gu mat64.Vector
+		xKp1.MulVec(F, x)
+		Gu.MulVec(G, u)
+		xKp1.AddVec(&xKp1, &Gu)
+		xKp1.AddVec(&xKp1, process[k])
+		if !mat64.EqualApprox(&xKp1, truth.States()[k], 1e-12) {
+			t.Fatalf("k=%d: invalid state\n%v\n%v", k, mat64.Formatted(&xKp1), mat64.Formatted(truth.States()[k]))
+		}
+		y := xKp1.At(0, 0) + measurements[k].At(0, 0)
+		if y != truth.Measurements()[k].At(0, 0) {
+			t.Fatalf("k=%d: invalid measurement %f instead of %f", k, truth.Measurements()[k].At(0, 0), y)
+		}
+		x = &xKp1
+	}
+	if !mat64.Equal(sim.State(), x) {
+		t.Fatal("invalid current state")
+	}
+	// The initial state must not be modified, and a reset must restart from it.
+	if x0.At(0, 0) != 0 || x0.At(1, 0) != 1 {
+		t.Fatal("the initial state was modified")
+	}
+	sim.Reset()
+	if !mat64.Equal(sim.State(), x0) {
+		t.Fatal("reset did not restore the initial state")
+	}
+	again, err := sim.Run(steps, []*mat64.Vector{u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mat64.Equal(again.States()[steps-1], truth.States()[steps-1]) {
+		t.Fatal("simulation after a reset differs")
+	}
+
+	// The non-linear simulator must match the linear one.
+	_, _, f, h := linearRobot()
+	nlSim, err := NewNLSimulator(x0, f, h, BatchNoise{process, measurements})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nlTruth, err := nlSim.Run(steps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Reset()
+	for k := 0; k < steps; k++ {
+		state, meas, err := sim.Step(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mat64.EqualApprox(state, nlTruth.States()[k], 1e-12) || !mat64.EqualApprox(meas, nlTruth.Measurements()[k], 1e-12) {
+			t.Fatalf("k=%d: non-linear simulation differs", k)
+		}
+	}
+}
+
+func TestFiltersDoNotSampleNoise(t *testing.T) {
+	// The filters must return the same estimates whether their Noise samples noise or not.
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Q := mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3})
+	R := mat64.NewSymDense(1, []float64{0.1})
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 10)
+	sim, err := NewSimulator(x0, F, G, H, NewAWGN(Q, R))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mat64.NewVector(1, []float64{0.1})
+	truth, err := sim.Run(20, []*mat64.Vector{u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := []func(noise Noise) (LDKF, error){
+		func(noise Noise) (LDKF, error) {
+			kf, _, err := NewVanilla(x0, P0, F, G, H, noise)
+			return kf, err
+		},
+		func(noise Noise) (LDKF, error) {
+			kf, _, err := NewSquareRoot(x0, P0, F, G, H, noise)
+			return kf, err
+		},
+		func(noise Noise) (LDKF, error) {
+			kf, _, err := NewInformationFromState(x0, P0, F, G, H, noise)
+			return kf, err
+		},
+	}
+	for i, newKF := range build {
+		noisy, err := newKF(NewAWGN(Q, R))
+		if err != nil {
+			t.Fatal(err)
+		}
+		noiseless, err := newKF(NewNoiseless(Q, R))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, y := range truth.Measurements() {
+			nEst, err := noisy.Update(y, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			est, err := noiseless.Update(y, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !mat64.Equal(nEst.State(), est.State()) || !mat64.Equal(nEst.Measurement(), est.Measurement()) {
+				t.Fatalf("filter #%d k=%d: the estimate depends on the noise samples", i, k)
+			}
+		}
+	}
+}
+
+func TestSimulatorErrors(t *testing.T) {
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	noise := NewNoiseless(ScaledIdentity(2, 1e-4), mat64.NewSymDense(1, []float64{0.1}))
+	x0 := mat64.NewVector(2, nil)
+	if _, err := NewSimulator(mat64.NewVector(3, nil), F, G, H, noise); err == nil {
+		t.Fatal("invalid state size did not fail")
+	}
+	if _, err := NewSimulator(x0, F, G, mat64.NewDense(1, 3, nil), noise); err == nil {
+		t.Fatal("invalid H size did not fail")
+	}
+	if _, err := NewSimulator(x0, F, G, H, nil); err == nil {
+		t.Fatal("missing noise did not fail")
+	}
+	if _, err := NewSimulator(x0, F, G, H, NewNoiseless(ScaledIdentity(3, 1e-4), mat64.NewSymDense(1, []float64{0.1}))); err == nil {
+		t.Fatal("invalid process noise size did not fail")
+	}
+	if _, err := NewNLSimulator(x0, nil, nil, noise); err == nil {
+		t.Fatal("missing functions did not fail")
+	}
+	sim, err := NewSimulator(x0, F, G, H, NewNoiseless(ScaledIdentity(2, 1e-4), mat64.NewSymDense(2, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = sim.Step(nil); err == nil {
+		t.Fatal("invalid measurement noise size did not fail")
+	}
+	if _, err = sim.Run(3, []*mat64.Vector{nil, nil}); err == nil {
+		t.Fatal("invalid number of controls did not fail")
+	}
+}
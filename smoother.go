@@ -0,0 +1,84 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// RTSSmooth performs a fixed-interval Rauch–Tung–Striebel smoothing of the estimates returned by
+// any LDKF (e.g. Vanilla, SquareRoot or Information) and returns the smoothed estimates.
+// The provided estimates are not modified.
+// If the estimates are time tagged (i.e. their epochs differ), there is no time update between the estimates at the
+// same epoch, so the smoother uses the identity instead of F, and no control, between them.
+// NOTE: F is the same for every time update, so the estimates of a filter with a TimeStepModel cannot be smoothed.
+// Parameters:
+// - estimates: history of the estimates as returned by Update
+// - F: state update matrix used by the filter
+// - G: control matrix (if all zeros, then the controls will not be used)
+// - controls: control vectors provided to each Update call (may be nil if G is nil)
+func RTSSmooth(estimates []Estimate, F, G mat64.Matrix, controls []*mat64.Vector) ([]Estimate, error) {
+	if len(estimates) == 0 {
+		return nil, errors.New("no estimates to smooth")
+	}
+	if err := checkMatDims(F, estimates[0].Covariance(), "F", "P", rows2cols); err != nil {
+		return nil, err
+	}
+	needCtrl := !IsNil(G)
+	if needCtrl && len(controls) != len(estimates) {
+		return nil, fmt.Errorf("must provide as many control vectors as estimates: %d != %d", len(controls), len(estimates))
+	}
+	l := len(estimates) - 1
+	timeTagged := !estimates[0].Epoch().Equal(estimates[l].Epoch())
+	smoothed := make([]Estimate, len(estimates))
+	last := estimates[l]
+	smoothed[l] = SmoothedEstimate{VanillaEstimate{last.State(), last.Measurement(), last.Innovation(), last.Covariance(), last.PredCovariance(), nil, last.Rejected(), last.Epoch()}}
+	for k := l - 1; k >= 0; k-- {
+		estimateK := estimates[k]
+		estimateKp1 := estimates[k+1]
+		smoothedKp1 := smoothed[k+1]
+		Fk := F
+		sameEpoch := timeTagged && estimateKp1.Epoch().Equal(estimateK.Epoch())
+		if sameEpoch {
+			Fk = Identity(estimateK.State().Len())
+		}
+		// S_k = P_k Fᵀ (P_{k+1}^{-})⁻¹
+		var PBarInv, PFt, S mat64.Dense
+		if ierr := PBarInv.Inverse(estimateKp1.PredCovariance()); ierr != nil {
+			return nil, fmt.Errorf("predicted covariance at k=%d is not invertible: %s", k+1, ierr)
+		}
+		PFt.Mul(estimateK.Covariance(), Fk.T())
+		S.Mul(&PFt, &PBarInv)
+		// x_{k}^{l} = x_{k}^{+} + S_k (x_{k+1}^{l} - x_{k+1}^{-})
+		var xBar, Δx, xHat mat64.Vector
+		xBar.MulVec(Fk, estimateK.State())
+		if needCtrl && !sameEpoch {
+			var Gu mat64.Vector
+			Gu.MulVec(G, controls[k+1])
+			xBar.AddVec(&xBar, &Gu)
+		}
+		Δx.SubVec(smoothedKp1.State(), &xBar)
+		xHat.MulVec(&S, &Δx)
+		xHat.AddVec(estimateK.State(), &xHat)
+		// P_{k}^{l} = P_{k}^{+} + S_k (P_{k+1}^{l} - P_{k+1}^{-}) S_kᵀ
+		var ΔP, SΔP, SΔPSt, Pkl mat64.Dense
+		ΔP.Sub(smoothedKp1.Covariance(), estimateKp1.PredCovariance())
+		SΔP.Mul(&S, &ΔP)
+		SΔPSt.Mul(&SΔP, S.T())
+		Pkl.Add(estimateK.Covariance(), &SΔPSt)
+		PklSym, err := AsSymDense(&Pkl)
+		if err != nil {
+			return nil, err
+		}
+		smoothed[k] = SmoothedEstimate{VanillaEstimate{&xHat, estimateK.Measurement(), estimateK.Innovation(), PklSym, estimateK.PredCovariance(), &S, estimateK.Rejected(), estimateK.Epoch()}}
+	}
+	return smoothed, nil
+}
+
+// SmoothedEstimate implements the Estimate interface and is returned by RTSSmooth.
+// Its state and covariance are smoothed and its Gain is the smoother gain S_k.
+// All the other values are those of the filtered estimate.
+type SmoothedEstimate struct {
+	VanillaEstimate
+}
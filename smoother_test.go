@@ -0,0 +1,135 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestRTSSmooth(t *testing.T) {
+	F, G, Δt := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Γ := mat64.NewDense(2, 1, []float64{0.5 * Δt * Δt, Δt})
+	var ΓΓt mat64.Dense
+	ΓΓt.Mul(Γ, Γ.T())
+	Q, _ := AsSymDense(&ΓΓt)
+	Q.SetSym(0, 0, Q.At(0, 0)+1e-6) // Ensures Q is invertible for the information filter.
+	R := mat64.NewSymDense(1, []float64{0.1})
+	noise := NewNoiseless(Q, R)
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 10)
+
+	steps := 40
+	measurements := make([]*mat64.Vector, steps)
+	controls := make([]*mat64.Vector, steps)
+	for k := 0; k < steps; k++ {
+		measurements[k] = mat64.NewVector(1, []float64{0.035*float64(k) + 0.2*math.Sin(float64(k))})
+		controls[k] = mat64.NewVector(1, []float64{math.Cos(float64(k) / 5)})
+	}
+
+	vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqrt, _, err := NewSquareRoot(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := NewInformationFromState(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vanillaSmoothed []Estimate
+	for fNo, kf := range []LDKF{vanilla, sqrt, info} {
+		estimates := make([]Estimate, steps)
+		for k := 0; k < steps; k++ {
+			est, err := kf.Update(measurements[k], controls[k])
+			if err != nil {
+				t.Fatal(err)
+			}
+			estimates[k] = est
+		}
+		smoothed, err := RTSSmooth(estimates, F, G, controls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(smoothed) != steps {
+			t.Fatalf("filter #%d: expected %d smoothed estimates, got %d", fNo, steps, len(smoothed))
+		}
+		if !mat64.EqualApprox(smoothed[steps-1].State(), estimates[steps-1].State(), 1e-12) {
+			t.Fatalf("filter #%d: last estimate should not be modified by the smoother", fNo)
+		}
+		for k := 0; k < steps; k++ {
+			for i := 0; i < 2; i++ {
+				if smoothed[k].Covariance().At(i, i) > estimates[k].Covariance().At(i, i)+1e-9 {
+					t.Fatalf("filter #%d k=%d: smoothed variance #%d larger than the filtered one", fNo, k, i)
+				}
+			}
+		}
+		if fNo == 0 {
+			vanillaSmoothed = smoothed
+			continue
+		}
+		// All the linear filters must lead to the same smoothed estimates.
+		for k := 0; k < steps; k++ {
+			if !mat64.EqualApprox(smoothed[k].State(), vanillaSmoothed[k].State(), 1e-6) {
+				t.Fatalf("filter #%d k=%d: smoothed state differs from Vanilla's\n%v\n%v", fNo, k, mat64.Formatted(smoothed[k].State()), mat64.Formatted(vanillaSmoothed[k].State()))
+			}
+			if !mat64.EqualApprox(smoothed[k].Covariance(), vanillaSmoothed[k].Covariance(), 1e-6) {
+				t.Fatalf("filter #%d k=%d: smoothed covariance differs from Vanilla's", fNo, k)
+			}
+		}
+	}
+
+	// Errors
+	if _, err := RTSSmooth(nil, F, G, controls); err == nil {
+		t.Fatal("smoothing no estimates did not fail")
+	}
+	if _, err := RTSSmooth(vanillaSmoothed, F, G, controls[1:]); err == nil {
+		t.Fatal("smoothing with too few controls did not fail")
+	}
+	if _, err := RTSSmooth(vanillaSmoothed, Identity(3), G, controls); err == nil {
+		t.Fatal("smoothing with an invalid F did not fail")
+	}
+}
+
+func TestRTSSmoothSameEpoch(t *testing.T) {
+	// Two measurements at the third epoch: there is no time update between them.
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	noise := NewNoiseless(mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3}), mat64.NewSymDense(1, []float64{0.1}))
+	kf, _, err := NewVanilla(mat64.NewVector(2, []float64{0, 0.35}), ScaledIdentity(2, 10), F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf.SetEpoch(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	Δts := []float64{1, 1, 0, 1, 1}
+	estimates := make([]Estimate, len(Δts))
+	controls := make([]*mat64.Vector, len(Δts))
+	for k, Δt := range Δts {
+		if err := kf.SetNextTimeStep(Δt); err != nil {
+			t.Fatal(err)
+		}
+		controls[k] = mat64.NewVector(1, []float64{0.1})
+		if estimates[k], err = kf.Update(mat64.NewVector(1, []float64{0.4 * float64(k)}), controls[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	smoothed, err := RTSSmooth(estimates, F, G, controls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The smoothed estimate of the first measurement at the same epoch is that of the second.
+	if !mat64.EqualApprox(smoothed[1].State(), smoothed[2].State(), 1e-12) {
+		t.Fatalf("smoothed states differ at the same epoch\n%v\n%v", mat64.Formatted(smoothed[1].State()), mat64.Formatted(smoothed[2].State()))
+	}
+	if !mat64.EqualApprox(smoothed[1].Covariance(), smoothed[2].Covariance(), 1e-12) {
+		t.Fatalf("smoothed covariances differ at the same epoch\n%v\n%v", mat64.Formatted(smoothed[1].Covariance()), mat64.Formatted(smoothed[2].Covariance()))
+	}
+	if mat64.EqualApprox(smoothed[0].State(), smoothed[1].State(), 1e-6) {
+		t.Fatal("smoothed states should differ between epochs")
+	}
+}
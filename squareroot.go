@@ -1,8 +1,10 @@
 package gokalman
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/gonum/matrix/mat64"
 )
@@ -44,7 +46,7 @@ func NewSquareRoot(x0 *mat64.Vector, P0 mat64.Symmetric, F, G, H mat64.Matrix, n
 	rowsH, _ := H.Dims()
 	est0 := NewSqrtEstimate(x0, mat64.NewVector(rowsH, nil), mat64.NewVector(rowsH, nil), &stddev, mat64.NewDense(stdr, stdc, nil), nil)
 	// Return the state and estimate to the SquareRoot structure.
-	sqrt := SquareRoot{F, G, H, nil, nil, nil, !IsNil(G), est0, est0, 0}
+	sqrt := SquareRoot{F: F, G: G, H: H, needCtrl: !IsNil(G), prevEst: est0, initEst: est0}
 	sqrt.SetNoise(noise) // Computes the Cholesky decompositions of the noise.
 	return &sqrt, &est0, nil
 }
@@ -56,9 +58,14 @@ type SquareRoot struct {
 	H                mat64.Matrix
 	Noise            Noise
 	sqrtQ, sqrtR     mat64.Matrix
+	sqrtQSR          mat64.Matrix // Cholesky factor of [Q S; Sᵀ R], nil if the process and measurement noises are independent.
 	needCtrl         bool
 	prevEst, initEst SquareRootEstimate
 	step             int
+	editor           MeasurementEditor
+	sequential       bool          // Process each scalar measurement one at a time.
+	model            TimeStepModel // Computes F and Q of each time update from the time step, if set.
+	timeTagger
 }
 
 // Prints the output.
@@ -111,6 +118,19 @@ func (kf *SquareRoot) SetNoise(n Noise) {
 	kf.Noise = n
 	kf.sqrtQ = &sqrtQ
 	kf.sqrtR = &sqrtR
+	kf.sqrtQSR = nil
+	if S := n.CrossCovariance(); S != nil {
+		if err := checkCrossCovariance(n.ProcessMatrix(), n.MeasurementMatrix(), S); err != nil {
+			panic(err)
+		}
+		var sqrtQSRchol mat64.Cholesky
+		if ok := sqrtQSRchol.Factorize(jointCovariance(n.ProcessMatrix(), n.MeasurementMatrix(), S)); !ok {
+			panic("joint process and measurement noise covariance is not positive definite")
+		}
+		var sqrtQSR mat64.TriDense
+		sqrtQSR.LFromCholesky(&sqrtQSRchol)
+		kf.sqrtQSR = &sqrtQSR
+	}
 }
 
 // GetNoise updates the F matrix.
@@ -122,7 +142,89 @@ func (kf *SquareRoot) GetNoise() Noise {
 func (kf *SquareRoot) Reset() {
 	kf.prevEst = kf.initEst
 	kf.step = 0
+	kf.reset()
 	kf.Noise.Reset()
+	if kf.editor != nil {
+		kf.editor.Reset()
+	}
+}
+
+// SetEstimate replaces the current state estimate and covariance, e.g. after the mixing step of an IMM.
+// The covariance must be positive definite to compute its square root.
+func (kf *SquareRoot) SetEstimate(x *mat64.Vector, P mat64.Symmetric) error {
+	if err := checkMatDims(x, P, "x", "P", rows2cols); err != nil {
+		return err
+	}
+	if err := checkMatDims(x, kf.prevEst.state, "x", "current state", rows2rows); err != nil {
+		return err
+	}
+	var sqrtP mat64.Cholesky
+	if ok := sqrtP.Factorize(P); !ok {
+		return errors.New("covariance is not positive definite")
+	}
+	var stddevL mat64.TriDense
+	stddevL.LFromCholesky(&sqrtP)
+	var stddev mat64.Dense
+	stddev.Clone(&stddevL)
+	kf.prevEst.state = x
+	kf.prevEst.stddev = &stddev
+	kf.prevEst.cachedCovar = nil
+	return nil
+}
+
+// SequentialEnabled returns whether the measurements are processed one scalar at a time.
+func (kf *SquareRoot) SequentialEnabled() bool {
+	return kf.sequential
+}
+
+// EnableSequential switches to processing each scalar measurement one at a time with Potter's algorithm,
+// which avoids the QR decomposition of the measurement update and the inversion of Syy.
+// If R is not diagonal, the measurements are first whitened with its Cholesky factor.
+// Measurement editing is then applied to each (whitened) scalar measurement.
+func (kf *SquareRoot) EnableSequential() {
+	kf.sequential = true
+}
+
+// DisableSequential switches back to processing the whole measurement vector at once.
+func (kf *SquareRoot) DisableSequential() {
+	kf.sequential = false
+}
+
+// SetTimeStepModel sets the model from which F and Q of each time update are computed over the time step to the next
+// epoch (cf. SetNextEpoch), instead of using F and the Noise's Q (nil to disable). The next epoch must then be set
+// before each update, and Q must be positive definite. The Noise's R and cross covariance, if any, are still used.
+func (kf *SquareRoot) SetTimeStepModel(m TimeStepModel) {
+	kf.model = m
+}
+
+// SetMeasurementEditor sets the measurement editing policy used to reject outliers (nil to disable).
+func (kf *SquareRoot) SetMeasurementEditor(e MeasurementEditor) {
+	kf.editor = e
+}
+
+// modelTimeUpdate returns F and the square roots of Q, and of [Q S; Sᵀ R] if the noises are correlated, of the next
+// time update from the TimeStepModel.
+func (kf *SquareRoot) modelTimeUpdate() (F, sqrtQ, sqrtQSR mat64.Matrix, err error) {
+	F, Q, err := kf.timeTagger.modelTimeUpdate(kf.model, kf.prevEst.state.Len())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var sqrtQchol mat64.Cholesky
+	if ok := sqrtQchol.Factorize(Q); !ok {
+		return nil, nil, nil, errors.New("process noise of the time update is not positive definite")
+	}
+	var sqrtQL mat64.TriDense
+	sqrtQL.LFromCholesky(&sqrtQchol)
+	if kf.sqrtQSR == nil {
+		return F, &sqrtQL, nil, nil
+	}
+	var sqrtQSRchol mat64.Cholesky
+	if ok := sqrtQSRchol.Factorize(jointCovariance(Q, kf.Noise.MeasurementMatrix(), kf.Noise.CrossCovariance())); !ok {
+		return nil, nil, nil, errors.New("joint process and measurement noise covariance of the time update is not positive definite")
+	}
+	var sqrtQSRL mat64.TriDense
+	sqrtQSRL.LFromCholesky(&sqrtQSRchol)
+	return F, &sqrtQL, &sqrtQSRL, nil
 }
 
 // Update implements the KalmanFilter interface.
@@ -135,112 +237,147 @@ func (kf *SquareRoot) Update(measurement, control *mat64.Vector) (est Estimate,
 		return nil, err
 	}
 
-	// Prediction Step //
-	// Get xKp1Minus
-	var xKp1Minus, xKp1Minus1, xKp1Minus2 mat64.Vector
-	xKp1Minus1.MulVec(kf.F, kf.prevEst.State())
-	if kf.needCtrl {
-		xKp1Minus2.MulVec(kf.G, control)
-		xKp1Minus.AddVec(&xKp1Minus1, &xKp1Minus2)
-	} else {
-		xKp1Minus = xKp1Minus1
+	// State transition and process noise of the time update.
+	F, sqrtQ, sqrtQSR := kf.F, kf.sqrtQ, kf.sqrtQSR
+	if kf.model != nil && !kf.sameEpoch() {
+		if F, sqrtQ, sqrtQSR, err = kf.modelTimeUpdate(); err != nil {
+			return nil, err
+		}
 	}
 
-	// Get sKplus
-	//sKPlus := kf.prevEst.stddev
-
-	// Get sKp1Minus
+	// With correlated process and measurement noises, the time and measurement updates are computed at once.
+	correlated := sqrtQSR != nil && !kf.sameEpoch()
+	if correlated && kf.sequential {
+		return nil, errors.New("correlated process and measurement noises are not available with sequential measurements")
+	}
 
-	// C Matrix
+	// Prediction Step //
 	nState, _ := kf.prevEst.state.Dims()
-	cVals := make([]float64, 2*nState*nState, 2*nState*nState)
-	var sTFT mat64.Dense
-	sTFT.Mul(kf.prevEst.stddev.T(), kf.F.T())
-	cValsPos := 0
-	sTFTr, sTFTc := sTFT.Dims()
-	for i := 0; i < sTFTr; i++ {
-		for j := 0; j < sTFTc; j++ {
-			cVals[cValsPos] = sTFT.At(i, j)
-			cValsPos++
-		}
-	}
-	// Now let's add the sqrtQ elements to the values for C
-	sQr, sQc := kf.sqrtQ.Dims()
-	for i := 0; i < sQr; i++ {
-		for j := 0; j < sQc; j++ {
-			cVals[cValsPos] = kf.sqrtQ.T().At(i, j)
-			cValsPos++
-		}
-	}
-	C := mat64.NewDense(2*nState, nState, cVals)
-	var TcUc mat64.QR
-	TcUc.Factorize(C)
-	var Uc mat64.Dense
-	Uc.RFromQR(&TcUc)
-
-	// Get sKp1Minus from the top block of QR decomposition.
-	//skR, skC := kf.prevEst.stddev.Dims()
 	skR := nState
 	skC := nState
-	SKp1Minus := Uc.View(0, 0, skR, skC)
-
-	// Delta Matrix
+	var xKp1Minus mat64.Vector
+	var SKp1Minus mat64.Matrix
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		xKp1Minus.CloneVec(kf.prevEst.State())
+		SKp1Minus = kf.prevEst.stddev.T()
+	} else {
+		// Get xKp1Minus
+		var xKp1Minus1, xKp1Minus2 mat64.Vector
+		xKp1Minus1.MulVec(F, kf.prevEst.State())
+		if kf.needCtrl {
+			xKp1Minus2.MulVec(kf.G, control)
+			xKp1Minus.AddVec(&xKp1Minus1, &xKp1Minus2)
+		} else {
+			xKp1Minus = xKp1Minus1
+		}
 
-	// And now let's compute the two bottom blocks of the Delta matrix.
-	var SKp1MinusTHT mat64.Dense
-	SKp1MinusTHT.Mul(SKp1Minus.T(), kf.H.T())
-	shR, shC := SKp1MinusTHT.Dims()
-	sRr, sRc := kf.sqrtR.Dims()
-	pMeas, _ := measurement.Dims()
-	Δ := mat64.NewDense(nState+pMeas, nState+pMeas, nil)
-	ΔrMax, ΔcMax := Δ.Dims()
-
-	// Note that we populate by *column* for simpler logic.
-	for Δc := 0; Δc < ΔcMax; Δc++ {
-		for Δr := 0; Δr < ΔrMax; Δr++ {
-			if Δc < sRc {
-				if Δr < sRr {
-					// Still in the upper left, let's set this to the R.T()
-					Δ.Set(Δr, Δc, kf.sqrtR.T().At(Δr, Δc))
-				} else if Δc < shC {
-					Δ.Set(Δr, Δc, SKp1MinusTHT.At(Δr-sRr, Δc))
-				} else {
-					Δ.Set(Δr, Δc, SKp1Minus.T().At(Δr-skC, Δc-shR))
-				}
-			} else if Δr < sRr {
-				Δ.Set(Δr, Δc, 0)
-			} else {
-				Δ.Set(Δr, Δc, SKp1Minus.T().At(Δr-sRr, Δc-shC))
+		// Get sKp1Minus
+
+		// C Matrix
+		cVals := make([]float64, 2*nState*nState, 2*nState*nState)
+		var sTFT mat64.Dense
+		sTFT.Mul(kf.prevEst.stddev.T(), F.T())
+		cValsPos := 0
+		sTFTr, sTFTc := sTFT.Dims()
+		for i := 0; i < sTFTr; i++ {
+			for j := 0; j < sTFTc; j++ {
+				cVals[cValsPos] = sTFT.At(i, j)
+				cValsPos++
+			}
+		}
+		// Now let's add the sqrtQ elements to the values for C
+		sQr, sQc := sqrtQ.Dims()
+		for i := 0; i < sQr; i++ {
+			for j := 0; j < sQc; j++ {
+				cVals[cValsPos] = sqrtQ.T().At(i, j)
+				cValsPos++
 			}
 		}
+		C := mat64.NewDense(2*nState, nState, cVals)
+		var TcUc mat64.QR
+		TcUc.Factorize(C)
+		var Uc mat64.Dense
+		Uc.RFromQR(&TcUc)
+
+		// Get sKp1Minus from the top block of QR decomposition.
+		SKp1Minus = Uc.View(0, 0, skR, skC)
 	}
 
-	// Extract the UΔ matrix post QR decomposition.
-	var TΔUΔ mat64.QR
-	TΔUΔ.Factorize(Δ)
-	var UΔ mat64.Dense
-	UΔ.RFromQR(&TΔUΔ)
-
-	// Extract Skp1Plus first
-	UΔR, UΔC := UΔ.Dims()
-	// Note that Skp1PlusT is transposed, hence the change of indices.
-	Skp1PlusT := UΔ.View(UΔR-skC, UΔC-skR, skC, skR)
-	SyyT := UΔ.View(0, 0, pMeas, pMeas)
-	Wkp1PlusT := UΔ.View(0, pMeas, UΔR-skC, UΔC-pMeas)
+	if kf.sequential {
+		var SKp1MinusL mat64.Dense
+		SKp1MinusL.Clone(SKp1Minus.T())
+		var ykHat, innovation mat64.Vector
+		ykHat.MulVec(kf.H, kf.prevEst.State())
+		innovation.MulVec(kf.H, &xKp1Minus)
+		innovation.SubVec(measurement, &innovation)
+		xkp1Plus, Skp1Plus, Kkp1, rejected, serr := sequentialSqrtUpdate(&xKp1Minus, &SKp1MinusL, kf.H, &innovation, kf.Noise.MeasurementMatrix(), kf.editor)
+		if serr != nil {
+			return nil, serr
+		}
+		sqrtEst := NewSqrtEstimate(xkp1Plus, &ykHat, &innovation, Skp1Plus, &SKp1MinusL, Kkp1)
+		sqrtEst.rejected = rejected
+		sqrtEst.epoch = kf.advance()
+		est = sqrtEst
+		kf.prevEst = sqrtEst
+		kf.step++
+		return
+	}
 
+	pMeas, _ := measurement.Dims()
 	var Skp1Plus, Syy, Wkp1Plus mat64.Dense
-	Skp1Plus.Clone(Skp1PlusT.T())
-	Syy.Clone(SyyT.T())
-	Wkp1Plus.Clone(Wkp1PlusT.T())
+	if correlated {
+		SyyC, WC, SC := correlatedSqrtUpdate(F, kf.H, kf.prevEst.stddev, sqrtQSR)
+		Skp1Plus, Syy, Wkp1Plus = *SC, *SyyC, *WC
+	} else {
+		// Delta Matrix
+
+		// SKp1Minus is the upper triangular factor from the QR, i.e. P_{k+1}^{-} = SKp1Minus^T*SKp1Minus,
+		// so SKp1Minus is already the transpose of the (lower triangular) square root of P_{k+1}^{-}.
+		// Δ = [sqrtR^T 0; SKp1Minus*H^T SKp1Minus]
+		var SKp1MinusHT mat64.Dense
+		SKp1MinusHT.Mul(SKp1Minus, kf.H.T())
+		sRr, sRc := kf.sqrtR.Dims()
+		Δ := mat64.NewDense(nState+pMeas, nState+pMeas, nil)
+		for i := 0; i < sRr; i++ {
+			for j := 0; j < sRc; j++ {
+				Δ.Set(i, j, kf.sqrtR.At(j, i))
+			}
+		}
+		for i := 0; i < nState; i++ {
+			for j := 0; j < pMeas; j++ {
+				Δ.Set(pMeas+i, j, SKp1MinusHT.At(i, j))
+			}
+			for j := 0; j < nState; j++ {
+				Δ.Set(pMeas+i, pMeas+j, SKp1Minus.At(i, j))
+			}
+		}
+
+		// Extract the UΔ matrix post QR decomposition.
+		var TΔUΔ mat64.QR
+		TΔUΔ.Factorize(Δ)
+		var UΔ mat64.Dense
+		UΔ.RFromQR(&TΔUΔ)
+
+		// Extract Skp1Plus first
+		UΔR, UΔC := UΔ.Dims()
+		// Note that Skp1PlusT is transposed, hence the change of indices.
+		Skp1PlusT := UΔ.View(UΔR-skC, UΔC-skR, skC, skR)
+		SyyT := UΔ.View(0, 0, pMeas, pMeas)
+		Wkp1PlusT := UΔ.View(0, pMeas, UΔR-skC, UΔC-pMeas)
+
+		Skp1Plus.Clone(Skp1PlusT.T())
+		Syy.Clone(SyyT.T())
+		Wkp1Plus.Clone(Wkp1PlusT.T())
+	}
 
 	// Compute estimated measurement update \hat{y}_{k}
 	var ykHat mat64.Vector
 	ykHat.MulVec(kf.H, kf.prevEst.State())
-	ykHat.AddVec(&ykHat, kf.Noise.Measurement(kf.step))
 
 	// Compute Kalman gain.
 	var SyyInv mat64.Dense
-	if invErr := SyyInv.Inverse(&Syy); err != nil {
+	if invErr := SyyInv.Inverse(&Syy); invErr != nil {
 		return nil, fmt.Errorf("matrix Syy is not invertible: %s\nSyy=%v", invErr, mat64.Formatted(&SyyInv, mat64.Prefix("    ")))
 	}
 	var Kkp1 mat64.Dense
@@ -255,20 +392,34 @@ func (kf *SquareRoot) Update(measurement, control *mat64.Vector) (est Estimate,
 	var innovation, xkp1Plus, xkp1Plus1, xkp1Plus2 mat64.Vector
 	xkp1Plus1.MulVec(kf.H, &xKp1Minus)
 	innovation.SubVec(measurement, &xkp1Plus1)
-	if rX, _ := innovation.Dims(); rX == 1 {
-		// innovation is a scalar and mat64 won't be happy.
-		var sKkp1 mat64.Dense
-		sKkp1.Scale(innovation.At(0, 0), &Kkp1)
-		rGain, _ := sKkp1.Dims()
-		xkp1Plus2.AddVec(sKkp1.ColView(0), mat64.NewVector(rGain, nil))
-	} else {
-		xkp1Plus2.MulVec(&Kkp1, &innovation)
+	var SKp1MinusL mat64.Dense
+	SKp1MinusL.Clone(SKp1Minus.T())
+	if kf.editor != nil {
+		// The innovation covariance is Syy*Syy'.
+		var S mat64.Dense
+		S.Mul(&Syy, Syy.T())
+		SSym, serr := AsSymDense(&S)
+		if serr != nil {
+			return nil, serr
+		}
+		if kf.editor.Reject(&innovation, SSym) {
+			// Skip the measurement update.
+			sqrtEst := NewSqrtEstimate(&xKp1Minus, &ykHat, &innovation, &SKp1MinusL, &SKp1MinusL, &Kkp1)
+			sqrtEst.rejected = true
+			sqrtEst.epoch = kf.advance()
+			est = sqrtEst
+			kf.prevEst = sqrtEst
+			kf.step++
+			return
+		}
 	}
+	xkp1Plus2.MulVec(&Kkp1, &innovation)
 	xkp1Plus.AddVec(&xKp1Minus, &xkp1Plus2)
-	xkp1Plus.AddVec(&xkp1Plus, kf.Noise.Process(kf.step))
 
-	est = NewSqrtEstimate(&xkp1Plus, &ykHat, &innovation, &Skp1Plus, SKp1Minus.(*mat64.Dense), &Kkp1)
-	kf.prevEst = est.(SquareRootEstimate)
+	sqrtEst := NewSqrtEstimate(&xkp1Plus, &ykHat, &innovation, &Skp1Plus, &SKp1MinusL, &Kkp1)
+	sqrtEst.epoch = kf.advance()
+	est = sqrtEst
+	kf.prevEst = sqrtEst
 	kf.step++
 	return
 }
@@ -280,6 +431,8 @@ type SquareRootEstimate struct {
 	stddev, predStddev           *mat64.Dense
 	gain                         mat64.Matrix
 	cachedCovar, predCachedCovar mat64.Symmetric
+	rejected                     bool
+	epoch                        time.Time
 }
 
 // IsWithinNσ returns whether the estimation is within the 2σ bounds.
@@ -339,6 +492,16 @@ func (e SquareRootEstimate) PredCovariance() mat64.Symmetric {
 	return e.predCachedCovar
 }
 
+// Rejected implements the Estimate interface.
+func (e SquareRootEstimate) Rejected() bool {
+	return e.rejected
+}
+
+// Epoch implements the Estimate interface.
+func (e SquareRootEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
 // Gain the Estimate interface.
 func (e SquareRootEstimate) Gain() mat64.Matrix {
 	return e.gain
@@ -356,5 +519,5 @@ func (e SquareRootEstimate) String() string {
 
 // NewSqrtEstimate initializes a new InformationEstimate.
 func NewSqrtEstimate(state, meas, innovation *mat64.Vector, stddev, predStddev, gain *mat64.Dense) SquareRootEstimate {
-	return SquareRootEstimate{state, meas, innovation, stddev, predStddev, gain, nil, nil}
+	return SquareRootEstimate{state, meas, innovation, stddev, predStddev, gain, nil, nil, false, time.Time{}}
 }
@@ -119,3 +119,44 @@ func TestSquareRootMultiD(t *testing.T) {
 	}
 
 }
+
+func TestSquareRootMatchesVanilla(t *testing.T) {
+	// The covariances of the square root KF must match those of the vanilla KF, including with a multi-dimensional
+	// measurement and a non-diagonal R.
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(2, 2, []float64{1, 0, 0.5, 1})
+	Q := mat64.NewSymDense(2, []float64{1e-4, 1e-5, 1e-5, 1e-3})
+	R := mat64.NewSymDense(2, []float64{0.1, 0.02, 0.02, 0.05})
+	noise := NewNoiseless(Q, R)
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := mat64.NewSymDense(2, []float64{10, 1, 1, 5})
+	sqrt, _, err := NewSquareRoot(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 10; k++ {
+		y := mat64.NewVector(2, []float64{0.1 * float64(k), 0.3})
+		u := mat64.NewVector(1, []float64{0.1})
+		sEst, err := sqrt.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vEst, err := vanilla.Update(y, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mat64.EqualApprox(sEst.PredCovariance(), vEst.PredCovariance(), 1e-10) {
+			t.Fatalf("k=%d: invalid predicted covariance\n%v\n%v", k, mat64.Formatted(sEst.PredCovariance()), mat64.Formatted(vEst.PredCovariance()))
+		}
+		if !mat64.EqualApprox(sEst.Covariance(), vEst.Covariance(), 1e-10) {
+			t.Fatalf("k=%d: invalid covariance\n%v\n%v", k, mat64.Formatted(sEst.Covariance()), mat64.Formatted(vEst.Covariance()))
+		}
+		if !mat64.EqualApprox(sEst.State(), vEst.State(), 1e-10) {
+			t.Fatalf("k=%d: invalid state\n%v\n%v", k, mat64.Formatted(sEst.State()), mat64.Formatted(vEst.State()))
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/gonum/matrix/mat64"
 )
@@ -34,57 +35,161 @@ func NewSRIF(x0 *mat64.Vector, P0 mat64.Symmetric, measSize int, nonTriR bool, n
 	b0 := mat64.NewVector(r, nil)
 	b0.MulVec(&R0, x0)
 
-	// Compute the square root of the measurement noise.
-	var sqrtRchol mat64.Cholesky
-	sqrtRchol.Factorize(n.MeasurementMatrix())
-	var sqrtMeasNoise mat64.TriDense
-	sqrtMeasNoise.LFromCholesky(&sqrtRchol)
-	var sqrtInvNoise mat64.Dense
-	if err := sqrtInvNoise.Inverse(&sqrtMeasNoise); err != nil {
+	// Compute the inverse of the square root of the measurement noise, used to whiten the measurements.
+	sqrtInvNoise, err := sqrtInvMeasurementNoise(n.MeasurementMatrix())
+	if err != nil {
 		return nil, nil, err
 	}
 	// Populate with the initial values.
 	est0 := NewSRIFEstimate(nil, b0, nil, nil, &R0, &R0)
-	return &SRIF{nil, nil, &sqrtMeasNoise, &est0, nonTriR, true, measSize, 0}, &est0, nil
+	return &SRIF{Noise: n, sqrtInvNoise: sqrtInvNoise, prevEst: &est0, nonTriR: nonTriR, locked: true, measSize: measSize}, &est0, nil
 }
 
 // SRIF defines a square root information filter for non-linear dynamical systems. Use NewSquareRootInformation to initialize.
 type SRIF struct {
-	Φ, Htilde    *mat64.Dense
+	Φ, Htilde, Γ *mat64.Dense
+	Noise        Noise
 	sqrtInvNoise mat64.Matrix
+	measDesc     *MeasurementDescriptor // Descriptor of the next measurement, if set with PrepareMeasurement.
+	measSqrtInv  mat64.Matrix           // Whitening matrix of the descriptor's measurement noise.
+	dmcQ         mat64.Symmetric        // Process noise of the DMC accelerations, used instead of the Noise's for the next update.
 	prevEst      *SRIFEstimate
 	nonTriR      bool // Do not a triangular R
 	locked       bool // Locks the KF to ensure Prepare is called.
+	sncEnabled   bool // Stores whether we should enable or disable the state noise compensation.
+	ekfMode      bool // Allows switching between CKF and EKF.
 	measSize     int  // Stores the measurement vector size, needed only for Predict()
 	step         int
+	editor       MeasurementEditor
+	sequential   bool // Process each scalar measurement one at a time.
+	timeTagger
 }
 
-// EKFEnabled always returns false.
+// EKFEnabled returns whether the KF is in EKF mode.
 func (kf *SRIF) EKFEnabled() bool {
-	return false
+	return kf.ekfMode
 }
 
-// DisableEKF does nothing.
-func (kf *SRIF) DisableEKF() {}
+// EnableEKF switches this to an EKF mode. In this mode, the reference trajectory is assumed to be updated
+// with the latest estimate after each measurement update, so the time update starts from a zero deviation.
+func (kf *SRIF) EnableEKF() {
+	kf.ekfMode = true
+}
 
-// EnableEKF does nothing.
-func (kf *SRIF) EnableEKF() {}
+// DisableEKF switches this back to a CKF mode.
+func (kf *SRIF) DisableEKF() {
+	kf.ekfMode = false
+}
+
+func (kf *SRIF) String() string {
+	return fmt.Sprintf("SRIF [k=%d]\n%s", kf.step, kf.Noise)
+}
 
-// PreparePNT does nothing.
-func (kf *SRIF) PreparePNT(Γ *mat64.Dense) {}
+// PreparePNT prepares the process noise transition matrix and enabled the SNC
+// for the next update. WARNING: If not called, the SNC *will not* be included.
+func (kf *SRIF) PreparePNT(Γ *mat64.Dense) {
+	kf.Γ = Γ
+	kf.sncEnabled = true
+}
 
-// SetNoise updates the Noise.
+// SetNoise updates the Noise and recomputes the whitening matrix of the measurements.
+// NOTE: Panics if the measurement noise is not positive definite.
 func (kf *SRIF) SetNoise(n Noise) {
-	panic("noise not yet supported for SRIF")
+	sqrtInvNoise, err := sqrtInvMeasurementNoise(n.MeasurementMatrix())
+	if err != nil {
+		panic(fmt.Errorf("invalid measurement noise: %s", err))
+	}
+	kf.Noise = n
+	kf.sqrtInvNoise = sqrtInvNoise
+}
+
+// GetNoise returns the Noise.
+func (kf *SRIF) GetNoise() Noise {
+	return kf.Noise
+}
+
+// SequentialEnabled returns whether the measurements are processed one scalar at a time.
+func (kf *SRIF) SequentialEnabled() bool {
+	return kf.sequential
+}
+
+// EnableSequential switches to triangularizing each whitened scalar measurement one at a time.
+// Measurement editing is still applied to the whole measurement vector.
+func (kf *SRIF) EnableSequential() {
+	kf.sequential = true
+}
+
+// DisableSequential switches back to processing the whole measurement vector at once.
+func (kf *SRIF) DisableSequential() {
+	kf.sequential = false
+}
+
+// SetMeasurementEditor sets the measurement editing policy used to reject outliers (nil to disable).
+func (kf *SRIF) SetMeasurementEditor(e MeasurementEditor) {
+	kf.editor = e
 }
 
 // Prepare unlocks the KF ready for the next Update call.
 func (kf *SRIF) Prepare(Φ, Htilde *mat64.Dense) {
 	kf.Φ = Φ
 	kf.Htilde = Htilde
+	kf.measDesc = nil
+	kf.measSqrtInv = nil
+	kf.dmcQ = nil
 	kf.locked = false
 }
 
+// PrepareDMC unlocks the KF ready for the next Update call with dynamic model compensation.
+// Φ and Htilde are those of the base state; they are augmented with the DMC accelerations, and the process noise
+// of the augmented state over Δt replaces the Noise's process matrix for the next update only.
+// NOTE: the KF must have been initialized with the augmented state (cf. DMC.AugmentState).
+func (kf *SRIF) PrepareDMC(dmc *DMC, Φ, Htilde *mat64.Dense, Δt float64) error {
+	ΦAug, err := dmc.STM(Φ, Δt)
+	if err != nil {
+		return err
+	}
+	n, _ := Φ.Dims()
+	if size := kf.prevEst.sqinfoState.Len(); size != n+dmc.Size() {
+		return fmt.Errorf("state of size %d is not augmented with the %d DMC accelerations of a base state of size %d", size, dmc.Size(), n)
+	}
+	kf.Prepare(ΦAug, dmc.Htilde(Htilde))
+	kf.PreparePNT(DenseIdentity(n + dmc.Size()))
+	kf.dmcQ = dmc.ProcessNoise(n, Δt)
+	return nil
+}
+
+// PrepareMeasurement unlocks the KF ready for the next Update call with a measurement whose size and noise
+// may differ from the previous ones. The descriptor's R is used instead of the Noise's for the next update only.
+func (kf *SRIF) PrepareMeasurement(Φ *mat64.Dense, m *MeasurementDescriptor) error {
+	if err := checkMatDims(Φ, m.Htilde, "Φ", "H", cols2cols); err != nil {
+		return err
+	}
+	sqrtInv, err := sqrtInvMeasurementNoise(m.R)
+	if err != nil {
+		return err
+	}
+	kf.Prepare(Φ, m.Htilde)
+	kf.measDesc = m
+	kf.measSqrtInv = sqrtInv
+	return nil
+}
+
+// measurementNoise returns the measurement noise of the next update and its whitening matrix.
+func (kf *SRIF) measurementNoise() (R mat64.Symmetric, sqrtInv mat64.Matrix) {
+	if kf.measDesc != nil {
+		return kf.measDesc.R, kf.measSqrtInv
+	}
+	return kf.Noise.MeasurementMatrix(), kf.sqrtInvNoise
+}
+
+// measurementLabels returns the labels of the next measurement, if any.
+func (kf *SRIF) measurementLabels() []string {
+	if kf.measDesc != nil {
+		return kf.measDesc.Labels
+	}
+	return nil
+}
+
 // Update computes a full time and measurement update.
 // Will return an error if the KF is locked (call Prepare to unlock).
 func (kf *SRIF) Update(realObservation, computedObservation *mat64.Vector) (est Estimate, err error) {
@@ -106,33 +211,57 @@ func (kf *SRIF) fullUpdate(purePrediction bool, realObservation, computedObserva
 		if err = checkMatDims(realObservation, computedObservation, "real observation", "computed observation", rowsAndcols); err != nil {
 			return nil, err
 		}
+		if err = checkMatDims(realObservation, kf.Htilde, "observation", "H", rows2rows); err != nil {
+			return nil, err
+		}
 	}
-	// RBar
-	var RBar, invΦ mat64.Dense
-	if ierr := invΦ.Inverse(kf.Φ); ierr != nil {
-		return nil, fmt.Errorf("could not invert `Φ` at k=%d: %s", kf.step, ierr)
-	}
-	RBar.Mul(kf.prevEst.R, &invΦ)
-
-	var xBar, bBar mat64.Vector
-	xBar.MulVec(kf.Φ, kf.prevEst.State())
-	bBar.MulVec(&RBar, &xBar)
-
-	if !kf.nonTriR {
-		// Make Rbar triangular.
-		rR, _ := RBar.Dims()
-		A := mat64.NewDense(rR, rR+1, nil)
-		A.Augment(&RBar, &bBar)
-		// Extract the new RBar and bBar
-		RBar = *(A.Slice(0, rR, 0, rR).(*mat64.Dense))
-		bBarMat := A.Slice(0, rR, rR, rR+1)
-		for i := 0; i < rR; i++ {
-			bBar.SetVec(i, bBarMat.At(i, 0))
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		n, _ := kf.Φ.Dims()
+		kf.Φ = DenseIdentity(n)
+		kf.sncEnabled = false
+		kf.dmcQ = nil
+	}
+	// Time update
+	var Γ, Rw *mat64.Dense
+	if kf.sncEnabled {
+		// With Q = L*Lᵀ, the process noise is Γw = (ΓL)ξ where ξ has a unit covariance, so Q may be singular.
+		Q := kf.Noise.ProcessMatrix()
+		if kf.dmcQ != nil {
+			Q = kf.dmcQ
+		}
+		L, lerr := sqrtProcessNoise(Q)
+		if lerr != nil {
+			return nil, fmt.Errorf("process noise at k=%d: %s", kf.step, lerr)
 		}
+		if L != nil {
+			if err = checkMatDims(kf.Γ, L, "Γ", "Q", cols2rows); err != nil {
+				return nil, err
+			}
+			_, q := L.Dims()
+			var ΓL mat64.Dense
+			ΓL.Mul(kf.Γ, L)
+			Γ = &ΓL
+			Rw = DenseIdentity(q)
+		}
+	}
+	b := kf.prevEst.sqinfoState
+	if kf.ekfMode {
+		// The reference trajectory was updated with the previous estimate, so the deviation is zero.
+		b = mat64.NewVector(b.Len(), nil)
 	}
+	RBar, bBar, RBarw, RBarwx, bBarw, err := timeSRIFUpdate(kf.prevEst.R, b, kf.Φ, Γ, Rw, !kf.nonTriR)
+	if err != nil {
+		return nil, fmt.Errorf("time update at k=%d: %s", kf.step, err)
+	}
+	Φ := mat64.DenseCopyOf(kf.Φ)
+	kf.sncEnabled = false
+	kf.dmcQ = nil
 
 	if purePrediction {
-		tmpEst := NewSRIFEstimate(kf.Φ, &bBar, mat64.NewVector(kf.measSize, nil), mat64.NewVector(kf.measSize, nil), &RBar, &RBar)
+		tmpEst := NewSRIFEstimate(Φ, bBar, mat64.NewVector(kf.measSize, nil), mat64.NewVector(kf.measSize, nil), RBar, RBar)
+		tmpEst.setProcessNoise(Γ, RBarw, RBarwx, bBarw)
+		tmpEst.epoch = kf.advance()
 		est = &tmpEst
 		kf.prevEst = est.(*SRIFEstimate)
 		kf.step++
@@ -142,16 +271,58 @@ func (kf *SRIF) fullUpdate(purePrediction bool, realObservation, computedObserva
 	// Compute observation deviation y
 	var y mat64.Vector
 	y.SubVec(realObservation, computedObservation)
+	R, sqrtInvNoise := kf.measurementNoise()
+	if kf.editor != nil {
+		var RBarInv, PBar mat64.Dense
+		if ierr := RBarInv.Inverse(RBar); ierr == nil {
+			PBar.Mul(&RBarInv, RBarInv.T())
+			var xBar, innov mat64.Vector
+			xBar.MulVec(&RBarInv, bBar)
+			innov.MulVec(kf.Htilde, &xBar)
+			innov.SubVec(&y, &innov)
+			S, serr := innovationCovariance(kf.Htilde, &PBar, R)
+			if serr != nil {
+				return nil, serr
+			}
+			if kf.editor.Reject(&innov, S) {
+				// Skip the measurement update.
+				tmpEst := NewSRIFEstimate(Φ, bBar, realObservation, &y, RBar, RBar)
+				tmpEst.setProcessNoise(Γ, RBarw, RBarwx, bBarw)
+				tmpEst.rejected = true
+				tmpEst.labels = kf.measurementLabels()
+				tmpEst.epoch = kf.advance()
+				est = &tmpEst
+				kf.prevEst = est.(*SRIFEstimate)
+				kf.step++
+				kf.locked = true
+				return
+			}
+		}
+	}
 	// Whiten the H and y
 	var Htilde mat64.Dense
-	Htilde.Mul(kf.sqrtInvNoise, kf.Htilde)
-	y.MulVec(kf.sqrtInvNoise, &y)
-
-	Rk, bk, _, err := measurementSRIFUpdate(&RBar, &Htilde, &bBar, &y)
-	if err != nil {
+	Htilde.Mul(sqrtInvNoise, kf.Htilde)
+	y.MulVec(sqrtInvNoise, &y)
+
+	var Rk *mat64.Dense
+	var bk *mat64.Vector
+	if kf.sequential {
+		// Triangularize one whitened scalar measurement at a time.
+		Rk, bk = RBar, bBar
+		_, n := Htilde.Dims()
+		for i := 0; i < y.Len(); i++ {
+			hi := mat64.DenseCopyOf(Htilde.Slice(i, i+1, 0, n))
+			if Rk, bk, _, err = measurementSRIFUpdate(Rk, hi, bk, mat64.NewVector(1, []float64{y.At(i, 0)})); err != nil {
+				return nil, err
+			}
+		}
+	} else if Rk, bk, _, err = measurementSRIFUpdate(RBar, &Htilde, bBar, &y); err != nil {
 		return nil, err
 	}
-	tmpEst := NewSRIFEstimate(kf.Φ, bk, realObservation, &y, Rk, &RBar)
+	tmpEst := NewSRIFEstimate(Φ, bk, realObservation, &y, Rk, RBar)
+	tmpEst.setProcessNoise(Γ, RBarw, RBarwx, bBarw)
+	tmpEst.labels = kf.measurementLabels()
+	tmpEst.epoch = kf.advance()
 	est = &tmpEst
 	kf.prevEst = est.(*SRIFEstimate)
 	kf.step++
@@ -159,7 +330,9 @@ func (kf *SRIF) fullUpdate(purePrediction bool, realObservation, computedObserva
 	return
 }
 
-// SmoothAll will smooth all the previous estimates using the provided data. Returns the smoothed estimates.
+// SmoothAll will smooth all the previous estimates using the square root information smoother
+// (cf. Bierman, "Factorization Methods for Discrete Sequential Estimation"), which directly computes
+// the smoothed R and b of each estimate, and accounts for the process noise if SNC was enabled.
 // Will return an error if there are more estimates than there should be.
 // WARNING: overwrites the provided array of estimates.
 func (kf *SRIF) SmoothAll(estimates []*SRIFEstimate) (err error) {
@@ -169,24 +342,41 @@ func (kf *SRIF) SmoothAll(estimates []*SRIFEstimate) (err error) {
 	l := len(estimates) - 1
 	for k := l - 1; k >= 0; k-- {
 		estimateKp1 := estimates[k+1]
-		// SNC was not enabled for this estimate.
-		var S, SP, SPSt mat64.Dense
-		if ierr := S.Inverse(estimateKp1.Φ); ierr != nil {
-			return errors.New("provided STM Φ is not invertible")
+		n, _ := estimateKp1.R.Dims()
+		q := 0
+		if estimateKp1.Γ != nil {
+			_, q = estimateKp1.Γ.Dims()
 		}
-		SP.Mul(&S, estimateKp1.Covariance())
-		SPSt.Mul(&SP, S.T())
-		var xHat mat64.Vector
-		xHat.MulVec(&S, estimateKp1.State())
-		Pkl, serr := AsSymDense(&SPSt)
-		if serr != nil {
-			err = serr
-			return
+		// Substitute x_{k+1} = Φ x_k + Γ w_k in the smoothed data equation at k+1, and in the process
+		// noise data equation from the time update, then triangularize with w_k first:
+		// [Rw + Rwx*Γ  Rwx*Φ | bw     ]    [* * | *      ]
+		// [R*Γ         R*Φ   | b      ] -> [0 R | b_{k}^{l} ]
+		A := mat64.NewDense(q+n, q+n+1, nil)
+		var RΦ mat64.Dense
+		RΦ.Mul(estimateKp1.R, estimateKp1.Φ)
+		setBlock(A, q, q, &RΦ)
+		setBlock(A, q, q+n, estimateKp1.sqinfoState)
+		if q > 0 {
+			var RwxΓ, RwxΦ, RΓ mat64.Dense
+			RwxΓ.Mul(estimateKp1.rwx, estimateKp1.Γ)
+			RwxΓ.Add(estimateKp1.rw, &RwxΓ)
+			RwxΦ.Mul(estimateKp1.rwx, estimateKp1.Φ)
+			RΓ.Mul(estimateKp1.R, estimateKp1.Γ)
+			setBlock(A, 0, 0, &RwxΓ)
+			setBlock(A, 0, q, &RwxΦ)
+			setBlock(A, 0, q+n, estimateKp1.bw)
+			setBlock(A, q, 0, &RΓ)
 		}
-		// WARNING: We do not update the actual values to compute the state and covariance, only the cached values.
-		estimates[k].cachedState = &xHat
-		estimates[k].cCovar = Pkl
-
+		HouseholderTransf(A, q+n, 0)
+		Rkl := mat64.DenseCopyOf(A.Slice(q, q+n, q, q+n))
+		bkl := mat64.NewVector(n, nil)
+		for i := 0; i < n; i++ {
+			bkl.SetVec(i, A.At(q+i, q+n))
+		}
+		estimates[k].R = Rkl
+		estimates[k].sqinfoState = bkl
+		estimates[k].cachedState = nil
+		estimates[k].cCovar = nil
 	}
 	return
 }
@@ -194,11 +384,16 @@ func (kf *SRIF) SmoothAll(estimates []*SRIFEstimate) (err error) {
 // SRIFEstimate is the output of each update state of the Vanilla KF.
 // It implements the Estimate interface.
 type SRIFEstimate struct {
-	Φ                  *mat64.Dense // Used for smoothing
+	Φ, Γ               *mat64.Dense // Used for smoothing
 	sqinfoState, meas  *mat64.Vector
 	Δobs, cachedState  *mat64.Vector
 	R, predR           *mat64.Dense
 	cCovar, cPredCovar mat64.Symmetric
+	rw, rwx            *mat64.Dense  // Process noise square root information from the time update, used for smoothing
+	bw                 *mat64.Vector // Process noise information state from the time update, used for smoothing
+	rejected           bool
+	labels             []string
+	epoch              time.Time
 }
 
 // IsWithinNσ returns whether the estimation is within the 2σ bounds.
@@ -243,6 +438,21 @@ func (e SRIFEstimate) Measurement() *mat64.Vector {
 	return e.meas
 }
 
+// Rejected implements the Estimate interface.
+func (e SRIFEstimate) Rejected() bool {
+	return e.rejected
+}
+
+// Epoch implements the Estimate interface.
+func (e SRIFEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
+// MeasurementLabels implements the LabeledEstimate interface.
+func (e SRIFEstimate) MeasurementLabels() []string {
+	return e.labels
+}
+
 // ObservationDev returns the observation deviation.
 func (e SRIFEstimate) ObservationDev() *mat64.Vector {
 	return e.Δobs
@@ -291,7 +501,142 @@ func (e SRIFEstimate) String() string {
 // NewSRIFEstimate initializes a new SRIFEstimate.
 // NOTE: R0 and predR0 are mat64.Dense for simplicity of implementation, but they should be symmetric.
 func NewSRIFEstimate(Φ *mat64.Dense, sqinfoState, meas, Δobs *mat64.Vector, R0, predR0 *mat64.Dense) SRIFEstimate {
-	return SRIFEstimate{Φ, sqinfoState, meas, Δobs, nil, R0, predR0, nil, nil}
+	return SRIFEstimate{Φ, nil, sqinfoState, meas, Δobs, nil, R0, predR0, nil, nil, nil, nil, nil, false, nil, time.Time{}}
+}
+
+// setProcessNoise stores the process noise terms of the time update, needed for smoothing.
+func (e *SRIFEstimate) setProcessNoise(Γ, rw, rwx *mat64.Dense, bw *mat64.Vector) {
+	e.Γ = Γ
+	e.rw = rw
+	e.rwx = rwx
+	e.bw = bw
+}
+
+// sqrtProcessNoise returns L such that L*Lᵀ = Q, where the columns of the zero variance components of Q are
+// dropped, e.g. for SNC without noise on some axes. Returns nil if Q is zero.
+func sqrtProcessNoise(Q mat64.Symmetric) (*mat64.Dense, error) {
+	n := Q.Symmetric()
+	tol := 0.0
+	for i := 0; i < n; i++ {
+		tol = math.Max(tol, math.Abs(Q.At(i, i)))
+	}
+	tol *= 1e-12
+	// Cholesky–Banachiewicz factorization which skips the null pivots.
+	L := mat64.NewDense(n, n, nil)
+	var cols []int
+	for j := 0; j < n; j++ {
+		d := Q.At(j, j)
+		for k := 0; k < j; k++ {
+			d -= L.At(j, k) * L.At(j, k)
+		}
+		if d < -tol {
+			return nil, errors.New("covariance is not positive semi-definite")
+		}
+		pivot := 0.0
+		if d > tol {
+			pivot = math.Sqrt(d)
+			L.Set(j, j, pivot)
+			cols = append(cols, j)
+		}
+		for i := j + 1; i < n; i++ {
+			s := Q.At(i, j)
+			for k := 0; k < j; k++ {
+				s -= L.At(i, k) * L.At(j, k)
+			}
+			if pivot > 0 {
+				L.Set(i, j, s/pivot)
+			} else if math.Abs(s) > tol {
+				return nil, errors.New("covariance is not positive semi-definite")
+			}
+		}
+	}
+	if len(cols) == 0 {
+		return nil, nil
+	}
+	Lr := mat64.NewDense(n, len(cols), nil)
+	for c, j := range cols {
+		for i := 0; i < n; i++ {
+			Lr.Set(i, c, L.At(i, j))
+		}
+	}
+	return Lr, nil
+}
+
+// sqrtInvMeasurementNoise returns the inverse of the lower Cholesky factor of the measurement noise.
+func sqrtInvMeasurementNoise(R mat64.Symmetric) (*mat64.Dense, error) {
+	var sqrtRchol mat64.Cholesky
+	if ok := sqrtRchol.Factorize(R); !ok {
+		return nil, errors.New("measurement noise is not positive definite")
+	}
+	var sqrtMeasNoise mat64.TriDense
+	sqrtMeasNoise.LFromCholesky(&sqrtRchol)
+	var sqrtInvNoise mat64.Dense
+	if err := sqrtInvNoise.Inverse(&sqrtMeasNoise); err != nil {
+		return nil, err
+	}
+	return &sqrtInvNoise, nil
+}
+
+// setBlock copies the provided matrix into m starting at (i, j).
+func setBlock(m *mat64.Dense, i, j int, b mat64.Matrix) {
+	r, c := b.Dims()
+	for bi := 0; bi < r; bi++ {
+		for bj := 0; bj < c; bj++ {
+			m.Set(i+bi, j+bj, b.At(bi, bj))
+		}
+	}
+}
+
+// timeSRIFUpdate performs the time update of the square root information R and information state b.
+// If Γ is provided, the process noise (of square root information matrix Rw) is accounted for via the
+// augmented Householder triangularization, and the process noise terms needed for smoothing are returned.
+// Without process noise, the returned RBar is only triangularized if requested.
+func timeSRIFUpdate(R *mat64.Dense, b *mat64.Vector, Φ, Γ, Rw *mat64.Dense, triangularize bool) (RBar *mat64.Dense, bBar *mat64.Vector, RBarw, RBarwx *mat64.Dense, bBarw *mat64.Vector, err error) {
+	var invΦ, RinvΦ mat64.Dense
+	if ierr := invΦ.Inverse(Φ); ierr != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("could not invert `Φ`: %s", ierr)
+	}
+	RinvΦ.Mul(R, &invΦ)
+	n, _ := b.Dims()
+	q := 0
+	if Γ != nil {
+		if err = checkMatDims(Φ, Γ, "Φ", "Γ", rows2rows); err != nil {
+			return
+		}
+		if err = checkMatDims(Γ, Rw, "Γ", "Q", cols2cols); err != nil {
+			return
+		}
+		_, q = Γ.Dims()
+	} else if !triangularize {
+		return &RinvΦ, b, nil, nil, nil, nil
+	}
+	// [Rw        0      | 0]    [RBarw RBarwx | bBarw]
+	// [-RΦ^{-1}Γ RΦ^{-1} | b] -> [0     RBar   | bBar ]
+	A := mat64.NewDense(q+n, q+n+1, nil)
+	setBlock(A, q, q, &RinvΦ)
+	setBlock(A, q, q+n, b)
+	if q > 0 {
+		var RinvΦΓ mat64.Dense
+		RinvΦΓ.Mul(&RinvΦ, Γ)
+		RinvΦΓ.Scale(-1, &RinvΦΓ)
+		setBlock(A, 0, 0, Rw)
+		setBlock(A, q, 0, &RinvΦΓ)
+	}
+	HouseholderTransf(A, q+n, 0)
+	RBar = mat64.DenseCopyOf(A.Slice(q, q+n, q, q+n))
+	bBar = mat64.NewVector(n, nil)
+	for i := 0; i < n; i++ {
+		bBar.SetVec(i, A.At(q+i, q+n))
+	}
+	if q > 0 {
+		RBarw = mat64.DenseCopyOf(A.Slice(0, q, 0, q))
+		RBarwx = mat64.DenseCopyOf(A.Slice(0, q, q, q+n))
+		bBarw = mat64.NewVector(q, nil)
+		for i := 0; i < q; i++ {
+			bBarw.SetVec(i, A.At(i, q+n))
+		}
+	}
+	return
 }
 
 // measurementSRIFUpdate prepare the matrix and performs the Householder transformation.
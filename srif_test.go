@@ -3,12 +3,11 @@ package gokalman
 import (
 	"fmt"
 	"math"
-	"os"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/ChristopherRabotin/smd"
+	"github.com/ChristopherRabotin/gokalman/od"
 	"github.com/gonum/matrix/mat64"
 )
 
@@ -55,7 +54,200 @@ func TestSRIFUpdate(t *testing.T) {
 	}
 }
 
-// The following is an example of StatOD using smd and gokalman
+func TestSRIFSmoothSNC(t *testing.T) {
+	// 1D constant velocity with white acceleration noise, observed in position only.
+	Δt := 1.0
+	Φ := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	Γ := mat64.NewDense(2, 1, []float64{Δt * Δt / 2, Δt})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	R := mat64.NewSymDense(1, []float64{0.25})
+	ys := []float64{0.3, 1.1, 1.8, 3.4, 3.9, 5.2, 5.8, 7.1, 8.3, 8.8, 10.4, 10.9}
+	for _, q := range []float64{0, 1e-2} {
+		noise := NewNoiseless(mat64.NewSymDense(1, []float64{q}), R)
+		hkf, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 10), noise, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srif, _, err := NewSRIF(mat64.NewVector(2, nil), ScaledIdentity(2, 10), 1, false, noise)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hkfEsts := make([]*HybridKFEstimate, len(ys))
+		srifEsts := make([]*SRIFEstimate, len(ys))
+		for k, y := range ys {
+			hkf.Prepare(Φ, H)
+			srif.Prepare(Φ, H)
+			if q > 0 {
+				hkf.PreparePNT(Γ)
+				srif.PreparePNT(Γ)
+			}
+			var hEst, sEst Estimate
+			if k == 6 {
+				// Check that predictions are also smoothed.
+				hEst, err = hkf.Predict()
+				if err != nil {
+					t.Fatal(err)
+				}
+				sEst, err = srif.Predict()
+			} else {
+				realObs := mat64.NewVector(1, []float64{y})
+				hEst, err = hkf.Update(realObs, mat64.NewVector(1, nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+				sEst, err = srif.Update(realObs, mat64.NewVector(1, nil))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !mat64.EqualApprox(hEst.State(), sEst.State(), 1e-8) {
+				t.Fatalf("q=%f k=%d: SRIF state differs from CKF\n%v\n%v", q, k, mat64.Formatted(hEst.State()), mat64.Formatted(sEst.State()))
+			}
+			if !mat64.EqualApprox(hEst.Covariance(), sEst.Covariance(), 1e-8) {
+				t.Fatalf("q=%f k=%d: SRIF covariance differs from CKF\n%v\n%v", q, k, mat64.Formatted(hEst.Covariance()), mat64.Formatted(sEst.Covariance()))
+			}
+			hkfEsts[k] = hEst.(*HybridKFEstimate)
+			srifEsts[k] = sEst.(*SRIFEstimate)
+		}
+		if err := hkf.SmoothAll(hkfEsts); err != nil {
+			t.Fatal(err)
+		}
+		if err := srif.SmoothAll(srifEsts); err != nil {
+			t.Fatal(err)
+		}
+		for k := range ys {
+			if !mat64.EqualApprox(hkfEsts[k].State(), srifEsts[k].State(), 1e-8) {
+				t.Fatalf("q=%f k=%d: SRIF smoothed state differs from RTS\n%v\n%v", q, k, mat64.Formatted(hkfEsts[k].State()), mat64.Formatted(srifEsts[k].State()))
+			}
+			if !mat64.EqualApprox(hkfEsts[k].Covariance(), srifEsts[k].Covariance(), 1e-8) {
+				t.Fatalf("q=%f k=%d: SRIF smoothed covariance differs from RTS\n%v\n%v", q, k, mat64.Formatted(hkfEsts[k].Covariance()), mat64.Formatted(srifEsts[k].Covariance()))
+			}
+		}
+	}
+}
+
+func TestSRIFSingularProcessNoise(t *testing.T) {
+	// SNC on position and velocity, without any noise on the position.
+	Δt := 1.0
+	Φ := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	Γ := DenseIdentity(2)
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	R := mat64.NewSymDense(1, []float64{0.25})
+	ys := []float64{0.3, 1.1, 1.8, 3.4, 3.9, 5.2, 5.8, 7.1}
+	for _, Q := range []*mat64.SymDense{
+		mat64.NewSymDense(2, []float64{0, 0, 0, 1e-2}),
+		mat64.NewSymDense(2, []float64{1e-2, 1e-2, 1e-2, 1e-2}),
+		mat64.NewSymDense(2, nil),
+	} {
+		noise := NewNoiseless(Q, R)
+		hkf, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 10), noise, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srif, _, err := NewSRIF(mat64.NewVector(2, nil), ScaledIdentity(2, 10), 1, false, noise)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hkfEsts := make([]*HybridKFEstimate, len(ys))
+		srifEsts := make([]*SRIFEstimate, len(ys))
+		for k, y := range ys {
+			hkf.Prepare(Φ, H)
+			srif.Prepare(Φ, H)
+			hkf.PreparePNT(Γ)
+			srif.PreparePNT(Γ)
+			realObs := mat64.NewVector(1, []float64{y})
+			hEst, err := hkf.Update(realObs, mat64.NewVector(1, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sEst, err := srif.Update(realObs, mat64.NewVector(1, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !mat64.EqualApprox(hEst.Covariance(), sEst.Covariance(), 1e-8) {
+				t.Fatalf("k=%d: SRIF covariance differs from CKF\n%v\n%v", k, mat64.Formatted(hEst.Covariance()), mat64.Formatted(sEst.Covariance()))
+			}
+			hkfEsts[k] = hEst.(*HybridKFEstimate)
+			srifEsts[k] = sEst.(*SRIFEstimate)
+		}
+		if err := hkf.SmoothAll(hkfEsts); err != nil {
+			t.Fatal(err)
+		}
+		if err := srif.SmoothAll(srifEsts); err != nil {
+			t.Fatal(err)
+		}
+		for k := range ys {
+			if !mat64.EqualApprox(hkfEsts[k].State(), srifEsts[k].State(), 1e-8) {
+				t.Fatalf("k=%d: SRIF smoothed state differs from RTS\n%v\n%v", k, mat64.Formatted(hkfEsts[k].State()), mat64.Formatted(srifEsts[k].State()))
+			}
+		}
+	}
+	if _, err := sqrtProcessNoise(mat64.NewSymDense(2, []float64{0, 1, 1, 1})); err == nil {
+		t.Fatal("expected an error for a covariance which is not positive semi-definite")
+	}
+}
+
+func TestSRIFEKF(t *testing.T) {
+	Δt := 1.0
+	Φ := mat64.NewDense(2, 2, []float64{1, Δt, 0, 1})
+	Γ := mat64.NewDense(2, 1, []float64{Δt * Δt / 2, Δt})
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	noise := NewNoiseless(mat64.NewSymDense(1, []float64{1e-2}), mat64.NewSymDense(1, []float64{0.25}))
+	hkf, _, err := NewHybridKF(mat64.NewVector(2, nil), ScaledIdentity(2, 10), noise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srif, _, err := NewSRIF(mat64.NewVector(2, nil), ScaledIdentity(2, 10), 1, false, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srif.EKFEnabled() {
+		t.Fatal("SRIF should start in CKF mode")
+	}
+	ys := []float64{0.3, 0.8, 0.7, 1.6, 0.5, 1.3, 0.6, 1.3}
+	for k, y := range ys {
+		if k == 2 {
+			hkf.EnableEKF()
+			srif.EnableEKF()
+			if !srif.EKFEnabled() {
+				t.Fatal("SRIF should be in EKF mode")
+			}
+		}
+		if k == 5 {
+			noise2 := NewNoiseless(mat64.NewSymDense(1, []float64{1e-2}), mat64.NewSymDense(1, []float64{0.04}))
+			hkf.SetNoise(noise2)
+			srif.SetNoise(noise2)
+			if !mat64.Equal(noise2.MeasurementMatrix(), srif.GetNoise().MeasurementMatrix()) {
+				t.Fatal("GetNoise/SetNoise issue")
+			}
+		}
+		hkf.Prepare(Φ, H)
+		srif.Prepare(Φ, H)
+		hkf.PreparePNT(Γ)
+		srif.PreparePNT(Γ)
+		realObs := mat64.NewVector(1, []float64{y})
+		hEst, err := hkf.Update(realObs, mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sEst, err := srif.Update(realObs, mat64.NewVector(1, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mat64.EqualApprox(hEst.State(), sEst.State(), 1e-8) {
+			t.Fatalf("k=%d: SRIF state differs from HybridKF\n%v\n%v", k, mat64.Formatted(hEst.State()), mat64.Formatted(sEst.State()))
+		}
+		if !mat64.EqualApprox(hEst.Covariance(), sEst.Covariance(), 1e-8) {
+			t.Fatalf("k=%d: SRIF covariance differs from HybridKF\n%v\n%v", k, mat64.Formatted(hEst.Covariance()), mat64.Formatted(sEst.Covariance()))
+		}
+	}
+	srif.DisableEKF()
+	if srif.EKFEnabled() {
+		t.Fatal("SRIF should be back in CKF mode")
+	}
+}
+
+// The following is an example of StatOD using the od package and gokalman
 var wg sync.WaitGroup
 
 func TestSRIFFullODExample(t *testing.T) {
@@ -70,89 +262,48 @@ func _SRIFFullODExample(smoothing bool, t *testing.T) {
 	startDT := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
 	endDT := startDT.Add(time.Duration(24) * time.Hour)
 	// Define the orbits
-	leo := smd.NewOrbitFromOE(7000, 0.001, 30, 80, 40, 0, smd.Earth)
+	leo := od.StateFromOE(7000, 0.001, 30, 80, 40, 0, od.EarthMu)
 
 	// Define the stations
 	σρ := math.Pow(1e-3, 2)    // m , but all measurements in km.
 	σρDot := math.Pow(1e-3, 2) // m/s , but all measurements in km/s.
-	st1 := smd.NewStation("st1", 0, 10, -35.398333, 148.981944, σρ, σρDot)
-	st2 := smd.NewStation("st2", 0, 10, 40.427222, 355.749444, σρ, σρDot)
-	st3 := smd.NewStation("st3", 0, 10, 35.247164, 243.205, σρ, σρDot)
-	stations := []smd.Station{st1, st2, st3}
-
-	measurements := make(map[time.Time]smd.Measurement)
-	measurementTimes := []time.Time{}
-	numMeasurements := 0 // Easier to count them here than to iterate the map to count.
-
-	// Define the special export functions
-	export := smd.ExportConfig{Filename: "SRIFFullOD", Cosmo: false, AsCSV: true, Timestamp: false}
-	export.CSVAppendHdr = func() string {
-		hdr := "secondsSinceEpoch,"
-		for _, st := range stations {
-			hdr += fmt.Sprintf("%sRange,%sRangeRate,%sNoisyRange,%sNoisyRangeRate,", st.Name, st.Name, st.Name, st.Name)
-		}
-		return hdr[:len(hdr)-1] // Remove trailing comma
-	}
-	export.CSVAppend = func(state smd.State) string {
-		Δt := state.DT.Sub(startDT).Seconds()
-		str := fmt.Sprintf("%f,", Δt)
-		θgst := Δt * smd.EarthRotationRate
-		roundedDT := state.DT.Truncate(time.Second)
-		// Compute visibility for each station.
-		for _, st := range stations {
-			measurement := st.PerformMeasurement(θgst, state)
-			if measurement.Visible {
-				// Sanity check
-				if _, exists := measurements[roundedDT]; exists {
-					t.Fatalf("already have a measurement for %s", state.DT)
-				}
-				measurements[roundedDT] = measurement
-				measurementTimes = append(measurementTimes, roundedDT)
-				numMeasurements++
-				str += measurement.CSV()
-			} else {
-				str += ",,,,"
-			}
-		}
-		return str[:len(str)-1] // Remove trailing comma
-	}
+	stations := odStations(σρ, σρDot, startDT)
 
-	// Generate the true orbit -- Mtrue
+	// Generate the true orbit and the measurements.
 	timeStep := 10 * time.Second
 	scName := "LEO"
-	smd.NewPreciseMission(smd.NewEmptySC(scName, 0), leo, startDT, endDT, smd.Perturbations{Jn: 2}, timeStep, false, export).Propagate()
+	measurements, err := simulateOD(leo, stations, startDT, endDT, timeStep)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
 
 	// Let's mark those as the truth so we can plot that.
 	stateTruth := make([]*mat64.Vector, len(measurements))
 	truthMeas := make([]*mat64.Vector, len(measurements))
-	for measNo, measTime := range measurementTimes {
-		measurement := measurements[measTime]
-		stateTruth[measNo] = measurement.State.Vector()
-		truthMeas[measNo] = measurement.StateVector()
+	measurementsAt := make(map[time.Time][]odMeasurement)
+	for measNo, measurement := range measurements {
+		stateTruth[measNo] = measurement.state
+		truthMeas[measNo] = measurement.observation
+		measurementsAt[measurement.epoch] = append(measurementsAt[measurement.epoch], measurement)
 	}
 	truth := NewBatchGroundTruth(stateTruth, truthMeas)
 
-	// Compute number of states which will be generated.
-	numStates := int((measurementTimes[len(measurementTimes)-1].Sub(measurementTimes[0])).Seconds()/timeStep.Seconds()) + 2
-	residuals := make([]*mat64.Vector, numStates)
-	estHistory := make([]*SRIFEstimate, numStates)
-	stateHistory := make([]*mat64.Vector, numStates) // Stores the histories of the orbit estimate (to post compute the truth)
+	residuals := []*mat64.Vector{}
+	estHistory := []*SRIFEstimate{}
 
 	// Get the first measurement as an initial orbit estimation.
-	firstDT := measurementTimes[0]
-	estOrbit := measurements[firstDT].State.Orbit
-	startDT = firstDT //.Add(-timeStep)
+	firstDT := measurements[0].epoch
+	lastDT := measurements[len(measurements)-1].epoch
+	Xref := measurements[0].state
 	// TODO: Add noise to initial orbit estimate.
 
-	// Perturbations in the estimate
-	estPerts := smd.Perturbations{Jn: 2}
-
-	stateEstChan := make(chan (smd.State), 1)
-	mEst := smd.NewPreciseMission(smd.NewEmptySC(scName+"Est", 0), &estOrbit, startDT, startDT.Add(-1), estPerts, timeStep, true, smd.ExportConfig{})
-	mEst.RegisterStateChan(stateEstChan)
-
-	// Go-routine to advance propagation.
-	go mEst.PropagateUntil(measurementTimes[len(measurementTimes)-1].Add(timeStep), true)
+	// Dynamics of the estimate
+	gravity := od.NewTwoBodyJ2()
+	dynamics, err := NewRK45(gravity.Derivatives, gravity.Jacobian, 1e-10)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dynamics.Epoch = startDT
 
 	// KF filter initialization stuff.
 
@@ -184,89 +335,89 @@ func _SRIFFullODExample(smoothing bool, t *testing.T) {
 	if err != nil {
 		panic(fmt.Errorf("%s", err))
 	}
+	kf.SetEpoch(firstDT)
 	// Now let's do the filtering.
-	for {
-		state, more := <-stateEstChan
-		if !more {
-			break
-		}
+	for dt := firstDT; !dt.After(lastDT); dt = dt.Add(timeStep) {
 		stateNo++
 		// Just to test with a non triangular R and b vector, let's switch about half way.
 		if stateNo == 200 {
 			kf.nonTriR = true
 		}
-		roundedDT := state.DT.Truncate(time.Second)
-		measurement, exists := measurements[roundedDT]
+		var Φ *mat64.Dense
+		if Xref, Φ, err = dynamics.Propagate(Xref, kf.Epoch(), dt); err != nil {
+			t.Fatalf("[ERR!] %s", err)
+		}
+		if err = kf.SetNextEpoch(dt); err != nil {
+			t.Fatalf("[ERR!] %s", err)
+		}
+		stepMeasurements, exists := measurementsAt[dt]
 		if !exists {
-			if measNo == 0 {
-				time.Sleep(time.Second)
-				t.Fatalf("should start KF at first measurement: \n%s (got)\n%s (exp)", roundedDT, measurementTimes[0])
-			}
 			// There is no truth measurement here, let's only predict the KF covariance.
-			kf.Prepare(state.Φ, nil)
+			kf.Prepare(Φ, nil)
 			est, perr := kf.Predict()
 			if perr != nil {
 				t.Fatalf("[ERR!] (#%04d)\n%s", measNo, perr)
 			}
 			if smoothing {
 				// Save to history in order to perform smoothing.
-				estHistory[stateNo-1] = est.(*SRIFEstimate)
-				stateHistory[stateNo-1] = nil
+				estHistory = append(estHistory, est.(*SRIFEstimate))
 			} else {
 				// Stream to CSV file
 				estChan <- truth.ErrorWithOffset(-1, est, nil)
 			}
 			continue
 		}
-		if roundedDT != measurementTimes[measNo] {
-			t.Fatalf("[ERR!] %04d delta = %s\tstate=%s\tmeas=%s", measNo, state.DT.Sub(measurementTimes[measNo]), state.DT, measurementTimes[measNo])
-		}
-
-		// Let's perform a full update since there is a measurement.
-		if measurement.Station.Name != prevStationName {
-			t.Logf("[info] #%04d %s in visibility of %s (T+%s)\n", measNo, scName, measurement.Station.Name, measurement.State.DT.Sub(startDT))
-			prevStationName = measurement.Station.Name
-		}
 
-		// Compute "real" measurement
-		computedObservation := measurement.Station.PerformMeasurement(measurement.Timeθgst, state)
-		if !computedObservation.Visible {
-			t.Logf("[WARN] station %s should see the SC but does not\n", measurement.Station.Name)
-			visibilityErrors++
-		}
+		for _, measurement := range stepMeasurements {
+			// Let's perform a full update since there is a measurement.
+			if measurement.station.Name != prevStationName {
+				t.Logf("[info] #%04d %s in visibility of %s (T+%s)\n", measNo, scName, measurement.station.Name, measurement.epoch.Sub(startDT))
+				prevStationName = measurement.station.Name
+			}
 
-		Htilde := computedObservation.HTilde()
-		kf.Prepare(state.Φ, Htilde)
-		estI, err := kf.Update(measurement.StateVector(), computedObservation.StateVector())
-		if err != nil {
-			t.Fatalf("[ERR!] %s", err)
-		}
-		est := estI.(*SRIFEstimate)
-		if !est.IsWithin2σ() {
-			t.Fatalf("%s", est)
-		}
-		if stateNo == 1 {
-			t.Logf("\n%s", est)
-		}
+			// Compute "real" measurement
+			if !measurement.station.Visible(Xref, dt) {
+				t.Logf("[WARN] station %s should see the SC but does not\n", measurement.station.Name)
+				visibilityErrors++
+			}
+			computedObservation, Htilde, err := measurement.station.Observe(Xref, dt)
+			if err != nil {
+				t.Fatalf("[ERR!] %s", err)
+			}
+			if err = kf.SetNextEpoch(dt); err != nil {
+				t.Fatalf("[ERR!] %s", err)
+			}
+			kf.Prepare(Φ, Htilde)
+			estI, err := kf.Update(measurement.observation, computedObservation)
+			if err != nil {
+				t.Fatalf("[ERR!] %s", err)
+			}
+			est := estI.(*SRIFEstimate)
+			if !est.IsWithin2σ() {
+				t.Fatalf("%s", est)
+			}
+			if stateNo == 1 {
+				t.Logf("\n%s", est)
+			}
 
-		prevP = est.Covariance().(*mat64.SymDense)
-		// Compute residual
-		residual := mat64.NewVector(2, nil)
-		residual.MulVec(Htilde, est.State())
-		residual.AddScaledVec(residual, -1, est.ObservationDev())
-		residual.ScaleVec(-1, residual)
-		residuals[stateNo-1] = residual
+			prevP = est.Covariance().(*mat64.SymDense)
+			// Compute residual
+			residual := mat64.NewVector(2, nil)
+			residual.MulVec(Htilde, est.State())
+			residual.AddScaledVec(residual, -1, est.ObservationDev())
+			residual.ScaleVec(-1, residual)
+			residuals = append(residuals, residual)
 
-		if smoothing {
-			// Save to history in order to perform smoothing.
-			estHistory[stateNo-1] = est
-			stateHistory[stateNo-1] = state.Vector()
-		} else {
-			// Stream to CSV file
-			estChan <- truth.ErrorWithOffset(measNo, est, state.Vector())
+			if smoothing {
+				// Save to history in order to perform smoothing.
+				estHistory = append(estHistory, est)
+			} else {
+				// Stream to CSV file
+				estChan <- truth.ErrorWithOffset(measNo, est, Xref)
+			}
+			measNo++
 		}
-		measNo++
-	} // end while true
+	}
 
 	if smoothing {
 		fmt.Println("[INFO] Smoothing started")
@@ -289,26 +440,12 @@ func _SRIFFullODExample(smoothing bool, t *testing.T) {
 		severity = "WARNING"
 	}
 	t.Logf("[%s] %d visibility errors\n", severity, visibilityErrors)
-	// Write the residuals to a CSV file
-	f, ferr := os.Create("./hkf-residuals.csv")
-	if ferr != nil {
-		panic(ferr)
-	}
-	defer f.Close()
-	f.WriteString("rho,rhoDot\n")
-	for _, residual := range residuals {
-		csv := "0,0\n"
-		if residual != nil {
-			csv = fmt.Sprintf("%f,%f\n", residual.At(0, 0), residual.At(1, 0))
-		}
-		if _, err := f.WriteString(csv); err != nil {
-			panic(err)
-		}
-	}
+	writeODResiduals("./hkf-residuals.csv", residuals)
 }
 
 func processEst(fn string, estChan chan (Estimate), rmsPos, rmsVel float64, t *testing.T) {
 	wg.Add(1)
+	defer wg.Done()
 	// We also compute the RMS here.
 	numMeasurements := 0
 	rmsPosition := 0.0
@@ -318,7 +455,6 @@ func processEst(fn string, estChan chan (Estimate), rmsPos, rmsVel float64, t *t
 		est, more := <-estChan
 		if !more {
 			ce.Close()
-			wg.Done()
 			break
 		}
 		numMeasurements++
@@ -336,6 +472,6 @@ func processEst(fn string, estChan chan (Estimate), rmsPos, rmsVel float64, t *t
 	t.Logf("RMS: Position = %f\tVelocity = %f\n", rmsPosition, rmsVelocity)
 	// We don't have any unmodeled dynamics, so the RMS should  be tiny.
 	if rmsPosition > rmsPos || rmsVelocity > rmsVel {
-		t.Fatal("RMS values too big")
+		t.Error("RMS values too big")
 	}
 }
@@ -56,7 +56,17 @@ func (t *BatchGroundTruth) ErrorWithOffset(k int, est Estimate, offset *mat64.Ve
 			}
 		}
 	}
-	return ErrorEstimate{VanillaEstimate{state: estState, meas: estMeas, covar: est.Covariance()}}
+	return ErrorEstimate{VanillaEstimate{state: estState, meas: estMeas, covar: est.Covariance(), epoch: est.Epoch()}}
+}
+
+// States returns the true states.
+func (t *BatchGroundTruth) States() []*mat64.Vector {
+	return t.states
+}
+
+// Measurements returns the true measurements.
+func (t *BatchGroundTruth) Measurements() []*mat64.Vector {
+	return t.measurements
 }
 
 // NewBatchGroundTruth initializes a new batch ground truth.
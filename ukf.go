@@ -0,0 +1,462 @@
+package gokalman
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// NLPropagation propagates the provided state through the non-linear dynamics, i.e. x_{k+1} = f(x_k).
+// For time tagged measurements, the time step is available from the filter's TimeStep method.
+type NLPropagation func(x *mat64.Vector) *mat64.Vector
+
+// NLMeasurement computes the observation of the provided state, i.e. y_k = h(x_k).
+type NLMeasurement func(x *mat64.Vector) *mat64.Vector
+
+// SigmaPoints defines the scheme used to compute the sigma points and their weights.
+type SigmaPoints struct {
+	α, β, κ float64
+	scaled  bool
+}
+
+// NewJulierSigmaPoints returns the original sigma point scheme from Julier & Uhlmann.
+// A value of κ = 3-n is a common heuristic for Gaussian states of size n.
+func NewJulierSigmaPoints(κ float64) SigmaPoints {
+	return SigmaPoints{1, 0, κ, false}
+}
+
+// NewScaledSigmaPoints returns the scaled sigma point scheme from van der Merwe.
+// Typical values are α=1e-3, β=2 (optimal for Gaussian distributions) and κ=0.
+func NewScaledSigmaPoints(α, β, κ float64) SigmaPoints {
+	return SigmaPoints{α, β, κ, true}
+}
+
+// λ returns the composite scaling parameter for a state of size n.
+func (s SigmaPoints) λ(n int) float64 {
+	if !s.scaled {
+		return s.κ
+	}
+	return s.α*s.α*(float64(n)+s.κ) - float64(n)
+}
+
+// Weights returns the mean and covariance weights of the 2n+1 sigma points for a state of size n,
+// and the factor γ by which the columns of the square root of the covariance are scaled.
+func (s SigmaPoints) Weights(n int) (Wm, Wc []float64, γ float64) {
+	λ := s.λ(n)
+	nλ := float64(n) + λ
+	Wm = make([]float64, 2*n+1)
+	Wc = make([]float64, 2*n+1)
+	Wm[0] = λ / nλ
+	Wc[0] = Wm[0]
+	if s.scaled {
+		Wc[0] += 1 - s.α*s.α + s.β
+	}
+	for i := 1; i < 2*n+1; i++ {
+		Wm[i] = 1 / (2 * nλ)
+		Wc[i] = Wm[i]
+	}
+	return Wm, Wc, math.Sqrt(nλ)
+}
+
+// Points returns the 2n+1 sigma points around x given its covariance P.
+func (s SigmaPoints) Points(x *mat64.Vector, P mat64.Symmetric) ([]*mat64.Vector, error) {
+	n := x.Len()
+	_, _, γ := s.Weights(n)
+	var chol mat64.Cholesky
+	if ok := chol.Factorize(P); !ok {
+		return nil, errors.New("covariance is not positive definite")
+	}
+	var L mat64.TriDense
+	L.LFromCholesky(&chol)
+	χ := make([]*mat64.Vector, 2*n+1)
+	χ[0] = mat64.NewVector(n, nil)
+	χ[0].CopyVec(x)
+	for i := 0; i < n; i++ {
+		col := mat64.NewVector(n, nil)
+		for j := 0; j < n; j++ {
+			col.SetVec(j, L.At(j, i))
+		}
+		χ[i+1] = mat64.NewVector(n, nil)
+		χ[i+1].AddScaledVec(x, γ, col)
+		χ[i+1+n] = mat64.NewVector(n, nil)
+		χ[i+1+n].AddScaledVec(x, -γ, col)
+	}
+	return χ, nil
+}
+
+func (s SigmaPoints) String() string {
+	if s.scaled {
+		return fmt.Sprintf("scaled{α=%g β=%g κ=%g}", s.α, s.β, s.κ)
+	}
+	return fmt.Sprintf("Julier{κ=%g}", s.κ)
+}
+
+// NewUKF returns a new Unscented Kalman Filter.
+// Parameters:
+// - x0: initial state estimate
+// - P0: initial covariance symmetric matrix
+// - f: non-linear propagation function
+// - h: non-linear measurement function
+// - noise: Noise (Q must be of the size of the state unless PreparePNT is called)
+// - sigmas: sigma point scheme
+func NewUKF(x0 *mat64.Vector, P0 mat64.Symmetric, f NLPropagation, h NLMeasurement, noise Noise, sigmas SigmaPoints) (*UKF, *UKFEstimate, error) {
+	// Let's check the dimensions of everything here to return an error ASAP.
+	if err := checkMatDims(x0, P0, "x0", "Covar0", rows2cols); err != nil {
+		return nil, nil, err
+	}
+	if f == nil || h == nil {
+		return nil, nil, errors.New("both the propagation and the measurement functions must be provided")
+	}
+	measSize, _ := noise.MeasurementMatrix().Dims()
+	cr, _ := P0.Dims()
+	est0 := &UKFEstimate{x0, mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), P0, mat64.NewSymDense(cr, nil), nil, nil, false, time.Time{}}
+	return &UKF{f, h, nil, noise, sigmas, est0, false, 0, nil, false, timeTagger{}}, est0, nil
+}
+
+// UKF defines an Unscented Kalman Filter. Use NewUKF to initialize.
+// The process and measurement noises are assumed additive.
+type UKF struct {
+	F          NLPropagation // Non-linear propagation function
+	H          NLMeasurement // Non-linear measurement function
+	Γ          *mat64.Dense
+	Noise      Noise
+	sigmas     SigmaPoints
+	prevEst    *UKFEstimate
+	sncEnabled bool // Stores whether we should enable or disable the state noise compensation.
+	step       int
+	editor     MeasurementEditor
+	sequential bool // Process each scalar measurement one at a time.
+	timeTagger
+}
+
+func (kf *UKF) String() string {
+	return fmt.Sprintf("UKF [k=%d] %s\n%s", kf.step, kf.sigmas, kf.Noise)
+}
+
+// SetNoise updates the Noise.
+func (kf *UKF) SetNoise(n Noise) {
+	kf.Noise = n
+}
+
+// GetNoise returns the Noise.
+func (kf *UKF) GetNoise() Noise {
+	return kf.Noise
+}
+
+// SequentialEnabled returns whether the measurements are processed one scalar at a time.
+func (kf *UKF) SequentialEnabled() bool {
+	return kf.sequential
+}
+
+// EnableSequential switches to conditioning the joint distribution of the state and the measurement on each scalar
+// measurement one at a time, which avoids the inversion of Pyy. This is exact for any R.
+// Measurement editing is then applied to each scalar measurement.
+func (kf *UKF) EnableSequential() {
+	kf.sequential = true
+}
+
+// DisableSequential switches back to processing the whole measurement vector at once.
+func (kf *UKF) DisableSequential() {
+	kf.sequential = false
+}
+
+// SetMeasurementEditor sets the measurement editing policy used to reject outliers (nil to disable).
+func (kf *UKF) SetMeasurementEditor(e MeasurementEditor) {
+	kf.editor = e
+}
+
+// PreparePNT prepares the process noise transition matrix and enabled the SNC
+// for the next update. If not called, Q is added directly to the predicted covariance.
+func (kf *UKF) PreparePNT(Γ *mat64.Dense) {
+	kf.Γ = Γ
+	kf.sncEnabled = true
+}
+
+// Predict computes only the time update (or prediction).
+func (kf *UKF) Predict() (est Estimate, err error) {
+	return kf.fullUpdate(true, nil)
+}
+
+// Update computes a full time and measurement update.
+func (kf *UKF) Update(realObservation *mat64.Vector) (est Estimate, err error) {
+	return kf.fullUpdate(false, realObservation)
+}
+
+// fullUpdate performs all the steps of an update and allows to stop right after the pure prediction (or time update) step.
+func (kf *UKF) fullUpdate(purePrediction bool, realObservation *mat64.Vector) (est Estimate, err error) {
+	if !purePrediction {
+		if err = checkMatDims(realObservation, kf.Noise.MeasurementMatrix(), "real observation", "R", rows2rows); err != nil {
+			return nil, err
+		}
+	}
+	n := kf.prevEst.state.Len()
+	Wm, Wc, _ := kf.sigmas.Weights(n)
+
+	// Time update: propagate the sigma points.
+	χ, err := kf.sigmas.Points(kf.prevEst.state, kf.prevEst.covar)
+	if err != nil {
+		return nil, fmt.Errorf("could not compute sigma points at k=%d: %s", kf.step, err)
+	}
+	var xBar *mat64.Vector
+	var PBar *mat64.Dense
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		xBar = mat64.NewVector(n, nil)
+		xBar.CloneVec(kf.prevEst.state)
+		PBar = mat64.DenseCopyOf(kf.prevEst.covar)
+	} else {
+		for i := range χ {
+			χ[i] = kf.F(χ[i])
+			if χ[i].Len() != n {
+				return nil, fmt.Errorf("propagation function returned a state of size %d instead of %d", χ[i].Len(), n)
+			}
+		}
+		xBar = weightedMean(χ, Wm)
+		PBar = weightedCovariance(χ, xBar, χ, xBar, Wc)
+		if kf.sncEnabled {
+			var ΓQΓt, ΓQ mat64.Dense
+			ΓQ.Mul(kf.Γ, kf.Noise.ProcessMatrix())
+			ΓQΓt.Mul(&ΓQ, kf.Γ.T())
+			PBar.Add(PBar, &ΓQΓt)
+		} else if !IsNil(kf.Noise.ProcessMatrix()) {
+			if err = checkMatDims(PBar, kf.Noise.ProcessMatrix(), "P", "Q", rowsAndcols); err != nil {
+				return nil, err
+			}
+			PBar.Add(PBar, kf.Noise.ProcessMatrix())
+		}
+	}
+	PBarSym, err := AsSymDense(PBar)
+	if err != nil {
+		return nil, err
+	}
+	kf.sncEnabled = false
+
+	if purePrediction {
+		measSize, _ := kf.Noise.MeasurementMatrix().Dims()
+		est = &UKFEstimate{xBar, mat64.NewVector(measSize, nil), mat64.NewVector(measSize, nil), PBarSym, PBarSym, nil, χ, false, kf.advance()}
+		kf.prevEst = est.(*UKFEstimate)
+		kf.step++
+		return
+	}
+
+	// Measurement update: redraw the sigma points around the prediction to account for the process noise.
+	χBar, err := kf.sigmas.Points(xBar, PBarSym)
+	if err != nil {
+		return nil, fmt.Errorf("could not compute sigma points at k=%d: %s", kf.step, err)
+	}
+	Y := make([]*mat64.Vector, len(χBar))
+	for i := range χBar {
+		Y[i] = kf.H(χBar[i])
+	}
+	yHat := weightedMean(Y, Wm)
+	if err = checkMatDims(realObservation, yHat, "real observation", "computed observation", rows2rows); err != nil {
+		return nil, err
+	}
+	Pyy := weightedCovariance(Y, yHat, Y, yHat, Wc)
+	Pyy.Add(Pyy, kf.Noise.MeasurementMatrix())
+	Pxy := weightedCovariance(χBar, xBar, Y, yHat, Wc)
+
+	if kf.sequential {
+		var innov mat64.Vector
+		innov.SubVec(realObservation, yHat)
+		xHat, PSym, K, rejected, serr := sequentialJointUpdate(xBar, PBarSym, Pxy, Pyy, &innov, kf.editor)
+		if serr != nil {
+			return nil, fmt.Errorf("sequential update at k=%d: %s", kf.step, serr)
+		}
+		est = &UKFEstimate{xHat, yHat, &innov, PSym, PBarSym, K, χBar, rejected, kf.advance()}
+		kf.prevEst = est.(*UKFEstimate)
+		kf.step++
+		return
+	}
+
+	// Kalman gain
+	var PyyInv, K mat64.Dense
+	if ierr := PyyInv.Inverse(Pyy); ierr != nil {
+		return nil, fmt.Errorf("could not invert `Pyy` at k=%d: %s", kf.step, ierr)
+	}
+	K.Mul(Pxy, &PyyInv)
+
+	var innov, xHat mat64.Vector
+	innov.SubVec(realObservation, yHat)
+	if kf.editor != nil {
+		PyySym, serr := AsSymDense(Pyy)
+		if serr != nil {
+			return nil, serr
+		}
+		if kf.editor.Reject(&innov, PyySym) {
+			// Skip the measurement update.
+			est = &UKFEstimate{xBar, yHat, &innov, PBarSym, PBarSym, &K, χBar, true, kf.advance()}
+			kf.prevEst = est.(*UKFEstimate)
+			kf.step++
+			return
+		}
+	}
+	xHat.MulVec(&K, &innov)
+	xHat.AddVec(xBar, &xHat)
+
+	var P, KPyy, KPyyKt mat64.Dense
+	KPyy.Mul(&K, Pyy)
+	KPyyKt.Mul(&KPyy, K.T())
+	P.Sub(PBarSym, &KPyyKt)
+	PSym, err := AsSymDense(&P)
+	if err != nil {
+		return nil, err
+	}
+
+	est = &UKFEstimate{&xHat, yHat, &innov, PSym, PBarSym, &K, χBar, false, kf.advance()}
+	kf.prevEst = est.(*UKFEstimate)
+	kf.step++
+	return
+}
+
+// sequentialJointUpdate conditions the joint Gaussian distribution of the state and the measurement, of covariance
+// [PBar Pxy; Pxy' Pyy], on each scalar measurement one at a time. Each scalar measurement is edited on its own with
+// the provided editor (if any), and rejected is true if any scalar measurement was rejected.
+// Returns the updated state, covariance and the equivalent Kalman gain.
+func sequentialJointUpdate(xBar *mat64.Vector, PBar mat64.Symmetric, Pxy, Pyy *mat64.Dense, innov *mat64.Vector, editor MeasurementEditor) (x *mat64.Vector, P *mat64.SymDense, K *mat64.Dense, rejected bool, err error) {
+	n := xBar.Len()
+	p := innov.Len()
+	C := mat64.NewDense(n+p, n+p, nil)
+	setBlock(C, 0, 0, PBar)
+	setBlock(C, 0, n, Pxy)
+	setBlock(C, n, 0, Pxy.T())
+	setBlock(C, n, n, Pyy)
+	// The joint mean is updated linearly with the innovation: δz = M*innov.
+	M := mat64.NewDense(n+p, p, nil)
+	δz := mat64.NewVector(n+p, nil)
+	for j := 0; j < p; j++ {
+		idx := n + j
+		s := C.At(idx, idx)
+		ν := innov.At(j, 0) - δz.At(idx, 0)
+		if editor != nil && editor.Reject(mat64.NewVector(1, []float64{ν}), mat64.NewSymDense(1, []float64{s})) {
+			rejected = true
+			continue
+		}
+		if s <= 0 {
+			return nil, nil, nil, false, fmt.Errorf("variance of measurement #%d is not positive: %f", j, s)
+		}
+		c := mat64.NewVector(n+p, nil)
+		c.CloneVec(C.ColView(idx))
+		δz.AddScaledVec(δz, ν/s, c)
+		// M = M + c*(e_j - M_idx)'/s
+		e := mat64.NewVector(p, nil)
+		e.SetVec(j, 1)
+		e.SubVec(e, mat64.NewVector(p, mat64.Row(nil, idx, M)))
+		M.RankOne(M, 1/s, c, e)
+		C.RankOne(C, -1/s, c, c)
+	}
+	x = mat64.NewVector(n, nil)
+	for i := 0; i < n; i++ {
+		x.SetVec(i, xBar.At(i, 0)+δz.At(i, 0))
+	}
+	if P, err = AsSymDense(mat64.DenseCopyOf(C.Slice(0, n, 0, n))); err != nil {
+		return
+	}
+	K = mat64.DenseCopyOf(M.Slice(0, n, 0, p))
+	return
+}
+
+// weightedMean returns the weighted mean of the provided points.
+func weightedMean(points []*mat64.Vector, W []float64) *mat64.Vector {
+	mean := mat64.NewVector(points[0].Len(), nil)
+	for i, pt := range points {
+		mean.AddScaledVec(mean, W[i], pt)
+	}
+	return mean
+}
+
+// weightedCovariance returns the weighted cross covariance of the two sets of points around their respective means.
+func weightedCovariance(a []*mat64.Vector, aMean *mat64.Vector, b []*mat64.Vector, bMean *mat64.Vector, W []float64) *mat64.Dense {
+	covar := mat64.NewDense(aMean.Len(), bMean.Len(), nil)
+	var δa, δb mat64.Vector
+	for i := range a {
+		δa.SubVec(a[i], aMean)
+		δb.SubVec(b[i], bMean)
+		covar.RankOne(covar, W[i], &δa, &δb)
+	}
+	return covar
+}
+
+// UKFEstimate is the output of each update state of the UKF.
+// It implements the Estimate interface.
+type UKFEstimate struct {
+	state, meas, innov *mat64.Vector
+	covar, predCovar   mat64.Symmetric
+	gain               mat64.Matrix
+	sigmas             []*mat64.Vector
+	rejected           bool
+	epoch              time.Time
+}
+
+// IsWithinNσ returns whether the estimation is within the 2σ bounds.
+func (e UKFEstimate) IsWithinNσ(N float64) bool {
+	for i := 0; i < e.state.Len(); i++ {
+		nσ := N * math.Sqrt(e.covar.At(i, i))
+		if e.state.At(i, 0) > nσ || e.state.At(i, 0) < -nσ {
+			return false
+		}
+	}
+	return true
+}
+
+// IsWithin2σ returns whether the estimation is within the 2σ bounds.
+func (e UKFEstimate) IsWithin2σ() bool {
+	return e.IsWithinNσ(2)
+}
+
+// State implements the Estimate interface.
+func (e UKFEstimate) State() *mat64.Vector {
+	return e.state
+}
+
+// Measurement implements the Estimate interface.
+func (e UKFEstimate) Measurement() *mat64.Vector {
+	return e.meas
+}
+
+// Innovation implements the Estimate interface.
+func (e UKFEstimate) Innovation() *mat64.Vector {
+	return e.innov
+}
+
+// Covariance implements the Estimate interface.
+func (e UKFEstimate) Covariance() mat64.Symmetric {
+	return e.covar
+}
+
+// PredCovariance implements the Estimate interface.
+func (e UKFEstimate) PredCovariance() mat64.Symmetric {
+	return e.predCovar
+}
+
+// Rejected implements the Estimate interface.
+func (e UKFEstimate) Rejected() bool {
+	return e.rejected
+}
+
+// Epoch implements the Estimate interface.
+func (e UKFEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
+// Gain returns the Kalman gain.
+func (e UKFEstimate) Gain() mat64.Matrix {
+	return e.gain
+}
+
+// SigmaPoints returns the sigma points used for this estimate.
+func (e UKFEstimate) SigmaPoints() []*mat64.Vector {
+	return e.sigmas
+}
+
+func (e UKFEstimate) String() string {
+	state := mat64.Formatted(e.State(), mat64.Prefix("  "))
+	meas := mat64.Formatted(e.Measurement(), mat64.Prefix("  "))
+	covar := mat64.Formatted(e.Covariance(), mat64.Prefix("  "))
+	innov := mat64.Formatted(e.Innovation(), mat64.Prefix("  "))
+	predp := mat64.Formatted(e.PredCovariance(), mat64.Prefix("   "))
+	return fmt.Sprintf("{\ns=%v\ny=%v\nP=%v\nP-=%v\ni=%v\n}", state, meas, covar, predp, innov)
+}
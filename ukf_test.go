@@ -0,0 +1,140 @@
+package gokalman
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gonum/floats"
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestSigmaPointsWeights(t *testing.T) {
+	for _, sigmas := range []SigmaPoints{NewJulierSigmaPoints(0), NewJulierSigmaPoints(-1), NewScaledSigmaPoints(1e-3, 2, 0), NewScaledSigmaPoints(0.5, 2, 1)} {
+		Wm, Wc, γ := sigmas.Weights(4)
+		if len(Wm) != 9 || len(Wc) != 9 {
+			t.Fatalf("%s: expected 9 weights", sigmas)
+		}
+		if !floats.EqualWithinAbs(floats.Sum(Wm), 1, 1e-9) {
+			t.Fatalf("%s: mean weights do not sum to one: %f", sigmas, floats.Sum(Wm))
+		}
+		if γ <= 0 {
+			t.Fatalf("%s: γ=%f", sigmas, γ)
+		}
+	}
+	// The sigma points must capture the mean and covariance exactly.
+	x := mat64.NewVector(2, []float64{1, -2})
+	P := mat64.NewSymDense(2, []float64{4, 1, 1, 3})
+	sigmas := NewScaledSigmaPoints(1e-1, 2, 0)
+	χ, err := sigmas.Points(x, P)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Wm, _, _ := sigmas.Weights(2)
+	// Use the mean weights for the covariance to recover P exactly.
+	mean := weightedMean(χ, Wm)
+	covar := weightedCovariance(χ, mean, χ, mean, Wm)
+	if !mat64.EqualApprox(mean, x, 1e-9) {
+		t.Fatalf("invalid sigma point mean: %v", mat64.Formatted(mean))
+	}
+	if !mat64.EqualApprox(covar, P, 1e-9) {
+		t.Fatalf("invalid sigma point covariance: %v", mat64.Formatted(covar))
+	}
+	if _, err = sigmas.Points(x, mat64.NewSymDense(2, []float64{-1, 0, 0, 1})); err == nil {
+		t.Fatal("non positive definite covariance did not fail")
+	}
+}
+
+func TestUKFLinear(t *testing.T) {
+	// With linear dynamics and measurements, the UKF must match the vanilla KF.
+	F, G, _ := Robot1DMatrices()
+	H := mat64.NewDense(1, 2, []float64{1, 0})
+	Q := mat64.NewSymDense(2, []float64{1e-4, 0, 0, 1e-3})
+	R := mat64.NewSymDense(1, []float64{0.1})
+	noise := NewNoiseless(Q, R)
+	x0 := mat64.NewVector(2, []float64{0, 0.35})
+	P0 := ScaledIdentity(2, 10)
+	vanilla, _, err := NewVanilla(x0, P0, F, G, H, noise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := func(x *mat64.Vector) *mat64.Vector {
+		var xKp1 mat64.Vector
+		xKp1.MulVec(F, x)
+		return &xKp1
+	}
+	h := func(x *mat64.Vector) *mat64.Vector {
+		var y mat64.Vector
+		y.MulVec(H, x)
+		return &y
+	}
+	for _, sigmas := range []SigmaPoints{NewJulierSigmaPoints(1), NewScaledSigmaPoints(1e-1, 2, 0)} {
+		vanilla.Reset()
+		ukf, _, err := NewUKF(x0, P0, f, h, noise, sigmas)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := 0; k < 50; k++ {
+			y := mat64.NewVector(1, []float64{0.035*float64(k) + 0.1*math.Sin(float64(k))})
+			vEst, err := vanilla.Update(y, mat64.NewVector(1, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			uEst, err := ukf.Update(y)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !mat64.EqualApprox(vEst.State(), uEst.State(), 1e-8) {
+				t.Fatalf("%s k=%d: states differ\n%v\n%v", sigmas, k, mat64.Formatted(vEst.State()), mat64.Formatted(uEst.State()))
+			}
+			if !mat64.EqualApprox(vEst.Covariance(), uEst.Covariance(), 1e-8) {
+				t.Fatalf("%s k=%d: covariances differ\n%v\n%v", sigmas, k, mat64.Formatted(vEst.Covariance()), mat64.Formatted(uEst.Covariance()))
+			}
+		}
+	}
+}
+
+func TestUKFRange(t *testing.T) {
+	// Constant velocity target in 2D observed by its range and bearing from the origin.
+	Δt := 1.0
+	f := func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(4, []float64{x.At(0, 0) + Δt*x.At(2, 0), x.At(1, 0) + Δt*x.At(3, 0), x.At(2, 0), x.At(3, 0)})
+	}
+	h := func(x *mat64.Vector) *mat64.Vector {
+		return mat64.NewVector(2, []float64{math.Hypot(x.At(0, 0), x.At(1, 0)), math.Atan2(x.At(1, 0), x.At(0, 0))})
+	}
+	truth := mat64.NewVector(4, []float64{100, 50, 1, -2})
+	x0 := mat64.NewVector(4, []float64{110, 40, 0, 0})
+	P0 := mat64.NewSymDense(4, []float64{400, 0, 0, 0, 0, 400, 0, 0, 0, 0, 25, 0, 0, 0, 0, 25})
+	Q := ScaledIdentity(4, 1e-6)
+	R := mat64.NewSymDense(2, []float64{1e-2, 0, 0, 1e-6})
+	kf, _, err := NewUKF(x0, P0, f, h, NewNoiseless(Q, R), NewScaledSigmaPoints(1, 2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var est Estimate
+	for k := 0; k < 30; k++ {
+		truth = f(truth)
+		if k%5 == 4 {
+			if est, err = kf.Predict(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if est, err = kf.Update(h(truth)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var Δx mat64.Vector
+	Δx.SubVec(truth, est.State())
+	for i := 0; i < 4; i++ {
+		if math.Abs(Δx.At(i, 0)) > 3*math.Sqrt(est.Covariance().At(i, i))+1e-3 {
+			t.Fatalf("state #%d not within 3σ: error=%f σ=%f", i, Δx.At(i, 0), math.Sqrt(est.Covariance().At(i, i)))
+		}
+	}
+	if _, err = kf.Update(mat64.NewVector(3, nil)); err == nil {
+		t.Fatal("invalid observation size did not fail")
+	}
+	if _, _, err = NewUKF(x0, P0, nil, h, NewNoiseless(Q, R), NewJulierSigmaPoints(0)); err == nil {
+		t.Fatal("missing propagation function did not fail")
+	}
+}
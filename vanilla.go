@@ -1,8 +1,10 @@
 package gokalman
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/gonum/matrix/mat64"
 )
@@ -34,12 +36,13 @@ func NewVanilla(x0 *mat64.Vector, Covar0 mat64.Symmetric, F, G, H mat64.Matrix,
 	rowsH, _ := H.Dims()
 	cr, _ := Covar0.Dims()
 	predCovar := mat64.NewSymDense(cr, nil)
-	est0 := VanillaEstimate{x0, mat64.NewVector(rowsH, nil), mat64.NewVector(rowsH, nil), Covar0, predCovar, nil}
+	est0 := VanillaEstimate{x0, mat64.NewVector(rowsH, nil), mat64.NewVector(rowsH, nil), Covar0, predCovar, nil, false, time.Time{}}
 
-	return &Vanilla{F, G, H, noise, !IsNil(G), est0, est0, 0, false}, &est0, nil
+	return &Vanilla{F: F, G: G, H: H, Noise: noise, needCtrl: !IsNil(G), prevEst: est0, initEst: est0}, &est0, nil
 }
 
 // NewPurePredictorVanilla returns a new Vanilla KF which only does prediction.
+// NOTE: the filters never sample the noise, so use a Simulator to generate noisy truth data.
 func NewPurePredictorVanilla(x0 *mat64.Vector, Covar0 mat64.Symmetric, F, G, H mat64.Matrix, noise Noise) (*Vanilla, *VanillaEstimate, error) {
 	// Let's check the dimensions of everything here to panic ASAP.
 	if err := checkMatDims(x0, Covar0, "x0", "Covar0", rows2cols); err != nil {
@@ -56,9 +59,9 @@ func NewPurePredictorVanilla(x0 *mat64.Vector, Covar0 mat64.Symmetric, F, G, H m
 	rowsH, _ := H.Dims()
 	cr, _ := Covar0.Dims()
 	predCovar := mat64.NewSymDense(cr, nil)
-	est0 := VanillaEstimate{x0, mat64.NewVector(rowsH, nil), mat64.NewVector(rowsH, nil), Covar0, predCovar, nil}
+	est0 := VanillaEstimate{x0, mat64.NewVector(rowsH, nil), mat64.NewVector(rowsH, nil), Covar0, predCovar, nil, false, time.Time{}}
 
-	return &Vanilla{F, G, H, noise, !IsNil(G), est0, est0, 0, true}, &est0, nil
+	return &Vanilla{F: F, G: G, H: H, Noise: noise, needCtrl: !IsNil(G), prevEst: est0, initEst: est0, predictionOnly: true}, &est0, nil
 }
 
 // Vanilla defines a vanilla kalman filter. Use NewVanilla to initialize.
@@ -71,6 +74,10 @@ type Vanilla struct {
 	prevEst, initEst VanillaEstimate
 	step             int
 	predictionOnly   bool
+	editor           MeasurementEditor
+	sequential       bool          // Process each scalar measurement one at a time.
+	model            TimeStepModel // Computes F and Q of each time update from the time step, if set.
+	timeTagger
 }
 
 func (kf *Vanilla) String() string {
@@ -117,11 +124,57 @@ func (kf *Vanilla) GetNoise() Noise {
 	return kf.Noise
 }
 
+// SetTimeStepModel sets the model from which F and Q of each time update are computed over the time step to the next
+// epoch (cf. SetNextEpoch), instead of using F and the Noise's Q (nil to disable). The next epoch must then be set
+// before each update. The Noise's R and cross covariance, if any, are still used.
+func (kf *Vanilla) SetTimeStepModel(m TimeStepModel) {
+	kf.model = m
+}
+
+// SetMeasurementEditor sets the measurement editing policy used to reject outliers (nil to disable).
+func (kf *Vanilla) SetMeasurementEditor(e MeasurementEditor) {
+	kf.editor = e
+}
+
+// SequentialEnabled returns whether the measurements are processed one scalar at a time.
+func (kf *Vanilla) SequentialEnabled() bool {
+	return kf.sequential
+}
+
+// EnableSequential switches to processing each scalar measurement one at a time, which avoids the inversion of H*P*H' + R.
+// If R is not diagonal, the measurements are first whitened with its Cholesky factor.
+// Measurement editing is then applied to each (whitened) scalar measurement.
+func (kf *Vanilla) EnableSequential() {
+	kf.sequential = true
+}
+
+// DisableSequential switches back to processing the whole measurement vector at once.
+func (kf *Vanilla) DisableSequential() {
+	kf.sequential = false
+}
+
 // Reset reinitializes the KF with its initial estimate.
 func (kf *Vanilla) Reset() {
 	kf.prevEst = kf.initEst
 	kf.step = 0
+	kf.reset()
 	kf.Noise.Reset()
+	if kf.editor != nil {
+		kf.editor.Reset()
+	}
+}
+
+// SetEstimate replaces the current state estimate and covariance, e.g. after the mixing step of an IMM.
+func (kf *Vanilla) SetEstimate(x *mat64.Vector, P mat64.Symmetric) error {
+	if err := checkMatDims(x, P, "x", "P", rows2cols); err != nil {
+		return err
+	}
+	if err := checkMatDims(x, kf.prevEst.state, "x", "current state", rows2rows); err != nil {
+		return err
+	}
+	kf.prevEst.state = x
+	kf.prevEst.covar = P
+	return nil
 }
 
 // Update implements the KalmanFilter interface.
@@ -134,45 +187,83 @@ func (kf *Vanilla) Update(measurement, control *mat64.Vector) (est Estimate, err
 		return nil, err
 	}
 
+	// Cross covariance of the process noise over the time update and the measurement noise, if any.
+	var M mat64.Matrix
+	if S := kf.Noise.CrossCovariance(); S != nil && !kf.sameEpoch() {
+		if kf.sequential && !kf.predictionOnly {
+			return nil, errors.New("correlated process and measurement noises are not available with sequential measurements")
+		}
+		if err = checkCrossCovariance(kf.Noise.ProcessMatrix(), kf.Noise.MeasurementMatrix(), S); err != nil {
+			return nil, err
+		}
+		M = S
+	}
+
+	// State transition and process noise of the time update.
+	F, Q := kf.F, kf.Noise.ProcessMatrix()
+	if kf.model != nil && !kf.sameEpoch() {
+		if F, Q, err = kf.modelTimeUpdate(kf.model, kf.prevEst.state.Len()); err != nil {
+			return nil, err
+		}
+	}
+
 	// Prediction step.
 	var xKp1Minus, xKp1Minus1, xKp1Minus2 mat64.Vector
-	xKp1Minus1.MulVec(kf.F, kf.prevEst.State())
-	if kf.needCtrl {
-		xKp1Minus2.MulVec(kf.G, control)
-		xKp1Minus.AddVec(&xKp1Minus1, &xKp1Minus2)
+	var Pkp1Minus mat64.Dense
+	if kf.sameEpoch() {
+		// Another measurement at the same epoch: there is no time update.
+		xKp1Minus.CloneVec(kf.prevEst.State())
+		Pkp1Minus.Clone(kf.prevEst.Covariance())
 	} else {
-		xKp1Minus = xKp1Minus1
-	}
-	xKp1Minus.AddVec(&xKp1Minus, kf.Noise.Process(kf.step))
+		xKp1Minus1.MulVec(F, kf.prevEst.State())
+		if kf.needCtrl {
+			xKp1Minus2.MulVec(kf.G, control)
+			xKp1Minus.AddVec(&xKp1Minus1, &xKp1Minus2)
+		} else {
+			xKp1Minus = xKp1Minus1
+		}
 
-	// P_{k+1}^{-}
-	var Pkp1Minus, FP, FPFt mat64.Dense
-	FP.Mul(kf.F, kf.prevEst.Covariance())
-	FPFt.Mul(&FP, kf.F.T())
-	Pkp1Minus.Add(&FPFt, kf.Noise.ProcessMatrix())
+		// P_{k+1}^{-}
+		var FP, FPFt mat64.Dense
+		FP.Mul(F, kf.prevEst.Covariance())
+		FPFt.Mul(&FP, F.T())
+		Pkp1Minus.Add(&FPFt, Q)
+	}
 
 	// Compute estimated measurement update \hat{y}_{k}
 	var ykHat mat64.Vector
 	ykHat.MulVec(kf.H, kf.prevEst.State())
-	ykHat.AddVec(&ykHat, kf.Noise.Measurement(kf.step))
-
-	// Kalman gain
-	var PHt, HPHt, Kkp1 mat64.Dense
-	PHt.Mul(&Pkp1Minus, kf.H.T())
-	HPHt.Mul(kf.H, &PHt)
-	HPHt.Add(&HPHt, kf.Noise.MeasurementMatrix())
-	if ierr := HPHt.Inverse(&HPHt); ierr != nil {
-		//panic(fmt.Errorf("could not invert `H*P_kp1_minus*H' + R`: %s", ierr))
+
+	if kf.sequential && !kf.predictionOnly {
+		var innov mat64.Vector
+		innov.MulVec(kf.H, &xKp1Minus)
+		innov.SubVec(measurement, &innov)
+		xkp1Plus, Pkp1PlusSym, Kkp1, rejected, serr := sequentialUpdate(&xKp1Minus, &Pkp1Minus, kf.H, &innov, kf.Noise.MeasurementMatrix(), kf.editor)
+		if serr != nil {
+			return nil, serr
+		}
+		Pkp1MinusSym, serr := AsSymDense(&Pkp1Minus)
+		if serr != nil {
+			return nil, serr
+		}
+		est = VanillaEstimate{xkp1Plus, &ykHat, &innov, Pkp1PlusSym, Pkp1MinusSym, Kkp1, rejected, kf.advance()}
+		kf.prevEst = est.(VanillaEstimate)
+		kf.step++
+		return
+	}
+
+	// Kalman gain, and innovation covariance S used for measurement editing.
+	Kkp1, S, ierr := kalmanGain(&Pkp1Minus, kf.H, kf.Noise.MeasurementMatrix(), M)
+	if ierr != nil {
 		return nil, fmt.Errorf("could not invert `H*P_kp1_minus*H' + R`: %s", ierr)
 	}
-	Kkp1.Mul(&PHt, &HPHt)
 
 	if kf.predictionOnly {
 		// Note that in the case of a pure prediction, we set the prediction
 		// covariance and the covariance to Pkp1Minus.
 		Pkp1MinusSym, _ := AsSymDense(&Pkp1Minus)
 		rowsH, _ := kf.H.Dims()
-		est = VanillaEstimate{&xKp1Minus, &ykHat, mat64.NewVector(rowsH, nil), Pkp1MinusSym, Pkp1MinusSym, &Kkp1}
+		est = VanillaEstimate{&xKp1Minus, &ykHat, mat64.NewVector(rowsH, nil), Pkp1MinusSym, Pkp1MinusSym, Kkp1, false, kf.advance()}
 		kf.prevEst = est.(VanillaEstimate)
 		kf.step++
 		return
@@ -182,38 +273,38 @@ func (kf *Vanilla) Update(measurement, control *mat64.Vector) (est Estimate, err
 	var innov, xkp1Plus, xkp1Plus1, xkp1Plus2 mat64.Vector
 	xkp1Plus1.MulVec(kf.H, &xKp1Minus)    // Predicted measurement
 	innov.SubVec(measurement, &xkp1Plus1) // Innovation vector
-	if rX, _ := innov.Dims(); rX == 1 {
-		// xkp1Plus1 is a scalar and mat64 won't be happy, so fiddle around to get a vector.
-		var sKkp1 mat64.Dense
-		sKkp1.Scale(innov.At(0, 0), &Kkp1)
-		rGain, _ := sKkp1.Dims()
-		xkp1Plus2.AddVec(sKkp1.ColView(0), mat64.NewVector(rGain, nil))
-	} else {
-		xkp1Plus2.MulVec(&Kkp1, &innov)
+	if kf.editor != nil {
+		SSym, serr := AsSymDense(S)
+		if serr != nil {
+			return nil, serr
+		}
+		if kf.editor.Reject(&innov, SSym) {
+			// Skip the measurement update.
+			Pkp1MinusSym, serr := AsSymDense(&Pkp1Minus)
+			if serr != nil {
+				return nil, serr
+			}
+			est = VanillaEstimate{&xKp1Minus, &ykHat, &innov, Pkp1MinusSym, Pkp1MinusSym, Kkp1, true, kf.advance()}
+			kf.prevEst = est.(VanillaEstimate)
+			kf.step++
+			return
+		}
 	}
+	xkp1Plus2.MulVec(Kkp1, &innov)
 	xkp1Plus.AddVec(&xKp1Minus, &xkp1Plus2)
-	xkp1Plus.AddVec(&xkp1Plus, kf.Noise.Process(kf.step))
-
-	var Pkp1Plus, Pkp1Plus1, Kkp1H, Kkp1R, Kkp1RKkp1 mat64.Dense
-	Kkp1H.Mul(&Kkp1, kf.H)
-	n, _ := Kkp1H.Dims()
-	Kkp1H.Sub(Identity(n), &Kkp1H)
-	Pkp1Plus1.Mul(&Kkp1H, &Pkp1Minus)
-	Pkp1Plus.Mul(&Pkp1Plus1, Kkp1H.T())
-	Kkp1R.Mul(&Kkp1, kf.Noise.MeasurementMatrix())
-	Kkp1RKkp1.Mul(&Kkp1R, Kkp1.T())
-	Pkp1Plus.Add(&Pkp1Plus, &Kkp1RKkp1)
+
+	Pkp1Plus := josephCovariance(Kkp1, kf.H, &Pkp1Minus, kf.Noise.MeasurementMatrix(), M)
 
 	Pkp1MinusSym, err := AsSymDense(&Pkp1Minus)
 	if err != nil {
 		return nil, err
 	}
 
-	Pkp1PlusSym, err := AsSymDense(&Pkp1Plus)
+	Pkp1PlusSym, err := AsSymDense(Pkp1Plus)
 	if err != nil {
 		return nil, err
 	}
-	est = VanillaEstimate{&xkp1Plus, &ykHat, &innov, Pkp1PlusSym, Pkp1MinusSym, &Kkp1}
+	est = VanillaEstimate{&xkp1Plus, &ykHat, &innov, Pkp1PlusSym, Pkp1MinusSym, Kkp1, false, kf.advance()}
 	kf.prevEst = est.(VanillaEstimate)
 	kf.step++
 	return
@@ -225,6 +316,8 @@ type VanillaEstimate struct {
 	state, meas, innovation *mat64.Vector
 	covar, predCovar        mat64.Symmetric
 	gain                    mat64.Matrix
+	rejected                bool
+	epoch                   time.Time
 }
 
 // IsWithinNσ returns whether the estimation is within the 2σ bounds.
@@ -268,6 +361,16 @@ func (e VanillaEstimate) PredCovariance() mat64.Symmetric {
 	return e.predCovar
 }
 
+// Rejected implements the Estimate interface.
+func (e VanillaEstimate) Rejected() bool {
+	return e.rejected
+}
+
+// Epoch implements the Estimate interface.
+func (e VanillaEstimate) Epoch() time.Time {
+	return e.epoch
+}
+
 // Gain the Estimate interface.
 func (e VanillaEstimate) Gain() mat64.Matrix {
 	return e.gain